@@ -0,0 +1,223 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"simple-secrets/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	rejectReason    string
+	approvalRequire []string
+	approvalExpiry  string
+)
+
+var approveCmd = &cobra.Command{
+	Use:   "approve <request-id>",
+	Short: "Approve another admin's pending request (admin only)",
+	Long: `Operations required by 'simple-secrets approvals set' need two admins.
+Running one files a pending request instead of executing; a different admin
+approves it with this command, and the requester then runs the operation
+again to execute it. Requests that are not approved, or not executed once
+approved, within the approval expiry (default 24h) expire.
+
+Operations: restore-database, rotate-master-key, delete (a secret) and
+grant-admin (create-user, 'user update --role' or adding a user to a group,
+whenever the role given holds the manage-users or write permission). 'list approvals' shows the requests.`,
+	Example: `  simple-secrets list approvals
+  simple-secrets approve a3`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		request, err := helper.GetService().Approvals().Approve(token, args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Request %s (%s) approved. %s can now run it until %s.\n",
+			request.ID, request.Describe(), request.RequestedBy, formatTokenTime(&request.ExpiresAt))
+		return nil
+	},
+}
+
+var rejectCmd = &cobra.Command{
+	Use:     "reject <request-id> [--reason <text>]",
+	Short:   "Reject another admin's pending request (admin only)",
+	Example: `  simple-secrets reject a3 --reason "restore the 09:00 backup instead"`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		request, err := helper.GetService().Approvals().Reject(token, args[0], rejectReason)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("⛔ Request %s (%s) rejected.\n", request.ID, request.Describe())
+		return nil
+	},
+}
+
+// approvalsCmd groups the approval settings
+var approvalsCmd = &cobra.Command{
+	Use:   "approvals",
+	Short: "Show or change which operations need a second admin",
+	Long: `The approval settings are sealed in approvals.json, like the requests.
+While any operation needs approval, changing the settings needs one too.`,
+}
+
+var approvalsShowCmd = &cobra.Command{
+	Use:     "show",
+	Short:   "Show the operations that need approval",
+	Example: `  simple-secrets approvals show`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		policy, err := helper.GetService().Approvals().Settings(token)
+		if err != nil {
+			return err
+		}
+
+		if len(policy.Operations) == 0 {
+			fmt.Println("No operations need approval.")
+		} else {
+			fmt.Printf("Operations needing approval: %s\n", strings.Join(policy.Operations, ", "))
+		}
+		fmt.Printf("Requests expire after: %s\n", internal.FormatRemaining(policy.Expiry))
+		return nil
+	},
+}
+
+var approvalsSetCmd = &cobra.Command{
+	Use:   "set [--require <operation,...>] [--expiry <duration>]",
+	Short: "Replace the operations that need approval (admin only)",
+	Long: `Replace the approval settings. Operations not given with --require no longer
+need approval; 'set' with no --require switches approvals off.
+
+Operations: ` + strings.Join(internal.ApprovableOperations(), ", "),
+	Example: `  simple-secrets approvals set --require restore-database,rotate-master-key,delete,grant-admin --expiry 4h
+  simple-secrets approvals set  # no approvals`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := helper.GetService().Approvals().Configure(token, approvalRequire, approvalExpiry); err != nil {
+			return err
+		}
+
+		if len(approvalRequire) == 0 {
+			fmt.Println("✅ No operations need approval.")
+			return nil
+		}
+		fmt.Printf("✅ Operations needing approval: %s\n", strings.Join(approvalRequire, ", "))
+		return nil
+	},
+}
+
+// listApprovals shows approval requests, only the open ones unless --all is given
+func listApprovals(cmd *cobra.Command) error {
+	helper, token, err := serviceCommandSetup(cmd)
+	if err != nil {
+		return err
+	}
+
+	requests, err := helper.GetService().Approvals().ListRequests(token)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var shown []*internal.ApprovalRequest
+	for _, r := range requests {
+		status := r.CurrentStatus(now)
+		if listAll || status == internal.ApprovalPending || status == internal.ApprovalApproved {
+			shown = append(shown, r)
+		}
+	}
+	if len(shown) == 0 {
+		fmt.Println("No open approval requests.")
+		return nil
+	}
+
+	fmt.Printf("Found %d approval request(s):\n\n", len(shown))
+	for _, r := range shown {
+		fmt.Printf("  %s %s: %s\n", approvalStatusIcon(r.CurrentStatus(now)), r.ID, r.Describe())
+		fmt.Printf("    Status: %s\n", r.CurrentStatus(now))
+		fmt.Printf("    Requested by %s at %s, expires %s\n", r.RequestedBy, formatTokenTime(&r.RequestedAt), formatTokenTime(&r.ExpiresAt))
+		if r.DecidedBy != "" {
+			fmt.Printf("    Decided by %s at %s\n", r.DecidedBy, formatTokenTime(r.DecidedAt))
+		}
+		if r.Reason != "" {
+			fmt.Printf("    Reason: %s\n", r.Reason)
+		}
+		if r.ExecutedAt != nil {
+			fmt.Printf("    Executed at %s\n", formatTokenTime(r.ExecutedAt))
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func approvalStatusIcon(status internal.ApprovalStatus) string {
+	switch status {
+	case internal.ApprovalPending:
+		return "⏳"
+	case internal.ApprovalApproved:
+		return "✅"
+	case internal.ApprovalExecuted:
+		return "✔️ "
+	}
+	return "⛔"
+}
+
+// requireApproval gates an operation the command runs outside the service layer. The
+// command passes the operation's result to done, which gives the approval back on failure.
+func requireApproval(cmd *cobra.Command, helper *CLIServiceHelper, operation, target string) (done func(error), err error) {
+	token, err := resolveTokenFromCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return helper.GetService().Approvals().Require(token, operation, target)
+}
+
+func init() {
+	rootCmd.AddCommand(approveCmd, rejectCmd, approvalsCmd)
+	approvalsCmd.AddCommand(approvalsShowCmd, approvalsSetCmd)
+
+	rejectCmd.Flags().StringVar(&rejectReason, "reason", "", "why the request is rejected (recorded and shown by 'list approvals --all')")
+	approvalsSetCmd.Flags().StringSliceVar(&approvalRequire, "require", nil, "operations that need a second admin's approval")
+	approvalsSetCmd.Flags().StringVar(&approvalExpiry, "expiry", "", "how long a request may wait for approval, and then for its execution (default 24h)")
+}
@@ -0,0 +1,393 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"simple-secrets/internal"
+	"simple-secrets/pkg/version"
+
+	"github.com/spf13/cobra"
+)
+
+// auditExitBroken is the exit code of 'audit verify' when the log was tampered with
+const auditExitBroken = 1
+
+var (
+	auditJSON      bool
+	auditFormat    string // audit query
+	auditExportAs  string // audit export
+	auditOut       string
+	auditOlderThan string
+	auditFilter    auditFilterFlags
+)
+
+// auditFilterFlags are the entry filters shared by 'audit query' and 'audit export'
+type auditFilterFlags struct {
+	user, key, op, result, since, until string
+}
+
+// auditCmd groups the audit log commands
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the audit log (admin only)",
+	Long: `Every operation made with a token is appended to audit.log in the config
+directory, including failed sign-ins: who, what, on which key, the result and
+when. Secret values are never logged. Each entry holds the hash of the one
+before it, and audit_head.json seals the last entry, so edits, removed entries
+and truncation are detected by 'simple-secrets audit verify'.
+
+audit.log is moved to the audit/ directory once it reaches audit_rotate_size_mb
+or its first entry is audit_rotate_after old (see 'simple-secrets config'); the
+chain continues into the next file. 'audit prune' removes old rotated files.`,
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that the audit log was not edited or truncated",
+	Long: `Check every entry's hash and its link to the entry before it, and that the log
+ends at the entry audit_head.json was sealed with. A missing audit_head.json is
+a problem once logging has started, even if audit.log is gone too. Exits with
+status 1 if a problem is found.`,
+	Example: `  simple-secrets audit verify
+  simple-secrets audit verify --json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		result, err := helper.GetService().Audit().Verify(token)
+		if err != nil {
+			return err
+		}
+		if err := printAuditVerification(result, auditJSON); err != nil {
+			return err
+		}
+		if result.Intact() {
+			return nil
+		}
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+		return &ExitCodeError{Code: auditExitBroken}
+	},
+}
+
+// printAuditVerification prints the result as JSON or as a summary with each problem
+func printAuditVerification(result *internal.AuditVerification, asJSON bool) error {
+	if asJSON {
+		encoded, err := json.MarshalIndent(struct {
+			Intact bool `json:"intact"`
+			*internal.AuditVerification
+		}{result.Intact(), result}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(encoded))
+		return nil
+	}
+
+	if result.Intact() {
+		fmt.Printf("✅ Audit log intact: %d entries in %s\n", result.Entries, result.Path)
+		return nil
+	}
+	fmt.Printf("❌ Audit log damaged: %d problem(s) in %s (%d entries)\n", len(result.Problems), result.Path, result.Entries)
+	for _, problem := range result.Problems {
+		fmt.Printf("   • %s\n", problem)
+	}
+	return nil
+}
+
+var auditQueryCmd = &cobra.Command{
+	Use:   "query [--user <name>] [--key <pattern>] [--op <op>] [--since <time>] [--until <time>] [--result <result>]",
+	Short: "Search the audit log",
+	Long: `Show the audit entries matching every filter given, oldest first, from the
+rotated logs and audit.log. --key accepts patterns such as 'prod-*'. --since and
+--until accept a date (2025-06-01), an RFC 3339 time or a duration before now
+such as 7d. --result is success, denied, auth_failed or error.`,
+	Example: `  # Who read db_password in the last week?
+  simple-secrets audit query --key db_password --op get --since 7d
+  simple-secrets audit query --result auth_failed --format csv`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if auditFormat != "table" && auditFormat != "json" && auditFormat != "csv" {
+			return fmt.Errorf("invalid format %q: must be table, json or csv", auditFormat)
+		}
+		filter, err := auditFilter.parse(time.Now())
+		if err != nil {
+			return err
+		}
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		entries, err := helper.GetService().Audit().Query(token, filter)
+		if err != nil {
+			return err
+		}
+		switch auditFormat {
+		case "json":
+			encoded, err := json.MarshalIndent(entries, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(encoded))
+			return nil
+		case "csv":
+			return writeAuditCSV(os.Stdout, entries)
+		}
+		if len(entries) == 0 {
+			fmt.Println("No matching audit entries.")
+			return nil
+		}
+		return writeAuditTable(os.Stdout, entries)
+	},
+}
+
+var auditExportCmd = &cobra.Command{
+	Use:   "export --format json|csv|cef [--out <file>]",
+	Short: "Export the audit log for another system",
+	Long: `Write the audit entries, oldest first, in a format other systems read:
+
+  json  one entry per line, as in audit.log, with the hashes that chain them
+  csv   a header row and one row per entry
+  cef   ArcSight Common Event Format, one event per line, for SIEMs
+
+The filters of 'audit query' select which entries are exported. Without --out
+the export is written to standard output; a file is created with mode 0600.`,
+	Example: `  simple-secrets audit export --format cef --since 1d >> /var/log/siem/simple-secrets.cef
+  simple-secrets audit export --format csv --out audit-2025-q2.csv --since 2025-04-01 --until 2025-07-01`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		write, ok := auditExporters[auditExportAs]
+		if !ok {
+			return fmt.Errorf("invalid format %q: must be json, csv or cef", auditExportAs)
+		}
+		filter, err := auditFilter.parse(time.Now())
+		if err != nil {
+			return err
+		}
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		entries, err := helper.GetService().Audit().Export(token, filter)
+		if err != nil {
+			return err
+		}
+		if auditOut == "" {
+			return write(os.Stdout, entries)
+		}
+		file, err := os.OpenFile(auditOut, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		if err := write(file, entries); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d audit entries to %s\n", len(entries), auditOut)
+		return nil
+	},
+}
+
+var auditPruneCmd = &cobra.Command{
+	Use:   "prune --older-than <duration>",
+	Short: "Remove rotated audit logs older than a duration",
+	Long: `Remove the rotated logs in the audit/ directory whose entries are all older than
+--older-than, oldest first. audit.log itself is never pruned. audit_head.json
+records the last entry removed, so 'audit verify' still checks the remaining
+chain from its new start. With audit_retention in config.json this happens
+whenever audit.log is rotated.`,
+	Example: `  simple-secrets audit prune --older-than 365d`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if auditOlderThan == "" {
+			return fmt.Errorf("--older-than is required, e.g. --older-than 365d")
+		}
+		olderThan, err := internal.ParseLifetime(auditOlderThan)
+		if err != nil {
+			return fmt.Errorf("--older-than: %w", err)
+		}
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		result, err := helper.GetService().Audit().Prune(token, olderThan)
+		if err != nil {
+			return err
+		}
+		if len(result.Files) == 0 {
+			fmt.Printf("No rotated audit logs older than %s.\n", auditOlderThan)
+			return nil
+		}
+		fmt.Printf("✅ Pruned %d rotated audit log(s) holding %d entries, through entry %d:\n", len(result.Files), result.Entries, result.Through)
+		for _, file := range result.Files {
+			fmt.Printf("   • %s\n", file)
+		}
+		return nil
+	},
+}
+
+// parse turns the filter flags into an AuditFilter
+func (f auditFilterFlags) parse(now time.Time) (internal.AuditFilter, error) {
+	filter := internal.AuditFilter{User: f.user, Key: f.key, Op: f.op, Result: f.result}
+	var err error
+	if f.since != "" {
+		if filter.Since, err = internal.ParseAuditTime(f.since, now); err != nil {
+			return filter, fmt.Errorf("--since: %w", err)
+		}
+	}
+	if f.until != "" {
+		if filter.Until, err = internal.ParseAuditTime(f.until, now); err != nil {
+			return filter, fmt.Errorf("--until: %w", err)
+		}
+	}
+	return filter, nil
+}
+
+// auditExporters writes entries in each 'audit export' format
+var auditExporters = map[string]func(io.Writer, []internal.AuditEntry) error{
+	"json": writeAuditJSONLines,
+	"csv":  writeAuditCSV,
+	"cef":  writeAuditCEF,
+}
+
+// writeAuditTable prints entries in aligned columns, in local time
+func writeAuditTable(w io.Writer, entries []internal.AuditEntry) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TIME\tUSER\tOP\tKEY\tRESULT\tCLIENT")
+	for _, e := range entries {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format("2006-01-02 15:04:05"),
+			orDash(e.User), e.Op, orDash(e.Key), e.Result, auditClientText(e))
+	}
+	return table.Flush()
+}
+
+// auditClientText shows the client with its --client label, if any
+func auditClientText(e internal.AuditEntry) string {
+	if e.Label == "" {
+		return e.Client
+	}
+	return e.Client + " (" + e.Label + ")"
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// writeAuditJSONLines writes entries as audit.log stores them, one per line
+func writeAuditJSONLines(w io.Writer, entries []internal.AuditEntry) error {
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeAuditCSV writes a header row and one row per entry
+func writeAuditCSV(w io.Writer, entries []internal.AuditEntry) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"seq", "time", "user", "client", "label", "op", "key", "result", "prev", "hash"})
+	for _, e := range entries {
+		out.Write([]string{strconv.FormatUint(e.Seq, 10), e.Time.UTC().Format(time.RFC3339Nano),
+			e.User, e.Client, e.Label, e.Op, e.Key, e.Result, e.Prev, e.Hash})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// auditCEFSeverity maps results to CEF severities (0-10)
+var auditCEFSeverity = map[string]int{
+	internal.AuditSuccess:    3,
+	internal.AuditError:      5,
+	internal.AuditDenied:     6,
+	internal.AuditAuthFailed: 7,
+}
+
+// writeAuditCEF writes one Common Event Format event per entry
+func writeAuditCEF(w io.Writer, entries []internal.AuditEntry) error {
+	for _, e := range entries {
+		extension := []string{
+			"rt=" + strconv.FormatInt(e.Time.UnixMilli(), 10),
+			"suser=" + cefValue(e.User),
+			"outcome=" + cefValue(e.Result),
+			"cs1Label=key", "cs1=" + cefValue(e.Key),
+			"cs2Label=client", "cs2=" + cefValue(e.Client),
+		}
+		if e.Label != "" {
+			extension = append(extension, "cs3Label=label", "cs3="+cefValue(e.Label))
+		}
+		extension = append(extension, "cn1Label=seq", "cn1="+strconv.FormatUint(e.Seq, 10))
+		_, err := fmt.Fprintf(w, "CEF:0|simple-secrets|simple-secrets|%s|%s|%s|%d|%s\n",
+			cefHeader(version.Short()), cefHeader(e.Op), cefHeader(e.Op), auditCEFSeverity[e.Result], strings.Join(extension, " "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cefHeader escapes a CEF header field, in which | and \ are special
+func cefHeader(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ").Replace(value)
+}
+
+// cefValue escapes a CEF extension value, in which = and \ are special
+func cefValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd, auditQueryCmd, auditExportCmd, auditPruneCmd)
+
+	auditVerifyCmd.Flags().BoolVar(&auditJSON, "json", false, "print the result as JSON")
+
+	for _, cmd := range []*cobra.Command{auditQueryCmd, auditExportCmd} {
+		cmd.Flags().StringVar(&auditFilter.user, "user", "", "only entries by this user")
+		cmd.Flags().StringVar(&auditFilter.key, "key", "", "only entries on this key; patterns such as 'prod-*' are accepted")
+		cmd.Flags().StringVar(&auditFilter.op, "op", "", "only this operation, e.g. get, put or authenticate")
+		cmd.Flags().StringVar(&auditFilter.result, "result", "", "only this result: success, denied, auth_failed or error")
+		cmd.Flags().StringVar(&auditFilter.since, "since", "", "only entries at or after this time, e.g. 2025-06-01 or 7d")
+		cmd.Flags().StringVar(&auditFilter.until, "until", "", "only entries at or before this time")
+	}
+	auditQueryCmd.Flags().StringVar(&auditFormat, "format", "table", "output format: table, json or csv")
+	auditExportCmd.Flags().StringVar(&auditExportAs, "format", "json", "export format: json, csv or cef")
+	auditExportCmd.Flags().StringVar(&auditOut, "out", "", "write to this file instead of standard output")
+	auditPruneCmd.Flags().StringVar(&auditOlderThan, "older-than", "", "remove rotated logs whose entries are all older than this, e.g. 365d")
+}
@@ -0,0 +1,67 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"simple-secrets/internal"
+)
+
+func TestWriteAuditCEF(t *testing.T) {
+	entries := []internal.AuditEntry{{
+		Seq:    7,
+		Time:   time.UnixMilli(1750000000000),
+		User:   "ci=deploy",
+		Client: `local:svc\ci@build|01`,
+		Op:     "get",
+		Key:    "db_password",
+		Result: internal.AuditDenied,
+	}}
+
+	var out bytes.Buffer
+	if err := writeAuditCEF(&out, entries); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	line := strings.TrimSuffix(out.String(), "\n")
+	if !strings.HasPrefix(line, "CEF:0|simple-secrets|simple-secrets|") || !strings.Contains(line, "|get|get|6|") {
+		t.Fatalf("unexpected header: %s", line)
+	}
+	for _, want := range []string{"rt=1750000000000", `suser=ci\=deploy`, "outcome=denied", "cs1=db_password", `cs2=local:svc\\ci@build|01`, "cn1=7"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("expected %q in %s", want, line)
+		}
+	}
+}
+
+func TestWriteAuditCSV(t *testing.T) {
+	entries := []internal.AuditEntry{{Seq: 1, Time: time.Unix(0, 0), User: "alice", Op: "put", Key: "a,b", Result: internal.AuditSuccess, Hash: "abc"}}
+
+	var out bytes.Buffer
+	if err := writeAuditCSV(&out, entries); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || lines[0] != "seq,time,user,client,label,op,key,result,prev,hash" {
+		t.Fatalf("unexpected CSV:\n%s", out.String())
+	}
+	if lines[1] != `1,1970-01-01T00:00:00Z,alice,,,put,"a,b",success,,abc` {
+		t.Fatalf("unexpected row: %s", lines[1])
+	}
+}
@@ -16,17 +16,14 @@ limitations under the License.
 package cmd
 
 import (
-	"crypto/rand"
-	"encoding/base64"
 	"fmt"
-	"io"
 	"simple-secrets/internal"
 
 	"github.com/spf13/cobra"
 )
 
-// RBACGuard loads users, checks first run, resolves token, and returns (user, store, error)
-func RBACGuard(needWrite bool, cmd *cobra.Command) (*internal.User, *internal.UserStore, error) {
+// RBACGuard loads users, checks first run, resolves token, requires the named permission and returns (user, store, error)
+func RBACGuard(permission string, cmd *cobra.Command) (*internal.User, *internal.UserStore, error) {
 	user, store, err := authenticateUser(cmd)
 	if err != nil {
 		return nil, nil, err
@@ -35,7 +32,7 @@ func RBACGuard(needWrite bool, cmd *cobra.Command) (*internal.User, *internal.Us
 		return nil, nil, nil // First run or empty token
 	}
 
-	if err := authorizeAccess(user, store, needWrite); err != nil {
+	if err := authorizeAccess(user, store, permission); err != nil {
 		return nil, nil, err
 	}
 
@@ -43,7 +40,7 @@ func RBACGuard(needWrite bool, cmd *cobra.Command) (*internal.User, *internal.Us
 }
 
 // AuthenticateWithToken handles authentication for commands with custom token parsing (like put)
-func AuthenticateWithToken(needWrite bool, token string) (*internal.User, *internal.UserStore, error) {
+func AuthenticateWithToken(permission string, token string) (*internal.User, *internal.UserStore, error) {
 	userStore, firstRun, firstRunToken, err := loadUserStoreForAuth()
 	if err != nil {
 		return nil, nil, err
@@ -52,6 +49,7 @@ func AuthenticateWithToken(needWrite bool, token string) (*internal.User, *inter
 	if firstRun {
 		PrintFirstRunMessage()
 		PrintTokenAtEnd(firstRunToken)
+		printRecoveryCodes(userStore.SetupRecoveryCodes())
 		return nil, nil, nil
 	}
 
@@ -65,8 +63,8 @@ func AuthenticateWithToken(needWrite bool, token string) (*internal.User, *inter
 		return nil, nil, err
 	}
 
-	if needWrite && !user.Can("write", userStore.Permissions()) {
-		return nil, nil, NewWritePermissionError()
+	if err := authorizeAccess(user, userStore, permission); err != nil {
+		return nil, nil, err
 	}
 
 	return user, userStore, nil
@@ -123,6 +121,7 @@ func loadUsersWithFirstRunCheck() (*internal.UserStore, bool, error) {
 	if firstRun {
 		PrintFirstRunMessage()
 		PrintTokenAtEnd(token)
+		printRecoveryCodes(userStore.SetupRecoveryCodes())
 		return nil, true, nil
 	}
 	return userStore, false, nil
@@ -140,21 +139,21 @@ func resolveTokenFromCommand(cmd *cobra.Command) (string, error) {
 	return internal.ResolveToken(tokenFlag)
 }
 
-// authorizeAccess checks if the user has the required permissions
-func authorizeAccess(user *internal.User, store *internal.UserStore, needWrite bool) error {
-	if needWrite && !user.Can("write", store.Permissions()) {
-		return NewWritePermissionError()
+// authorizeAccess checks if the user has the named permission
+func authorizeAccess(user *internal.User, store *internal.UserStore, permission string) error {
+	if !user.Can(permission, store.Permissions()) {
+		return NewPermissionDeniedError(permission)
 	}
 	return nil
 }
 
 // GenerateSecureToken generates a cryptographically secure random token
 func GenerateSecureToken() (string, error) {
-	randToken := make([]byte, 20)
-	if _, err := io.ReadFull(rand.Reader, randToken); err != nil {
+	token, err := internal.NewStructuredToken()
+	if err != nil {
 		return "", fmt.Errorf("failed to generate token: %w", err)
 	}
-	return base64.RawURLEncoding.EncodeToString(randToken), nil
+	return token, nil
 }
 
 // ErrAuthenticationRequired returns a standard authentication required error
@@ -162,11 +161,7 @@ var ErrAuthenticationRequired = fmt.Errorf("authentication required: token canno
 
 // Common error constructors to reduce duplication
 func NewPermissionDeniedError(permission string) error {
-	return fmt.Errorf("permission denied: need '%s' permission", permission)
-}
-
-func NewWritePermissionError() error {
-	return fmt.Errorf("permission denied: need 'write'")
+	return internal.NewPermissionDeniedError(permission)
 }
 
 func NewSecretNotFoundError() error {
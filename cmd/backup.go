@@ -0,0 +1,435 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"simple-secrets/internal"
+
+	"github.com/spf13/cobra"
+)
+
+// backupExitFailed is the exit code of 'backup verify' for an archive that fails verification
+const backupExitFailed = 1
+
+// backupPassphraseEnv holds the archive passphrase when --passphrase-file is not given
+const backupPassphraseEnv = "SIMPLE_SECRETS_BACKUP_PASSPHRASE"
+
+var (
+	backupOut            string
+	backupPassphraseFile string
+	backupRecipient      string
+	backupIdentity       string
+	backupJSON           bool
+	backupYes            bool
+	backupDryRun         bool
+)
+
+// backupCmd groups the backup archive and retention commands
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Create, verify and restore encrypted backup archives, and prune old backups",
+	Long: `Create, verify and restore encrypted backup archives (.ssbak) of the whole store:
+master.key, secrets.json, users.json, roles.json, config.json, the other store
+files, per-secret backups and the audit log, with a manifest of their checksums.
+
+An archive is encrypted with a passphrase, read from --passphrase-file or the
+SIMPLE_SECRETS_BACKUP_PASSPHRASE environment variable, or for a recipient public
+key made with 'backup keygen', so that only the holder of the private key can
+open it.
+
+'backup prune' removes old backup directories under backups/ by the retention
+policy in config.json.`,
+}
+
+var backupCreateCmd = &cobra.Command{
+	Use:   "create --out <file.ssbak> [--passphrase-file <file> | --recipient <public key>]",
+	Short: "Write the whole store to an encrypted archive",
+	Long: `Write every store file to a single encrypted archive. Needs an admin token.
+
+The passphrase must be at least 12 characters. With --recipient, the archive is
+encrypted for a public key from 'backup keygen' (given as the key itself or a
+file holding it) and no passphrase is needed.`,
+	Example: `  SIMPLE_SECRETS_BACKUP_PASSPHRASE='correct horse battery' simple-secrets backup create --out nightly.ssbak
+  simple-secrets backup create --out nightly.ssbak --passphrase-file /run/secrets/backup-passphrase
+  simple-secrets backup create --out nightly.ssbak --recipient backup.key.pub`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := backupKeyForCreate()
+		if err != nil {
+			return err
+		}
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		manifest, err := helper.GetService().Backups().Create(token, backupOut, key)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Backup written to %s: %d file(s), %d secret(s)\n", backupOut, len(manifest.Files), manifest.Secrets)
+		return nil
+	},
+}
+
+var backupVerifyCmd = &cobra.Command{
+	Use:   "verify <file.ssbak>",
+	Short: "Check that an archive opens and matches its manifest",
+	Long: `Decrypt an archive and check every file against the manifest's checksums,
+that the archive and its files are in formats this version can read, and that
+every secret decrypts with the archived master key. Nothing is changed, and no
+token is needed, so archives can be checked on another machine.
+
+Exit codes: 0 valid, 1 failed verification.`,
+	Example: `  simple-secrets backup verify nightly.ssbak
+  simple-secrets backup verify nightly.ssbak --identity backup.key --json`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := backupKeyForOpen()
+		if err != nil {
+			return err
+		}
+		result, _, err := internal.VerifyBackupArchive(args[0], key)
+		if err != nil {
+			return err
+		}
+		if err := printBackupVerification(args[0], result, backupJSON); err != nil {
+			return err
+		}
+		if result.OK() {
+			return nil
+		}
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+		return &ExitCodeError{Code: backupExitFailed}
+	},
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore <file.ssbak> [--yes]",
+	Short: "Replace the whole store with an archive",
+	Long: `Replace the store with the contents of an archive. The archive is verified
+first, and the current store files are copied to backups/pre-restore-<time>/
+before anything is replaced. Store files the archive does not hold are removed,
+so the store ends up exactly as it was backed up, including users and tokens.
+
+Needs an admin token, and is subject to two-person approval like
+restore-database. On a machine that has not been set up, the archive is
+restored without a token.`,
+	Example: `  simple-secrets backup restore nightly.ssbak
+  simple-secrets backup restore nightly.ssbak --identity backup.key --yes`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := backupKeyForOpen()
+		if err != nil {
+			return err
+		}
+		firstRun, err := internal.IsFirstRun()
+		if err != nil {
+			return err
+		}
+
+		if !backupYes && !confirmBackupRestore(args[0]) {
+			fmt.Println("Aborted.")
+			return nil
+		}
+
+		var result *internal.BackupRestore
+		if firstRun {
+			usersPath, err := internal.DefaultUserConfigPath("users.json")
+			if err != nil {
+				return err
+			}
+			result, err = internal.RestoreBackupArchive(filepath.Dir(usersPath), args[0], key)
+			if err != nil {
+				return fmt.Errorf("restore failed: %w", err)
+			}
+		} else {
+			helper, token, err := serviceCommandSetup(cmd)
+			if err != nil {
+				return err
+			}
+			approved, err := requireApproval(cmd, helper, internal.OpRestoreDatabase, filepath.Base(args[0]))
+			if err != nil {
+				return err
+			}
+			result, err = helper.GetService().Backups().Restore(token, args[0], key)
+			approved(err)
+			if err != nil {
+				return fmt.Errorf("restore failed: %w", err)
+			}
+		}
+
+		fmt.Printf("✅ Restored %d file(s) and %d secret(s) from %s (created %s", len(result.Manifest.Files),
+			result.Manifest.Secrets, args[0], result.Manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+		if result.Manifest.CreatedBy != "" {
+			fmt.Printf(" by %s", result.Manifest.CreatedBy)
+		}
+		fmt.Println(")")
+		for _, removed := range result.Removed {
+			fmt.Printf("   Removed %s, which the archive does not hold\n", removed)
+		}
+		if result.Snapshot != "" {
+			fmt.Printf("Your previous store was copied to %s\n", result.Snapshot)
+		}
+		return nil
+	},
+}
+
+var backupKeygenCmd = &cobra.Command{
+	Use:   "keygen --out <file>",
+	Short: "Create a key pair for archives that need no passphrase",
+	Long: `Create a key pair for 'backup create --recipient'. The private key is written
+to a new file readable only by you, and the public key to <file>.pub and the
+screen. Archives created for the public key are opened with
+'--identity <file>'; keep the private key away from the machine making backups.`,
+	Example: `  simple-secrets backup keygen --out backup.key`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		public, private, err := internal.GenerateBackupKeyPair()
+		if err != nil {
+			return err
+		}
+		if err := writeNewKeyFile(backupOut, private, 0600); err != nil {
+			return err
+		}
+		if err := writeNewKeyFile(backupOut+".pub", public, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Private key written to %s\n", backupOut)
+		fmt.Printf("Public key written to %s:\n\n%s\n", backupOut+".pub", public)
+		return nil
+	},
+}
+
+var backupPruneCmd = &cobra.Command{
+	Use:   "prune [--dry-run] [--json]",
+	Short: "Remove the backup directories the retention policy does not keep",
+	Long: `Remove the rotate-, manual- and pre-restore- directories under backups/ that
+the backup_retention policy in config.json does not keep. Needs an admin token.
+
+The policy keeps the newest backup of each of the last N hours, days, weeks and
+months that have one, and every backup younger than min_age; rules under
+"types" replace it for one type. The newest backup of each type is always kept.
+Without backup_retention, the rotation_backup_count newest rotate- and manual-
+backups are kept and pre-restore- backups are left alone. The same pruning runs
+after every master key rotation.
+
+See 'simple-secrets config' for the settings.`,
+	Example: `  simple-secrets backup prune --dry-run
+  simple-secrets backup prune`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		plan, err := helper.GetService().Backups().Prune(token, backupDryRun)
+		if err != nil {
+			return err
+		}
+		if backupJSON {
+			return writeReportJSON(os.Stdout, plan)
+		}
+		return writeBackupPrunePlan(os.Stdout, plan)
+	},
+}
+
+// writeBackupPrunePlan prints each backup with whether it is kept and why, then a summary
+func writeBackupPrunePlan(w io.Writer, plan *internal.BackupPrunePlan) error {
+	if len(plan.Backups) == 0 {
+		_, err := fmt.Fprintln(w, "No backups.")
+		return err
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "BACKUP\tTYPE\tCREATED\tACTION\tKEPT FOR")
+	for _, entry := range plan.Backups {
+		created := "?"
+		if !entry.Time.IsZero() {
+			created = entry.Time.Local().Format("2006-01-02 15:04:05")
+		}
+		action := "keep"
+		if !entry.Keep() {
+			action = "remove"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", entry.Name, entry.Type, created, action, orDash(strings.Join(entry.Reasons, ",")))
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	verb := "Removed"
+	if plan.DryRun {
+		verb = "Would remove"
+	}
+	_, err := fmt.Fprintf(w, "\n%s %d of %d backup(s), by %s.\n", verb, len(plan.Removed()), len(plan.Backups), plan.Policy)
+	return err
+}
+
+// backupKeyForCreate returns the passphrase or recipient to encrypt a new archive with
+func backupKeyForCreate() (internal.BackupKey, error) {
+	if backupRecipient != "" {
+		if backupPassphraseFile != "" {
+			return internal.BackupKey{}, fmt.Errorf("use either --passphrase-file or --recipient, not both")
+		}
+		recipient, err := readKeyArgument(backupRecipient)
+		return internal.BackupKey{Recipient: recipient}, err
+	}
+	passphrase, err := readBackupPassphrase()
+	if err != nil {
+		return internal.BackupKey{}, err
+	}
+	if passphrase == "" {
+		return internal.BackupKey{}, fmt.Errorf("a passphrase is required: use --passphrase-file, %s or --recipient", backupPassphraseEnv)
+	}
+	return internal.BackupKey{Passphrase: passphrase}, nil
+}
+
+// backupKeyForOpen returns the passphrase and private key given to open an archive
+func backupKeyForOpen() (internal.BackupKey, error) {
+	var key internal.BackupKey
+	if backupIdentity != "" {
+		data, err := os.ReadFile(backupIdentity)
+		if err != nil {
+			return key, fmt.Errorf("read --identity: %w", err)
+		}
+		key.Identity = strings.TrimSpace(string(data))
+	}
+	passphrase, err := readBackupPassphrase()
+	key.Passphrase = passphrase
+	return key, err
+}
+
+// readBackupPassphrase reads --passphrase-file, or the environment variable
+func readBackupPassphrase() (string, error) {
+	if backupPassphraseFile == "" {
+		return os.Getenv(backupPassphraseEnv), nil
+	}
+	data, err := os.ReadFile(backupPassphraseFile)
+	if err != nil {
+		return "", fmt.Errorf("read --passphrase-file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// readKeyArgument accepts a public key itself or the path of a file holding one
+func readKeyArgument(value string) (string, error) {
+	if strings.HasPrefix(value, "ssbak-pub-") {
+		return value, nil
+	}
+	data, err := os.ReadFile(value)
+	if err != nil {
+		return "", fmt.Errorf("read --recipient: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writeNewKeyFile writes a key to a new file; an existing file is never overwritten
+func writeNewKeyFile(path, key string, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists; keygen does not overwrite files", path)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(file, key); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// confirmBackupRestore asks before the store is replaced
+func confirmBackupRestore(archive string) bool {
+	fmt.Printf("Will restore the whole store from %s\n", archive)
+	fmt.Println("\nThis will:")
+	fmt.Println("  • Copy your current store to backups/pre-restore-<time>/")
+	fmt.Println("  • Replace every secret, user, role and setting with the archive's")
+	fmt.Print("Proceed? (type 'yes'): ")
+
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(strings.ToLower(line)) == "yes"
+}
+
+// printBackupVerification prints the result as JSON or as a summary with each problem
+func printBackupVerification(archive string, result *internal.BackupVerification, asJSON bool) error {
+	if asJSON {
+		encoded, err := json.MarshalIndent(struct {
+			Valid bool `json:"valid"`
+			*internal.BackupVerification
+		}{result.OK(), result}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(encoded))
+		return nil
+	}
+
+	manifest := result.Manifest
+	if !result.OK() {
+		fmt.Printf("❌ Backup %s failed verification: %d problem(s)\n", archive, len(result.Problems))
+		for _, problem := range result.Problems {
+			fmt.Printf("   • %s\n", problem)
+		}
+		return nil
+	}
+	fmt.Printf("✅ Backup %s is valid\n", archive)
+	fmt.Printf("  Created: %s", manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	if manifest.CreatedBy != "" {
+		fmt.Printf(" by %s", manifest.CreatedBy)
+	}
+	fmt.Println()
+	fmt.Printf("  Format version: %d\n", manifest.Version)
+	fmt.Printf("  Encryption: %s\n", result.Encryption)
+	fmt.Printf("  Files: %d, secrets: %d\n", len(manifest.Files), manifest.Secrets)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	backupCmd.AddCommand(backupCreateCmd, backupVerifyCmd, backupRestoreCmd, backupKeygenCmd, backupPruneCmd)
+
+	backupCreateCmd.Flags().StringVar(&backupOut, "out", "", "write the archive to this file, e.g. nightly.ssbak")
+	backupCreateCmd.MarkFlagRequired("out")
+	backupCreateCmd.Flags().StringVar(&backupRecipient, "recipient", "", "encrypt for this public key from 'backup keygen', or a file holding it")
+	backupKeygenCmd.Flags().StringVar(&backupOut, "out", "", "write the private key to this new file, and the public key to <file>.pub")
+	backupKeygenCmd.MarkFlagRequired("out")
+
+	for _, cmd := range []*cobra.Command{backupCreateCmd, backupVerifyCmd, backupRestoreCmd} {
+		cmd.Flags().StringVar(&backupPassphraseFile, "passphrase-file", "", "read the archive passphrase from this file (or set "+backupPassphraseEnv+")")
+	}
+	for _, cmd := range []*cobra.Command{backupVerifyCmd, backupRestoreCmd} {
+		cmd.Flags().StringVar(&backupIdentity, "identity", "", "private key file, for archives created with --recipient")
+	}
+	for _, cmd := range []*cobra.Command{backupVerifyCmd, backupPruneCmd} {
+		cmd.Flags().BoolVar(&backupJSON, "json", false, "print the result as JSON")
+	}
+	backupPruneCmd.Flags().BoolVar(&backupDryRun, "dry-run", false, "show what would be removed without removing anything")
+	backupRestoreCmd.Flags().BoolVar(&backupYes, "yes", false, "skip the confirmation prompt")
+}
@@ -56,43 +56,55 @@ func NewCLIServiceHelper() (*CLIServiceHelper, error) {
 	}, nil
 }
 
-// AuthenticateCommand handles authentication for cobra commands
+// AuthenticateCommand authenticates the command's token and requires the named permission
 // Returns (user, userStore, error) compatible with existing CLI code
-func (csh *CLIServiceHelper) AuthenticateCommand(cmd *cobra.Command, needWrite bool) (*internal.User, *internal.UserStore, error) {
+func (csh *CLIServiceHelper) AuthenticateCommand(cmd *cobra.Command, permission string) (*internal.User, *internal.UserStore, error) {
 	token, err := resolveTokenFromCommand(cmd)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// Resolve the token first (CLI responsibility, not service responsibility)
-	resolvedToken, err := internal.ResolveToken(token)
-	if err != nil {
-		return nil, nil, err
+	return csh.AuthenticateToken(token, permission)
+}
+
+// AuthenticateStoreCommand is AuthenticateCommand for commands that act on the whole store
+// rather than on particular keys, which scoped tokens are never allowed to run
+func (csh *CLIServiceHelper) AuthenticateStoreCommand(cmd *cobra.Command, permission string) (*internal.User, *internal.UserStore, error) {
+	user, store, err := csh.AuthenticateCommand(cmd, permission)
+	if err != nil || user == nil {
+		return user, store, err
+	}
+	if user.Scopes != nil {
+		return nil, nil, fmt.Errorf("%w: this token is limited to its scopes and cannot run '%s'", internal.ErrPermissionDenied, cmd.CommandPath())
 	}
+	return user, store, nil
+}
 
-	// Use focused auth operations
-	user, err := csh.service.Auth().ValidateToken(resolvedToken)
+// Authenticate identifies the command's user without requiring a permission, for commands
+// that decide which permission applies only once they know who is calling
+func (csh *CLIServiceHelper) Authenticate(cmd *cobra.Command) (*internal.User, *internal.UserStore, error) {
+	token, err := resolveTokenFromCommand(cmd)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// Check permissions
-	if err := csh.service.Auth().ValidateAccess(resolvedToken, needWrite); err != nil {
+	// Resolve the token first (CLI responsibility, not service responsibility)
+	resolvedToken, err := internal.ResolveToken(token)
+	if err != nil {
 		return nil, nil, err
 	}
 
-	// For CLI compatibility, get UserStore through service layer
-	userStore := csh.service.GetUserStore()
-	if userStore == nil {
-		return nil, nil, fmt.Errorf("failed to get user store from service")
+	user, err := csh.service.Auth().ValidateToken(resolvedToken)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return user, userStore, nil
+	return csh.userStoreFor(user)
 }
 
 // AuthenticateToken handles authentication with a direct token (for custom parsing like put command)
 // Returns (user, userStore, error) compatible with existing CLI code
-func (csh *CLIServiceHelper) AuthenticateToken(token string, needWrite bool) (*internal.User, *internal.UserStore, error) {
+func (csh *CLIServiceHelper) AuthenticateToken(token string, permission string) (*internal.User, *internal.UserStore, error) {
 	// Resolve the token first (CLI responsibility)
 	resolvedToken, err := internal.ResolveToken(token)
 	if err != nil {
@@ -100,17 +112,16 @@ func (csh *CLIServiceHelper) AuthenticateToken(token string, needWrite bool) (*i
 	}
 
 	// Use focused auth operations
-	user, err := csh.service.Auth().ValidateToken(resolvedToken)
+	user, err := csh.service.Auth().Authorize(resolvedToken, permission)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// Check permissions
-	if err := csh.service.Auth().ValidateAccess(resolvedToken, needWrite); err != nil {
-		return nil, nil, err
-	}
+	return csh.userStoreFor(user)
+}
 
-	// For CLI compatibility, get UserStore through service layer
+// userStoreFor pairs an authenticated user with the UserStore for CLI compatibility
+func (csh *CLIServiceHelper) userStoreFor(user *internal.User) (*internal.User, *internal.UserStore, error) {
 	userStore := csh.service.GetUserStore()
 	if userStore == nil {
 		return nil, nil, fmt.Errorf("failed to get user store from service")
@@ -60,15 +60,71 @@ Available Configuration Options:
    Range: 1-10 (recommended)
    Note: Individual secret backups are always 1 by design. This only affects master key rotation.
 
+3. max_token_age (duration, optional, default: no limit)
+   Description: Reject tokens older than this, counted from when they were created or last rotated
+   Example: "max_token_age": "90d"
+   Format: whole days (90d) or Go durations (12h, 30m)
+   Note: Tokens rejected this way fail with "token expired"; 'list users --stale' shows them.
+
+4. token_expiry_warning (duration, optional, default: 7d)
+   Description: How long before a token expires every command starts printing a notice
+   Example: "token_expiry_warning": "14d"
+
+5. auth_backoff_after (integer, optional, default: 3)
+   Description: Failed sign-ins in a row, per client or user, before further attempts back off
+   Example: "auth_backoff_after": 5
+   Note: The first backoff is 1 second and doubles with each further failure. 0 disables backoff.
+
+6. auth_lockout_threshold (integer, optional, default: 10)
+   Description: Failed sign-ins in a row that lock out the client and the user the tokens named
+   Example: "auth_lockout_threshold": 5
+   Note: 0 disables failed sign-in tracking. 'list users' shows lockouts; 'user unlock' clears them.
+
+7. auth_lockout_duration (duration, optional, default: 15m)
+   Description: How long a lockout lasts, and how long failed sign-ins are remembered
+   Example: "auth_lockout_duration": "1h"
+
+8. audit_rotate_size_mb (integer, optional, default: 10)
+   Description: Move audit.log into the audit/ directory once it reaches this size
+   Example: "audit_rotate_size_mb": 50
+   Note: 0 disables rotation by size. The hash chain continues into the next file.
+
+9. audit_rotate_after (duration, optional, default: 30d)
+   Description: Move audit.log into the audit/ directory once its first entry is this old
+   Example: "audit_rotate_after": "7d"
+
+10. audit_retention (duration, optional, default: keep forever)
+   Description: Remove rotated audit logs older than this whenever audit.log is rotated
+   Example: "audit_retention": "365d"
+   Note: 'simple-secrets audit prune --older-than' does the same on demand.
+
+11. backup_retention (object, optional, default: keep rotation_backup_count backups)
+   Description: How many rotate-, manual- and pre-restore- backups to keep in backups/
+   Example: "backup_retention": {"hourly": 24, "daily": 7, "weekly": 4, "monthly": 12, "min_age": "1d",
+                                 "types": {"pre-restore": {"daily": 3}}}
+   Note: Keeps the newest backup of each of the last N hours, days, weeks and months, and every
+         backup younger than min_age. A rule under "types" replaces the top-level rule for that
+         type. Applied after each master key rotation; 'backup prune --dry-run' shows what goes.
+
+12. version (integer, managed automatically)
+   Description: Format version of this file, written by setup and 'simple-secrets migrate'
+   Note: Do not change it by hand. Files with a newer version than this binary supports are refused.
+
+Which operations need a second admin's approval is not set here but sealed in approvals.json;
+see 'simple-secrets approvals set'.
+
 Example config.json:
 -------------------
 {
-  "rotation_backup_count": 1
+  "version": 1,
+  "rotation_backup_count": 1,
+  "max_token_age": "90d"
 }
 
 Example with token (not recommended):
 ------------------------------------
 {
+  "version": 1,
   "token": "your-token-here",
   "rotation_backup_count": 2
 }
@@ -19,6 +19,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"simple-secrets/internal"
@@ -28,9 +29,9 @@ import (
 
 var createUserCmd = &cobra.Command{
 	Use:     "create-user [username] [role]",
-	Short:   "Create a new user (admin or reader).",
-	Long:    "Create a new user and generate a secure token. Admins can manage users and secrets; readers can only view secrets.",
-	Example: "simple-secrets create-user alice reader\nsimple-secrets create-user bob admin",
+	Short:   "Create a new user with a role.",
+	Long:    "Create a new user and generate a secure token. The built-in roles are admin (manages users and secrets) and reader (views secrets); custom roles are defined with 'simple-secrets role create'.",
+	Example: "simple-secrets create-user alice reader\nsimple-secrets create-user bob admin\nsimple-secrets create-user ci ci-writer",
 	Args:    cobra.MaximumNArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Check if token flag was explicitly set to empty string
@@ -56,7 +57,7 @@ var createUserCmd = &cobra.Command{
 		}
 
 		// Collect user input
-		userInput, err := collectUserInput(args)
+		userInput, err := collectUserInput(args, availableRoleNames(helper))
 		if err != nil {
 			return err
 		}
@@ -79,7 +80,7 @@ type UserInput struct {
 }
 
 // collectUserInput gathers username and role from args or interactive prompts
-func collectUserInput(args []string) (*UserInput, error) {
+func collectUserInput(args []string, roleNames []string) (*UserInput, error) {
 	reader := bufio.NewReader(os.Stdin)
 	var username string
 	var roleStr string
@@ -99,12 +100,12 @@ func collectUserInput(args []string) (*UserInput, error) {
 		roleStr = args[1]
 	}
 	if roleStr == "" {
-		fmt.Print("Role (admin/reader): ")
+		fmt.Printf("Role (%s): ", strings.Join(roleNames, "/"))
 		roleStr, _ = reader.ReadString('\n')
 		roleStr = strings.TrimSpace(roleStr)
 	}
 
-	role, err := parseRole(roleStr)
+	role, err := parseRole(roleStr, roleNames)
 	if err != nil {
 		return nil, err
 	}
@@ -115,16 +116,21 @@ func collectUserInput(args []string) (*UserInput, error) {
 	}, nil
 }
 
-// parseRole converts a role string to the appropriate Role type
-func parseRole(roleStr string) (internal.Role, error) {
-	switch roleStr {
-	case "admin":
-		return internal.RoleAdmin, nil
-	case "reader":
-		return internal.RoleReader, nil
-	default:
-		return "", fmt.Errorf("invalid role: must be 'admin' or 'reader'")
+// parseRole checks that a role string names one of the defined roles
+func parseRole(roleStr string, roleNames []string) (internal.Role, error) {
+	if !slices.Contains(roleNames, roleStr) {
+		return "", fmt.Errorf("invalid role %q: must be one of %s", roleStr, strings.Join(roleNames, ", "))
 	}
+	return internal.Role(roleStr), nil
+}
+
+// availableRoleNames lists the roles defined in roles.json
+func availableRoleNames(helper *CLIServiceHelper) []string {
+	store := helper.GetService().GetUserStore()
+	if store == nil {
+		return []string{string(internal.RoleAdmin), string(internal.RoleReader)}
+	}
+	return store.Roles().RoleNames()
 }
 
 // printUserCreationSuccess displays the success message with the new token
@@ -141,8 +147,12 @@ func completeCreateUserArgs(cmd *cobra.Command, args []string, toComplete string
 	}
 
 	if len(args) == 1 {
-		// Second argument: role - suggest valid roles
-		return []string{"admin", "reader"}, cobra.ShellCompDirectiveNoFileComp
+		// Second argument: role - suggest the defined roles
+		helper, err := GetCLIServiceHelper()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return availableRoleNames(helper), cobra.ShellCompDirectiveNoFileComp
 	}
 
 	return nil, cobra.ShellCompDirectiveNoFileComp
@@ -0,0 +1,115 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"simple-secrets/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var describeJSON bool
+
+var describeCmd = &cobra.Command{
+	Use:   "describe <key>",
+	Short: "Show a secret's state and how it is read, without its value",
+	Long: `Show a secret's state and how it has been used: the number of reads, when and
+by whom it was last read, the --client labels it was read with, and when it
+was last written. Needs permission to list the key.
+
+Usage is counted from the first command run with this version, shown as
+"Tracked since".`,
+	Example: `  simple-secrets describe db_password
+  simple-secrets describe db_password --json`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		description, err := helper.GetService().Secrets().Describe(token, args[0])
+		if errors.Is(err, os.ErrNotExist) {
+			return NewSecretNotFoundError()
+		}
+		if err != nil {
+			return err
+		}
+
+		if describeJSON {
+			encoded, err := json.MarshalIndent(description, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(encoded))
+			return nil
+		}
+		printSecretDescription(description)
+		return nil
+	},
+}
+
+func printSecretDescription(d *internal.SecretDescription) {
+	fmt.Printf("🔑 %s\n", d.Key)
+	fmt.Printf("  State: %s\n", d.State)
+	if d.State == internal.SecretStateDisabled {
+		fmt.Printf("  Disabled: %s\n", formatDisabledSecretOrigin(internal.DisabledSecret{Key: d.Key, DisabledAt: d.DisabledAt, DisabledBy: d.DisabledBy}))
+		if d.Reason != "" {
+			fmt.Printf("  Reason: %s\n", d.Reason)
+		}
+	}
+	backup := "no"
+	if d.HasBackup {
+		backup = "yes (restore with 'simple-secrets restore secret " + d.Key + "')"
+	}
+	fmt.Printf("  Previous version: %s\n", backup)
+
+	fmt.Println()
+	fmt.Printf("  Reads: %d\n", d.Usage.Reads)
+	lastRead := formatTokenTime(d.Usage.LastReadAt)
+	if d.Usage.LastReadBy != "" {
+		lastRead += " by " + d.Usage.LastReadBy
+	}
+	if d.Usage.LastClient != "" {
+		lastRead += " (" + d.Usage.LastClient + ")"
+	}
+	fmt.Printf("  Last read: %s\n", lastRead)
+	if len(d.Usage.ReadsByClient) > 0 {
+		clients := make([]string, 0, len(d.Usage.ReadsByClient))
+		for client := range d.Usage.ReadsByClient {
+			clients = append(clients, client)
+		}
+		sort.Strings(clients)
+		fmt.Println("  Reads by client:")
+		for _, client := range clients {
+			fmt.Printf("    %s: %d\n", client, d.Usage.ReadsByClient[client])
+		}
+	}
+	fmt.Printf("  Last written: %s\n", formatTokenTime(d.Usage.LastWrittenAt))
+	fmt.Printf("  Tracked since: %s\n", d.TrackedSince.Local().Format("2006-01-02 15:04:05"))
+}
+
+func init() {
+	rootCmd.AddCommand(describeCmd)
+	describeCmd.Flags().BoolVar(&describeJSON, "json", false, "print the description as JSON")
+	describeCmd.ValidArgsFunction = completeSecretNames
+}
@@ -25,6 +25,9 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// disableReason is recorded with a disabled user or secret (--reason)
+var disableReason string
+
 // disableCmd represents the disable command
 var disableCmd = &cobra.Command{
 	Use:   "disable [user|token|secret] [username|token|key]",
@@ -35,10 +38,14 @@ var disableCmd = &cobra.Command{
   • secret <key>        - Mark a secret as disabled
 
 Disabled tokens cannot be used for authentication.
-Disabled secrets are hidden from normal operations but can be re-enabled.`,
+Disabled secrets are hidden from normal operations but can be re-enabled.
+When a user or secret is disabled, the time, the disabling user and an
+optional --reason are recorded; 'list users' and 'list disabled' show them.`,
 	Example: `  simple-secrets disable user alice            # Disable alice's token by username
   simple-secrets disable token abc123def456    # Disable specific token by value
-  simple-secrets disable secret api-key        # Disable a secret`,
+  simple-secrets disable secret api-key        # Disable a secret
+  simple-secrets disable secret api-key --reason "leaked in CI logs"
+  simple-secrets disable user alice --reason "left the team"`,
 	Args: cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Check if token flag was explicitly set to empty string
@@ -46,6 +53,10 @@ Disabled secrets are hidden from normal operations but can be re-enabled.`,
 			return ErrAuthenticationRequired
 		}
 
+		if disableReason != "" && args[0] == "token" {
+			return fmt.Errorf("--reason can only be used with 'disable user' or 'disable secret'")
+		}
+
 		switch args[0] {
 		case "user":
 			return disableUser(cmd, args[1])
@@ -66,7 +77,7 @@ func disableUser(cmd *cobra.Command, username string) error {
 	}
 
 	// Check permissions first before showing confirmation prompt
-	currentUser, store, err := helper.AuthenticateCommand(cmd, true)
+	currentUser, _, err := helper.AuthenticateCommand(cmd, internal.PermManageUsers)
 	if err != nil {
 		return err
 	}
@@ -74,10 +85,6 @@ func disableUser(cmd *cobra.Command, username string) error {
 		return nil
 	}
 
-	if !currentUser.Can("manage-users", store.Permissions()) {
-		return NewPermissionDeniedError("manage-users")
-	}
-
 	// Show detailed help for the disable user operation
 	fmt.Println("⚠️  User Token Disable Operation")
 	fmt.Println("• This will disable the specified user's authentication token immediately")
@@ -103,11 +110,14 @@ func disableUser(cmd *cobra.Command, username string) error {
 	}
 
 	// Disable by username
-	if err := helper.GetService().Users().DisableUser(resolvedToken, username); err != nil {
+	if err := helper.GetService().Users().DisableUser(resolvedToken, username, disableReason); err != nil {
 		return err
 	}
 
 	fmt.Printf("✅ Token disabled for user '%s'\n", username)
+	if disableReason != "" {
+		fmt.Printf("• Reason: %s\n", disableReason)
+	}
 	fmt.Println("• The user can no longer authenticate with their current token")
 	fmt.Println("• Use 'enable user' to generate a new token for this user")
 	return nil
@@ -120,7 +130,7 @@ func disableTokenByValue(cmd *cobra.Command, tokenValue string) error {
 	}
 
 	// Check permissions first before showing confirmation prompt
-	currentUser, store, err := helper.AuthenticateCommand(cmd, true)
+	currentUser, _, err := helper.AuthenticateCommand(cmd, internal.PermManageUsers)
 	if err != nil {
 		return err
 	}
@@ -128,10 +138,6 @@ func disableTokenByValue(cmd *cobra.Command, tokenValue string) error {
 		return nil
 	}
 
-	if !currentUser.Can("manage-users", store.Permissions()) {
-		return NewPermissionDeniedError("manage-users")
-	}
-
 	// Show detailed help for the disable token operation
 	fmt.Println("⚠️  Token Disable Operation")
 	fmt.Println("• This will disable the specified token immediately")
@@ -195,12 +201,15 @@ func disableSecret(cmd *cobra.Command, key string) error {
 	}
 
 	// Use service layer for secret disabling
-	err = helper.GetService().Secrets().Disable(resolvedToken, key)
+	err = helper.GetService().Secrets().Disable(resolvedToken, key, disableReason)
 	if err != nil {
 		return err
 	}
 
 	fmt.Printf("✅ Secret '%s' has been disabled\n", key)
+	if disableReason != "" {
+		fmt.Printf("• Reason: %s\n", disableReason)
+	}
 	fmt.Println("• The secret is hidden from normal operations")
 	fmt.Println("• Use 'enable secret' to re-enable this secret")
 	return nil
@@ -256,6 +265,8 @@ func completeDisableArgs(cmd *cobra.Command, args []string, toComplete string) (
 func init() {
 	rootCmd.AddCommand(disableCmd)
 
+	disableCmd.Flags().StringVar(&disableReason, "reason", "", "Reason for disabling a user or secret (recorded and shown by 'list users' or 'list disabled')")
+
 	// Add custom completion for disable command
 	disableCmd.ValidArgsFunction = completeDisableArgs
 }
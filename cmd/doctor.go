@@ -0,0 +1,173 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"simple-secrets/internal"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes reported by doctor for monitoring
+const (
+	doctorExitWarnings = 1
+	doctorExitErrors   = 2
+)
+
+var (
+	doctorJSON bool
+	doctorFix  bool
+)
+
+// doctorCmd checks the integrity and health of the local store
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the integrity and health of the secrets store",
+	Long: `Check the integrity and health of the secrets store:
+
+  • file permissions are 0600 and directory permissions 0700
+  • master.key is valid and every secret and .bak backup decrypts with it
+  • rotation backups are complete and decrypt with their own key
+  • no orphaned .tmp.* or .lock files are left behind
+  • disabled entries can be parsed
+  • users.json and roles.json follow the user rules and their seals are intact
+  • config.json is valid
+
+doctor reads the files directly and needs no token, so it keeps working when
+users.json itself is damaged. Nothing is changed unless --fix is given, which
+only applies safe repairs: tightening permissions and removing orphaned
+temporary and lock files.
+
+Exit codes: 0 healthy, 1 warnings only, 2 errors.`,
+	Example: `  simple-secrets doctor
+  simple-secrets doctor --json
+  simple-secrets doctor --fix`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		usersPath, err := internal.DefaultUserConfigPath("users.json")
+		if err != nil {
+			return err
+		}
+
+		report, err := internal.RunDoctor(filepath.Dir(usersPath), doctorFix)
+		if err != nil {
+			return err
+		}
+
+		if err := printDoctorReport(report, doctorJSON); err != nil {
+			return err
+		}
+		return doctorExitStatus(cmd, report)
+	},
+}
+
+// printDoctorReport prints the report as JSON or grouped by check
+func printDoctorReport(report *internal.DoctorReport, asJSON bool) error {
+	if asJSON {
+		encoded, err := json.MarshalIndent(struct {
+			Status internal.DoctorSeverity `json:"status"`
+			*internal.DoctorReport
+		}{report.Status(), report}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(encoded))
+		return nil
+	}
+
+	fmt.Printf("🩺 Checking %s\n\n", report.ConfigDir)
+	errorCount, warningCount, fixable := 0, 0, 0
+	for _, check := range report.Checks {
+		findings := report.FindingsFor(check)
+		fmt.Printf("%s %s\n", doctorCheckIcon(findings), check)
+
+		for _, finding := range findings {
+			fmt.Printf("   • %s: %s%s\n", doctorDisplayPath(report.ConfigDir, finding.Path), finding.Message, doctorFixNote(finding))
+			if finding.Fixed {
+				continue
+			}
+			if finding.Fixable {
+				fixable++
+			}
+			if finding.Severity == internal.DoctorError {
+				errorCount++
+				continue
+			}
+			warningCount++
+		}
+	}
+
+	fmt.Printf("\n%d error(s), %d warning(s)\n", errorCount, warningCount)
+	if fixable > 0 {
+		fmt.Printf("%d problem(s) can be repaired with 'simple-secrets doctor --fix'\n", fixable)
+	}
+	return nil
+}
+
+// doctorCheckIcon summarizes a check by its most severe outstanding finding
+func doctorCheckIcon(findings []internal.DoctorFinding) string {
+	report := internal.DoctorReport{Findings: findings}
+	switch report.Status() {
+	case internal.DoctorError:
+		return "❌"
+	case internal.DoctorWarning:
+		return "⚠️ "
+	}
+	return "✅"
+}
+
+// doctorDisplayPath shows paths relative to the config directory
+func doctorDisplayPath(configDir, path string) string {
+	rel, err := filepath.Rel(configDir, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+func doctorFixNote(finding internal.DoctorFinding) string {
+	if finding.Fixed {
+		return " (fixed)"
+	}
+	if finding.Fixable {
+		return " (fixable with --fix)"
+	}
+	return ""
+}
+
+// doctorExitStatus turns an unhealthy report into a non-zero exit code without extra output
+func doctorExitStatus(cmd *cobra.Command, report *internal.DoctorReport) error {
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+
+	switch report.Status() {
+	case internal.DoctorError:
+		return &ExitCodeError{Code: doctorExitErrors}
+	case internal.DoctorWarning:
+		return &ExitCodeError{Code: doctorExitWarnings}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().BoolVar(&doctorJSON, "json", false, "print the report as JSON")
+	doctorCmd.Flags().BoolVar(&doctorFix, "fix", false, "apply safe repairs (permissions, orphaned temp and lock files)")
+}
@@ -62,7 +62,7 @@ func enableUser(cmd *cobra.Command, username string) error {
 	}
 
 	// Check permissions first
-	currentUser, store, err := helper.AuthenticateCommand(cmd, true)
+	currentUser, _, err := helper.AuthenticateCommand(cmd, internal.PermManageUsers)
 	if err != nil {
 		return err
 	}
@@ -70,10 +70,6 @@ func enableUser(cmd *cobra.Command, username string) error {
 		return nil
 	}
 
-	if !currentUser.Can("manage-users", store.Permissions()) {
-		return NewPermissionDeniedError("manage-users")
-	}
-
 	// Show help for the enable user operation
 	fmt.Println("⚠️  User Enable Operation")
 	fmt.Println("• This will generate a new authentication token for the specified user")
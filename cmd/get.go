@@ -24,11 +24,13 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var getField string
+
 var getCmd = &cobra.Command{
 	Use:     "get [key]",
 	Short:   "Retrieve a secret.",
-	Long:    "Retrieve the value for a given secret key.",
-	Example: "simple-secrets get db_password",
+	Long:    "Retrieve the value for a given secret key.\n\nUse --field to read a single field of a structured secret (stored with put --json, --from-file or --set).",
+	Example: "simple-secrets get db_password\nsimple-secrets get db-creds --field password",
 	Args:    cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Get CLI service helper
@@ -51,7 +53,7 @@ var getCmd = &cobra.Command{
 
 		// Get secret using focused service operations
 		key := args[0]
-		value, err := helper.GetService().Secrets().Get(resolvedToken, key)
+		value, err := getSecretOrField(helper.GetService(), resolvedToken, key, getField)
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) {
 				return NewSecretNotFoundError()
@@ -64,7 +66,16 @@ var getCmd = &cobra.Command{
 	},
 }
 
+// getSecretOrField returns the whole secret, or a single field when one is requested
+func getSecretOrField(service *internal.Service, token, key, field string) (string, error) {
+	if field == "" {
+		return service.Secrets().Get(token, key)
+	}
+	return service.Secrets().GetField(token, key, field)
+}
+
 func init() {
+	getCmd.Flags().StringVar(&getField, "field", "", "return a single field of a structured secret")
 	rootCmd.AddCommand(getCmd)
 
 	// Add completion for secret names
@@ -0,0 +1,168 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var groupRoles []string
+
+// groupCmd groups the group management operations
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Manage groups of users (admin only)",
+	Long: `Groups give their members roles and path policies in one place. A member
+keeps their own role and also holds every role of their groups, and policies
+written for a group (policy allow --group) apply to all of its members.
+'list users' shows each user's groups and effective permissions.
+
+Groups are stored in groups.json next to users.json. A group that makes the
+last admin an admin cannot be deleted, nor can that admin be removed from it.`,
+}
+
+var groupListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List groups with their roles and members",
+	Example: `  simple-secrets group list`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		groups, err := helper.GetService().Groups().ListGroups(token)
+		if err != nil {
+			return err
+		}
+		if len(groups) == 0 {
+			fmt.Println("No groups defined. Create one with 'simple-secrets group create <name> --role <role>'.")
+			return nil
+		}
+
+		fmt.Printf("Found %d group(s):\n\n", len(groups))
+		for _, group := range groups {
+			roles := make([]string, len(group.Roles))
+			for i, role := range group.Roles {
+				roles[i] = string(role)
+			}
+			fmt.Printf("  👥 %s\n", group.Name)
+			fmt.Printf("    Roles: %s\n", joinOrNone(roles))
+			fmt.Printf("    Members: %s\n\n", joinOrNone(group.Members))
+		}
+		return nil
+	},
+}
+
+var groupCreateCmd = &cobra.Command{
+	Use:   "create <name> [--role <role>]...",
+	Short: "Create a group",
+	Example: `  simple-secrets group create payments --role ci-writer
+  simple-secrets group create ops --role admin`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := helper.GetService().Groups().CreateGroup(token, args[0], groupRoles); err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Group %q created with roles: %s\n", args[0], joinOrNone(groupRoles))
+		return nil
+	},
+}
+
+var groupDeleteCmd = &cobra.Command{
+	Use:     "delete <name>",
+	Short:   "Delete a group; its members keep their own roles",
+	Example: `  simple-secrets group delete payments`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := helper.GetService().Groups().DeleteGroup(token, args[0]); err != nil {
+			return err
+		}
+
+		fmt.Printf("🗑️  Group %q deleted.\n", args[0])
+		return nil
+	},
+}
+
+var groupAddMemberCmd = &cobra.Command{
+	Use:     "add-member <group> <username>",
+	Short:   "Add a user to a group",
+	Example: `  simple-secrets group add-member payments alice`,
+	Args:    cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := helper.GetService().Groups().AddMember(token, args[0], args[1]); err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ User %q added to group %q.\n", args[1], args[0])
+		return nil
+	},
+}
+
+var groupRemoveMemberCmd = &cobra.Command{
+	Use:     "remove-member <group> <username>",
+	Short:   "Remove a user from a group",
+	Example: `  simple-secrets group remove-member payments alice`,
+	Args:    cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := helper.GetService().Groups().RemoveMember(token, args[0], args[1]); err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ User %q removed from group %q.\n", args[1], args[0])
+		return nil
+	},
+}
+
+// joinOrNone joins values for display, showing "none" for an empty list
+func joinOrNone(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
+
+func init() {
+	rootCmd.AddCommand(groupCmd)
+	groupCmd.AddCommand(groupListCmd, groupCreateCmd, groupDeleteCmd, groupAddMemberCmd, groupRemoveMemberCmd)
+
+	groupCreateCmd.Flags().StringSliceVar(&groupRoles, "role", nil, "role the group's members inherit, comma-separated or repeated")
+}
@@ -16,8 +16,13 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"os"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 
 	"simple-secrets/internal"
@@ -25,26 +30,46 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	listStale bool
+	listAll   bool
+)
+
 // listNewCmd represents the new consolidated list command
 var listCmd = &cobra.Command{
-	Use:   "list [keys|backups|users|disabled]",
-	Short: "List secrets, backups, users, or disabled secrets",
+	Use:   "list [keys|backups|users|disabled|approvals|fields] [key]",
+	Short: "List secrets, backups, users, disabled secrets, approval requests, or fields of a secret",
 	Long: `List different types of data in the system:
-  • keys     - List all stored secret keys
-  • backups  - List available rotation backups
-  • users    - List all users in the system
-  • disabled - List all disabled secrets`,
+  • keys         - List all stored secret keys
+  • backups      - List available rotation backups
+  • users        - List all users in the system (--stale: tokens expired or near expiry)
+  • disabled     - List all disabled secrets
+  • approvals    - List approval requests waiting for a decision or execution (--all: every request)
+  • fields <key> - List the field names of a structured secret`,
 	Example: `  simple-secrets list keys
   simple-secrets list backups
   simple-secrets list users
-  simple-secrets list disabled`,
-	Args: cobra.ExactArgs(1),
+  simple-secrets list users --stale
+  simple-secrets list disabled
+  simple-secrets list approvals
+  simple-secrets list fields db-creds`,
+	Args: cobra.RangeArgs(1, 2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Check if token flag was explicitly set to empty string
 		if flag := cmd.Flag("token"); flag != nil && flag.Changed && TokenFlag == "" {
 			return ErrAuthenticationRequired
 		}
 
+		if args[0] != "fields" && len(args) > 1 {
+			return fmt.Errorf("list %s does not accept additional arguments", args[0])
+		}
+		if listStale && args[0] != "users" {
+			return fmt.Errorf("--stale only applies to 'list users'")
+		}
+		if listAll && args[0] != "approvals" {
+			return fmt.Errorf("--all only applies to 'list approvals'")
+		}
+
 		switch args[0] {
 		case "keys":
 			return listKeys(cmd)
@@ -54,8 +79,15 @@ var listCmd = &cobra.Command{
 			return listUsers(cmd)
 		case "disabled":
 			return listDisabledSecrets(cmd)
+		case "approvals":
+			return listApprovals(cmd)
+		case "fields":
+			if len(args) < 2 {
+				return fmt.Errorf("list fields requires a key name")
+			}
+			return listFields(cmd, args[1])
 		default:
-			return NewUnknownTypeError("list", args[0], "'keys', 'backups', 'users', or 'disabled'")
+			return NewUnknownTypeError("list", args[0], "'keys', 'backups', 'users', 'disabled', 'approvals', or 'fields'")
 		}
 	},
 }
@@ -102,7 +134,7 @@ func listBackups(cmd *cobra.Command) error {
 		return err
 	}
 
-	user, _, err := helper.AuthenticateCommand(cmd, false)
+	user, _, err := helper.AuthenticateStoreCommand(cmd, internal.PermRead)
 	if err != nil {
 		return err
 	}
@@ -116,6 +148,7 @@ func listBackups(cmd *cobra.Command) error {
 	}
 
 	backups, err := store.ListRotationBackups()
+	helper.GetService().Audit().Record(user, "list-backups", "", err)
 	if err != nil {
 		return err
 	}
@@ -142,27 +175,27 @@ func listUsers(cmd *cobra.Command) error {
 		return err
 	}
 
-	user, store, err := helper.AuthenticateCommand(cmd, true)
+	user, store, err := helper.AuthenticateCommand(cmd, internal.PermManageUsers)
 	if err != nil {
 		return err
 	}
 	if user == nil {
 		return nil
 	}
-	if !user.Can("manage-users", store.Permissions()) {
-		return NewPermissionDeniedError("manage-users")
+	helper.GetService().Audit().Record(user, "list-users", "", nil)
+	if listStale {
+		printStaleTokens(store)
+		return nil
 	}
 
-	// Load current users
-	usersPath, err := internal.DefaultUserConfigPath("users.json")
-	if err != nil {
-		return err
-	}
-	users, err := internal.LoadUsersList(usersPath)
+	failedUsers, failedClients, err := store.FailedAuthentications()
 	if err != nil {
 		return err
 	}
 
+	// Current users, with the groups they belong to applied
+	users := slices.Clone(store.Users())
+
 	fmt.Printf("Found %d user(s):\n\n", len(users))
 
 	// Sort users by username for consistent output
@@ -170,10 +203,11 @@ func listUsers(cmd *cobra.Command) error {
 		return users[i].Username < users[j].Username
 	})
 
+	now := time.Now()
 	for _, u := range users {
 		// User icon based on role
 		icon := "👤"
-		if u.Role == internal.RoleAdmin {
+		if u.Can(internal.PermManageUsers, store.Permissions()) {
 			icon = "🔑"
 		}
 
@@ -185,15 +219,100 @@ func listUsers(cmd *cobra.Command) error {
 
 		fmt.Printf("  %s %s%s\n", icon, u.Username, currentUserIndicator)
 		fmt.Printf("    Role: %s\n", u.Role)
+		if len(u.Groups) > 0 {
+			fmt.Printf("    Groups: %s\n", strings.Join(u.Groups, ", "))
+		}
+		fmt.Printf("    Effective permissions: %s\n", formatEffectivePermissions(u, store.Permissions()))
+		fmt.Printf("    Status: %s\n", formatUserStatus(u))
+		if u.Contact != "" {
+			fmt.Printf("    Contact: %s\n", u.Contact)
+		}
+		if u.Description != "" {
+			fmt.Printf("    Description: %s\n", u.Description)
+		}
 
 		// Display token rotation timestamp or legacy user indicator
 		fmt.Printf("    Token last rotated: %s\n", getTokenRotationDisplay(u.TokenRotatedAt))
+		if len(u.Tokens) > 0 {
+			fmt.Printf("    Named tokens: %d (see 'token list --user %s')\n", len(u.Tokens), u.Username)
+		}
+		for _, p := range u.PendingGrace(time.Now()) {
+			fmt.Printf("    Grace: previous %q token valid until %s (%s)\n", u.TokenDisplayName(p.Token), formatTokenTime(&p.ExpiresAt), p.Access)
+		}
+		if f, ok := failedUsers[u.Username]; ok {
+			fmt.Printf("    Failed sign-ins: %s\n", formatAuthFailure(f, now))
+		}
+		fmt.Println()
+	}
+
+	if len(failedClients) > 0 {
+		clients := slices.Sorted(maps.Keys(failedClients))
+		fmt.Printf("Clients with failed sign-ins:\n\n")
+		for _, client := range clients {
+			fmt.Printf("  %s: %s\n", client, formatAuthFailure(failedClients[client], now))
+		}
 		fmt.Println()
 	}
 
 	return nil
 }
 
+// formatAuthFailure describes a user's or client's failed sign-ins and any lockout or backoff
+func formatAuthFailure(f *internal.AuthFailure, now time.Time) string {
+	display := fmt.Sprintf("%d in a row, last at %s", f.Failures, formatTokenTime(&f.LastFailure))
+	if f.LastClient != "" {
+		display += " from " + f.LastClient
+	}
+	until, locked := f.Blocked(now)
+	switch {
+	case locked:
+		return fmt.Sprintf("🔒 locked out until %s (%s), see 'user unlock'", formatTokenTime(until), display)
+	case until != nil:
+		return fmt.Sprintf("backing off until %s (%s)", formatTokenTime(until), display)
+	}
+	return display
+}
+
+// formatEffectivePermissions lists the permissions a user holds through their role and groups,
+// naming the roles inherited from groups
+func formatEffectivePermissions(u *internal.User, perms internal.RolePermissions) string {
+	display := joinOrNone(u.EffectivePermissions(perms))
+	if len(u.GroupRoles) == 0 {
+		return display
+	}
+	inherited := make([]string, len(u.GroupRoles))
+	for i, role := range u.GroupRoles {
+		inherited[i] = string(role)
+	}
+	return fmt.Sprintf("%s (with group roles: %s)", display, strings.Join(inherited, ", "))
+}
+
+// printStaleTokens reports tokens past or within the warning period of their expiry or max_token_age
+func printStaleTokens(store *internal.UserStore) {
+	lifetime := store.TokenLifetime()
+	now := time.Now()
+	stale := store.StaleTokens(now)
+	if len(stale) == 0 {
+		fmt.Printf("No tokens are expired or expire within %s.\n", internal.FormatRemaining(lifetime.WarningPeriod))
+		return
+	}
+
+	fmt.Printf("Found %d stale token(s):\n\n", len(stale))
+	for _, t := range stale {
+		switch {
+		case t.ExpiresAt == nil:
+			fmt.Printf("  ❓ %s / %s: age unknown (issued before rotation times were recorded); rotate it to apply max_token_age\n", t.Username, t.Token)
+		case t.Expired:
+			fmt.Printf("  ⛔ %s / %s: expired %s ago (%s)\n", t.Username, t.Token, internal.FormatRemaining(now.Sub(*t.ExpiresAt)), formatTokenTime(t.ExpiresAt))
+		default:
+			fmt.Printf("  ⚠️  %s / %s: expires in %s (%s)\n", t.Username, t.Token, internal.FormatRemaining(t.ExpiresAt.Sub(now)), formatTokenTime(t.ExpiresAt))
+		}
+	}
+	if lifetime.MaxAge > 0 {
+		fmt.Printf("\nmax_token_age is %s; rotate tokens with 'simple-secrets rotate token <username> [--name <token>]'.\n", internal.FormatRemaining(lifetime.MaxAge))
+	}
+}
+
 func listDisabledSecrets(cmd *cobra.Command) error {
 	// RBAC: read access
 	helper, err := GetCLIServiceHelper()
@@ -201,28 +320,28 @@ func listDisabledSecrets(cmd *cobra.Command) error {
 		return err
 	}
 
-	user, _, err := helper.AuthenticateCommand(cmd, false)
+	token, err := resolveTokenFromCommand(cmd)
 	if err != nil {
 		return err
 	}
-	if user == nil {
-		return nil
-	}
 
-	store, err := internal.LoadSecretsStore(internal.NewFilesystemBackend())
+	// The service hides disabled secrets that policies don't let the caller list
+	disabledSecrets, err := helper.GetService().Secrets().ListDisabledDetails(token)
 	if err != nil {
 		return err
 	}
-
-	disabledSecrets := store.ListDisabledSecrets()
 	if len(disabledSecrets) == 0 {
 		fmt.Println("No disabled secrets found.")
 		return nil
 	}
 
 	fmt.Printf("Disabled secrets (%d):\n", len(disabledSecrets))
-	for _, key := range disabledSecrets {
-		fmt.Printf("  🚫 %s\n", key)
+	for _, secret := range disabledSecrets {
+		fmt.Printf("  🚫 %s\n", secret.Key)
+		fmt.Printf("    Disabled: %s\n", formatDisabledSecretOrigin(secret))
+		if secret.Reason != "" {
+			fmt.Printf("    Reason: %s\n", secret.Reason)
+		}
 	}
 	fmt.Println()
 	fmt.Println("Use 'enable secret <key>' to re-enable a disabled secret.")
@@ -230,11 +349,78 @@ func listDisabledSecrets(cmd *cobra.Command) error {
 	return nil
 }
 
+// formatDisabledSecretOrigin describes when and by whom a secret was disabled.
+// Secrets migrated from the legacy format may have neither recorded.
+func formatDisabledSecretOrigin(secret internal.DisabledSecret) string {
+	when := "unknown time"
+	if secret.DisabledAt != nil {
+		when = secret.DisabledAt.Local().Format("2006-01-02 15:04:05")
+	}
+	if secret.DisabledBy == "" {
+		return when
+	}
+	return fmt.Sprintf("%s by %s", when, secret.DisabledBy)
+}
+
+// formatUserStatus describes a user's account status, with when, by whom and why it was
+// disabled or locked
+func formatUserStatus(u *internal.User) string {
+	status := u.AccountStatus()
+	if status == internal.StatusActive {
+		return string(status)
+	}
+	when := "unknown time"
+	if u.DisabledAt != nil {
+		when = u.DisabledAt.Local().Format("2006-01-02 15:04:05")
+	}
+	if u.DisabledBy != "" {
+		when += " by " + u.DisabledBy
+	}
+	if u.DisabledReason != "" {
+		when += ": " + u.DisabledReason
+	}
+	return fmt.Sprintf("%s (%s)", status, when)
+}
+
+func listFields(cmd *cobra.Command, key string) error {
+	helper, err := GetCLIServiceHelper()
+	if err != nil {
+		return err
+	}
+
+	token, err := resolveTokenFromCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	resolvedToken, err := internal.ResolveToken(token)
+	if err != nil {
+		return err
+	}
+
+	fields, err := helper.GetService().Secrets().ListFields(resolvedToken, key)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return NewSecretNotFoundError()
+		}
+		return err
+	}
+
+	for _, field := range fields {
+		fmt.Println(field)
+	}
+	return nil
+}
+
 // completeListArgs provides completion for list command arguments
 func completeListArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 	if len(args) == 0 {
 		// First argument: suggest list types
-		return []string{"keys", "backups", "users", "disabled"}, cobra.ShellCompDirectiveNoFileComp
+		return []string{"keys", "backups", "users", "disabled", "approvals", "fields"}, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	if len(args) == 1 && args[0] == "fields" {
+		return completeSecretNames(cmd, args, toComplete)
 	}
 
 	return nil, cobra.ShellCompDirectiveNoFileComp
@@ -242,6 +428,8 @@ func completeListArgs(cmd *cobra.Command, args []string, toComplete string) ([]s
 
 func init() {
 	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().BoolVar(&listStale, "stale", false, "with 'users', list tokens that expired or expire within the warning period")
+	listCmd.Flags().BoolVar(&listAll, "all", false, "with 'approvals', include rejected, executed and expired requests")
 
 	// Add custom completion for list command
 	listCmd.ValidArgsFunction = completeListArgs
@@ -0,0 +1,113 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"simple-secrets/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var migrateDryRun bool
+
+// migrateCmd upgrades persisted files to the current on-disk format
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade stored files to the current on-disk format",
+	Long: `Upgrade secrets.json, users.json, roles.json and config.json to the format
+version this binary writes. Each file is upgraded one version at a time, and a
+copy of the file is saved under backups/migrations/ before every step.
+
+Older formats are still read transparently, so migrating is never required to
+keep working; secrets.json is migrated automatically the first time it is opened.
+A file written by a newer version of simple-secrets is refused rather than
+rewritten - upgrade the binary instead.
+
+Use --dry-run to see (and validate) the pending steps without writing anything.`,
+	Example: `  simple-secrets migrate --dry-run
+  simple-secrets migrate`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, err := GetCLIServiceHelper()
+		if err != nil {
+			return err
+		}
+
+		user, _, err := helper.AuthenticateCommand(cmd, internal.PermManageUsers)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return nil
+		}
+
+		usersPath, err := internal.DefaultUserConfigPath("users.json")
+		if err != nil {
+			return err
+		}
+
+		results, err := internal.MigrateConfigDir(filepath.Dir(usersPath), migrateDryRun)
+		if !migrateDryRun {
+			helper.GetService().Audit().Record(user, "migrate", "", err)
+		}
+		printMigrationResults(results, migrateDryRun)
+		return err
+	},
+}
+
+// printMigrationResults shows each file's version and the steps planned or applied
+func printMigrationResults(results []internal.FileMigration, dryRun bool) {
+	header := "Migration results:"
+	if dryRun {
+		header = "Migration plan (dry run - nothing will be written):"
+	}
+	fmt.Println(header)
+
+	pending := 0
+	for _, result := range results {
+		if result.UpToDate() {
+			fmt.Printf("  ✅ %s: version %d (up to date)\n", result.File, result.ToVersion)
+			continue
+		}
+
+		pending++
+		fmt.Printf("  🔄 %s: version %d -> %d\n", result.File, result.FromVersion, result.ToVersion)
+		for _, step := range result.Steps {
+			fmt.Printf("     • %s\n", step)
+		}
+		for _, backup := range result.Backups {
+			fmt.Printf("     backup: %s\n", backup)
+		}
+	}
+
+	if pending == 0 {
+		fmt.Println("All files are already in the current format.")
+		return
+	}
+	if dryRun {
+		fmt.Printf("%d file(s) would be migrated. Run 'simple-secrets migrate' to apply.\n", pending)
+		return
+	}
+	fmt.Printf("%d file(s) migrated.\n", pending)
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "show and validate pending migrations without writing anything")
+}
@@ -0,0 +1,222 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"simple-secrets/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	policyUser    string
+	policyGroup   string
+	policyRole    string
+	policyActions []string
+	policyKeys    []string
+	policyKey     string
+	policyAction  string
+)
+
+// policyCmd groups the path-scoped access policy commands
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Manage path-scoped access policies (admin only)",
+	Long: `Policies allow or deny the read, write and list actions on keys matching
+glob patterns (*, ? and [...]), for a single user, for the members of a group
+or for everyone with a role (including roles inherited from a group).
+
+Evaluation:
+  • The user's role must hold the permission for the action (read for read/list, write for write)
+  • A matching deny policy always wins (deny-overrides)
+  • Once any allow policy applies to a user, their groups or their roles, only the keys it matches are accessible
+  • Users without allow policies keep the access their role grants
+
+'list keys' only shows keys the caller may list.`,
+}
+
+var policyListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List policies",
+	Example: `  simple-secrets policy list`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		policies, err := helper.GetService().Policies().ListPolicies(token)
+		if err != nil {
+			return err
+		}
+
+		if len(policies) == 0 {
+			fmt.Println("No policies defined; every user has the access their role grants.")
+			return nil
+		}
+		fmt.Printf("Policies (%d):\n\n", len(policies))
+		for _, policy := range policies {
+			fmt.Printf("  %s %s: %s\n", policyEffectIcon(policy.Effect), policy.ID, policy)
+		}
+		return nil
+	},
+}
+
+var policyAllowCmd = &cobra.Command{
+	Use:   "allow (--user <name> | --group <name> | --role <name>) --actions <action,...> --keys <pattern,...>",
+	Short: "Allow actions on matching keys",
+	Example: `  simple-secrets policy allow --role ci-writer --actions read,list --keys 'payments-*'
+  simple-secrets policy allow --user alice --actions read,write,list --keys 'hr-*'
+  simple-secrets policy allow --group payments --actions read,list --keys 'payments-*'`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return addPolicy(cmd, internal.PolicyAllow)
+	},
+}
+
+var policyDenyCmd = &cobra.Command{
+	Use:     "deny (--user <name> | --group <name> | --role <name>) --actions <action,...> --keys <pattern,...>",
+	Short:   "Deny actions on matching keys, overriding any allow",
+	Example: `  simple-secrets policy deny --role reader --actions read,list --keys 'hr-*'`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return addPolicy(cmd, internal.PolicyDeny)
+	},
+}
+
+var policyRemoveCmd = &cobra.Command{
+	Use:     "remove <id>",
+	Short:   "Remove a policy",
+	Example: `  simple-secrets policy remove p2`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := helper.GetService().Policies().RemovePolicy(token, args[0]); err != nil {
+			return err
+		}
+
+		fmt.Printf("🗑️  Policy %s removed.\n", args[0])
+		return nil
+	},
+}
+
+var policyTestCmd = &cobra.Command{
+	Use:   "test --user <name> --key <key> [--action <action>]",
+	Short: "Explain whether a user may act on a key",
+	Example: `  simple-secrets policy test --user ci --key payments-db
+  simple-secrets policy test --user ci --key hr-db --action read`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		actions := internal.PolicyActions()
+		if policyAction != "" {
+			actions = []string{policyAction}
+		}
+
+		decisions, err := helper.GetService().Policies().TestPolicy(token, policyUser, policyKey, actions)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Policy decision for user %q on key %q:\n", policyUser, policyKey)
+		for _, decision := range decisions {
+			icon := "❌"
+			if decision.Allowed {
+				icon = "✅"
+			}
+			fmt.Printf("  %s %-6s %s\n", icon, decision.Action, decision.Reason)
+			if decision.Policy != nil {
+				fmt.Printf("           %s: %s\n", decision.Policy.ID, decision.Policy)
+			}
+		}
+		return nil
+	},
+}
+
+// addPolicy creates a policy with the given effect from the command's flags
+func addPolicy(cmd *cobra.Command, effect string) error {
+	var subjects []string
+	if policyUser != "" {
+		subjects = append(subjects, internal.UserSubject(policyUser))
+	}
+	if policyGroup != "" {
+		subjects = append(subjects, internal.GroupSubject(policyGroup))
+	}
+	if policyRole != "" {
+		subjects = append(subjects, internal.RoleSubject(policyRole))
+	}
+	if len(subjects) != 1 {
+		return fmt.Errorf("specify exactly one of --user, --group or --role")
+	}
+	subject := subjects[0]
+
+	helper, token, err := serviceCommandSetup(cmd)
+	if err != nil {
+		return err
+	}
+
+	policy, err := helper.GetService().Policies().AddPolicy(token, internal.Policy{
+		Effect:  effect,
+		Subject: subject,
+		Actions: policyActions,
+		Keys:    policyKeys,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Policy %s added: %s\n", policy.ID, policy)
+	return nil
+}
+
+func policyEffectIcon(effect string) string {
+	if effect == internal.PolicyDeny {
+		return "⛔"
+	}
+	return "✅"
+}
+
+func init() {
+	rootCmd.AddCommand(policyCmd)
+	policyCmd.AddCommand(policyListCmd, policyAllowCmd, policyDenyCmd, policyRemoveCmd, policyTestCmd)
+
+	for _, cmd := range []*cobra.Command{policyAllowCmd, policyDenyCmd} {
+		cmd.Flags().StringVar(&policyUser, "user", "", "apply the policy to this user")
+		cmd.Flags().StringVar(&policyGroup, "group", "", "apply the policy to the members of this group")
+		cmd.Flags().StringVar(&policyRole, "role", "", "apply the policy to everyone with this role")
+		cmd.Flags().StringSliceVar(&policyActions, "actions", nil, "actions to cover: read, write, list")
+		cmd.Flags().StringSliceVar(&policyKeys, "keys", nil, "key glob patterns, comma-separated or repeated")
+		cmd.MarkFlagRequired("actions")
+		cmd.MarkFlagRequired("keys")
+	}
+
+	policyTestCmd.Flags().StringVar(&policyUser, "user", "", "user to evaluate")
+	policyTestCmd.Flags().StringVar(&policyKey, "key", "", "key to evaluate")
+	policyTestCmd.Flags().StringVar(&policyAction, "action", "", "evaluate a single action instead of all of them")
+	policyTestCmd.MarkFlagRequired("user")
+	policyTestCmd.MarkFlagRequired("key")
+}
@@ -574,3 +574,95 @@ func stringSlicesEqual(a, b []string) bool {
 	}
 	return true
 }
+
+func TestExtractStructuredFlags(t *testing.T) {
+	remaining, options, err := extractStructuredFlags([]string{
+		"db-creds", "--set", "password=x", "--token", "tok", "--set", "host=db2",
+	})
+	if err != nil {
+		t.Fatalf("extractStructuredFlags() unexpected error: %v", err)
+	}
+	if !stringSlicesEqual(remaining, []string{"db-creds", "--token", "tok"}) {
+		t.Errorf("extractStructuredFlags() remaining = %v", remaining)
+	}
+	if !stringSlicesEqual(options.setFields, []string{"password=x", "host=db2"}) {
+		t.Errorf("extractStructuredFlags() setFields = %v", options.setFields)
+	}
+
+	_, options, err = extractStructuredFlags([]string{"db-creds", "--json", `{"a":"b"}`})
+	if err != nil {
+		t.Fatalf("extractStructuredFlags() unexpected error: %v", err)
+	}
+	if options.jsonValue != `{"a":"b"}` || !options.isRequested() {
+		t.Errorf("extractStructuredFlags() jsonValue = %q", options.jsonValue)
+	}
+
+	if _, _, err := extractStructuredFlags([]string{"db-creds", "--from-file"}); err == nil {
+		t.Error("extractStructuredFlags() expected error for missing flag value")
+	}
+}
+
+func TestValidateStructuredPutArguments(t *testing.T) {
+	tests := []struct {
+		name         string
+		filteredArgs []string
+		generate     bool
+		options      structuredPutOptions
+		errContains  string
+	}{
+		{
+			name:         "json_with_key",
+			filteredArgs: []string{"db-creds"},
+			options:      structuredPutOptions{jsonValue: `{"a":"b"}`},
+		},
+		{
+			name:         "json_and_file",
+			filteredArgs: []string{"db-creds"},
+			options:      structuredPutOptions{jsonValue: `{}`, fromFile: "creds.yaml"},
+			errContains:  "cannot combine --json and --from-file",
+		},
+		{
+			name:         "set_with_json",
+			filteredArgs: []string{"db-creds"},
+			options:      structuredPutOptions{jsonValue: `{}`, setFields: []string{"a=b"}},
+			errContains:  "cannot combine --set",
+		},
+		{
+			name:         "with_generate",
+			filteredArgs: []string{"db-creds"},
+			generate:     true,
+			options:      structuredPutOptions{setFields: []string{"a=b"}},
+			errContains:  "cannot combine --generate",
+		},
+		{
+			name:         "missing_key",
+			filteredArgs: []string{},
+			options:      structuredPutOptions{fromFile: "creds.yaml"},
+			errContains:  "requires key argument",
+		},
+		{
+			name:         "extra_plain_value",
+			filteredArgs: []string{"db-creds", "value"},
+			options:      structuredPutOptions{fromFile: "creds.yaml"},
+			errContains:  "cannot provide a plain value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := validateStructuredPutArguments(tt.filteredArgs, tt.generate, tt.options)
+			if tt.errContains == "" {
+				if err != nil {
+					t.Fatalf("validateStructuredPutArguments() unexpected error: %v", err)
+				}
+				if key != tt.filteredArgs[0] {
+					t.Errorf("validateStructuredPutArguments() key = %q", key)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("validateStructuredPutArguments() error = %v, want containing %q", err, tt.errContains)
+			}
+		})
+	}
+}
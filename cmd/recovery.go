@@ -0,0 +1,191 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"simple-secrets/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	recoverCode string
+	recoverUser string
+)
+
+// recoverCmd groups the recovery operations that work without a token
+var recoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Regain access with a recovery code",
+}
+
+var recoverAdminCmd = &cobra.Command{
+	Use:   "admin --code <code> [--user <admin>]",
+	Short: "Give an admin a new token using a one-time recovery code",
+	Long: `Use one of the recovery codes shown at setup to give an admin a new token when
+every admin token is lost. No token is needed. The admin's previous token stops
+working, a disabled or locked account is made active again, and the code cannot
+be used again.
+
+Every recovery is recorded in recovery_codes.json and shown by
+'simple-secrets recovery-codes status'. Wrong codes count as failed sign-ins.
+Without --code the code is read from standard input, which keeps it out of
+shell history. --user is needed when there is more than one admin.`,
+	Example: `  simple-secrets recover admin --code abcd-efgh-ijkl-mnop
+  simple-secrets recover admin --user ops < code.txt`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		code := recoverCode
+		if code == "" {
+			fmt.Fprint(os.Stderr, "Recovery code: ")
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf("no recovery code given: use --code or standard input")
+			}
+			code = strings.TrimSpace(line)
+		}
+
+		helper, err := GetCLIServiceHelper()
+		if err != nil {
+			return err
+		}
+		recovery, err := helper.GetService().Recovery().RecoverAdmin(code, recoverUser)
+		if err != nil {
+			return err
+		}
+
+		printRecoveryWarning(recovery)
+		fmt.Printf("\n✅ Admin %q recovered.\n", recovery.Username)
+		fmt.Printf("Token: %s\n", recovery.Token)
+		fmt.Println("Store this token securely. It will not be shown again.")
+		return nil
+	},
+}
+
+// printRecoveryWarning announces a recovery on standard error, where it shows up in the
+// terminal and in the logs of whatever ran the command
+func printRecoveryWarning(recovery *internal.AdminRecovery) {
+	banner := strings.Repeat("!", 60)
+	fmt.Fprintln(os.Stderr, banner)
+	fmt.Fprintf(os.Stderr, "⚠️  ADMIN RECOVERY: a recovery code was used to give %q a new token\n", recovery.Username)
+	fmt.Fprintf(os.Stderr, "   at %s by %s\n", time.Now().UTC().Format(time.RFC3339), internal.LocalAuthClient())
+	fmt.Fprintf(os.Stderr, "   The previous token of %q no longer works.\n", recovery.Username)
+	fmt.Fprintf(os.Stderr, "   %d recovery code(s) left", recovery.Remaining)
+	if recovery.Remaining <= 2 {
+		fmt.Fprint(os.Stderr, "; run 'simple-secrets recovery-codes regenerate' soon")
+	}
+	fmt.Fprintln(os.Stderr, ".")
+	fmt.Fprintln(os.Stderr, "   If you did not do this, someone holds a recovery code: rotate every admin token")
+	fmt.Fprintln(os.Stderr, "   and regenerate the recovery codes.")
+	fmt.Fprintln(os.Stderr, banner)
+}
+
+// recoveryCodesCmd groups the management of recovery codes
+var recoveryCodesCmd = &cobra.Command{
+	Use:   "recovery-codes",
+	Short: "Manage the admin recovery codes (admin only)",
+	Long: `Recovery codes let 'simple-secrets recover admin' give an admin a new token
+when every admin token is lost. Setup creates ten codes; each works once. Only
+their hashes are stored, in recovery_codes.json, which is sealed like users.json.`,
+}
+
+var recoveryCodesRegenerateCmd = &cobra.Command{
+	Use:   "regenerate",
+	Short: "Replace every recovery code with a new set",
+	Long: `Create ten new recovery codes. The old codes, used or not, stop working.
+The new codes are shown once; store them offline, away from the admin tokens.`,
+	Example: `  simple-secrets recovery-codes regenerate`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		codes, err := helper.GetService().Recovery().RegenerateCodes(token)
+		if err != nil {
+			return err
+		}
+		fmt.Println("✅ New recovery codes created. The previous codes no longer work.")
+		printRecoveryCodes(codes)
+		return nil
+	},
+}
+
+var recoveryCodesStatusCmd = &cobra.Command{
+	Use:     "status",
+	Short:   "Show how many recovery codes are left and every recovery made",
+	Example: `  simple-secrets recovery-codes status`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		status, err := helper.GetService().Recovery().Status(token)
+		if err != nil {
+			return err
+		}
+		if status.GeneratedAt == nil {
+			fmt.Println("No recovery codes. Create them with 'simple-secrets recovery-codes regenerate'.")
+		} else {
+			by := "setup"
+			if status.GeneratedBy != "" {
+				by = status.GeneratedBy
+			}
+			fmt.Printf("Recovery codes: %d unused, created %s by %s\n",
+				status.Remaining, status.GeneratedAt.Local().Format("2006-01-02 15:04"), by)
+		}
+
+		if len(status.Recoveries) == 0 {
+			fmt.Println("No recoveries made.")
+			return nil
+		}
+		fmt.Printf("\n⚠️  %d recovery(ies) made:\n", len(status.Recoveries))
+		for _, event := range status.Recoveries {
+			fmt.Printf("  %s  %s  from %s\n", event.At.Local().Format("2006-01-02 15:04:05"), event.Username, event.Client)
+		}
+		return nil
+	},
+}
+
+// printRecoveryCodes shows recovery codes once, with how to keep them
+func printRecoveryCodes(codes []string) {
+	fmt.Println("\n" + strings.Repeat("=", 50))
+	fmt.Println("RECOVERY CODES (each works once):")
+	for _, code := range codes {
+		fmt.Printf("  %s\n", code)
+	}
+	fmt.Println(strings.Repeat("=", 50))
+	fmt.Println("Store these offline, away from your token. If every admin token is lost,")
+	fmt.Println("'simple-secrets recover admin --code <code>' gives an admin a new token.")
+}
+
+func init() {
+	rootCmd.AddCommand(recoverCmd, recoveryCodesCmd)
+	recoverCmd.AddCommand(recoverAdminCmd)
+	recoveryCodesCmd.AddCommand(recoveryCodesRegenerateCmd, recoveryCodesStatusCmd)
+
+	recoverAdminCmd.Flags().StringVar(&recoverCode, "code", "", "recovery code; read from standard input if omitted")
+	recoverAdminCmd.Flags().StringVar(&recoverUser, "user", "", "admin to recover, if there is more than one")
+}
@@ -0,0 +1,279 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"simple-secrets/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportFormat     string
+	reportNamespaces []string
+	reportUnusedFor  string
+)
+
+// reportCmd groups the reports prepared for access reviews
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Reports for access reviews",
+	Long: `Reports for access reviews and audits: who can reach which secrets, gathered
+from users.json, roles.json, groups.json and policies.json, and which secrets
+are no longer used. Reports need the 'manage-users' permission.`,
+}
+
+var reportAccessCmd = &cobra.Command{
+	Use:   "access [--namespace <pattern>...] [--format table|json|csv]",
+	Short: "Show what every user can do on each key",
+	Long: `Show the effective permissions of every user: what their roles, groups and
+policies let them do on each key, with each token's age, status and last use.
+
+Each cell lists r (read), w (write) and l (list). With --namespace the columns
+are key patterns instead of keys; an action allowed on only some keys of a
+namespace is shown as '~'. A namespace matching no key is evaluated for a key
+of that name, standing for keys yet to be created.
+
+Disabled and locked users, and users whose tokens have all expired, have no
+effective access and are shown with '---'.`,
+	Example: `  simple-secrets report access
+  simple-secrets report access --namespace 'prod-*' --namespace 'staging-*'
+  simple-secrets report access --format csv > access-review.csv`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		write, ok := accessReportWriters[reportFormat]
+		if !ok {
+			return fmt.Errorf("invalid format %q: must be table, json or csv", reportFormat)
+		}
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		report, err := helper.GetService().Reports().Access(token, reportNamespaces)
+		if err != nil {
+			return err
+		}
+		return write(os.Stdout, report)
+	},
+}
+
+var reportStaleCmd = &cobra.Command{
+	Use:   "stale --unused-for <duration> [--format table|json|csv]",
+	Short: "List secrets that nobody has read or written for a while",
+	Long: `List the secrets neither read nor written within --unused-for, longest idle
+first, as candidates for deletion. Use is counted from the first command run
+with this version, so a secret with no recorded use is idle since then.
+
+'simple-secrets describe <key>' shows a secret's reads in detail.`,
+	Example: `  simple-secrets report stale --unused-for 90d
+  simple-secrets report stale --unused-for 30d --format csv > stale.csv`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		write, ok := staleReportWriters[reportFormat]
+		if !ok {
+			return fmt.Errorf("invalid format %q: must be table, json or csv", reportFormat)
+		}
+		unusedFor, err := internal.ParseLifetime(reportUnusedFor)
+		if err != nil {
+			return fmt.Errorf("--unused-for: %w", err)
+		}
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		stale, err := helper.GetService().Reports().Stale(token, unusedFor)
+		if err != nil {
+			return err
+		}
+		return write(os.Stdout, stale)
+	},
+}
+
+// accessReportWriters writes an access report in each --format
+var accessReportWriters = map[string]func(io.Writer, *internal.AccessReport) error{
+	"table": writeAccessTable,
+	"json":  writeReportJSON[*internal.AccessReport],
+	"csv":   writeAccessCSV,
+}
+
+// staleReportWriters writes the stale secrets in each --format
+var staleReportWriters = map[string]func(io.Writer, []internal.StaleSecret) error{
+	"table": writeStaleTable,
+	"json":  writeReportJSON[[]internal.StaleSecret],
+	"csv":   writeStaleCSV,
+}
+
+// writeReportJSON writes a report as indented JSON
+func writeReportJSON[T any](w io.Writer, report T) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// writeAccessTable prints one row per token, with the user's access on their first row
+func writeAccessTable(w io.Writer, report *internal.AccessReport) error {
+	if len(report.Users) == 0 {
+		_, err := fmt.Fprintln(w, "No users.")
+		return err
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "USER\tROLES\tSTATUS\tTOKEN\tAGE\tTOKEN STATUS\tLAST USED\t%s\n", strings.Join(report.Columns, "\t"))
+	for _, user := range report.Users {
+		cells := make([]string, len(report.Columns))
+		for i, column := range report.Columns {
+			cells[i] = accessCellText(user.Access[column])
+		}
+		if len(user.Tokens) == 0 {
+			fmt.Fprintf(table, "%s\t%s\t%s\t-\t-\t-\t-\t%s\n", user.Username, joinRoles(user.Roles), user.Status, strings.Join(cells, "\t"))
+			continue
+		}
+		for i, t := range user.Tokens {
+			token := fmt.Sprintf("%s\t%s\t%s\t%s", t.Name, tokenAgeText(t.AgeDays), t.Status, formatTokenTime(t.LastUsedAt))
+			if i > 0 {
+				fmt.Fprintf(table, "\t\t\t%s\n", token)
+				continue
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", user.Username, joinRoles(user.Roles), user.Status, token, strings.Join(cells, "\t"))
+		}
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	if report.Namespaces {
+		fmt.Fprintln(w, "\nr = read, w = write, l = list, ~ = on some keys of the namespace only")
+	}
+	return nil
+}
+
+// accessCellText renders a cell as three letters, such as "r-l", with '~' for actions
+// allowed on only some keys of a namespace
+func accessCellText(cell internal.AccessCell) string {
+	letters := []byte("---")
+	for i, action := range internal.PolicyActions() {
+		switch {
+		case cell.Allows(action):
+			letters[i] = action[0]
+		case cell.AllowsSome(action):
+			letters[i] = '~'
+		}
+	}
+	return string(letters)
+}
+
+// tokenAgeText renders a token's age in days, or "?" for default tokens of unknown age
+func tokenAgeText(days *int) string {
+	if days == nil {
+		return "?"
+	}
+	return strconv.Itoa(*days) + "d"
+}
+
+func joinRoles(roles []internal.Role) string {
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = string(role)
+	}
+	return strings.Join(names, ",")
+}
+
+// writeAccessCSV writes one row per token, repeating the user's access on each, so the
+// matrix can be filtered in a spreadsheet. Users without tokens get one row.
+func writeAccessCSV(w io.Writer, report *internal.AccessReport) error {
+	out := csv.NewWriter(w)
+	out.Write(append([]string{"username", "roles", "groups", "status", "token", "token_age_days",
+		"token_status", "token_last_used", "token_expires"}, report.Columns...))
+	for _, user := range report.Users {
+		cells := make([]string, len(report.Columns))
+		for i, column := range report.Columns {
+			cells[i] = accessCellText(user.Access[column])
+		}
+		tokens := user.Tokens
+		if len(tokens) == 0 {
+			tokens = []internal.TokenAccess{{}}
+		}
+		for _, t := range tokens {
+			age := ""
+			if t.AgeDays != nil {
+				age = strconv.Itoa(*t.AgeDays)
+			}
+			row := []string{user.Username, joinRoles(user.Roles), strings.Join(user.Groups, ","), string(user.Status),
+				t.Name, age, t.Status, csvTime(t.LastUsedAt), csvTime(t.ExpiresAt)}
+			out.Write(append(row, cells...))
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeStaleTable prints one row per stale secret
+func writeStaleTable(w io.Writer, stale []internal.StaleSecret) error {
+	if len(stale) == 0 {
+		_, err := fmt.Fprintln(w, "No stale secrets.")
+		return err
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "KEY\tIDLE\tREADS\tLAST READ\tLAST READ BY\tLAST WRITTEN")
+	for _, s := range stale {
+		fmt.Fprintf(table, "%s\t%dd\t%d\t%s\t%s\t%s\n", s.Key, s.IdleDays, s.Reads, formatTokenTime(s.LastReadAt),
+			orDash(s.LastReadBy), formatTokenTime(s.LastWrittenAt))
+	}
+	return table.Flush()
+}
+
+// writeStaleCSV writes a header row and one row per stale secret
+func writeStaleCSV(w io.Writer, stale []internal.StaleSecret) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"key", "idle_days", "reads", "last_read_at", "last_read_by", "last_written_at"})
+	for _, s := range stale {
+		out.Write([]string{s.Key, strconv.Itoa(s.IdleDays), strconv.FormatUint(s.Reads, 10), csvTime(s.LastReadAt),
+			s.LastReadBy, csvTime(s.LastWrittenAt)})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// csvTime formats an optional time as RFC 3339 in UTC, or "" when unset
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.AddCommand(reportAccessCmd, reportStaleCmd)
+
+	for _, cmd := range []*cobra.Command{reportAccessCmd, reportStaleCmd} {
+		cmd.Flags().StringVar(&reportFormat, "format", "table", "output format: table, json or csv")
+	}
+	reportAccessCmd.Flags().StringArrayVar(&reportNamespaces, "namespace", nil, "report on keys matching this pattern as one column, e.g. 'prod-*' (repeatable)")
+	reportStaleCmd.Flags().StringVar(&reportUnusedFor, "unused-for", "", "list secrets not read or written for this long, e.g. 90d")
+	reportStaleCmd.MarkFlagRequired("unused-for")
+}
@@ -0,0 +1,91 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"simple-secrets/internal"
+)
+
+func TestAccessCellText(t *testing.T) {
+	tests := []struct {
+		cell internal.AccessCell
+		want string
+	}{
+		{internal.AccessCell{}, "---"},
+		{internal.AccessCell{Actions: []string{"read", "write", "list"}}, "rwl"},
+		{internal.AccessCell{Actions: []string{"list"}, Partial: []string{"read"}}, "~-l"},
+	}
+	for _, tt := range tests {
+		if got := accessCellText(tt.cell); got != tt.want {
+			t.Errorf("accessCellText(%+v) = %q, want %q", tt.cell, got, tt.want)
+		}
+	}
+}
+
+func TestWriteAccessCSV(t *testing.T) {
+	age := 3
+	report := &internal.AccessReport{
+		Columns: []string{"app-db"},
+		Users: []internal.UserAccess{
+			{
+				Username: "alice", Roles: []internal.Role{"reader"}, Status: internal.StatusActive,
+				Tokens: []internal.TokenAccess{{Name: "default", AgeDays: &age, Status: "active"}, {Name: "ci", Status: "expired"}},
+				Access: map[string]internal.AccessCell{"app-db": {Actions: []string{"read", "list"}}},
+			},
+			{Username: "bob", Roles: []internal.Role{"reader"}, Status: internal.StatusDisabled, Tokens: []internal.TokenAccess{}},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := writeAccessCSV(&out, report); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	want := []string{
+		"username,roles,groups,status,token,token_age_days,token_status,token_last_used,token_expires,app-db",
+		"alice,reader,,active,default,3,active,,,r-l",
+		"alice,reader,,active,ci,,expired,,,r-l",
+		"bob,reader,,disabled,,,,,,---",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected CSV:\n%s", out.String())
+	}
+}
+
+func TestWriteStaleCSV(t *testing.T) {
+	read := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	stale := []internal.StaleSecret{
+		{Key: "old-token", IdleDays: 120, Reads: 4, LastReadAt: &read, LastReadBy: "ci"},
+		{Key: "never-read", IdleDays: 95},
+	}
+
+	var out bytes.Buffer
+	if err := writeStaleCSV(&out, stale); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	want := []string{
+		"key,idle_days,reads,last_read_at,last_read_by,last_written_at",
+		"old-token,120,4,2025-01-02T03:04:05Z,ci,",
+		"never-read,95,0,,,",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected CSV:\n%s", out.String())
+	}
+}
@@ -18,6 +18,8 @@ package cmd
 import (
 	"fmt"
 
+	"simple-secrets/internal"
+
 	"github.com/spf13/cobra"
 )
 
@@ -61,16 +63,13 @@ func restoreSecret(cmd *cobra.Command, secretKey string) error {
 		return err
 	}
 
-	user, _, err := helper.AuthenticateCommand(cmd, true)
+	token, err := resolveTokenFromCommand(cmd)
 	if err != nil {
 		return err
 	}
-	if user == nil {
-		return nil
-	}
 
-	service := helper.GetService()
-	if err := service.Admin().RestoreSecret(secretKey); err != nil {
+	// Policies scope restores like any other write to the key
+	if err := helper.GetService().Secrets().Restore(token, secretKey); err != nil {
 		return err
 	}
 
@@ -85,7 +84,7 @@ func restoreDatabase(cmd *cobra.Command, backupName string) error {
 		return err
 	}
 
-	user, _, err := helper.AuthenticateCommand(cmd, true)
+	user, _, err := helper.AuthenticateStoreCommand(cmd, internal.PermWrite)
 	if err != nil {
 		return err
 	}
@@ -93,8 +92,16 @@ func restoreDatabase(cmd *cobra.Command, backupName string) error {
 		return nil
 	}
 
+	approved, err := requireApproval(cmd, helper, internal.OpRestoreDatabase, backupName)
+	if err != nil {
+		return err
+	}
+
 	service := helper.GetService()
-	if err := service.Admin().RestoreDatabase(backupName); err != nil {
+	err = service.Admin().RestoreDatabase(backupName)
+	approved(err)
+	service.Audit().Record(user, "restore-database", backupName, err)
+	if err != nil {
 		return fmt.Errorf("failed to restore database: %w", err)
 	}
 
@@ -19,9 +19,10 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
-	"simple-secrets/pkg/api"
+	"simple-secrets/internal"
 
 	"github.com/spf13/cobra"
 )
@@ -45,7 +46,7 @@ var restoreDatabaseCmd = &cobra.Command{
 		}
 
 		// RBAC: write access (this is a destructive operation)
-		user, _, err := helper.AuthenticateCommand(cmd, true)
+		user, _, err := helper.AuthenticateStoreCommand(cmd, internal.PermWrite)
 		if err != nil {
 			return err
 		}
@@ -60,9 +61,14 @@ var restoreDatabaseCmd = &cobra.Command{
 			restoreBackupName = args[0]
 		}
 
-		// Show what backup will be restored
+		// Resolve the most recent backup here rather than in the restore, so that an
+		// approval names the backup that is actually restored
 		if restoreBackupName == "" {
-			backups, err := service.Admin().ListBackups()
+			store, err := internal.LoadSecretsStore(internal.NewFilesystemBackend())
+			if err != nil {
+				return err
+			}
+			backups, err := store.ListRotationBackups()
 			if err != nil {
 				return fmt.Errorf("failed to list backups: %w", err)
 			}
@@ -71,21 +77,17 @@ var restoreDatabaseCmd = &cobra.Command{
 				return nil
 			}
 
-			// Find most recent backup (assume all returned backups are valid)
-			var mostRecent *api.BackupInfo
-			for _, backup := range backups {
-				mostRecent = backup
-				break
-			}
-
-			if mostRecent == nil {
+			// The same choice RestoreFromBackup makes: the newest backup that is complete
+			i := slices.IndexFunc(backups, func(b internal.BackupInfo) bool { return b.IsValid })
+			if i < 0 {
 				fmt.Println("No valid rotation backups found.")
 				return nil
 			}
+			mostRecent := backups[i]
 
-			fmt.Printf("Will restore from most recent backup: %s\n", mostRecent.ID)
-			fmt.Printf("  Created: %s\n", mostRecent.Timestamp)
-			restoreBackupName = mostRecent.ID
+			fmt.Printf("Will restore from most recent backup: %s\n", mostRecent.Name)
+			fmt.Printf("  Created: %s\n", mostRecent.Timestamp.Format("2006-01-02 15:04:05"))
+			restoreBackupName = mostRecent.Name
 		}
 
 		// Display backup name for non-most-recent restores
@@ -108,8 +110,16 @@ var restoreDatabaseCmd = &cobra.Command{
 			}
 		}
 
+		approved, err := requireApproval(cmd, helper, internal.OpRestoreDatabase, restoreBackupName)
+		if err != nil {
+			return err
+		}
+
 		// Perform the restore
-		if err := service.Admin().RestoreDatabase(restoreBackupName); err != nil {
+		err = service.Admin().RestoreDatabase(restoreBackupName)
+		approved(err)
+		service.Audit().Record(user, "restore-database", restoreBackupName, err)
+		if err != nil {
 			return fmt.Errorf("restore failed: %w", err)
 		}
 
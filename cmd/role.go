@@ -0,0 +1,160 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"simple-secrets/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var rolePermissions []string
+
+// roleCmd groups role management operations
+var roleCmd = &cobra.Command{
+	Use:   "role",
+	Short: "Manage roles and their permissions (admin only)",
+	Long: `Roles grant a set of permissions from the permission catalog.
+The built-in admin role always holds every permission and cannot be changed
+or deleted. Run 'simple-secrets role list' to see the catalog.`,
+}
+
+var roleListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List roles and the permission catalog",
+	Example: `  simple-secrets role list`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		roles, err := helper.GetService().Roles().ListRoles(token)
+		if err != nil {
+			return err
+		}
+
+		printRoles(roles)
+		return nil
+	},
+}
+
+var roleCreateCmd = &cobra.Command{
+	Use:     "create <name> --permissions <perm,...>",
+	Short:   "Create a role",
+	Example: `  simple-secrets role create ci-writer --permissions read,write`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := helper.GetService().Roles().CreateRole(token, args[0], rolePermissions); err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Role %q created with permissions: %s\n", args[0], strings.Join(rolePermissions, ", "))
+		return nil
+	},
+}
+
+var roleUpdateCmd = &cobra.Command{
+	Use:     "update <name> --permissions <perm,...>",
+	Short:   "Replace the permissions of a role",
+	Example: `  simple-secrets role update ci-writer --permissions read`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := helper.GetService().Roles().UpdateRole(token, args[0], rolePermissions); err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Role %q updated with permissions: %s\n", args[0], strings.Join(rolePermissions, ", "))
+		return nil
+	},
+}
+
+var roleDeleteCmd = &cobra.Command{
+	Use:     "delete <name>",
+	Short:   "Delete a role that no user is assigned to",
+	Example: `  simple-secrets role delete ci-writer`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := helper.GetService().Roles().DeleteRole(token, args[0]); err != nil {
+			return err
+		}
+
+		fmt.Printf("🗑️  Role %q deleted.\n", args[0])
+		return nil
+	},
+}
+
+// serviceCommandSetup returns the service helper and the resolved token for commands that authorize in the service
+func serviceCommandSetup(cmd *cobra.Command) (*CLIServiceHelper, string, error) {
+	helper, err := GetCLIServiceHelper()
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := resolveTokenFromCommand(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	return helper, token, nil
+}
+
+// printRoles displays each role with its permissions, followed by the permission catalog
+func printRoles(roles internal.RolePermissions) {
+	fmt.Printf("Found %d role(s):\n\n", len(roles))
+	for _, name := range roles.RoleNames() {
+		builtIn := ""
+		if internal.Role(name) == internal.RoleAdmin {
+			builtIn = " (built-in)"
+		}
+		fmt.Printf("  🎭 %s%s\n", name, builtIn)
+		fmt.Printf("    Permissions: %s\n", strings.Join(roles[internal.Role(name)], ", "))
+		fmt.Println()
+	}
+
+	fmt.Println("Permission catalog:")
+	for _, perm := range internal.PermissionCatalog {
+		fmt.Printf("  • %-17s %s\n", perm.Name, perm.Description)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(roleCmd)
+	roleCmd.AddCommand(roleListCmd, roleCreateCmd, roleUpdateCmd, roleDeleteCmd)
+
+	for _, cmd := range []*cobra.Command{roleCreateCmd, roleUpdateCmd} {
+		cmd.Flags().StringSliceVar(&rolePermissions, "permissions", nil, "permissions to grant, comma-separated or repeated (see 'role list')")
+		cmd.MarkFlagRequired("permissions")
+	}
+}
@@ -16,6 +16,7 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"simple-secrets/internal"
@@ -27,6 +28,9 @@ import (
 
 var TokenFlag string
 
+// ClientFlag labels the job or host running the command in the audit log and secret usage
+var ClientFlag string
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "simple-secrets",
@@ -37,7 +41,8 @@ Features:
 	• AES-256-GCM encryption for all secrets
 	• Master key rotation with automatic backup cleanup
 	• Database backup/restore from rotation snapshots
-	• Role-based access control (RBAC) for users (admin/reader)
+	• Encrypted backup archives of the whole store
+	• Role-based access control (RBAC) with built-in and custom roles
 	• CLI user management (create-user, list users, token rotation)
 	• Self-service token rotation for enhanced security
 	• Individual secret backup/restore functionality
@@ -48,24 +53,49 @@ Features:
 All secrets are encrypted and stored locally in ~/.simple-secrets/.
 
 See 'simple-secrets --help' or the README for more info.`,
-	Run: handleRootCommand,
+	PersistentPreRunE: applyClientLabel,
+	Run:               handleRootCommand,
+}
+
+// applyClientLabel records --client, or SIMPLE_SECRETS_CLIENT, with every access the command makes
+func applyClientLabel(cmd *cobra.Command, args []string) error {
+	label := ClientFlag
+	if label == "" {
+		label = os.Getenv("SIMPLE_SECRETS_CLIENT")
+	}
+	return internal.SetClientLabel(label)
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	err := rootCmd.Execute()
+
+	var exitErr *ExitCodeError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.Code)
+	}
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
+// ExitCodeError ends the process with a specific exit code, for commands used in monitoring
+type ExitCodeError struct {
+	Code int
+}
+
+func (e *ExitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
 func init() {
 	// Set up token generator for internal package
 	internal.DefaultTokenGenerator = GenerateSecureToken
 
 	// Persistent token flag for all commands
 	rootCmd.PersistentFlags().StringVar(&TokenFlag, "token", "", "authentication token (overrides env/config)")
+	rootCmd.PersistentFlags().StringVar(&ClientFlag, "client", "", "label recorded with each access, e.g. ci-deploy (or SIMPLE_SECRETS_CLIENT)")
 
 	// Add setup flag for manual triggering of first-run experience
 	rootCmd.Flags().Bool("setup", false, "run first-time setup (use after removing ~/.simple-secrets for reset)")
@@ -123,22 +153,12 @@ func getAvailableDisabledSecrets(cmd *cobra.Command) ([]string, error) {
 		return nil, err
 	}
 
-	// For disabled secrets, we need to access the store directly
-	user, _, err := helper.AuthenticateCommand(cmd, false)
-	if err != nil {
-		return nil, err
-	}
-	if user == nil {
-		return nil, fmt.Errorf("authentication required")
-	}
-
-	store, err := internal.LoadSecretsStore(internal.NewFilesystemBackend())
+	token, err := resolveTokenFromCommand(cmd)
 	if err != nil {
 		return nil, err
 	}
 
-	disabledSecrets := store.ListDisabledSecrets()
-	return disabledSecrets, nil
+	return helper.GetService().Secrets().ListDisabled(token)
 }
 
 // getAvailableBackupSecrets retrieves all secret keys that have backups for completion
@@ -149,22 +169,21 @@ func getAvailableBackupSecrets(cmd *cobra.Command) ([]string, error) {
 		return nil, err
 	}
 
-	// Authenticate to access backups
-	user, _, err := helper.AuthenticateCommand(cmd, false)
+	token, err := resolveTokenFromCommand(cmd)
 	if err != nil {
 		return nil, err
 	}
-	if user == nil {
-		return nil, fmt.Errorf("authentication required")
+
+	// Only offer keys the caller is allowed to list
+	secretKeys, err := helper.GetService().Secrets().List(token)
+	if err != nil {
+		return nil, err
 	}
 
 	store, err := internal.LoadSecretsStore(internal.NewFilesystemBackend())
 	if err != nil {
 		return nil, err
 	}
-
-	// Get all secret keys and check which have backups
-	secretKeys := store.ListKeys()
 	var backedUpSecrets []string
 
 	for _, key := range secretKeys {
@@ -186,7 +205,7 @@ func getAvailableBackupNames(cmd *cobra.Command) ([]string, error) {
 	}
 
 	// Authenticate to access backups
-	user, _, err := helper.AuthenticateCommand(cmd, false)
+	user, _, err := helper.AuthenticateStoreCommand(cmd, internal.PermRead)
 	if err != nil {
 		return nil, err
 	}
@@ -221,16 +240,13 @@ func getAvailableUsernames(cmd *cobra.Command) ([]string, error) {
 	}
 
 	// Check authentication and permissions
-	user, store, err := helper.AuthenticateCommand(cmd, false)
+	user, _, err := helper.AuthenticateCommand(cmd, internal.PermManageUsers)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
 		return nil, fmt.Errorf("authentication required")
 	}
-	if !user.Can("manage-users", store.Permissions()) {
-		return nil, fmt.Errorf("permission denied")
-	}
 
 	// Load users list
 	usersPath, err := internal.DefaultUserConfigPath("users.json")
@@ -341,6 +357,7 @@ func displayExistingInstallationInfo() {
 	fmt.Println("  • List users:         ./simple-secrets list --token <token> users")
 
 	fmt.Println("\n🔑 Need your token? If you've lost it:")
+	fmt.Println("  • Use a recovery code from setup: simple-secrets recover admin --code <code>")
 	fmt.Println("  • Nuclear option: Back up ~/.simple-secrets/, delete it, and run --setup to start fresh")
 	fmt.Println("  • Or check if it's saved in ~/.simple-secrets/config.json")
 	fmt.Println("  • Or check your environment: echo $SIMPLE_SECRETS_TOKEN")
@@ -369,7 +386,7 @@ func performFirstTimeSetup() {
 		return
 	}
 
-	_, token, err := internal.HandleFirstRunSetup(usersPath, rolesPath)
+	store, token, err := internal.HandleFirstRunSetup(usersPath, rolesPath)
 	if err != nil {
 		fmt.Printf("\n❌ Setup failed: %v\n", err)
 		return
@@ -390,4 +407,6 @@ func performFirstTimeSetup() {
 	fmt.Printf("TOKEN: %s\n", token)
 	fmt.Println(strings.Repeat("=", 50))
 	fmt.Println("Save this token securely. It will not be shown again.")
+
+	printRecoveryCodes(store.SetupRecoveryCodes())
 }
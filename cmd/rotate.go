@@ -17,12 +17,11 @@ package cmd
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"simple-secrets/internal"
 	"strings"
-	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -30,6 +29,9 @@ import (
 var (
 	rotateNewYes       bool
 	rotateNewBackupDir string
+	rotateTokenName    string
+	rotateGrace        string
+	rotateGraceAccess  string
 )
 
 // rotateNewCmd represents the new consolidated rotate command
@@ -42,10 +44,15 @@ var rotateCmd = &cobra.Command{
 
 Token rotation options:
   • token             - Self: rotate your own token (no username needed)
-  • token <username>  - Admin: rotate another user's token`,
+  • token <username>  - Admin: rotate another user's token
+  • --name <name>     - Rotate a named token (see 'token list') instead of the default one
+  • --grace <duration> - Keep the old token valid for a while, so hosts using it can be
+                         updated without an outage (read-only unless --grace-access full)`,
 	Example: `  simple-secrets rotate master-key --yes
-  simple-secrets rotate token           # Rotate your own token
-  simple-secrets rotate token alice     # Admin rotates alice's token`,
+  simple-secrets rotate token                   # Rotate your own token
+  simple-secrets rotate token alice             # Admin rotates alice's token
+  simple-secrets rotate token --name ci-deploy  # Rotate your token named ci-deploy
+  simple-secrets rotate token deploy --grace 24h  # Old token stays valid (read-only) for 24h`,
 	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Check if token flag was explicitly set to empty string
@@ -57,6 +64,9 @@ Token rotation options:
 		case "master-key":
 			return rotateMasterKey(cmd)
 		case "token":
+			if rotateTokenName != "" && rotateTokenName != internal.DefaultTokenName {
+				return rotateNamedToken(cmd, args[1:])
+			}
 			if len(args) < 2 {
 				// No username provided - self-rotation
 				return rotateSelfToken(cmd)
@@ -75,7 +85,7 @@ func rotateMasterKey(cmd *cobra.Command) error {
 		return err
 	}
 
-	user, _, err := helper.AuthenticateCommand(cmd, true)
+	user, _, err := helper.AuthenticateStoreCommand(cmd, internal.PermWrite)
 	if err != nil {
 		return err
 	}
@@ -86,9 +96,16 @@ func rotateMasterKey(cmd *cobra.Command) error {
 	if !rotateNewYes && !confirmMasterKeyRotation() {
 		return nil
 	}
+	approved, err := requireApproval(cmd, helper, internal.OpRotateMasterKey, "")
+	if err != nil {
+		return err
+	}
 
 	service := helper.GetService()
-	if err := service.Admin().RotateMasterKey(rotateNewBackupDir); err != nil {
+	err = service.Admin().RotateMasterKey(rotateNewBackupDir)
+	approved(err)
+	service.Audit().Record(user, "rotate-master-key", "", err)
+	if err != nil {
 		return err
 	}
 
@@ -103,7 +120,7 @@ func validateMasterKeyRotationAccess(cmd *cobra.Command) (*internal.User, *inter
 		return nil, nil, err
 	}
 
-	user, _, err := helper.AuthenticateCommand(cmd, true)
+	user, _, err := helper.AuthenticateStoreCommand(cmd, internal.PermWrite)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -156,7 +173,7 @@ func rotateSelfToken(cmd *cobra.Command) error {
 	if err != nil {
 		return err
 	}
-	currentUser, _, err := helper.AuthenticateCommand(cmd, false)
+	currentUser, _, err := helper.AuthenticateCommand(cmd, internal.PermRotateOwnToken)
 	if err != nil {
 		return err
 	}
@@ -164,8 +181,16 @@ func rotateSelfToken(cmd *cobra.Command) error {
 		return nil // First run message already shown
 	}
 
-	service := helper.GetService()
-	newToken, err := service.Users().RotateSelfToken(currentUser)
+	grace, err := rotationGrace()
+	if err != nil {
+		return err
+	}
+	token, err := resolveTokenFromCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	newToken, err := helper.GetService().Tokens().RotateToken(token, "", internal.DefaultTokenName, grace)
 	if err != nil {
 		return err
 	}
@@ -181,7 +206,7 @@ func rotateToken(cmd *cobra.Command, targetUsername string) error {
 		return err
 	}
 
-	currentUser, _, err := helper.AuthenticateCommand(cmd, false) // Don't require write access yet
+	currentUser, _, err := helper.Authenticate(cmd) // The permission depends on whose token is rotated
 	if err != nil {
 		return err
 	}
@@ -195,6 +220,10 @@ func rotateToken(cmd *cobra.Command, targetUsername string) error {
 	}
 
 	// Otherwise, proceed with admin rotation (requires rotate-tokens permission)
+	grace, err := rotationGrace()
+	if err != nil {
+		return err
+	}
 	context, err := prepareTokenRotationContextForUser(cmd, targetUsername)
 	if err != nil {
 		return err
@@ -202,8 +231,10 @@ func rotateToken(cmd *cobra.Command, targetUsername string) error {
 	if context == nil {
 		return nil // First run or access denied
 	}
+	context.Grace = grace
 
 	newToken, err := executeTokenRotation(context)
+	helper.GetService().Audit().Record(context.RequestingUser, "rotate-token", targetUsername, err)
 	if err != nil {
 		return err
 	}
@@ -212,6 +243,34 @@ func rotateToken(cmd *cobra.Command, targetUsername string) error {
 	return nil
 }
 
+// rotateNamedToken replaces the value of a named token of the caller or of the given user
+func rotateNamedToken(cmd *cobra.Command, args []string) error {
+	username := ""
+	if len(args) > 0 {
+		username = args[0]
+	}
+
+	grace, err := rotationGrace()
+	if err != nil {
+		return err
+	}
+	helper, token, err := serviceCommandSetup(cmd)
+	if err != nil {
+		return err
+	}
+
+	newToken, err := helper.GetService().Tokens().RotateToken(token, username, rotateTokenName, grace)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nToken %q rotated.\n", rotateTokenName)
+	fmt.Printf("New token: %s\n", newToken)
+	fmt.Println()
+	printTokenRotationWarnings()
+	return nil
+}
+
 // completeRotateArgs provides completion for rotate command arguments
 func completeRotateArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 	if len(args) == 0 {
@@ -235,6 +294,9 @@ func completeRotateArgs(cmd *cobra.Command, args []string, toComplete string) ([
 func init() {
 	rotateCmd.Flags().BoolVar(&rotateNewYes, "yes", false, "Skip confirmation prompt for master key rotation")
 	rotateCmd.Flags().StringVar(&rotateNewBackupDir, "backup-dir", "", "Custom backup directory for master key rotation")
+	rotateCmd.Flags().StringVar(&rotateTokenName, "name", "", "Rotate the named token instead of the default one")
+	rotateCmd.Flags().StringVar(&rotateGrace, "grace", "", "Keep the old token valid for this long, e.g. 24h")
+	rotateCmd.Flags().StringVar(&rotateGraceAccess, "grace-access", internal.GraceReadOnly, "Access the old token keeps during the grace period: read-only or full")
 
 	rootCmd.AddCommand(rotateCmd)
 
@@ -257,7 +319,7 @@ func validateTokenRotationAccess(cmd *cobra.Command, targetUsername string) (*in
 		return nil, "", nil, err
 	}
 
-	currentUser, store, err := helper.AuthenticateCommand(cmd, true)
+	currentUser, _, err := helper.AuthenticateCommand(cmd, internal.PermRotateTokens)
 	if err != nil {
 		return nil, "", nil, err
 	}
@@ -265,10 +327,6 @@ func validateTokenRotationAccess(cmd *cobra.Command, targetUsername string) (*in
 		return nil, "", nil, nil
 	}
 
-	if !currentUser.Can("rotate-tokens", store.Permissions()) {
-		return nil, "", nil, NewPermissionDeniedError("rotate-tokens")
-	}
-
 	usersPath, err := internal.DefaultUserConfigPath("users.json")
 	if err != nil {
 		return nil, "", nil, err
@@ -289,7 +347,7 @@ func validateSelfTokenRotationAccess(cmd *cobra.Command) (*internal.User, string
 		return nil, "", nil, err
 	}
 
-	currentUser, store, err := helper.AuthenticateCommand(cmd, false) // Use false - we check specific permission below
+	currentUser, _, err := helper.AuthenticateCommand(cmd, internal.PermRotateOwnToken)
 	if err != nil {
 		return nil, "", nil, err
 	}
@@ -297,10 +355,6 @@ func validateSelfTokenRotationAccess(cmd *cobra.Command) (*internal.User, string
 		return nil, "", nil, nil
 	}
 
-	if !currentUser.Can("rotate-own-token", store.Permissions()) {
-		return nil, "", nil, fmt.Errorf("permission denied: cannot rotate own token")
-	}
-
 	usersPath, err := internal.DefaultUserConfigPath("users.json")
 	if err != nil {
 		return nil, "", nil, err
@@ -331,11 +385,7 @@ func generateAndUpdateUserToken(users []*internal.User, targetIndex int) (string
 		return "", fmt.Errorf("failed to generate random token: %w", err)
 	}
 
-	newTokenHash := internal.HashToken(newToken)
-	now := time.Now()
-
-	users[targetIndex].TokenHash = newTokenHash
-	users[targetIndex].TokenRotatedAt = &now
+	users[targetIndex].SetToken(newToken)
 
 	return newToken, nil
 }
@@ -349,6 +399,7 @@ type TokenRotationContext struct {
 	UsersPath      string
 	Users          []*internal.User
 	IsSelfRotation bool
+	Grace          internal.TokenGrace // how long the old token stays valid
 }
 
 // prepareTokenRotationContext prepares the context for self token rotation
@@ -403,30 +454,30 @@ func prepareTokenRotationContextForUser(cmd *cobra.Command, targetUsername strin
 	}, nil
 }
 
-// executeTokenRotation performs the actual token rotation and persistence
+// executeTokenRotation performs the actual token rotation and persistence. The users are
+// reloaded under users.json's lock, as they may have changed since the confirmation prompt.
 func executeTokenRotation(context *TokenRotationContext) (string, error) {
-	newToken, err := generateAndUpdateUserToken(context.Users, context.TargetIndex)
+	var newToken string
+	err := internal.UpdateUsersList(context.UsersPath, func(users []*internal.User) error {
+		targetIndex, err := findUserIndex(users, context.TargetUsername)
+		if err != nil {
+			return err
+		}
+		grace, err := internal.RotationGrace(filepath.Dir(context.UsersPath), users[targetIndex], internal.DefaultTokenName, context.Grace)
+		if err != nil {
+			return err
+		}
+		users[targetIndex].RetireToken(internal.DefaultTokenName, grace)
+		newToken, err = generateAndUpdateUserToken(users, targetIndex)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
 
-	if err := saveUsersList(context.UsersPath, context.Users); err != nil {
-		return "", err
-	}
-
 	return newToken, nil
 }
 
-// saveUsersList marshals and saves the users list to disk atomically
-func saveUsersList(usersPath string, users []*internal.User) error {
-	data, err := json.MarshalIndent(users, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal users: %w", err)
-	}
-
-	return internal.AtomicWriteFile(usersPath, data, 0600)
-}
-
 // printTokenRotationSuccess displays the success message and instructions
 func printTokenRotationSuccess(username string, role internal.Role, newToken string) {
 	fmt.Printf("\nToken rotated for user \"%s\" (%s role).\n", username, role)
@@ -452,15 +503,36 @@ func printSelfTokenRotationSuccess(newToken string) {
 func printTokenRotationWarnings() {
 	fmt.Println("⚠️  IMPORTANT:")
 	fmt.Println("• Store this token securely - it will not be shown again")
-	fmt.Println("• The old token is now invalid and cannot be used")
+	fmt.Println(oldTokenNotice("The old token"))
 	fmt.Println("• Update any scripts or configs that use the old token")
 }
 
+// oldTokenNotice describes what happens to the replaced token, depending on --grace
+func oldTokenNotice(subject string) string {
+	if rotateGrace == "" {
+		return "• " + subject + " is now invalid and cannot be used"
+	}
+	return fmt.Sprintf("• %s keeps %s access for %s; end this early with 'simple-secrets token revoke-previous'", subject, rotateGraceAccess, rotateGrace)
+}
+
+// rotationGrace returns the grace period requested with --grace and --grace-access
+func rotationGrace() (internal.TokenGrace, error) {
+	if rotateGrace == "" {
+		return internal.TokenGrace{}, nil
+	}
+	period, err := internal.ParseLifetime(rotateGrace)
+	if err != nil {
+		return internal.TokenGrace{}, fmt.Errorf("--grace: %w", err)
+	}
+	grace := internal.TokenGrace{Period: period, Access: rotateGraceAccess}
+	return grace, grace.Validate()
+}
+
 // printSelfTokenRotationWarnings displays important warnings about self token rotation
 func printSelfTokenRotationWarnings() {
 	fmt.Println("⚠️  IMPORTANT:")
 	fmt.Println("• Store this token securely - it will not be shown again")
-	fmt.Println("• Your old token is now invalid and cannot be used")
+	fmt.Println(oldTokenNotice("Your old token"))
 	fmt.Println("• Update your local configuration with the new token")
 }
 
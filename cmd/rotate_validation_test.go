@@ -17,7 +17,6 @@ package cmd
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"os"
 	"simple-secrets/internal"
@@ -54,8 +53,9 @@ func setupTestEnvironment(t *testing.T) (string, string) {
 
 	// Save the updated user
 	usersPath, _ := internal.DefaultUserConfigPath("users.json")
-	usersData, _ := json.Marshal(users)
-	os.WriteFile(usersPath, usersData, 0600)
+	if err := internal.SaveUsersList(usersPath, users); err != nil {
+		t.Fatalf("SaveUsersList failed: %v", err)
+	}
 
 	return tmp, testToken
 }
@@ -246,8 +246,9 @@ func TestValidateTokenRotationAccess(t *testing.T) {
 	}
 	users = append(users, readerUser)
 
-	usersData, _ := json.Marshal(users)
-	os.WriteFile(usersPath, usersData, 0600)
+	if err := internal.SaveUsersList(usersPath, users); err != nil {
+		t.Fatalf("SaveUsersList failed: %v", err)
+	}
 
 	// Test successful validation
 	TokenFlag = token
@@ -16,7 +16,11 @@ limitations under the License.
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"simple-secrets/internal"
@@ -24,6 +28,19 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// setupExitAlreadySetUp is the exit code of 'setup --non-interactive' when an installation exists
+const setupExitAlreadySetUp = 3
+
+var (
+	setupNonInteractive bool
+	setupAdminUsername  string
+	setupTokenOut       string
+	setupTokenFD        int
+	setupJSON           bool
+	setupProvision      string
+	setupRecoveryOut    string
+)
+
 var setupCmd = &cobra.Command{
 	Use:   "setup",
 	Short: "Run first-time setup to create admin user and authentication token",
@@ -32,6 +49,8 @@ var setupCmd = &cobra.Command{
 This command creates:
   • Your admin user account
   • Your authentication token
+  • Ten one-time recovery codes, for 'simple-secrets recover admin' if
+    every admin token is lost
   • The secure storage directory (~/.simple-secrets/)
 
 After setup, you can use your token with other commands or set the
@@ -41,12 +60,230 @@ Examples:
   simple-secrets setup
 
 If you've already run setup and want to reset:
-  rm -rf ~/.simple-secrets && simple-secrets setup`,
-	Run: handleSetupCommand,
+  rm -rf ~/.simple-secrets && simple-secrets setup
+
+Scripted setup:
+  --non-interactive sets up without prompting, for configuration management.
+  The admin token goes to --token-out (a new file with mode 0600), to the
+  file descriptor --token-fd, or to standard output. --provision creates
+  custom roles and more users from a JSON file:
+
+    {
+      "roles": [{"name": "ci-writer", "permissions": ["read", "write"]}],
+      "users": [{"username": "ci", "role": "ci-writer", "token_out": "/etc/ci/token"}]
+    }
+
+  Users without token_out have their token printed. Nothing is written unless
+  every role and user is valid. The admin recovery codes are printed, or
+  written to --recovery-codes-out. If simple-secrets is already set up,
+  nothing is changed and the command exits with status 3, so it is safe to
+  re-run.
+
+  simple-secrets setup --non-interactive --admin-username ops --token-out /root/ops.token
+  simple-secrets setup --non-interactive --provision users.json --json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if setupNonInteractive {
+			return runNonInteractiveSetup(cmd)
+		}
+		for _, name := range []string{"admin-username", "token-out", "token-fd", "json", "provision", "recovery-codes-out"} {
+			if cmd.Flags().Changed(name) {
+				return fmt.Errorf("--%s requires --non-interactive", name)
+			}
+		}
+		handleSetupCommand(cmd, args)
+		return nil
+	},
 }
 
 func init() {
 	rootCmd.AddCommand(setupCmd)
+
+	setupCmd.Flags().BoolVar(&setupNonInteractive, "non-interactive", false, "set up without prompting; exits 3 if already set up")
+	setupCmd.Flags().StringVar(&setupAdminUsername, "admin-username", "admin", "username of the admin user")
+	setupCmd.Flags().StringVar(&setupTokenOut, "token-out", "", "write the admin token to this new file (mode 0600)")
+	setupCmd.Flags().IntVar(&setupTokenFD, "token-fd", -1, "write the admin token to this open file descriptor")
+	setupCmd.Flags().BoolVar(&setupJSON, "json", false, "print the result as JSON")
+	setupCmd.Flags().StringVar(&setupProvision, "provision", "", "JSON file of roles and users to create along with the admin")
+	setupCmd.Flags().StringVar(&setupRecoveryOut, "recovery-codes-out", "", "write the admin recovery codes to this new file (mode 0600)")
+}
+
+// setupResult is the JSON output of 'setup --non-interactive --json'
+type setupResult struct {
+	Status        string                   `json:"status"` // created or exists
+	ConfigDir     string                   `json:"config_dir"`
+	Users         []internal.SetupUser     `json:"users,omitempty"`
+	Roles         internal.RolePermissions `json:"roles,omitempty"`
+	RecoveryCodes []string                 `json:"recovery_codes,omitempty"`
+}
+
+// runNonInteractiveSetup creates an installation from flags and a provisioning file
+func runNonInteractiveSetup(cmd *cobra.Command) error {
+	cmd.SilenceUsage = true
+	if setupTokenOut != "" && setupTokenFD >= 0 {
+		return fmt.Errorf("use only one of --token-out and --token-fd")
+	}
+	if err := ValidateSecureInput(setupAdminUsername, UsernameValidationConfig); err != nil {
+		return err
+	}
+	provision, err := readProvisionFile(setupProvision)
+	if err != nil {
+		return err
+	}
+
+	plan, err := internal.PlanSetup(setupAdminUsername, provision)
+	if errors.Is(err, internal.ErrAlreadySetUp) {
+		return reportAlreadySetUp(cmd, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	plan.Users[0].TokenOut = setupTokenOut
+	written, err := deliverSetupTokens(plan)
+	if err == nil {
+		err = plan.Apply()
+	}
+	if err != nil {
+		// The tokens in them belong to users that were never created
+		for _, path := range written {
+			_ = os.Remove(path)
+		}
+		if errors.Is(err, internal.ErrAlreadySetUp) {
+			return reportAlreadySetUp(cmd, err)
+		}
+		return err
+	}
+
+	return printSetupResult(plan)
+}
+
+// readProvisionFile reads the roles and users to create, if a file was given
+func readProvisionFile(path string) (*internal.ProvisionFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	provision, err := internal.ParseProvisionFile(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range provision.Users {
+		if err := ValidateSecureInput(user.Username, UsernameValidationConfig); err != nil {
+			return nil, err
+		}
+	}
+	return provision, nil
+}
+
+// deliverSetupTokens writes tokens and recovery codes to their files and the admin token to
+// --token-fd, clearing them from the plan so they are not also printed. It returns the files
+// it created.
+func deliverSetupTokens(plan *internal.SetupPlan) ([]string, error) {
+	var written []string
+	for i := range plan.Users {
+		user := &plan.Users[i]
+		if user.TokenOut == "" {
+			continue
+		}
+		if err := writeTokenFile(user.TokenOut, user.Token); err != nil {
+			return written, err
+		}
+		written = append(written, user.TokenOut)
+		user.Token = ""
+	}
+
+	if setupRecoveryOut != "" {
+		if err := writeTokenFile(setupRecoveryOut, strings.Join(plan.RecoveryCodes, "\n")); err != nil {
+			return written, err
+		}
+		written = append(written, setupRecoveryOut)
+		plan.RecoveryCodes = nil
+	}
+
+	if setupTokenFD >= 0 {
+		out := os.NewFile(uintptr(setupTokenFD), "token-fd")
+		if out == nil {
+			return written, fmt.Errorf("invalid --token-fd %d", setupTokenFD)
+		}
+		defer out.Close()
+		if _, err := fmt.Fprintln(out, plan.Users[0].Token); err != nil {
+			return written, fmt.Errorf("write token to file descriptor %d: %w", setupTokenFD, err)
+		}
+		plan.Users[0].Token = ""
+	}
+	return written, nil
+}
+
+// writeTokenFile writes a token to a new file readable only by its owner. An existing
+// file is never overwritten.
+func writeTokenFile(path, token string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("token file %s already exists; setup does not overwrite files", path)
+	}
+	if err != nil {
+		return fmt.Errorf("create token file: %w", err)
+	}
+	if _, err := fmt.Fprintln(file, token); err != nil {
+		file.Close()
+		return fmt.Errorf("write token file: %w", err)
+	}
+	return file.Close()
+}
+
+// reportAlreadySetUp reports an existing installation and exits with setupExitAlreadySetUp
+func reportAlreadySetUp(cmd *cobra.Command, err error) error {
+	cmd.SilenceErrors = true
+	usersPath, pathErr := internal.DefaultUserConfigPath("users.json")
+	if pathErr != nil {
+		return pathErr
+	}
+
+	if setupJSON {
+		encoded, err := json.MarshalIndent(setupResult{Status: "exists", ConfigDir: filepath.Dir(usersPath)}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(encoded))
+	} else {
+		fmt.Fprintf(os.Stderr, "%v; nothing was changed\n", err)
+	}
+	return &ExitCodeError{Code: setupExitAlreadySetUp}
+}
+
+// printSetupResult prints the users created with any tokens not written elsewhere
+func printSetupResult(plan *internal.SetupPlan) error {
+	if setupJSON {
+		encoded, err := json.MarshalIndent(setupResult{Status: "created", ConfigDir: plan.ConfigDir, Users: plan.Users, Roles: plan.Roles, RecoveryCodes: plan.RecoveryCodes}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(encoded))
+		return nil
+	}
+
+	fmt.Printf("✅ simple-secrets set up in %s\n", plan.ConfigDir)
+	for i, user := range plan.Users {
+		switch {
+		case user.TokenOut != "":
+			fmt.Printf("  👤 %s (%s): token written to %s\n", user.Username, user.Role, user.TokenOut)
+		case i == 0 && setupTokenFD >= 0:
+			fmt.Printf("  👤 %s (%s): token written to file descriptor %d\n", user.Username, user.Role, setupTokenFD)
+		default:
+			fmt.Printf("  👤 %s (%s): token %s\n", user.Username, user.Role, user.Token)
+		}
+	}
+	fmt.Printf("  Roles: %s\n", strings.Join(plan.Roles.RoleNames(), ", "))
+	if setupRecoveryOut != "" {
+		fmt.Printf("  Recovery codes written to %s\n", setupRecoveryOut)
+	} else {
+		printRecoveryCodes(plan.RecoveryCodes)
+	}
+	return nil
 }
 
 func handleSetupCommand(cmd *cobra.Command, args []string) {
@@ -78,6 +315,7 @@ func handleSetupCommand(cmd *cobra.Command, args []string) {
 		fmt.Println("  • List users:         ./simple-secrets list --token <token> users")
 
 		fmt.Println("\n🔑 Need your token? If you've lost it:")
+		fmt.Println("  • Use a recovery code from setup: simple-secrets recover admin --code <code>")
 		fmt.Println("  • Nuclear option: Back up ~/.simple-secrets/, delete it, and run setup to start fresh")
 		fmt.Println("  • Or check if it's saved in ~/.simple-secrets/config.json")
 		fmt.Println("  • Or check your environment: echo $SIMPLE_SECRETS_TOKEN")
@@ -106,7 +344,7 @@ func handleSetupCommand(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	_, token, err := internal.HandleFirstRunSetup(usersPath, rolesPath)
+	store, token, err := internal.HandleFirstRunSetup(usersPath, rolesPath)
 	if err != nil {
 		fmt.Printf("\n❌ Setup failed: %v\n", err)
 		return
@@ -127,4 +365,6 @@ func handleSetupCommand(cmd *cobra.Command, args []string) {
 	fmt.Printf("TOKEN: %s\n", token)
 	fmt.Println(strings.Repeat("=", 50))
 	fmt.Println("Save this token securely. It will not be shown again.")
+
+	printRecoveryCodes(store.SetupRecoveryCodes())
 }
@@ -0,0 +1,342 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"simple-secrets/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	tokenUser        string
+	tokenName        string
+	tokenDescription string
+	tokenExpiresIn   string
+	tokenScopes      []string
+	tokenTTL         string
+)
+
+// tokenCmd groups the named token commands
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage named tokens",
+	Long: `Each user can hold several named tokens, one per place they are used
+(a laptop, a CI job, a deploy script), so a single token can be revoked without
+breaking the others. The token a user is created with is listed as "default".
+
+Managing your own tokens needs the 'rotate-own-token' permission; managing
+another user's tokens with --user needs 'rotate-tokens'.
+
+'token issue' signs a short-lived derived token limited to some keys, for
+handing to a CI job or script without creating a user for it.`,
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create --name <name> [--description <text>] [--expires-in <duration>]",
+	Short: "Create a named token",
+	Example: `  simple-secrets token create --name laptop
+  simple-secrets token create --name ci-deploy --description "GitHub Actions deploy job" --expires-in 90d
+  simple-secrets token create --user alice --name backup-job`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options := internal.TokenOptions{Name: tokenName, Description: tokenDescription}
+		if tokenExpiresIn != "" {
+			lifetime, err := internal.ParseLifetime(tokenExpiresIn)
+			if err != nil {
+				return err
+			}
+			expiresAt := time.Now().Add(lifetime).UTC()
+			options.ExpiresAt = &expiresAt
+		}
+
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		value, created, err := helper.GetService().Tokens().CreateToken(token, tokenUser, options)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Token %q created (ID %s).\n", created.Name, created.ID)
+		if created.ExpiresAt != nil {
+			fmt.Printf("Expires: %s\n", formatTokenTime(created.ExpiresAt))
+		}
+		fmt.Printf("Token: %s\n", value)
+		fmt.Println()
+		fmt.Println("⚠️  Store this token securely - it will not be shown again.")
+		return nil
+	},
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list [--user <username>]",
+	Short: "List tokens with their creation, last use and expiry",
+	Example: `  simple-secrets token list
+  simple-secrets token list --user alice`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		tokens, err := helper.GetService().Tokens().ListTokens(token, tokenUser)
+		if err != nil {
+			return err
+		}
+
+		printTokens(tokens)
+		return nil
+	},
+}
+
+var tokenIssueCmd = &cobra.Command{
+	Use:   "issue --scope <action:pattern> [--scope ...] --ttl <duration>",
+	Short: "Issue a short-lived derived token limited to some keys",
+	Long: `Issue a derived token signed with a key held by the store. It carries its own
+scopes and expiry, so it needs no entry in users.json, and it can never do more
+than the token that issued it: each scope needs the matching permission, and it
+expires no later than the issuing token.
+
+A scope is action:pattern, where action is read, write or list and pattern is a
+glob over key names. Derived tokens cannot manage users, roles, policies or
+tokens, and stop working when the master key is rotated.`,
+	Example: `  simple-secrets token issue --scope 'read:payments-*' --ttl 1h
+  simple-secrets token issue --scope 'read:app-*' --scope 'list:app-*' --ttl 15m`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ttl, err := internal.ParseLifetime(tokenTTL)
+		if err != nil {
+			return err
+		}
+
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		value, claims, err := helper.GetService().Tokens().IssueToken(token, tokenScopes, ttl)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Derived token %s issued for %s.\n", claims.ID, strings.Join(claims.Scopes, ", "))
+		fmt.Printf("Expires: %s\n", formatTokenTime(&claims.ExpiresAt))
+		fmt.Printf("Token: %s\n", value)
+		fmt.Println()
+		fmt.Printf("Revoke it early with 'simple-secrets token revoke %s'.\n", claims.ID)
+		return nil
+	},
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <name|id> [--user <username>]",
+	Short: "Revoke a named or derived token",
+	Long: `Revoke one of your named tokens, or another user's with --user.
+
+A derived token is revoked by passing its value, which its issuer may do, or
+its dt_ ID, which needs 'rotate-tokens'.`,
+	Example: `  simple-secrets token revoke laptop
+  simple-secrets token revoke tok_3f9a1c2e --user alice
+  simple-secrets token revoke dt_5b0c7e21a9f4`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		name, err := helper.GetService().Tokens().RevokeToken(token, tokenUser, args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("🗑️  Token %q revoked.\n", name)
+		return nil
+	},
+}
+
+var tokenRevokePreviousCmd = &cobra.Command{
+	Use:   "revoke-previous [name] [--user <username>]",
+	Short: "End the grace period of a rotated token",
+	Long: `End the grace period started by 'rotate token --grace', so the old value of the
+token stops working now instead of when the period ends. Without a name the
+default token is used.`,
+	Example: `  simple-secrets token revoke-previous
+  simple-secrets token revoke-previous ci-deploy --user deploy`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		nameOrID := internal.DefaultTokenName
+		if len(args) == 1 {
+			nameOrID = args[0]
+		}
+		name, err := helper.GetService().Tokens().RevokePrevious(token, tokenUser, nameOrID)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("🗑️  Previous %q token revoked; only the rotated token works now.\n", name)
+		return nil
+	},
+}
+
+var tokenScanCmd = &cobra.Command{
+	Use:   "scan [path...]",
+	Short: "Find leaked simple-secrets tokens in files",
+	Long: `Search files, directories or standard input for simple-secrets tokens. Only
+tokens in the ss_<id>_<secret>_<crc> format with a valid checksum are reported,
+so random strings that merely look like tokens are ignored. Findings show the
+token's ID, never its secret. Exits with an error when any token is found, so
+it can run as a pre-commit hook or CI step. Needs no token and does not read
+the store.`,
+	Example: `  simple-secrets token scan .
+  git diff --cached | simple-secrets token scan`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		found := 0
+		if len(args) == 0 {
+			n, err := scanReaderForTokens("<stdin>", os.Stdin)
+			if err != nil {
+				return err
+			}
+			found += n
+		}
+		for _, root := range args {
+			n, err := scanPathForTokens(root)
+			if err != nil {
+				return err
+			}
+			found += n
+		}
+
+		if found > 0 {
+			return fmt.Errorf("found %d simple-secrets token(s); disable each one with 'simple-secrets disable token <value>' or rotate it", found)
+		}
+		fmt.Println("✅ No simple-secrets tokens found.")
+		return nil
+	},
+}
+
+// scanPathForTokens scans a file, or every file below a directory except .git
+func scanPathForTokens(root string) (int, error) {
+	found := 0
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		n, err := scanReaderForTokens(path, file)
+		found += n
+		return err
+	})
+	return found, err
+}
+
+// scanReaderForTokens prints each token found in r and returns how many there were
+func scanReaderForTokens(name string, r io.Reader) (int, error) {
+	leaks, err := internal.ScanForTokens(r)
+	if err != nil {
+		return 0, fmt.Errorf("scan %s: %w", name, err)
+	}
+	for _, leak := range leaks {
+		fmt.Printf("⚠️  %s:%d: %s\n", name, leak.Line, internal.MaskToken(leak.TokenID))
+	}
+	return len(leaks), nil
+}
+
+// printTokens displays each token with its metadata
+func printTokens(tokens []internal.TokenInfo) {
+	if len(tokens) == 0 {
+		fmt.Println("No active tokens.")
+		return
+	}
+
+	now := time.Now()
+	fmt.Printf("Found %d token(s):\n\n", len(tokens))
+	for _, t := range tokens {
+		fmt.Printf("  🔑 %s", t.Name)
+		if t.ID != t.Name {
+			fmt.Printf(" (%s)", t.ID)
+		}
+		fmt.Println()
+		if t.Description != "" {
+			fmt.Printf("    Description: %s\n", t.Description)
+		}
+		fmt.Printf("    Created: %s\n", formatTokenTime(t.CreatedAt))
+		fmt.Printf("    Last used: %s\n", formatTokenTime(t.LastUsedAt))
+		if t.ExpiresAt != nil {
+			expired := ""
+			if !now.Before(*t.ExpiresAt) {
+				expired = " (expired)"
+			}
+			fmt.Printf("    Expires: %s%s\n", formatTokenTime(t.ExpiresAt), expired)
+		}
+		fmt.Println()
+	}
+}
+
+// formatTokenTime formats a token timestamp in local time, or "never" when unset
+func formatTokenTime(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenCreateCmd, tokenListCmd, tokenRevokeCmd, tokenRevokePreviousCmd, tokenIssueCmd, tokenScanCmd)
+
+	for _, cmd := range []*cobra.Command{tokenCreateCmd, tokenListCmd, tokenRevokeCmd, tokenRevokePreviousCmd} {
+		cmd.Flags().StringVar(&tokenUser, "user", "", "manage another user's tokens (needs 'rotate-tokens')")
+	}
+	tokenCreateCmd.Flags().StringVar(&tokenName, "name", "", "name of the token, e.g. laptop or ci-deploy")
+	tokenCreateCmd.Flags().StringVar(&tokenDescription, "description", "", "where the token is used")
+	tokenCreateCmd.Flags().StringVar(&tokenExpiresIn, "expires-in", "", "expire the token after this duration, e.g. 12h or 90d")
+	tokenCreateCmd.MarkFlagRequired("name")
+	tokenIssueCmd.Flags().StringArrayVar(&tokenScopes, "scope", nil, "action:pattern the token may use, e.g. read:payments-* (repeatable)")
+	tokenIssueCmd.Flags().StringVar(&tokenTTL, "ttl", "1h", "how long the token lives, at most 24h")
+	tokenIssueCmd.MarkFlagRequired("scope")
+}
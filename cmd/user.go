@@ -0,0 +1,192 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"simple-secrets/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	userContact     string
+	userDescription string
+	userRole        string
+	userStatus      string
+	userReason      string
+	unlockClient    string
+)
+
+// userCmd groups changes to a single user account
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Update, rename or unlock a user account (admin only)",
+	Long: `Change a user's account details. Every user has a status: active, disabled
+(every token revoked; see 'disable user' and 'enable user') or locked (tokens
+kept but refused until the account is unlocked). 'list users' shows the status
+along with a contact and description recorded for the account, and any
+lockout after failed sign-ins, which 'user unlock' clears.`,
+}
+
+var userUpdateCmd = &cobra.Command{
+	Use:   "update <username> [--contact <contact>] [--description <text>] [--role <role>] [--status active|locked]",
+	Short: "Change a user's contact, description, role or status",
+	Long: `Change a user's contact, description, role or status. Only the flags given
+are changed; pass an empty value to clear the contact or description.
+
+Locking an account refuses all of its tokens without revoking them, and
+--status active unlocks it. You cannot lock your own account or the last
+active admin.`,
+	Example: `  simple-secrets user update ci-deploy --contact platform@example.com --description "GitHub Actions deploys"
+  simple-secrets user update alice --role reader
+  simple-secrets user update alice --status locked --reason "laptop stolen"
+  simple-secrets user update alice --status active`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		update, err := userUpdateFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+		if err := helper.GetService().Users().UpdateUser(token, args[0], update); err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ User %q updated.\n", args[0])
+		return nil
+	},
+}
+
+var userRenameCmd = &cobra.Command{
+	Use:   "rename <username> <new-username>",
+	Short: "Rename a user",
+	Long: `Rename a user, keeping their role and tokens. Policies for the user and
+records of the users and secrets they disabled are updated to the new name.
+Derived tokens the user issued stop working, as they name the old user.`,
+	Example: `  simple-secrets user rename ci ci-deploy`,
+	Args:    cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := ValidateSecureInput(args[1], UsernameValidationConfig); err != nil {
+			return err
+		}
+
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+		if err := helper.GetService().Users().RenameUser(token, args[0], args[1]); err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ User %q renamed to %q.\n", args[0], args[1])
+		return nil
+	},
+}
+
+var userUnlockCmd = &cobra.Command{
+	Use:   "unlock [<username>] [--client <client>]",
+	Short: "Clear failed sign-ins locking out a user or client",
+	Long: `Clear the failed authentications recorded for a user or a client, ending
+their backoff or lockout at once. Repeated failures from a client (the CLI's
+OS user and host, or a server's remote address) first back off and then lock
+out both the client and the user the tokens named; 'list users' shows them.
+Lockouts end on their own after auth_lockout_duration (see 'config').
+
+This is unrelated to 'user update --status locked', which an admin sets and
+clears with --status active.`,
+	Example: `  simple-secrets user unlock alice
+  simple-secrets user unlock --client 203.0.113.7`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if (len(args) == 1) == (unlockClient != "") {
+			return fmt.Errorf("specify exactly one of <username> or --client")
+		}
+
+		helper, token, err := serviceCommandSetup(cmd)
+		if err != nil {
+			return err
+		}
+
+		users := helper.GetService().Users()
+		subject := fmt.Sprintf("client %q", unlockClient)
+		var cleared bool
+		if len(args) == 1 {
+			subject = fmt.Sprintf("user %q", args[0])
+			cleared, err = users.UnlockUser(token, args[0])
+		} else {
+			cleared, err = users.UnlockClient(token, unlockClient)
+		}
+		if err != nil {
+			return err
+		}
+
+		if !cleared {
+			fmt.Printf("No failed sign-ins recorded for %s.\n", subject)
+			return nil
+		}
+		fmt.Printf("🔓 Failed sign-ins for %s cleared.\n", subject)
+		return nil
+	},
+}
+
+// userUpdateFromFlags collects the fields 'user update' was asked to change
+func userUpdateFromFlags(cmd *cobra.Command) (internal.UserUpdate, error) {
+	var update internal.UserUpdate
+	flags := cmd.Flags()
+	if flags.Changed("contact") {
+		update.Contact = &userContact
+	}
+	if flags.Changed("description") {
+		update.Description = &userDescription
+	}
+	if flags.Changed("role") {
+		update.Role = &userRole
+	}
+	if flags.Changed("status") {
+		status, err := internal.ParseUserStatus(userStatus)
+		if err != nil {
+			return update, err
+		}
+		update.Status = &status
+		update.Reason = userReason
+	}
+
+	if update == (internal.UserUpdate{}) {
+		return update, fmt.Errorf("nothing to update: pass --contact, --description, --role or --status")
+	}
+	if userReason != "" && update.Status == nil {
+		return update, fmt.Errorf("--reason can only be used with --status")
+	}
+	return update, nil
+}
+
+func init() {
+	rootCmd.AddCommand(userCmd)
+	userCmd.AddCommand(userUpdateCmd, userRenameCmd, userUnlockCmd)
+
+	userUpdateCmd.Flags().StringVar(&userContact, "contact", "", "who to ask about the account, e.g. an owner's email")
+	userUpdateCmd.Flags().StringVar(&userDescription, "description", "", "what the account is for")
+	userUpdateCmd.Flags().StringVar(&userRole, "role", "", "give the user another role")
+	userUpdateCmd.Flags().StringVar(&userStatus, "status", "", "lock the account (locked) or unlock it (active)")
+	userUpdateCmd.Flags().StringVar(&userReason, "reason", "", "why the account is locked (recorded and shown by 'list users')")
+	userUnlockCmd.Flags().StringVar(&unlockClient, "client", "", "client to unlock, as shown by 'list users'")
+}
@@ -0,0 +1,69 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"simple-secrets/internal"
+
+	"github.com/spf13/cobra"
+)
+
+// usersCmd groups maintenance operations on the user files
+var usersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "Maintain the user and role files",
+}
+
+// usersResealCmd accepts intentional manual edits to the user files
+var usersResealCmd = &cobra.Command{
+	Use:   "reseal",
+	Short: "Accept manual edits to users.json, roles.json and groups.json (admin only)",
+	Long: `users.json, roles.json and groups.json carry an integrity seal derived from the master key.
+Any edit made outside simple-secrets breaks the seal, and authentication is
+refused until the files are resealed or restored.
+
+After an intentional manual edit, run this command to seal the files again.
+Your token must belong to an admin in the last sealed version of the files,
+so an edit can never grant the permission needed to accept itself.`,
+	Example: `  simple-secrets users reseal`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token, err := resolveTokenFromCommand(cmd)
+		if err != nil {
+			return err
+		}
+
+		usersPath, err := internal.DefaultUserConfigPath("users.json")
+		if err != nil {
+			return err
+		}
+
+		if err := internal.ResealUserFiles(filepath.Dir(usersPath), token); err != nil {
+			return err
+		}
+
+		fmt.Println("✅ users.json, roles.json and groups.json resealed.")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(usersCmd)
+	usersCmd.AddCommand(usersResealCmd)
+}
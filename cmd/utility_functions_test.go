@@ -105,7 +105,7 @@ func TestParseRole(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := parseRole(tt.roleStr)
+			result, err := parseRole(tt.roleStr, []string{"admin", "reader"})
 
 			if tt.expectError {
 				if err == nil {
@@ -0,0 +1,83 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestTwoPersonApproval(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Users().Create("carol", "admin")
+	carolToken := testing_framework.Assert(t, output, err).Success().ExtractToken()
+	output, err = cli.Put("prod-db", "s3cret")
+	testing_framework.Assert(t, output, err).Success()
+
+	output, err = cli.Raw("approvals", "set", "--require", "delete,rotate-master-key")
+	testing_framework.Assert(t, output, err).Success()
+	output, err = cli.Raw("approvals", "show")
+	testing_framework.Assert(t, output, err).Success().Contains("Operations needing approval: delete, rotate-master-key")
+
+	t.Run("operation_files_a_request", func(t *testing.T) {
+		output, err := cli.Raw("delete", "prod-db")
+		testing_framework.Assert(t, output, err).Failure().
+			Contains("approval required").
+			Contains("simple-secrets approve a1")
+
+		output, err = cli.Get("prod-db")
+		testing_framework.Assert(t, output, err).Success().Contains("s3cret")
+
+		output, err = cli.Raw("list", "approvals")
+		testing_framework.Assert(t, output, err).Success().Contains("a1: delete prod-db").Contains("Status: pending")
+	})
+
+	t.Run("requester_cannot_approve", func(t *testing.T) {
+		output, err := cli.Raw("approve", "a1")
+		testing_framework.Assert(t, output, err).Failure().Contains("cannot decide your own request")
+	})
+
+	t.Run("approved_operation_runs_once", func(t *testing.T) {
+		output, err := cli.Raw("approve", "a1", "--token", carolToken)
+		testing_framework.Assert(t, output, err).Success().Contains("approved")
+
+		output, err = cli.Raw("delete", "prod-db")
+		testing_framework.Assert(t, output, err).Success().Contains("deleted")
+
+		output, err = cli.Raw("list", "approvals", "--all")
+		testing_framework.Assert(t, output, err).Success().Contains("Status: executed").Contains("Decided by carol")
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		output, err := cli.Raw("rotate", "master-key", "--yes")
+		testing_framework.Assert(t, output, err).Failure().Contains("request a2 (rotate-master-key) created")
+
+		output, err = cli.Raw("reject", "a2", "--reason", "not during the freeze", "--token", carolToken)
+		testing_framework.Assert(t, output, err).Success().Contains("rejected")
+
+		output, err = cli.Raw("list", "approvals")
+		testing_framework.Assert(t, output, err).Success().Contains("No open approval requests")
+	})
+
+	t.Run("settings_change_needs_approval", func(t *testing.T) {
+		output, err := cli.Raw("approvals", "set")
+		testing_framework.Assert(t, output, err).Failure().Contains("change-approvals required=none")
+	})
+}
@@ -0,0 +1,115 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestAuditLog(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Put("api-key", "s3cret-value")
+	testing_framework.Assert(t, output, err).Success()
+	output, err = cli.Get("api-key")
+	testing_framework.Assert(t, output, err).Success()
+
+	output, err = env.RunRawCommand([]string{"get", "api-key", "--token", "not-a-valid-token"}, env.CleanEnvironment(), "")
+	testing_framework.Assert(t, output, err).Failure()
+
+	logPath := filepath.Join(env.ConfigDir(), "audit.log")
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	log := string(data)
+	for _, want := range []string{`"op":"put","key":"api-key","result":"success"`, `"op":"get","key":"api-key","result":"auth_failed"`} {
+		if !strings.Contains(log, want) {
+			t.Errorf("the audit log should contain %s:\n%s", want, log)
+		}
+	}
+	if strings.Contains(log, "s3cret-value") {
+		t.Fatalf("the audit log must not contain secret values:\n%s", log)
+	}
+
+	output, err = cli.Raw("audit", "verify")
+	testing_framework.Assert(t, output, err).Success().Contains("Audit log intact")
+
+	// Removing the last entries is detected through the sealed head
+	lines := strings.SplitAfter(strings.TrimSuffix(log, "\n"), "\n")
+	if err := os.WriteFile(logPath, []byte(strings.Join(lines[:1], "")), 0600); err != nil {
+		t.Fatalf("truncate audit log: %v", err)
+	}
+	output, err = cli.Raw("audit", "verify")
+	testing_framework.Assert(t, output, err).Failure().Contains("entries were removed")
+	if code := exitCode(err); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+}
+
+func TestAuditQueryAndExport(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Put("db-password", "value")
+	testing_framework.Assert(t, output, err).Success()
+	output, err = cli.Get("db-password")
+	testing_framework.Assert(t, output, err).Success()
+	output, err = env.RunRawCommand([]string{"get", "db-password", "--token", "not-a-valid-token"}, env.CleanEnvironment(), "")
+	testing_framework.Assert(t, output, err).Failure()
+
+	output, err = cli.Raw("audit", "query", "--key", "db-*", "--op", "get", "--since", "1h")
+	testing_framework.Assert(t, output, err).Success().Contains("TIME").Contains("auth_failed").Contains("success")
+
+	output, err = cli.Raw("audit", "query", "--result", "auth_failed", "--format", "json")
+	testing_framework.Assert(t, output, err).Success()
+	var entries []struct {
+		Op     string `json:"op"`
+		Result string `json:"result"`
+	}
+	if err := json.Unmarshal(output, &entries); err != nil || len(entries) != 1 || entries[0].Op != "get" {
+		t.Fatalf("expected the one failed get: %v\n%s", err, output)
+	}
+
+	output, err = cli.Raw("audit", "query", "--since", "yesterday-ish")
+	testing_framework.Assert(t, output, err).Failure().Contains("invalid time")
+
+	output, err = cli.Raw("audit", "export", "--format", "cef", "--op", "put")
+	testing_framework.Assert(t, output, err).Success().Contains("CEF:0|simple-secrets|simple-secrets|").Contains("cs1=db-password")
+
+	out := filepath.Join(t.TempDir(), "audit.csv")
+	output, err = cli.Raw("audit", "export", "--format", "csv", "--out", out)
+	testing_framework.Assert(t, output, err).Success()
+	data, err := os.ReadFile(out)
+	if err != nil || !strings.HasPrefix(string(data), "seq,time,user,client,label,op,key,result,prev,hash\n") {
+		t.Fatalf("expected a CSV export: %v\n%s", err, data)
+	}
+
+	// Nothing has been rotated, so nothing is pruned, and the log still verifies
+	output, err = cli.Raw("audit", "prune", "--older-than", "1d")
+	testing_framework.Assert(t, output, err).Success().Contains("No rotated audit logs")
+	output, err = cli.Raw("audit", "verify")
+	testing_framework.Assert(t, output, err).Success().Contains("Audit log intact")
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestAuthLockout(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Users().Create("alice", "reader")
+	aliceToken := testing_framework.Assert(t, output, err).Success().ExtractToken()
+
+	config := `{"version": 1, "auth_backoff_after": 0, "auth_lockout_threshold": 3, "auth_lockout_duration": "1h"}`
+	if err := os.WriteFile(filepath.Join(env.ConfigDir(), "config.json"), []byte(config), 0600); err != nil {
+		t.Fatalf("write config.json: %v", err)
+	}
+
+	// A guess at alice's secret carries her token ID and a valid checksum
+	body := aliceToken[:16] + strings.Repeat("a", 52)
+	guess := fmt.Sprintf("%s_%08x", body, crc32.ChecksumIEEE([]byte(body)))
+
+	t.Run("failures_lock_out_the_user", func(t *testing.T) {
+		for range 2 {
+			output, err := cli.Raw("list", "keys", "--token", guess)
+			testing_framework.Assert(t, output, err).Failure().Contains("invalid token")
+		}
+		// A success from the same client clears the client's count, not alice's
+		output, err := cli.Raw("list", "keys")
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = cli.Raw("list", "keys", "--token", guess)
+		testing_framework.Assert(t, output, err).Failure().Contains("invalid token")
+
+		output, err = cli.Raw("list", "keys", "--token", aliceToken)
+		testing_framework.Assert(t, output, err).Failure().
+			Contains("too many failed authentication attempts").
+			Contains("simple-secrets user unlock alice")
+	})
+
+	t.Run("list_users_shows_lockout", func(t *testing.T) {
+		output, err := cli.Raw("list", "users")
+		testing_framework.Assert(t, output, err).Success().
+			Contains("Failed sign-ins: 🔒 locked out until").
+			Contains("3 in a row")
+	})
+
+	t.Run("unlock", func(t *testing.T) {
+		output, err := cli.Raw("user", "unlock", "alice")
+		testing_framework.Assert(t, output, err).Success().Contains(`Failed sign-ins for user "alice" cleared`)
+
+		output, err = cli.Raw("list", "keys", "--token", aliceToken)
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = cli.Raw("user", "unlock", "alice")
+		testing_framework.Assert(t, output, err).Success().Contains("No failed sign-ins recorded")
+
+		output, err = cli.Raw("user", "unlock")
+		testing_framework.Assert(t, output, err).Failure().Contains("specify exactly one of <username> or --client")
+	})
+
+	t.Run("valid_token_gets_through_client_lockout", func(t *testing.T) {
+		for range 3 {
+			output, err := cli.Raw("list", "keys", "--token", "not-a-token")
+			testing_framework.Assert(t, output, err).Failure().Contains("invalid token")
+		}
+		output, err := cli.Raw("list", "keys", "--token", "not-a-token")
+		testing_framework.Assert(t, output, err).Failure().Contains("too many failed authentication attempts")
+
+		// Other processes of the same OS user keep working, including the admin unlocking the client
+		output, err = cli.Raw("list", "keys", "--token", aliceToken)
+		testing_framework.Assert(t, output, err).Success()
+		output, err = cli.Raw("list", "users")
+		testing_framework.Assert(t, output, err).Success().Contains("🔒 locked out until")
+	})
+}
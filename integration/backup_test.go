@@ -0,0 +1,119 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestBackupArchiveCommands(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Put("db-password", "original")
+	testing_framework.Assert(t, output, err).Success()
+
+	work := t.TempDir()
+	passphraseFile := filepath.Join(work, "passphrase")
+	if err := os.WriteFile(passphraseFile, []byte("correct horse battery\n"), 0600); err != nil {
+		t.Fatalf("write passphrase: %v", err)
+	}
+	archive := filepath.Join(work, "store.ssbak")
+
+	output, err = cli.Raw("backup", "create", "--out", archive, "--passphrase-file", passphraseFile)
+	testing_framework.Assert(t, output, err).Success().Contains("1 secret(s)")
+	output, err = cli.Raw("backup", "verify", archive, "--passphrase-file", passphraseFile)
+	testing_framework.Assert(t, output, err).Success().Contains("is valid").Contains("Encryption: passphrase")
+	output, err = cli.Raw("backup", "verify", archive)
+	testing_framework.Assert(t, output, err).Failure().Contains("encrypted with a passphrase")
+
+	output, err = cli.Put("db-password", "changed")
+	testing_framework.Assert(t, output, err).Success()
+	output, err = cli.Raw("backup", "restore", archive, "--passphrase-file", passphraseFile, "--yes")
+	testing_framework.Assert(t, output, err).Success().Contains("Restored").Contains("pre-restore-")
+	output, err = cli.Get("db-password")
+	testing_framework.Assert(t, output, err).Success().Contains("original")
+
+	// An archive for a recipient key opens only with the private key
+	key := filepath.Join(work, "backup.key")
+	output, err = cli.Raw("backup", "keygen", "--out", key)
+	testing_framework.Assert(t, output, err).Success().Contains("ssbak-pub-")
+	recipientArchive := filepath.Join(work, "recipient.ssbak")
+	output, err = cli.Raw("backup", "create", "--out", recipientArchive, "--recipient", key+".pub")
+	testing_framework.Assert(t, output, err).Success()
+	output, err = cli.Raw("backup", "verify", recipientArchive, "--identity", key)
+	testing_framework.Assert(t, output, err).Success().Contains("Encryption: recipient")
+	output, err = cli.Raw("backup", "keygen", "--out", key)
+	testing_framework.Assert(t, output, err).Failure().Contains("already exists")
+}
+
+func TestBackupPruneCommand(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	backups := filepath.Join(env.ConfigDir(), "backups")
+	now := time.Now()
+	for _, backup := range []struct {
+		prefix string
+		age    time.Duration
+	}{
+		{"rotate-", time.Hour},
+		{"rotate-", 3 * 24 * time.Hour},
+		{"manual-", 40 * 24 * time.Hour},
+		{"pre-restore-", 2 * time.Hour},
+		{"pre-restore-", 10 * 24 * time.Hour},
+	} {
+		name := backup.prefix + now.Add(-backup.age).Format("20060102-150405")
+		if err := os.MkdirAll(filepath.Join(backups, name), 0700); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	config := `{"version": 1, "backup_retention": {"daily": 1, "types": {"manual": {"monthly": 2}}}}`
+	if err := os.WriteFile(filepath.Join(env.ConfigDir(), "config.json"), []byte(config), 0600); err != nil {
+		t.Fatalf("write config.json: %v", err)
+	}
+
+	cli := env.CLI()
+	output, err := cli.Raw("backup", "prune", "--dry-run")
+	testing_framework.Assert(t, output, err).Success().Contains("Would remove 2 of 5 backup(s), by backup_retention")
+	entries, _ := os.ReadDir(backups)
+	if count := countBackupDirs(entries); count != 5 {
+		t.Fatalf("a dry run should remove nothing, %d backups left", count)
+	}
+
+	output, err = cli.Raw("backup", "prune")
+	testing_framework.Assert(t, output, err).Success().Contains("Removed 2 of 5 backup(s)")
+	entries, _ = os.ReadDir(backups)
+	if count := countBackupDirs(entries); count != 3 {
+		t.Fatalf("expected 3 backups after pruning, got %d", count)
+	}
+}
+
+func countBackupDirs(entries []os.DirEntry) int {
+	count := 0
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != "sealed" {
+			count++
+		}
+	}
+	return count
+}
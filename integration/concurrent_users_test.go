@@ -0,0 +1,67 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+// TestConcurrentUserManagement runs user changes as separate processes at once; none may be lost
+func TestConcurrentUserManagement(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	const numProcesses = 10
+
+	var wg sync.WaitGroup
+	for i := range numProcesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			output, err := cli.Users().Create(fmt.Sprintf("user%d", i), "reader")
+			if err != nil {
+				t.Errorf("create user%d: %v\n%s", i, err, output)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range numProcesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			output, err := cli.Raw("user", "update", fmt.Sprintf("user%d", i), "--contact", fmt.Sprintf("owner%d@example.com", i))
+			if err != nil {
+				t.Errorf("update user%d: %v\n%s", i, err, output)
+			}
+		}()
+	}
+	wg.Wait()
+
+	output, err := cli.Raw("list", "users")
+	testing_framework.Assert(t, output, err).Success().Contains(fmt.Sprintf("Found %d user(s)", numProcesses+1))
+	for i := range numProcesses {
+		if !strings.Contains(string(output), fmt.Sprintf("Contact: owner%d@example.com", i)) {
+			t.Errorf("the update of user%d was lost:\n%s", i, output)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestDerivedTokens(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	for _, key := range []string{"payments-db", "hr-db"} {
+		output, err := cli.Put(key, "value-of-"+key)
+		testing_framework.Assert(t, output, err).Success()
+	}
+
+	output, err := cli.Raw("token", "issue", "--scope", "read:payments-*", "--ttl", "1h")
+	derivedToken := testing_framework.Assert(t, output, err).Success().
+		Contains("Derived token dt_").
+		Contains("read:payments-*").
+		ExtractToken()
+	derived := testing_framework.NewCLIRunnerWithToken(env, derivedToken)
+
+	t.Run("reads_only_within_scope", func(t *testing.T) {
+		output, err := derived.Get("payments-db")
+		testing_framework.Assert(t, output, err).Success().Contains("value-of-payments-db")
+
+		output, err = derived.Get("hr-db")
+		testing_framework.Assert(t, output, err).Failure().Contains("outside the token's scopes")
+
+		output, err = derived.Put("payments-db", "changed")
+		testing_framework.Assert(t, output, err).Failure()
+	})
+
+	t.Run("cannot_administer", func(t *testing.T) {
+		output, err := cli.Raw("create-user", "mallory", "admin", "--token", derivedToken)
+		testing_framework.Assert(t, output, err).Failure().Contains("permission denied")
+
+		output, err = cli.Raw("list", "backups", "--token", derivedToken)
+		testing_framework.Assert(t, output, err).Failure().Contains("limited to its scopes")
+
+		output, err = cli.Raw("token", "issue", "--scope", "read:*", "--token", derivedToken)
+		testing_framework.Assert(t, output, err).Failure().Contains("cannot issue further tokens")
+	})
+
+	t.Run("never_exceeds_issuer", func(t *testing.T) {
+		output, err := cli.Users().Create("reader1", "reader")
+		readerToken := testing_framework.Assert(t, output, err).Success().ExtractToken()
+
+		output, err = cli.Raw("token", "issue", "--scope", "write:payments-*", "--token", readerToken)
+		testing_framework.Assert(t, output, err).Failure().Contains("need 'write' permission")
+
+		output, err = cli.Raw("token", "issue", "--scope", "read:payments-*", "--ttl", "48h")
+		testing_framework.Assert(t, output, err).Failure().Contains("within 24h")
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		output, err := cli.Raw("token", "revoke", derivedToken)
+		testing_framework.Assert(t, output, err).Success().Contains("revoked")
+
+		output, err = derived.Get("payments-db")
+		testing_framework.Assert(t, output, err).Failure().Contains("has been revoked")
+	})
+}
@@ -0,0 +1,101 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+func TestDoctorCommand(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Put("api-key", "value")
+	testing_framework.Assert(t, output, err).Success()
+
+	t.Run("healthy_store", func(t *testing.T) {
+		output, err := cli.Raw("doctor")
+		testing_framework.Assert(t, output, err).Success().Contains("0 error(s), 0 warning(s)")
+	})
+
+	orphan := filepath.Join(env.ConfigDir(), "secrets.json.tmp.999999999.1")
+	if err := os.WriteFile(orphan, nil, 0600); err != nil {
+		t.Fatalf("failed to write orphan: %v", err)
+	}
+
+	t.Run("warnings_exit_1", func(t *testing.T) {
+		output, err := cli.Raw("doctor")
+		if code := exitCode(err); code != 1 {
+			t.Fatalf("expected exit code 1 for warnings, got %d: %s", code, output)
+		}
+		testing_framework.Assert(t, output, nil).Contains("orphaned-files").Contains("fixable with --fix")
+	})
+
+	t.Run("errors_exit_2_json", func(t *testing.T) {
+		if err := os.Chmod(filepath.Join(env.ConfigDir(), "secrets.json"), 0644); err != nil {
+			t.Fatalf("chmod: %v", err)
+		}
+
+		output, err := cli.Raw("doctor", "--json")
+		if code := exitCode(err); code != 2 {
+			t.Fatalf("expected exit code 2 for errors, got %d: %s", code, output)
+		}
+
+		var report struct {
+			Status   string `json:"status"`
+			Findings []struct {
+				Check string `json:"check"`
+			} `json:"findings"`
+		}
+		if err := json.Unmarshal(output, &report); err != nil {
+			t.Fatalf("doctor --json output is not JSON: %v\n%s", err, output)
+		}
+		if report.Status != "error" || len(report.Findings) != 2 {
+			t.Fatalf("unexpected report: %s", output)
+		}
+	})
+
+	t.Run("fix", func(t *testing.T) {
+		output, err := cli.Raw("doctor", "--fix")
+		testing_framework.Assert(t, output, err).Success().Contains("(fixed)")
+
+		if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+			t.Fatalf("orphaned temp file was not removed")
+		}
+		output, err = cli.Raw("doctor")
+		testing_framework.Assert(t, output, err).Success()
+	})
+}
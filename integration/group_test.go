@@ -0,0 +1,77 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestGroups(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Users().Create("alice", "reader")
+	aliceToken := testing_framework.Assert(t, output, err).Success().ExtractToken()
+	alice := testing_framework.NewCLIRunnerWithToken(env, aliceToken)
+
+	output, err = cli.Raw("role", "create", "ci-writer", "--permissions", "read,write")
+	testing_framework.Assert(t, output, err).Success()
+
+	t.Run("create_and_add_member", func(t *testing.T) {
+		output, err := cli.Raw("group", "create", "payments", "--role", "ci-writer")
+		testing_framework.Assert(t, output, err).Success().Contains(`Group "payments" created`)
+
+		output, err = cli.Raw("group", "add-member", "payments", "alice")
+		testing_framework.Assert(t, output, err).Success().Contains(`added to group "payments"`)
+
+		output, err = cli.Raw("group", "list")
+		testing_framework.Assert(t, output, err).Success().Contains("Roles: ci-writer").Contains("Members: alice")
+	})
+
+	t.Run("members_inherit_roles_and_policies", func(t *testing.T) {
+		output, err := alice.Put("payments-db", "v1")
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = cli.Raw("policy", "deny", "--group", "payments", "--actions", "read", "--keys", "payments-*")
+		testing_framework.Assert(t, output, err).Success().Contains("deny group:payments read")
+
+		output, err = alice.Get("payments-db")
+		testing_framework.Assert(t, output, err).Failure().Contains("denied by policy")
+	})
+
+	t.Run("list_users_shows_effective_permissions", func(t *testing.T) {
+		output, err := cli.Raw("list", "users")
+		testing_framework.Assert(t, output, err).Success().
+			Contains("Groups: payments").
+			Contains("Effective permissions: read, write, rotate-own-token (with group roles: ci-writer)")
+	})
+
+	t.Run("last_admin_through_group", func(t *testing.T) {
+		output, err := cli.Raw("group", "create", "ops", "--role", "admin")
+		testing_framework.Assert(t, output, err).Success()
+		output, err = cli.Raw("group", "add-member", "ops", "alice")
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = cli.Raw("user", "update", "admin", "--role", "reader", "--token", aliceToken)
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = cli.Raw("group", "remove-member", "ops", "alice", "--token", aliceToken)
+		testing_framework.Assert(t, output, err).Failure().Contains("last admin")
+	})
+}
@@ -0,0 +1,72 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestUserFileTamperDetection(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Users().Create("bob", "reader")
+	testing_framework.Assert(t, output, err).Success()
+
+	usersPath := filepath.Join(env.ConfigDir(), "users.json")
+	data, err := os.ReadFile(usersPath)
+	if err != nil {
+		t.Fatalf("failed to read users.json: %v", err)
+	}
+	if !strings.Contains(string(data), `"mac"`) {
+		t.Fatalf("users.json should be sealed: %s", data)
+	}
+
+	t.Run("manual_edit_refuses_authentication", func(t *testing.T) {
+		edited := strings.Replace(string(data), `"role": "reader"`, `"role": "admin"`, 1)
+		if err := os.WriteFile(usersPath, []byte(edited), 0600); err != nil {
+			t.Fatalf("failed to edit users.json: %v", err)
+		}
+
+		output, err := cli.List().Keys()
+		testing_framework.Assert(t, output, err).Failure().
+			Contains("integrity check failed").
+			Contains("users reseal")
+	})
+
+	t.Run("reseal_accepts_edit", func(t *testing.T) {
+		output, err := cli.Raw("users", "reseal")
+		testing_framework.Assert(t, output, err).Success().Contains("resealed")
+
+		output, err = cli.List().Users()
+		testing_framework.Assert(t, output, err).Success().Contains("bob")
+	})
+
+	t.Run("rotation_keeps_seal", func(t *testing.T) {
+		output, err := cli.Raw("rotate", "master-key", "--yes")
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = cli.List().Keys()
+		testing_framework.Assert(t, output, err).Success()
+	})
+}
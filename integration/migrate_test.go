@@ -0,0 +1,95 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestMigrateCommand(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	rolesPath := filepath.Join(env.ConfigDir(), "roles.json")
+	legacyRoles := `{"admin": ["read", "write", "rotate-tokens", "manage-users", "rotate-own-token"], "reader": ["read", "rotate-own-token"]}`
+	if err := os.WriteFile(rolesPath, []byte(legacyRoles), 0600); err != nil {
+		t.Fatalf("failed to write legacy roles.json: %v", err)
+	}
+	// An installation from before sealing has no sealed copies
+	if err := os.RemoveAll(filepath.Join(env.ConfigDir(), "backups", "sealed")); err != nil {
+		t.Fatalf("failed to remove sealed copies: %v", err)
+	}
+
+	t.Run("legacy_files_still_readable", func(t *testing.T) {
+		output, err := cli.List().Keys()
+		testing_framework.Assert(t, output, err).Success()
+	})
+
+	t.Run("dry_run", func(t *testing.T) {
+		output, err := cli.Raw("migrate", "--dry-run")
+		testing_framework.Assert(t, output, err).Success().
+			Contains("dry run").
+			Contains("roles.json: version 0 -> 1").
+			Contains("users.json: version 2 (up to date)")
+
+		data, _ := os.ReadFile(rolesPath)
+		if string(data) != legacyRoles {
+			t.Fatalf("dry run must not modify roles.json: %s", data)
+		}
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		output, err := cli.Raw("migrate")
+		testing_framework.Assert(t, output, err).Success().Contains("1 file(s) migrated")
+
+		data, _ := os.ReadFile(rolesPath)
+		if !strings.Contains(string(data), `"version": 1`) || !strings.Contains(string(data), `"mac"`) {
+			t.Fatalf("expected roles.json to carry a version and a seal: %s", data)
+		}
+
+		backups, _ := filepath.Glob(filepath.Join(env.ConfigDir(), "backups", "migrations", "roles.json.v0-*"))
+		if len(backups) != 1 {
+			t.Fatalf("expected a backup before the migration step, got %v", backups)
+		}
+	})
+
+	t.Run("unsealed_file_refused_once_sealed", func(t *testing.T) {
+		if err := os.WriteFile(rolesPath, []byte(legacyRoles), 0600); err != nil {
+			t.Fatalf("failed to write roles.json: %v", err)
+		}
+
+		output, err := cli.List().Keys()
+		testing_framework.Assert(t, output, err).Failure().Contains("roles.json has no integrity seal")
+		output, err = cli.Raw("migrate")
+		testing_framework.Assert(t, output, err).Failure()
+	})
+
+	t.Run("newer_format_refused", func(t *testing.T) {
+		if err := os.WriteFile(rolesPath, []byte(`{"version": 999, "roles": {}}`), 0600); err != nil {
+			t.Fatalf("failed to write roles.json: %v", err)
+		}
+
+		output, err := cli.List().Keys()
+		testing_framework.Assert(t, output, err).Failure().Contains("newer format")
+	})
+}
@@ -0,0 +1,86 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestPathScopedPolicies(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	for _, key := range []string{"payments-db", "payments-api", "hr-db"} {
+		output, err := cli.Put(key, "value-of-"+key)
+		testing_framework.Assert(t, output, err).Success()
+	}
+
+	output, err := cli.Users().Create("ci", "reader")
+	ciToken := testing_framework.Assert(t, output, err).Success().ExtractToken()
+	ci := testing_framework.NewCLIRunnerWithToken(env, ciToken)
+
+	output, err = cli.Raw("policy", "allow", "--user", "ci", "--actions", "read,list", "--keys", "payments-*")
+	testing_framework.Assert(t, output, err).Success().Contains("Policy p1 added")
+
+	output, err = cli.Raw("policy", "deny", "--user", "ci", "--actions", "read", "--keys", "payments-api")
+	testing_framework.Assert(t, output, err).Success().Contains("Policy p2 added")
+
+	t.Run("list_filters_keys", func(t *testing.T) {
+		output, err := ci.List().Keys()
+		testing_framework.Assert(t, output, err).Success().
+			Contains("payments-db").
+			Contains("payments-api").
+			NotContains("hr-db")
+
+		output, err = cli.List().Keys()
+		testing_framework.Assert(t, output, err).Success().Contains("hr-db")
+	})
+
+	t.Run("get_respects_allow_and_deny", func(t *testing.T) {
+		output, err := ci.Get("payments-db")
+		testing_framework.Assert(t, output, err).Success().Contains("value-of-payments-db")
+
+		output, err = ci.Get("hr-db")
+		testing_framework.Assert(t, output, err).Failure().Contains("access denied")
+
+		output, err = ci.Get("payments-api")
+		testing_framework.Assert(t, output, err).Failure().Contains("denied by policy p2")
+	})
+
+	t.Run("policy_test_explains", func(t *testing.T) {
+		output, err := cli.Raw("policy", "test", "--user", "ci", "--key", "payments-api")
+		testing_framework.Assert(t, output, err).Success().
+			Contains("denied by policy p2").
+			Contains("lacks the 'write' permission").
+			Contains("allowed by policy p1")
+	})
+
+	t.Run("policy_commands_require_manage_users", func(t *testing.T) {
+		output, err := cli.Raw("policy", "list", "--token", ciToken)
+		testing_framework.Assert(t, output, err).Failure().Contains("need 'manage-users' permission")
+	})
+
+	t.Run("remove_restores_access", func(t *testing.T) {
+		output, err := cli.Raw("policy", "remove", "p2")
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = ci.Get("payments-api")
+		testing_framework.Assert(t, output, err).Success().Contains("value-of-payments-api")
+	})
+}
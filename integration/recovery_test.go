@@ -0,0 +1,70 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestAdminRecovery(t *testing.T) {
+	env := &testing_framework.TestEnvironment{}
+	env.SetupCleanEnvironment(t, t.TempDir(), "../simple-secrets")
+	defer env.Cleanup()
+
+	output, err := env.RunRawCommand([]string{"setup", "--non-interactive", "--json"}, env.CleanEnvironment(), "")
+	testing_framework.Assert(t, output, err).Success()
+	var setup struct {
+		Users []struct {
+			Token string `json:"token"`
+		} `json:"users"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	if err := json.Unmarshal(output, &setup); err != nil || len(setup.RecoveryCodes) != 10 {
+		t.Fatalf("setup should print ten recovery codes: %v\n%s", err, output)
+	}
+	lostToken := setup.Users[0].Token
+
+	// Recovery needs no token
+	output, err = env.RunRawCommand([]string{"recover", "admin", "--code", setup.RecoveryCodes[0]}, env.CleanEnvironment(), "")
+	recovered := testing_framework.Assert(t, output, err).Success().
+		Contains("ADMIN RECOVERY").
+		Contains("9 recovery code(s) left").
+		ExtractToken()
+
+	output, err = env.RunRawCommand([]string{"recover", "admin", "--code", setup.RecoveryCodes[0]}, env.CleanEnvironment(), "")
+	testing_framework.Assert(t, output, err).Failure().Contains("invalid or already used recovery code")
+
+	output, err = env.RunRawCommand([]string{"list", "keys", "--token", lostToken}, env.CleanEnvironment(), "")
+	testing_framework.Assert(t, output, err).Failure()
+
+	// The code can also be given on standard input
+	output, err = env.RunRawCommand([]string{"recover", "admin"}, env.CleanEnvironment(), setup.RecoveryCodes[1]+"\n")
+	recovered = testing_framework.Assert(t, output, err).Success().ExtractToken()
+
+	output, err = env.RunRawCommand([]string{"recovery-codes", "status", "--token", recovered}, env.CleanEnvironment(), "")
+	testing_framework.Assert(t, output, err).Success().
+		Contains("Recovery codes: 8 unused").
+		Contains("2 recovery(ies) made")
+
+	output, err = env.RunRawCommand([]string{"recovery-codes", "regenerate", "--token", recovered}, env.CleanEnvironment(), "")
+	testing_framework.Assert(t, output, err).Success().Contains("RECOVERY CODES")
+
+	output, err = env.RunRawCommand([]string{"recover", "admin", "--code", setup.RecoveryCodes[2]}, env.CleanEnvironment(), "")
+	testing_framework.Assert(t, output, err).Failure().Contains("invalid or already used recovery code")
+}
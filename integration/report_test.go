@@ -0,0 +1,100 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestReportAccess(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	for _, key := range []string{"payments-db", "hr-db"} {
+		output, err := cli.Put(key, "value")
+		testing_framework.Assert(t, output, err).Success()
+	}
+	output, err := cli.Users().Create("ci", "reader")
+	ciToken := testing_framework.Assert(t, output, err).Success().ExtractToken()
+	output, err = cli.Raw("policy", "allow", "--user", "ci", "--actions", "read,list", "--keys", "payments-*")
+	testing_framework.Assert(t, output, err).Success()
+
+	output, err = cli.Raw("report", "access")
+	testing_framework.Assert(t, output, err).Success().Contains("TOKEN STATUS").Contains("hr-db").Contains("payments-db")
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 2 && fields[0] == "ci" && strings.Join(fields[len(fields)-2:], " ") != "--- r-l" {
+			t.Errorf("ci should have no access to hr-db and read and list payments-db: %q", line)
+		}
+	}
+
+	output, err = cli.Raw("report", "access", "--namespace", "payments-*", "--format", "json")
+	testing_framework.Assert(t, output, err).Success()
+	var report struct {
+		Columns []string `json:"columns"`
+		Users   []struct {
+			Username string `json:"username"`
+			Access   map[string]struct {
+				Actions []string `json:"actions"`
+			} `json:"access"`
+		} `json:"users"`
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		t.Fatalf("parse JSON report: %v\n%s", err, output)
+	}
+	if len(report.Columns) != 1 || report.Columns[0] != "payments-*" || len(report.Users) != 2 {
+		t.Fatalf("unexpected report: %s", output)
+	}
+
+	output, err = cli.Raw("report", "access", "--format", "csv")
+	testing_framework.Assert(t, output, err).Success().Contains("username,roles,groups,status,token").Contains("ci,reader,,active,default,0,active")
+
+	output, err = cli.Raw("report", "access", "--token", ciToken)
+	testing_framework.Assert(t, output, err).Failure()
+}
+
+func TestDescribeAndReportStale(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Put("db-password", "value")
+	testing_framework.Assert(t, output, err).Success()
+	for range 2 {
+		output, err = cli.Raw("get", "db-password", "--client", "ci-deploy")
+		testing_framework.Assert(t, output, err).Success()
+	}
+
+	output, err = cli.Raw("describe", "db-password")
+	testing_framework.Assert(t, output, err).Success().
+		Contains("Reads: 2").Contains("by admin (ci-deploy)").Contains("ci-deploy: 2")
+
+	output, err = cli.Raw("describe", "missing")
+	testing_framework.Assert(t, output, err).Failure()
+	output, err = cli.Raw("get", "db-password", "--client", "bad label")
+	testing_framework.Assert(t, output, err).Failure().Contains("invalid client label")
+
+	output, err = cli.Raw("report", "stale", "--unused-for", "90d")
+	testing_framework.Assert(t, output, err).Success().Contains("No stale secrets.")
+
+	output, err = cli.Raw("audit", "query", "--op", "get")
+	testing_framework.Assert(t, output, err).Success().Contains("ci-deploy")
+}
@@ -61,7 +61,7 @@ func TestConsolidatedListCommandsRefactored(t *testing.T) {
 			name:     "list no args",
 			command:  func() ([]byte, error) { return env.CLI().Raw("list") },
 			wantErr:  true,
-			contains: "accepts 1 arg(s), received 0",
+			contains: "accepts between 1 and 2 arg(s), received 0",
 		},
 	}
 
@@ -0,0 +1,80 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestCustomRoles(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+
+	output, err := cli.Raw("role", "create", "ci-writer", "--permissions", "read,write")
+	testing_framework.Assert(t, output, err).Success().Contains(`Role "ci-writer" created`)
+
+	output, err = cli.Raw("role", "list")
+	testing_framework.Assert(t, output, err).Success().
+		Contains("ci-writer").
+		Contains("Permissions: read, write").
+		Contains("Permission catalog:").
+		Contains("rotate-own-token")
+
+	output, err = cli.Users().Create("ci", "ci-writer")
+	ciToken := testing_framework.Assert(t, output, err).Success().ExtractToken()
+	ci := testing_framework.NewCLIRunnerWithToken(env, ciToken)
+
+	t.Run("granted_permissions_work", func(t *testing.T) {
+		output, err := ci.Put("deploy-key", "abc123")
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = ci.Get("deploy-key")
+		testing_framework.Assert(t, output, err).Success().Contains("abc123")
+	})
+
+	t.Run("missing_permissions_are_named", func(t *testing.T) {
+		output, err := ci.List().Users()
+		testing_framework.Assert(t, output, err).Failure().Contains("need 'manage-users' permission")
+
+		output, err = ci.Rotate().SelfToken()
+		testing_framework.Assert(t, output, err).Failure().Contains("need 'rotate-own-token' permission")
+	})
+
+	t.Run("update_revokes_write", func(t *testing.T) {
+		output, err := cli.Raw("role", "update", "ci-writer", "--permissions", "read")
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = ci.Put("deploy-key", "changed")
+		testing_framework.Assert(t, output, err).Failure().Contains("need 'write' permission")
+	})
+
+	t.Run("delete_refuses_assigned_and_builtin_roles", func(t *testing.T) {
+		output, err := cli.Raw("role", "delete", "ci-writer")
+		testing_framework.Assert(t, output, err).Failure().Contains("still assigned to: ci")
+
+		output, err = cli.Raw("role", "delete", "admin")
+		testing_framework.Assert(t, output, err).Failure().Contains("cannot be deleted")
+	})
+
+	t.Run("unknown_permission_is_rejected", func(t *testing.T) {
+		output, err := cli.Raw("role", "create", "ops", "--permissions", "deploy")
+		testing_framework.Assert(t, output, err).Failure().Contains(`unknown permission "deploy"`)
+	})
+}
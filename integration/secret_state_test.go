@@ -0,0 +1,77 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestDisableSecretWithReason(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+
+	output, err := cli.Put("api-key", "value")
+	testing_framework.Assert(t, output, err).Success()
+
+	t.Run("disable_with_reason", func(t *testing.T) {
+		output, err := cli.Raw("disable", "secret", "api-key", "--reason", "leaked in CI logs")
+		testing_framework.Assert(t, output, err).Success().Contains("Reason: leaked in CI logs")
+	})
+
+	t.Run("list_disabled_shows_metadata", func(t *testing.T) {
+		output, err := cli.List().Disabled()
+		testing_framework.Assert(t, output, err).Success().
+			Contains("api-key").
+			Contains("by admin").
+			Contains("Reason: leaked in CI logs")
+	})
+
+	t.Run("state_is_stored_explicitly", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(env.ConfigDir(), "secrets.json"))
+		if err != nil {
+			t.Fatalf("failed to read secrets.json: %v", err)
+		}
+		if strings.Contains(string(data), "__DISABLED_") {
+			t.Fatalf("disabled secrets should not use key mangling: %s", data)
+		}
+		if !strings.Contains(string(data), `"state": "disabled"`) {
+			t.Fatalf("expected explicit disabled state in secrets.json: %s", data)
+		}
+	})
+
+	t.Run("put_on_disabled_secret_fails", func(t *testing.T) {
+		output, err := cli.Put("api-key", "replacement")
+		testing_framework.Assert(t, output, err).Failure().Contains("secret is disabled")
+	})
+
+	t.Run("reason_rejected_for_tokens", func(t *testing.T) {
+		output, err := cli.Raw("disable", "token", "some-token-value", "--reason", "nope")
+		testing_framework.Assert(t, output, err).Failure().Contains("--reason can only be used with 'disable user' or 'disable secret'")
+	})
+
+	t.Run("reserved_prefix_rejected", func(t *testing.T) {
+		output, err := cli.Put("__DISABLED_sneaky", "value")
+		testing_framework.Assert(t, output, err).Failure().Contains("reserved prefix")
+	})
+}
@@ -0,0 +1,153 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestNonInteractiveSetup(t *testing.T) {
+	tempDir := t.TempDir()
+	env := &testing_framework.TestEnvironment{}
+	env.SetupCleanEnvironment(t, tempDir, "../simple-secrets")
+	defer env.Cleanup()
+
+	tokenPath := filepath.Join(tempDir, "ops.token")
+	ciTokenPath := filepath.Join(tempDir, "ci.token")
+	provisionPath := filepath.Join(tempDir, "provision.json")
+	provision := `{
+		"roles": [{"name": "ci-writer", "permissions": ["read", "write"]}],
+		"users": [
+			{"username": "ci", "role": "ci-writer", "token_out": "` + ciTokenPath + `"},
+			{"username": "viewer", "role": "reader"}
+		]
+	}`
+	if err := os.WriteFile(provisionPath, []byte(provision), 0600); err != nil {
+		t.Fatalf("write provisioning file: %v", err)
+	}
+
+	output, err := env.RunRawCommand([]string{"setup", "--non-interactive", "--admin-username", "ops",
+		"--token-out", tokenPath, "--provision", provisionPath, "--json"}, env.CleanEnvironment(), "")
+	testing_framework.Assert(t, output, err).Success()
+
+	var result struct {
+		Status string `json:"status"`
+		Users  []struct {
+			Username  string `json:"username"`
+			Token     string `json:"token"`
+			TokenFile string `json:"token_file"`
+		} `json:"users"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		t.Fatalf("setup --json output is not JSON: %v\n%s", err, output)
+	}
+	if result.Status != "created" || len(result.Users) != 3 {
+		t.Fatalf("unexpected result: %s", output)
+	}
+	if result.Users[0].Token != "" || result.Users[1].Token != "" || result.Users[2].Token == "" {
+		t.Fatalf("only tokens without a file should be printed: %s", output)
+	}
+
+	info, err := os.Stat(tokenPath)
+	if err != nil {
+		t.Fatalf("token file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("token file mode is %o, want 600", info.Mode().Perm())
+	}
+	data, err := os.ReadFile(tokenPath)
+	if err != nil {
+		t.Fatalf("read token file: %v", err)
+	}
+	opsToken := strings.TrimSpace(string(data))
+
+	ciToken, err := os.ReadFile(ciTokenPath)
+	if err != nil {
+		t.Fatalf("read ci token file: %v", err)
+	}
+	output, err = env.RunRawCommand([]string{"put", "deploy-key", "value"},
+		append(env.CleanEnvironment(), "SIMPLE_SECRETS_TOKEN="+strings.TrimSpace(string(ciToken))), "")
+	testing_framework.Assert(t, output, err).Success()
+
+	output, err = env.RunRawCommand([]string{"list", "users"},
+		append(env.CleanEnvironment(), "SIMPLE_SECRETS_TOKEN="+opsToken), "")
+	testing_framework.Assert(t, output, err).Success().Contains("ops").Contains("ci").Contains("viewer")
+
+	t.Run("rerun_exits_3_without_changes", func(t *testing.T) {
+		before, err := os.ReadFile(filepath.Join(env.ConfigDir(), "users.json"))
+		if err != nil {
+			t.Fatalf("read users.json: %v", err)
+		}
+
+		output, err := env.RunRawCommand([]string{"setup", "--non-interactive", "--json"}, env.CleanEnvironment(), "")
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+			t.Fatalf("expected exit code 3, got %v\n%s", err, output)
+		}
+		testing_framework.Assert(t, output, err).Contains(`"status": "exists"`)
+
+		after, err := os.ReadFile(filepath.Join(env.ConfigDir(), "users.json"))
+		if err != nil || string(before) != string(after) {
+			t.Fatalf("users.json must be unchanged: %v", err)
+		}
+	})
+
+	t.Run("flags_require_non_interactive", func(t *testing.T) {
+		output, err := env.RunRawCommand([]string{"setup", "--json"}, env.CleanEnvironment(), "")
+		testing_framework.Assert(t, output, err).Failure().Contains("--json requires --non-interactive")
+	})
+}
+
+func TestNonInteractiveSetupFailsWithoutWriting(t *testing.T) {
+	tempDir := t.TempDir()
+	env := &testing_framework.TestEnvironment{}
+	env.SetupCleanEnvironment(t, tempDir, "../simple-secrets")
+	defer env.Cleanup()
+
+	t.Run("invalid_provisioning", func(t *testing.T) {
+		provisionPath := filepath.Join(tempDir, "provision.json")
+		if err := os.WriteFile(provisionPath, []byte(`{"users": [{"username": "ci", "role": "writer"}]}`), 0600); err != nil {
+			t.Fatalf("write provisioning file: %v", err)
+		}
+		output, err := env.RunRawCommand([]string{"setup", "--non-interactive", "--provision", provisionPath}, env.CleanEnvironment(), "")
+		testing_framework.Assert(t, output, err).Failure().Contains(`provisioning user "ci"`)
+	})
+
+	t.Run("existing_token_file", func(t *testing.T) {
+		tokenPath := filepath.Join(tempDir, "existing.token")
+		if err := os.WriteFile(tokenPath, []byte("keep me"), 0600); err != nil {
+			t.Fatalf("write token file: %v", err)
+		}
+		output, err := env.RunRawCommand([]string{"setup", "--non-interactive", "--token-out", tokenPath}, env.CleanEnvironment(), "")
+		testing_framework.Assert(t, output, err).Failure().Contains("already exists")
+
+		if data, _ := os.ReadFile(tokenPath); string(data) != "keep me" {
+			t.Fatalf("an existing token file must not be overwritten, got %q", data)
+		}
+	})
+
+	if _, err := os.Stat(filepath.Join(env.ConfigDir(), "users.json")); !os.IsNotExist(err) {
+		t.Fatalf("a failed setup must not create users.json, stat: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestStructuredSecretValues(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+
+	t.Run("put_json", func(t *testing.T) {
+		output, err := cli.Put("db-creds", "--json", `{"username":"app","password":"s3cret","port":5432}`)
+		testing_framework.Assert(t, output, err).Success().Contains("3 field(s)")
+	})
+
+	t.Run("get_field", func(t *testing.T) {
+		output, err := cli.Raw("get", "db-creds", "--field", "password")
+		testing_framework.Assert(t, output, err).Success()
+		if strings.TrimSpace(string(output)) != "s3cret" {
+			t.Fatalf("expected field value, got: %s", output)
+		}
+	})
+
+	t.Run("set_field", func(t *testing.T) {
+		output, err := cli.Put("db-creds", "--set", "password=rotated")
+		testing_framework.Assert(t, output, err).Success().Contains("updated")
+
+		output, err = cli.Raw("get", "db-creds", "--field", "password")
+		testing_framework.Assert(t, output, err).Success().Contains("rotated")
+	})
+
+	t.Run("restore_previous_version", func(t *testing.T) {
+		output, err := cli.Secrets().Restore("db-creds")
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = cli.Raw("get", "db-creds", "--field", "password")
+		testing_framework.Assert(t, output, err).Success().Contains("s3cret")
+	})
+
+	t.Run("list_fields", func(t *testing.T) {
+		output, err := cli.Raw("list", "fields", "db-creds")
+		testing_framework.Assert(t, output, err).Success()
+		if strings.TrimSpace(string(output)) != "password\nport\nusername" {
+			t.Fatalf("unexpected field list: %s", output)
+		}
+	})
+
+	t.Run("put_from_yaml_file", func(t *testing.T) {
+		path := filepath.Join(env.TempDir(), "creds.yaml")
+		if err := os.WriteFile(path, []byte("username: svc\npassword: 'p@ss'\n"), 0600); err != nil {
+			t.Fatalf("failed to write YAML file: %v", err)
+		}
+
+		output, err := cli.Put("svc-creds", "--from-file", path)
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = cli.Raw("get", "svc-creds", "--field", "password")
+		testing_framework.Assert(t, output, err).Success().Contains("p@ss")
+	})
+
+	t.Run("field_on_plain_secret_fails", func(t *testing.T) {
+		output, err := cli.Put("plain", "value")
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = cli.Raw("get", "plain", "--field", "password")
+		testing_framework.Assert(t, output, err).Failure().Contains("not a structured value")
+	})
+}
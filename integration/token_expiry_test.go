@@ -0,0 +1,66 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestTokenExpiry(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Put("db-password", "hunter2")
+	testing_framework.Assert(t, output, err).Success()
+
+	config := `{"version": 1, "max_token_age": "1h", "token_expiry_warning": "2h"}`
+	if err := os.WriteFile(filepath.Join(env.ConfigDir(), "config.json"), []byte(config), 0600); err != nil {
+		t.Fatalf("write config.json: %v", err)
+	}
+
+	t.Run("warning_period_prints_notice", func(t *testing.T) {
+		output, err := cli.Get("db-password")
+		testing_framework.Assert(t, output, err).Success().
+			Contains("hunter2").
+			Contains(`Warning: token "default" of user "admin" expires in`)
+	})
+
+	t.Run("expired_token_is_rejected", func(t *testing.T) {
+		output, err := cli.Raw("token", "create", "--name", "brief", "--expires-in", "1s")
+		briefToken := testing_framework.Assert(t, output, err).Success().ExtractToken()
+
+		time.Sleep(1100 * time.Millisecond)
+
+		output, err = testing_framework.NewCLIRunnerWithToken(env, briefToken).Get("db-password")
+		testing_framework.Assert(t, output, err).Failure().
+			Contains("token expired").
+			NotContains("hunter2")
+	})
+
+	t.Run("stale_report", func(t *testing.T) {
+		output, err := cli.Raw("list", "users", "--stale")
+		testing_framework.Assert(t, output, err).Success().
+			Contains("admin / default: expires in").
+			Contains("admin / brief: expired").
+			Contains("max_token_age is 1h0m0s")
+	})
+}
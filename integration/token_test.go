@@ -0,0 +1,173 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestNamedTokens(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Put("db-password", "hunter2")
+	testing_framework.Assert(t, output, err).Success()
+
+	output, err = cli.Users().Create("alice", "reader")
+	aliceToken := testing_framework.Assert(t, output, err).Success().ExtractToken()
+
+	output, err = cli.Raw("token", "create", "--name", "laptop", "--description", "work laptop", "--token", aliceToken)
+	laptopToken := testing_framework.Assert(t, output, err).Success().Contains(`Token "laptop" created`).ExtractToken()
+	laptop := testing_framework.NewCLIRunnerWithToken(env, laptopToken)
+
+	output, err = cli.Raw("token", "create", "--user", "alice", "--name", "ci", "--expires-in", "30d")
+	ciToken := testing_framework.Assert(t, output, err).Success().Contains("Expires:").ExtractToken()
+	ci := testing_framework.NewCLIRunnerWithToken(env, ciToken)
+
+	t.Run("every_token_authenticates", func(t *testing.T) {
+		for _, runner := range []*testing_framework.CLIRunner{laptop, ci, testing_framework.NewCLIRunnerWithToken(env, aliceToken)} {
+			output, err := runner.Get("db-password")
+			testing_framework.Assert(t, output, err).Success().Contains("hunter2")
+		}
+	})
+
+	t.Run("list_shows_metadata", func(t *testing.T) {
+		output, err := cli.Raw("token", "list", "--token", aliceToken)
+		testing_framework.Assert(t, output, err).Success().
+			Contains("Found 3 token(s)").
+			Contains("default").
+			Contains("Description: work laptop").
+			Contains("Last used:").
+			Contains("Expires:")
+
+		output, err = cli.Raw("token", "list", "--user", "admin", "--token", aliceToken)
+		testing_framework.Assert(t, output, err).Failure().Contains("need 'rotate-tokens' permission")
+	})
+
+	t.Run("rotate_named_token", func(t *testing.T) {
+		output, err := cli.Raw("rotate", "token", "alice", "--name", "ci")
+		testing_framework.Assert(t, output, err).Success().Contains(`Token "ci" rotated`)
+		newCIToken := testing_framework.ParseTokenFromSelfRotation(string(output))
+
+		output, err = ci.Get("db-password")
+		testing_framework.Assert(t, output, err).Failure()
+
+		output, err = testing_framework.NewCLIRunnerWithToken(env, newCIToken).Get("db-password")
+		testing_framework.Assert(t, output, err).Success().Contains("hunter2")
+	})
+
+	t.Run("revoke_leaves_other_tokens", func(t *testing.T) {
+		output, err := cli.Raw("token", "revoke", "laptop", "--token", aliceToken)
+		testing_framework.Assert(t, output, err).Success().Contains(`Token "laptop" revoked`)
+
+		output, err = laptop.Get("db-password")
+		testing_framework.Assert(t, output, err).Failure()
+
+		output, err = testing_framework.NewCLIRunnerWithToken(env, aliceToken).Get("db-password")
+		testing_framework.Assert(t, output, err).Success().Contains("hunter2")
+	})
+}
+
+func TestStructuredTokens(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Users().Create("alice", "reader")
+	aliceToken := testing_framework.Assert(t, output, err).Success().ExtractToken()
+	if !strings.HasPrefix(aliceToken, "ss_") {
+		t.Fatalf("expected a structured ss_ token, got %q", aliceToken)
+	}
+
+	t.Run("typo_detected", func(t *testing.T) {
+		typo := aliceToken[:len(aliceToken)-1] + "x"
+		output, err := cli.Raw("list", "keys", "--token", typo)
+		testing_framework.Assert(t, output, err).Failure().Contains("mistyped or truncated")
+	})
+
+	t.Run("scan_finds_leaks", func(t *testing.T) {
+		dir := t.TempDir()
+		leaked := filepath.Join(dir, "deploy.env")
+		if err := os.WriteFile(leaked, []byte("SIMPLE_SECRETS_TOKEN="+aliceToken+"\n"), 0600); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+
+		output, err := cli.Raw("token", "scan", dir)
+		testing_framework.Assert(t, output, err).Failure().
+			Contains("deploy.env:1").
+			Contains("found 1 simple-secrets token(s)").
+			NotContains(aliceToken)
+
+		if err := os.WriteFile(leaked, []byte("SIMPLE_SECRETS_TOKEN=redacted\n"), 0600); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+		output, err = cli.Raw("token", "scan", dir)
+		testing_framework.Assert(t, output, err).Success().Contains("No simple-secrets tokens found")
+	})
+}
+
+func TestRotationGracePeriod(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Put("db-password", "hunter2")
+	testing_framework.Assert(t, output, err).Success()
+
+	output, err = cli.Users().Create("deploy", "reader")
+	oldToken := testing_framework.Assert(t, output, err).Success().ExtractToken()
+	old := testing_framework.NewCLIRunnerWithToken(env, oldToken)
+
+	output, err = cli.Raw("rotate", "token", "deploy", "--grace", "1h")
+	rotated := testing_framework.Assert(t, output, err).Success().Contains("keeps read-only access for 1h").Output()
+	newToken := testing_framework.ParseTokenFromSelfRotation(rotated)
+	if newToken == "" || newToken == oldToken {
+		t.Fatalf("expected a new token in rotate output: %s", rotated)
+	}
+
+	t.Run("old_token_reads_during_grace", func(t *testing.T) {
+		output, err := old.Get("db-password")
+		testing_framework.Assert(t, output, err).Success().Contains("hunter2").Contains("replaced by a rotation")
+
+		output, err = old.Put("db-password", "changed")
+		testing_framework.Assert(t, output, err).Failure()
+
+		output, err = cli.Raw("list", "users")
+		testing_framework.Assert(t, output, err).Success().Contains(`Grace: previous "default" token valid until`)
+	})
+
+	t.Run("invalid_grace_access", func(t *testing.T) {
+		output, err := cli.Raw("rotate", "token", "deploy", "--grace", "1h", "--grace-access", "write")
+		testing_framework.Assert(t, output, err).Failure().Contains("invalid grace access")
+	})
+
+	t.Run("revoke_previous", func(t *testing.T) {
+		output, err := cli.Raw("token", "revoke-previous", "--user", "deploy")
+		testing_framework.Assert(t, output, err).Success().Contains(`Previous "default" token revoked`)
+
+		output, err = old.Get("db-password")
+		testing_framework.Assert(t, output, err).Failure()
+
+		output, err = testing_framework.NewCLIRunnerWithToken(env, newToken).Get("db-password")
+		testing_framework.Assert(t, output, err).Success().Contains("hunter2")
+	})
+}
@@ -0,0 +1,94 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"testing"
+
+	"simple-secrets/integration/testing_framework"
+)
+
+func TestUserAccountManagement(t *testing.T) {
+	env := testing_framework.NewEnvironment(t)
+	defer env.Cleanup()
+
+	cli := env.CLI()
+	output, err := cli.Put("db-password", "hunter2")
+	testing_framework.Assert(t, output, err).Success()
+
+	output, err = cli.Users().Create("ci", "reader")
+	ciToken := testing_framework.Assert(t, output, err).Success().ExtractToken()
+	ci := testing_framework.NewCLIRunnerWithToken(env, ciToken)
+
+	t.Run("update_metadata", func(t *testing.T) {
+		output, err := cli.Raw("user", "update", "ci", "--contact", "platform@example.com", "--description", "deploy pipeline")
+		testing_framework.Assert(t, output, err).Success().Contains(`User "ci" updated`)
+
+		output, err = cli.Raw("list", "users")
+		testing_framework.Assert(t, output, err).Success().
+			Contains("Status: active").
+			Contains("Contact: platform@example.com").
+			Contains("Description: deploy pipeline")
+
+		output, err = cli.Raw("user", "update", "ci")
+		testing_framework.Assert(t, output, err).Failure().Contains("nothing to update")
+	})
+
+	t.Run("lock_and_unlock", func(t *testing.T) {
+		output, err := cli.Raw("user", "update", "ci", "--status", "locked", "--reason", "credentials leaked")
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = ci.Get("db-password")
+		testing_framework.Assert(t, output, err).Failure().Contains("is locked (credentials leaked)")
+
+		output, err = cli.Raw("list", "users")
+		testing_framework.Assert(t, output, err).Success().Contains("Status: locked").Contains("by admin: credentials leaked")
+
+		output, err = cli.Raw("user", "update", "ci", "--status", "active")
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = ci.Get("db-password")
+		testing_framework.Assert(t, output, err).Success().Contains("hunter2")
+
+		output, err = cli.Raw("user", "update", "ci", "--status", "disabled")
+		testing_framework.Assert(t, output, err).Failure().Contains("use 'disable user'")
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		output, err := cli.Raw("policy", "allow", "--user", "ci", "--actions", "read", "--keys", "db-*")
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = cli.Raw("user", "rename", "ci", "ci-deploy")
+		testing_framework.Assert(t, output, err).Success().Contains(`renamed to "ci-deploy"`)
+
+		output, err = cli.Raw("policy", "list")
+		testing_framework.Assert(t, output, err).Success().Contains("user:ci-deploy").NotContains("user:ci ")
+
+		output, err = ci.Get("db-password")
+		testing_framework.Assert(t, output, err).Success().Contains("hunter2")
+
+		output, err = cli.Raw("user", "rename", "ci-deploy", "bad;name")
+		testing_framework.Assert(t, output, err).Failure().Contains("shell metacharacters")
+	})
+
+	t.Run("disable_with_reason", func(t *testing.T) {
+		output, err := cli.RawWithInput("yes\n", "disable", "user", "ci-deploy", "--reason", "pipeline retired")
+		testing_framework.Assert(t, output, err).Success()
+
+		output, err = cli.Raw("list", "users")
+		testing_framework.Assert(t, output, err).Success().Contains("Status: disabled").Contains("pipeline retired")
+	})
+}
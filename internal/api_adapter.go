@@ -18,7 +18,6 @@ package internal
 
 import (
 	"fmt"
-	"os"
 	"simple-secrets/pkg/api"
 )
 
@@ -126,29 +125,47 @@ func (sa *ServiceAdapter) Disable(key string) error {
 
 // Authenticator interface implementation
 func (sa *ServiceAdapter) Authenticate(token string) (*api.User, error) {
-	user, err := sa.users.Lookup(token)
+	return sa.AuthenticateFrom(token, LocalAuthClient())
+}
+
+// AuthenticateFrom authenticates a token presented by the named client. Servers pass the
+// remote address so failed attempts back off and lock out per client.
+func (sa *ServiceAdapter) AuthenticateFrom(token, client string) (*api.User, error) {
+	user, err := sa.users.LookupFrom(token, client)
 	if err != nil {
 		return nil, err
 	}
+	var scopes []string
+	for _, scope := range user.Scopes {
+		scopes = append(scopes, scope.String())
+	}
 	return &api.User{
 		Username: user.Username,
 		Role:     string(user.Role),
+		Scopes:   scopes,
 	}, nil
 }
 
 func (sa *ServiceAdapter) CanRead(user *api.User) bool {
-	// Both admin and reader roles can read
-	return user.Role == "admin" || user.Role == "reader"
+	// Roles, including those granted through groups, need the read permission; derived tokens a read or list scope
+	return sa.can(user, PermRead) && user.HasScopeFor(ActionRead, ActionList)
 }
 
 func (sa *ServiceAdapter) CanWrite(user *api.User) bool {
-	// Only admin role can write
-	return user.Role == "admin"
+	// Roles need the write permission; derived tokens a write scope
+	return sa.can(user, PermWrite) && user.HasScopeFor(ActionWrite)
 }
 
 func (sa *ServiceAdapter) CanAdmin(user *api.User) bool {
-	// Only admin role has admin permissions
-	return user.Role == "admin"
+	// Only admins, including admins through a group, never through a derived token
+	u, err := sa.users.FindUser(user.Username)
+	return err == nil && u.IsAdmin() && len(user.Scopes) == 0
+}
+
+// can reports whether the user's current roles grant the permission, as the CLI decides it
+func (sa *ServiceAdapter) can(user *api.User, perm string) bool {
+	u, err := sa.users.FindUser(user.Username)
+	return err == nil && u.Can(perm, sa.users.Permissions())
 }
 
 // UserManager interface implementation
@@ -175,24 +192,7 @@ func (sa *ServiceAdapter) ListUsers() ([]*api.User, error) {
 }
 
 func (sa *ServiceAdapter) RotateToken(username string) (string, error) {
-	// Find the user
-	users := sa.users.Users()
-	for _, user := range users {
-		if user.Username == username {
-			// Generate new token
-			token, err := generateSecureToken()
-			if err != nil {
-				return "", err
-			}
-
-			// Update the user's token hash
-			user.TokenHash = HashToken(token)
-
-			return token, nil
-		}
-	}
-
-	return "", fmt.Errorf("user %q not found", username)
+	return sa.users.RotateUserToken(username)
 }
 
 // AdminOperations interface implementation
@@ -215,23 +215,7 @@ func (sa *ServiceAdapter) RotateSelfToken(currentUser *api.User) (string, error)
 
 // RestoreSecret restores an individual secret from its backup
 func (sa *ServiceAdapter) RestoreSecret(secretKey string) error {
-	// Get backup path
-	backupPath := sa.secrets.GetBackupPath(secretKey)
-
-	// Read backup file
-	data, err := os.ReadFile(backupPath)
-	if err != nil {
-		return fmt.Errorf("backup file not found for secret %q", secretKey)
-	}
-
-	// Decrypt backup
-	decryptedValue, err := sa.secrets.DecryptBackup(string(data))
-	if err != nil {
-		return fmt.Errorf("failed to decrypt backup for secret %q: %v", secretKey, err)
-	}
-
-	// Restore the secret
-	return sa.secrets.Put(secretKey, decryptedValue)
+	return sa.secrets.RestoreSecretFromBackup(secretKey)
 }
 
 // RestoreDatabase restores the entire database from a rotation backup
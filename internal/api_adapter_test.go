@@ -18,6 +18,7 @@ package internal
 import (
 	"simple-secrets/pkg/api"
 	"testing"
+	"time"
 )
 
 // newTempStoreForAPI creates a temporary store for API interface testing
@@ -310,3 +311,63 @@ func TestNewServiceLayerMethods(t *testing.T) {
 		}
 	})
 }
+
+// TestAuthenticatorDerivedToken validates that derived tokens keep their scopes through the API
+func TestAuthenticatorDerivedToken(t *testing.T) {
+	dir := newSealedInstallation(t)
+	userStore, err := loadUserStoreFromConfigDir(dir)
+	if err != nil {
+		t.Fatalf("load users: %v", err)
+	}
+	admin, _ := userStore.FindUser("admin")
+	token, _, err := userStore.IssueDerivedToken(admin, nil, []string{"read:app-*"}, time.Hour)
+	if err != nil {
+		t.Fatalf("issue derived token: %v", err)
+	}
+
+	var auth api.Authenticator = NewServiceAdapter(nil, userStore)
+	user, err := auth.Authenticate(token)
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if user.Username != "admin" || len(user.Scopes) != 1 || user.Scopes[0] != "read:app-*" {
+		t.Fatalf("unexpected derived user %+v", user)
+	}
+	if !auth.CanRead(user) || auth.CanWrite(user) || auth.CanAdmin(user) {
+		t.Error("a read-scoped derived token should only be able to read")
+	}
+	if !user.InScope("read", "app-db") || user.InScope("read", "hr-db") {
+		t.Error("InScope should follow the token's patterns")
+	}
+}
+
+// TestAuthenticatorUsesRolePermissions validates that custom and group-granted roles are honoured
+func TestAuthenticatorUsesRolePermissions(t *testing.T) {
+	roles := createDefaultRoles()
+	roles["ci-writer"] = []string{PermRead, PermWrite}
+	roles["auditor"] = []string{PermRead}
+	users := []*User{
+		{Username: "ci", Role: "ci-writer"},
+		{Username: "carol", Role: "auditor"},
+		{Username: "dave", Role: "auditor"},
+	}
+	groups := []*Group{{Name: "ops", Roles: []Role{RoleAdmin}, Members: []string{"dave"}}}
+	auth := NewServiceAdapter(nil, createUserStore(users, roles).withGroups(groups))
+
+	if ci := (&api.User{Username: "ci", Role: "ci-writer"}); !auth.CanRead(ci) || !auth.CanWrite(ci) || auth.CanAdmin(ci) {
+		t.Error("a custom role with read and write should read and write, but not admin")
+	}
+	if carol := (&api.User{Username: "carol", Role: "auditor"}); !auth.CanRead(carol) || auth.CanWrite(carol) {
+		t.Error("a custom read-only role should only read")
+	}
+	if dave := (&api.User{Username: "dave", Role: "auditor"}); !auth.CanWrite(dave) || !auth.CanAdmin(dave) {
+		t.Error("an admin through a group should write and admin")
+	}
+	if !auth.CanRead(&api.User{Username: "ci", Role: "ci-writer", Scopes: []string{"read:app-*"}}) ||
+		auth.CanWrite(&api.User{Username: "ci", Role: "ci-writer", Scopes: []string{"read:app-*"}}) {
+		t.Error("a derived token should stay limited to its scopes")
+	}
+	if auth.CanRead(&api.User{Username: "mallory", Role: "admin"}) {
+		t.Error("an unknown user must not be trusted for the role it claims")
+	}
+}
@@ -0,0 +1,399 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// approvalsFileName records every approval request, pending or decided
+const approvalsFileName = "approvals.json"
+
+// Operations 'approvals set' can make wait for a second admin's approval
+const (
+	OpRestoreDatabase = "restore-database"
+	OpRotateMasterKey = "rotate-master-key"
+	OpDeleteSecret    = "delete"
+	OpGrantAdmin      = "grant-admin" // giving a user a role with manage-users or write, directly or through a group
+)
+
+// OpChangeApprovals changes the approval settings. It needs approval whenever any other
+// operation does, so that one admin cannot switch approvals off alone.
+const OpChangeApprovals = "change-approvals"
+
+// approvableOperations lists the operations that can require approval, in display order
+var approvableOperations = []string{OpRestoreDatabase, OpRotateMasterKey, OpDeleteSecret, OpGrantAdmin}
+
+// DefaultApprovalExpiry is how long a request may wait for approval, and then for its execution
+const DefaultApprovalExpiry = 24 * time.Hour
+
+// ErrApprovalRequired indicates an operation is waiting for another admin's approval
+var ErrApprovalRequired = errors.New("approval required")
+
+// ApprovalStatus is the state of an approval request
+type ApprovalStatus string
+
+// A request is pending until another admin approves or rejects it. An approved request
+// is executed when its requester runs the operation again; either step must happen
+// before the request expires.
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalRejected ApprovalStatus = "rejected"
+	ApprovalExecuted ApprovalStatus = "executed"
+	ApprovalExpired  ApprovalStatus = "expired"
+)
+
+// ApprovalRequest is one operation waiting for, or decided by, a second admin
+type ApprovalRequest struct {
+	ID          string         `json:"id"`
+	Operation   string         `json:"operation"`
+	Target      string         `json:"target,omitempty"` // the key, username or backup the operation acts on
+	RequestedBy string         `json:"requested_by"`
+	RequestedAt time.Time      `json:"requested_at"`
+	ExpiresAt   time.Time      `json:"expires_at"`
+	Status      ApprovalStatus `json:"status"`
+	DecidedBy   string         `json:"decided_by,omitempty"`
+	DecidedAt   *time.Time     `json:"decided_at,omitempty"`
+	Reason      string         `json:"reason,omitempty"` // why the request was rejected
+	ExecutedAt  *time.Time     `json:"executed_at,omitempty"`
+}
+
+// CurrentStatus returns the request's status, reporting undecided or unexecuted requests
+// past their expiry as expired
+func (r *ApprovalRequest) CurrentStatus(now time.Time) ApprovalStatus {
+	if (r.Status == ApprovalPending || r.Status == ApprovalApproved) && !now.Before(r.ExpiresAt) {
+		return ApprovalExpired
+	}
+	return r.Status
+}
+
+// Describe renders the operation and its target, as shown by 'list approvals'
+func (r *ApprovalRequest) Describe() string {
+	if r.Target == "" {
+		return r.Operation
+	}
+	return fmt.Sprintf("%s %s", r.Operation, r.Target)
+}
+
+// approvalsFile is the on-disk layout of approvals.json
+type approvalsFile struct {
+	Version  int                `json:"version"`
+	Required []string           `json:"required,omitempty"` // operations needing a second admin
+	Expiry   string             `json:"expiry,omitempty"`   // e.g. "24h" (default)
+	Requests []*ApprovalRequest `json:"requests"`
+	Revision uint64             `json:"revision,omitempty"` // sealed write counter, see integrity.go
+	MAC      string             `json:"mac,omitempty"`      // integrity seal, see integrity.go
+}
+
+// ApprovalPolicy lists the operations that need a second admin, from approvals.json
+type ApprovalPolicy struct {
+	Operations []string
+	Expiry     time.Duration
+}
+
+// Requires reports whether the operation needs approval
+func (p ApprovalPolicy) Requires(operation string) bool {
+	if operation == OpChangeApprovals {
+		return len(p.Operations) > 0
+	}
+	return slices.Contains(p.Operations, operation)
+}
+
+// ParseApprovalSettings validates the operations and expiry given to 'approvals set'.
+// An empty expiry means DefaultApprovalExpiry.
+func ParseApprovalSettings(operations []string, expiry string) (ApprovalPolicy, error) {
+	policy := ApprovalPolicy{Expiry: DefaultApprovalExpiry}
+	for _, operation := range operations {
+		if !slices.Contains(approvableOperations, operation) {
+			return policy, fmt.Errorf("unknown operation %q: valid operations are %s",
+				operation, strings.Join(approvableOperations, ", "))
+		}
+		if !slices.Contains(policy.Operations, operation) {
+			policy.Operations = append(policy.Operations, operation)
+		}
+	}
+	if expiry != "" {
+		parsed, err := ParseLifetime(expiry)
+		if err != nil {
+			return policy, fmt.Errorf("approval expiry: %w", err)
+		}
+		policy.Expiry = parsed
+	}
+	return policy, nil
+}
+
+// Describe renders the settings, as the target of a change-approvals request
+func (p ApprovalPolicy) Describe() string {
+	operations := "none"
+	if len(p.Operations) > 0 {
+		operations = strings.Join(p.Operations, ",")
+	}
+	return fmt.Sprintf("required=%s expiry=%s", operations, FormatRemaining(p.Expiry))
+}
+
+// legacyApprovalConfig is the part of config.json that configured approvals before the
+// settings moved into the sealed approvals.json
+type legacyApprovalConfig struct {
+	ApprovalRequired []string `json:"approval_required,omitempty"`
+	ApprovalExpiry   string   `json:"approval_expiry,omitempty"`
+}
+
+// checkLegacyApprovalConfig refuses to run operations while config.json still carries
+// approval settings. They are no longer read, and silently dropping them would switch
+// approvals off.
+func checkLegacyApprovalConfig(configDir string) error {
+	data, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		return nil
+	}
+	var config legacyApprovalConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil // ResolveToken reports a corrupted config.json
+	}
+	if config.ApprovalRequired == nil && config.ApprovalExpiry == "" {
+		return nil
+	}
+	return fmt.Errorf("config.json sets approval_required or approval_expiry, which are no longer read: "+
+		"approval settings are sealed in %s. Apply them with 'simple-secrets approvals set --require <operation,...> --expiry <duration>' "+
+		"and remove them from config.json; operations that can need approval are refused until then", approvalsFileName)
+}
+
+// approvals keeps the approval settings and requests in approvals.json
+type approvals struct {
+	path      string
+	configDir string
+}
+
+func newApprovals(configDir string) *approvals {
+	return &approvals{path: filepath.Join(configDir, approvalsFileName), configDir: configDir}
+}
+
+// read reads and verifies approvals.json. A missing file means no settings and no
+// requests, unless it was sealed before.
+func (a *approvals) read() (*approvalsFile, error) {
+	var file approvalsFile
+	if err := readConfigFile(a.path, approvalsFormat, &file); err != nil {
+		if os.IsNotExist(err) {
+			return &approvalsFile{}, checkMissingSealedFile(a.path)
+		}
+		return nil, fmt.Errorf("%s is corrupted or invalid: %w", approvalsFileName, err)
+	}
+	if err := verifyDocument(a.path, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// load returns the approval requests
+func (a *approvals) load() ([]*ApprovalRequest, error) {
+	file, err := a.read()
+	if err != nil {
+		return nil, err
+	}
+	return file.Requests, nil
+}
+
+// policy returns the approval settings sealed in approvals.json. Settings that cannot be
+// parsed are an error rather than no approvals.
+func (f *approvalsFile) policy() (ApprovalPolicy, error) {
+	policy, err := ParseApprovalSettings(f.Required, f.Expiry)
+	if err != nil {
+		return policy, fmt.Errorf("%s: %w; operations that can need approval are refused until it is fixed", approvalsFileName, err)
+	}
+	return policy, nil
+}
+
+// Policy returns the approval settings
+func (a *approvals) Policy() (ApprovalPolicy, error) {
+	file, err := a.read()
+	if err != nil {
+		return ApprovalPolicy{}, err
+	}
+	return file.policy()
+}
+
+// update applies a change to approvals.json under the file lock and saves it
+func (a *approvals) update(change func(file *approvalsFile) error) error {
+	lock, err := LockFile(a.path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	file, err := a.read()
+	if err != nil {
+		return err
+	}
+	if err := change(file); err != nil {
+		return err
+	}
+	return writeSealedFile(a.path, &approvalsFile{Version: ApprovalsFormatVersion, Required: file.Required, Expiry: file.Expiry, Requests: file.Requests})
+}
+
+// configure replaces the approval settings, as validated by ParseApprovalSettings
+func (a *approvals) configure(operations []string, expiry string) error {
+	return a.update(func(file *approvalsFile) error {
+		file.Required, file.Expiry = operations, expiry
+		return nil
+	})
+}
+
+// gate lets an operation proceed if it needs no approval or the user holds an approved
+// request for it. The request is claimed, marked executed, under the file lock, so two
+// commands cannot both run on one approval; call done on the returned approval with the
+// operation's result to give it back if the operation failed. Otherwise gate files a
+// request, or points at the one already waiting, and returns ErrApprovalRequired.
+func (a *approvals) gate(user *User, operation, target string, now time.Time) (*approval, error) {
+	if operation != OpChangeApprovals {
+		if err := checkLegacyApprovalConfig(a.configDir); err != nil {
+			return nil, err
+		}
+	}
+	policy, err := a.Policy()
+	if err != nil {
+		return nil, err
+	}
+	if !policy.Requires(operation) {
+		return nil, nil
+	}
+
+	var approved *approval
+	var outcome error
+	err = a.update(func(file *approvalsFile) error {
+		// The settings may have changed since they were read without the lock
+		policy, err := file.policy()
+		if err != nil {
+			return err
+		}
+		if !policy.Requires(operation) {
+			return nil
+		}
+		for _, r := range file.Requests {
+			if r.RequestedBy != user.Username || r.Operation != operation || r.Target != target {
+				continue
+			}
+			switch r.CurrentStatus(now) {
+			case ApprovalApproved:
+				executed := now.UTC()
+				r.Status, r.ExecutedAt = ApprovalExecuted, &executed
+				approved = &approval{approvals: a, id: r.ID}
+				return nil
+			case ApprovalPending:
+				outcome = fmt.Errorf("%w: request %s (%s) is waiting for another admin to run 'simple-secrets approve %s'",
+					ErrApprovalRequired, r.ID, r.Describe(), r.ID)
+				return nil
+			}
+		}
+
+		request := &ApprovalRequest{
+			ID:          nextApprovalID(file.Requests),
+			Operation:   operation,
+			Target:      target,
+			RequestedBy: user.Username,
+			RequestedAt: now.UTC(),
+			ExpiresAt:   now.Add(policy.Expiry).UTC(),
+			Status:      ApprovalPending,
+		}
+		outcome = fmt.Errorf("%w: request %s (%s) created; another admin must run 'simple-secrets approve %s' "+
+			"within %s, then run this command again", ErrApprovalRequired, request.ID, request.Describe(), request.ID, FormatRemaining(policy.Expiry))
+		file.Requests = append(file.Requests, request)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record approval request: %w", err)
+	}
+	return approved, outcome
+}
+
+// approval is an approved request an operation runs under
+type approval struct {
+	approvals *approvals
+	id        string
+}
+
+// done gives the claimed request back, approved again, when the operation failed, so it
+// can be tried again. A successful operation keeps it executed. A nil approval, for an
+// operation that needed none, does nothing.
+func (ap *approval) done(err *error) {
+	if ap == nil || *err == nil {
+		return
+	}
+	update := ap.approvals.update(func(file *approvalsFile) error {
+		for _, r := range file.Requests {
+			if r.ID == ap.id && r.Status == ApprovalExecuted {
+				r.Status, r.ExecutedAt = ApprovalApproved, nil
+			}
+		}
+		return nil
+	})
+	if update != nil {
+		// The approval stays used up, which only costs the requester a new request
+		fmt.Fprintf(os.Stderr, "Warning: approval request %s could not be given back after the failure: %v\n", ap.id, update)
+	}
+}
+
+// decide approves or rejects a pending request. Nobody may decide their own request.
+func (a *approvals) decide(user *User, id string, approve bool, reason string, now time.Time) (*ApprovalRequest, error) {
+	var decided *ApprovalRequest
+	err := a.update(func(file *approvalsFile) error {
+		i := slices.IndexFunc(file.Requests, func(r *ApprovalRequest) bool { return r.ID == id })
+		if i < 0 {
+			return fmt.Errorf("approval request %q not found", id)
+		}
+		r := file.Requests[i]
+		if status := r.CurrentStatus(now); status != ApprovalPending {
+			return fmt.Errorf("approval request %s is %s, not pending", id, status)
+		}
+		if r.RequestedBy == user.Username {
+			return fmt.Errorf("you cannot decide your own request %s; another admin must", id)
+		}
+
+		decidedAt := now.UTC()
+		r.Status, r.DecidedBy, r.DecidedAt = ApprovalRejected, user.Username, &decidedAt
+		r.Reason = strings.TrimSpace(reason)
+		if approve {
+			r.Status = ApprovalApproved
+		}
+		decided = r
+		return nil
+	})
+	return decided, err
+}
+
+// nextApprovalID returns an ID one above the highest numbered request
+func nextApprovalID(requests []*ApprovalRequest) string {
+	highest := 0
+	for _, r := range requests {
+		if n, err := strconv.Atoi(strings.TrimPrefix(r.ID, "a")); err == nil && n > highest {
+			highest = n
+		}
+	}
+	return "a" + strconv.Itoa(highest+1)
+}
+
+// ApprovableOperations returns the operations 'approvals set' can make require approval
+func ApprovableOperations() []string {
+	return slices.Clone(approvableOperations)
+}
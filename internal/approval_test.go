@@ -0,0 +1,257 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApprovalGate(t *testing.T) {
+	dir := newSealedInstallation(t)
+	gate := newApprovals(dir)
+	if err := gate.configure([]string{OpDeleteSecret}, "1h"); err != nil {
+		t.Fatalf("configure approvals: %v", err)
+	}
+	alice, carol := &User{Username: "alice"}, &User{Username: "carol"}
+	now := time.Now()
+
+	if approval, err := gate.gate(alice, OpRotateMasterKey, "", now); err != nil || approval != nil {
+		t.Fatalf("operations not configured need no approval: %v", err)
+	}
+	if _, err := gate.gate(alice, OpDeleteSecret, "db", now); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("expected a request to be filed, got %v", err)
+	}
+	if _, err := gate.gate(alice, OpDeleteSecret, "db", now); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("a pending request should keep the operation waiting, got %v", err)
+	}
+
+	if _, err := gate.decide(alice, "a1", true, "", now); err == nil {
+		t.Fatal("a requester must not approve their own request")
+	}
+	request, err := gate.decide(carol, "a1", true, "", now)
+	if err != nil || request.Status != ApprovalApproved || request.DecidedBy != "carol" {
+		t.Fatalf("approve: %+v, %v", request, err)
+	}
+	if _, err := gate.gate(carol, OpDeleteSecret, "db", now); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("an approval only lets its requester proceed, got %v", err)
+	}
+
+	// A failed operation leaves the approval for another attempt
+	approval, err := gate.gate(alice, OpDeleteSecret, "db", now)
+	if err != nil || approval == nil {
+		t.Fatalf("an approved request should let the operation run: %v", err)
+	}
+	if _, err := gate.gate(alice, OpDeleteSecret, "db", now); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("a claimed approval must not let a second command run, got %v", err)
+	}
+	failed := errors.New("disk full")
+	approval.done(&failed)
+	approval, err = gate.gate(alice, OpDeleteSecret, "db", now)
+	if err != nil {
+		t.Fatalf("a failed operation must not use up its approval: %v", err)
+	}
+	var succeeded error
+	approval.done(&succeeded)
+	if _, err := gate.gate(alice, OpDeleteSecret, "db", now); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("an approval is used up by one execution, got %v", err)
+	}
+
+	requests, err := gate.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(requests) != 3 || requests[0].Status != ApprovalExecuted || requests[0].ExecutedAt == nil {
+		t.Fatalf("every request should be recorded, got %+v", requests)
+	}
+
+	later := now.Add(2 * time.Hour)
+	if got := requests[2].CurrentStatus(later); got != ApprovalExpired {
+		t.Fatalf("an undecided request should expire, got %s", got)
+	}
+	if _, err := gate.decide(carol, requests[2].ID, true, "", later); err == nil {
+		t.Fatal("an expired request must not be approved")
+	}
+}
+
+func TestDeleteNeedsApproval(t *testing.T) {
+	dir := newSealedInstallation(t)
+	setup, err := NewService(WithStorageBackend(NewFilesystemBackend()), WithConfigDir(dir))
+	if err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+	carolToken, err := setup.Users().CreateUser("admin-token", "carol", string(RoleAdmin))
+	if err != nil {
+		t.Fatalf("create carol: %v", err)
+	}
+	if err := setup.Secrets().Put("admin-token", "db", "v1"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if err := setup.Roles().CreateRole("admin-token", "user-admin", []string{PermRead, PermManageUsers}); err != nil {
+		t.Fatalf("create role: %v", err)
+	}
+	writer := "writer"
+	if err := setup.Roles().CreateRole("admin-token", writer, []string{PermRead, PermWrite}); err != nil {
+		t.Fatalf("create role: %v", err)
+	}
+	if err := setup.Groups().CreateGroup("admin-token", "user-admins", []string{"user-admin"}); err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+	daveToken, err := setup.Users().CreateUser("admin-token", "dave", "user-admin")
+	if err != nil {
+		t.Fatalf("create dave: %v", err)
+	}
+
+	if err := setup.Approvals().Configure("admin-token", []string{OpDeleteSecret, OpGrantAdmin}, ""); err != nil {
+		t.Fatalf("configure approvals: %v", err)
+	}
+	service, err := NewService(WithStorageBackend(NewFilesystemBackend()), WithConfigDir(dir))
+	if err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+
+	if err := service.Secrets().Delete("admin-token", "db"); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("expected the delete to wait for approval, got %v", err)
+	}
+	if _, err := service.Secrets().Get("admin-token", "db"); err != nil {
+		t.Fatalf("the secret must survive until the delete is approved: %v", err)
+	}
+	if _, err := service.Users().CreateUser("admin-token", "mallory", string(RoleAdmin)); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("creating an admin should wait for approval, got %v", err)
+	}
+
+	// grant-admin follows the permissions of the role given, not its name
+	if _, err := service.Users().CreateUser("admin-token", "erin", "user-admin"); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("creating a user with manage-users should wait for approval, got %v", err)
+	}
+	if _, err := service.Users().CreateUser("admin-token", "frank", string(RoleReader)); err != nil {
+		t.Fatalf("a role without manage-users or write needs no approval: %v", err)
+	}
+	if err := service.Users().UpdateUser("admin-token", "frank", UserUpdate{Role: &writer}); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("giving a user write should wait for approval, got %v", err)
+	}
+	if err := service.Groups().AddMember("admin-token", "user-admins", "frank"); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("joining a group granting manage-users should wait for approval, got %v", err)
+	}
+
+	// Approving takes an admin, not just manage-users
+	if _, err := service.Approvals().Approve(daveToken, "a1"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("manage-users without admin must not approve, got %v", err)
+	}
+
+	if _, err := service.Approvals().Approve(carolToken, "a1"); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	if err := service.Secrets().Delete("admin-token", "db"); err != nil {
+		t.Fatalf("the approved delete should run: %v", err)
+	}
+	if _, err := service.Secrets().Get("admin-token", "db"); err == nil {
+		t.Fatal("the secret should be gone after the approved delete")
+	}
+}
+
+func TestApprovalSettingsAreSealed(t *testing.T) {
+	dir := newSealedInstallation(t)
+	service, err := NewService(WithStorageBackend(NewFilesystemBackend()), WithConfigDir(dir))
+	if err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+	carolToken, err := service.Users().CreateUser("admin-token", "carol", string(RoleAdmin))
+	if err != nil {
+		t.Fatalf("create carol: %v", err)
+	}
+	if err := service.Secrets().Put("admin-token", "db", "v1"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if err := service.Approvals().Configure("admin-token", []string{"drop-tables"}, ""); err == nil {
+		t.Fatal("unknown operations should be rejected")
+	}
+	if err := service.Approvals().Configure("admin-token", []string{OpDeleteSecret}, "4h"); err != nil {
+		t.Fatalf("configure approvals: %v", err)
+	}
+	policy, err := service.Approvals().Settings("admin-token")
+	if err != nil || !policy.Requires(OpDeleteSecret) || policy.Expiry != 4*time.Hour {
+		t.Fatalf("settings should be read back from approvals.json, got %+v (%v)", policy, err)
+	}
+
+	t.Run("switching_off_needs_approval", func(t *testing.T) {
+		if err := service.Approvals().Configure("admin-token", nil, ""); !errors.Is(err, ErrApprovalRequired) {
+			t.Fatalf("one admin must not switch approvals off alone, got %v", err)
+		}
+		if _, err := service.Approvals().Approve(carolToken, "a1"); err != nil {
+			t.Fatalf("approve: %v", err)
+		}
+		if err := service.Approvals().Configure("admin-token", []string{OpDeleteSecret, OpGrantAdmin}, ""); !errors.Is(err, ErrApprovalRequired) {
+			t.Fatalf("an approval covers only the settings it was filed for, got %v", err)
+		}
+		if err := service.Approvals().Configure("admin-token", nil, ""); err != nil {
+			t.Fatalf("the approved change should apply: %v", err)
+		}
+		if err := service.Approvals().Configure("admin-token", []string{OpDeleteSecret}, ""); err != nil {
+			t.Fatalf("with no approvals required, the settings change alone: %v", err)
+		}
+	})
+
+	t.Run("config_json_is_not_read", func(t *testing.T) {
+		config := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(config, []byte(`{"version": 1, "approval_required": []}`), 0600); err != nil {
+			t.Fatalf("write config.json: %v", err)
+		}
+		defer os.Remove(config)
+		if err := service.Secrets().Delete("admin-token", "db"); err == nil || !strings.Contains(err.Error(), "no longer read") {
+			t.Fatalf("leftover approval settings in config.json should stop gated operations, got %v", err)
+		}
+	})
+
+	t.Run("invalid_settings_fail_closed", func(t *testing.T) {
+		path := filepath.Join(dir, approvalsFileName)
+		file, err := newApprovals(dir).read()
+		if err != nil {
+			t.Fatalf("read approvals: %v", err)
+		}
+		file.Expiry = "soon"
+		if err := writeSealedFile(path, file); err != nil {
+			t.Fatalf("write approvals: %v", err)
+		}
+		if err := service.Secrets().Delete("admin-token", "db"); err == nil || !strings.Contains(err.Error(), "approval expiry") {
+			t.Fatalf("unparsable settings should refuse the operation, got %v", err)
+		}
+		if _, err := service.Secrets().Get("admin-token", "db"); err != nil {
+			t.Fatalf("the secret must survive: %v", err)
+		}
+	})
+
+	t.Run("tampered_settings_are_refused", func(t *testing.T) {
+		path := filepath.Join(dir, approvalsFileName)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read approvals.json: %v", err)
+		}
+		edited := strings.Replace(string(data), `"soon"`, `"1h"`, 1)
+		if err := os.WriteFile(path, []byte(edited), 0600); err != nil {
+			t.Fatalf("write approvals.json: %v", err)
+		}
+		if err := service.Secrets().Delete("admin-token", "db"); !errors.Is(err, ErrTampered) {
+			t.Fatalf("expected ErrTampered, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,643 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditLogFileName is the append-only audit log, one JSON entry per line
+const auditLogFileName = "audit.log"
+
+// auditHeadFileName seals the sequence number and hash of the last audit entry, so
+// removing entries from the end of the log is detected as well as editing them
+const auditHeadFileName = "audit_head.json"
+
+// auditArchiveDirName holds the rotated logs, named audit-<time>-<last seq>.log. The
+// chain runs through them in order and on into audit.log.
+const auditArchiveDirName = "audit"
+
+// Defaults for rotating audit.log, see auditConfig
+const (
+	DefaultAuditRotateSizeMB = 10
+	DefaultAuditRotateAfter  = 30 * 24 * time.Hour
+)
+
+// Results recorded in the audit log
+const (
+	AuditSuccess    = "success"
+	AuditDenied     = "denied"      // authenticated, but without the permission
+	AuditAuthFailed = "auth_failed" // the token was invalid, expired, revoked or locked out
+	AuditError      = "error"
+)
+
+// AuditEntry is one line of the audit log. It never holds a secret's value.
+type AuditEntry struct {
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	User   string    `json:"user,omitempty"` // empty if a failed token named no user
+	Client string    `json:"client,omitempty"`
+	Label  string    `json:"label,omitempty"` // the --client label the operation was run with
+	Op     string    `json:"op"`
+	Key    string    `json:"key,omitempty"` // the secret, or the user, role, group, policy or token operated on
+	Result string    `json:"result"`
+	Prev   string    `json:"prev"` // hash of the previous entry, empty for the first
+	Hash   string    `json:"hash"` // SHA-256 of this entry with an empty hash
+}
+
+// computeHash hashes the entry with its hash field cleared; the previous hash is part of it
+func (e AuditEntry) computeHash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// auditHeadFile is the on-disk layout of audit_head.json
+type auditHeadFile struct {
+	Version    int    `json:"version"`
+	Seq        uint64 `json:"seq"`
+	Hash       string `json:"hash"`
+	PrunedSeq  uint64 `json:"pruned_seq,omitempty"`  // last entry removed by 'audit prune'; the log starts after it
+	PrunedHash string `json:"pruned_hash,omitempty"` // its hash, which the first remaining entry chains to
+	Revision   uint64 `json:"revision,omitempty"`    // sealed write counter, see integrity.go
+	MAC        string `json:"mac,omitempty"`         // integrity seal, see integrity.go
+}
+
+// AuditVerification is the result of checking the audit log's hash chain
+type AuditVerification struct {
+	Path     string   `json:"path"`
+	Files    int      `json:"files"` // audit.log and the rotated logs
+	Entries  int      `json:"entries"`
+	LastSeq  uint64   `json:"last_seq"`
+	Problems []string `json:"problems"` // empty if the log is intact
+}
+
+// Intact reports whether no problem was found
+func (v *AuditVerification) Intact() bool {
+	return len(v.Problems) == 0
+}
+
+// AuditPolicy controls when audit.log is rotated and how long rotated logs are kept
+type AuditPolicy struct {
+	MaxSize     int64         // rotate once audit.log reaches this many bytes; 0 means never
+	RotateAfter time.Duration // rotate once the first entry in audit.log is this old; 0 means never
+	Retention   time.Duration // prune rotated logs older than this on rotation; 0 keeps them
+}
+
+// auditConfig is the part of config.json that configures audit log rotation
+type auditConfig struct {
+	AuditRotateSizeMB *int   `json:"audit_rotate_size_mb,omitempty"` // e.g. 10 (default); 0 disables
+	AuditRotateAfter  string `json:"audit_rotate_after,omitempty"`   // e.g. "30d" (default)
+	AuditRetention    string `json:"audit_retention,omitempty"`      // e.g. "365d"; unset keeps rotated logs
+}
+
+// parse validates the configured size and durations
+func (c auditConfig) parse() (AuditPolicy, error) {
+	policy := AuditPolicy{MaxSize: DefaultAuditRotateSizeMB << 20, RotateAfter: DefaultAuditRotateAfter}
+	if c.AuditRotateSizeMB != nil {
+		if *c.AuditRotateSizeMB < 0 {
+			return policy, fmt.Errorf("audit_rotate_size_mb: must not be negative, got %d", *c.AuditRotateSizeMB)
+		}
+		policy.MaxSize = int64(*c.AuditRotateSizeMB) << 20
+	}
+	if c.AuditRotateAfter != "" {
+		after, err := ParseLifetime(c.AuditRotateAfter)
+		if err != nil {
+			return policy, fmt.Errorf("audit_rotate_after: %w", err)
+		}
+		policy.RotateAfter = after
+	}
+	if c.AuditRetention != "" {
+		retention, err := ParseLifetime(c.AuditRetention)
+		if err != nil {
+			return policy, fmt.Errorf("audit_retention: %w", err)
+		}
+		policy.Retention = retention
+	}
+	return policy, nil
+}
+
+// loadAuditPolicy reads the audit settings from configDir's config.json.
+// A missing file means the defaults; an invalid setting is reported and the valid part applied.
+func loadAuditPolicy(configDir string) AuditPolicy {
+	defaults, _ := auditConfig{}.parse()
+
+	data, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		return defaults
+	}
+	if err := configFormat.checkReadable(data); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v. Audit settings are ignored\n", err)
+		return defaults
+	}
+
+	var config auditConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return defaults // ResolveToken reports a corrupted config.json
+	}
+	policy, err := config.parse()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: config.json %v; the setting is ignored\n", err)
+	}
+	return policy
+}
+
+// auditLog appends entries to audit.log. Appends are serialized across processes by the
+// file lock; each takes the previous hash from the last entry written.
+type auditLog struct {
+	dir       string
+	userStore *UserStore // identifies the user a token names; nil records no user
+	policy    AuditPolicy
+}
+
+func newAuditLog(configDir string, userStore *UserStore) *auditLog {
+	return &auditLog{dir: configDir, userStore: userStore, policy: loadAuditPolicy(configDir)}
+}
+
+func (a *auditLog) path() string       { return filepath.Join(a.dir, auditLogFileName) }
+func (a *auditLog) headPath() string   { return filepath.Join(a.dir, auditHeadFileName) }
+func (a *auditLog) archiveDir() string { return filepath.Join(a.dir, auditArchiveDirName) }
+
+// auditWarning reports the first failure to write the audit log in this process
+var auditWarning sync.Once
+
+// record appends an entry, warning instead of failing the operation it records
+func (a *auditLog) record(entry AuditEntry) {
+	if err := a.append(entry); err != nil {
+		auditWarning.Do(func() {
+			fmt.Fprintf(os.Stderr, "Warning: audit log: %v\n", err)
+		})
+	}
+}
+
+// append chains an entry to the last one in the log, writes it and seals the new head,
+// rotating audit.log first when it is due. A head that no longer matches the end of the
+// log is left as it is, so that evidence of truncation or a rewritten log survives for
+// 'audit verify'; the entry is still written.
+func (a *auditLog) append(entry AuditEntry) error {
+	lock, err := LockFile(a.path())
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	last, err := a.lastEntry()
+	if err != nil {
+		return err
+	}
+	rotated, err := a.rotateIfDue(last, time.Now())
+	if err != nil {
+		return fmt.Errorf("rotate %s: %w", auditLogFileName, err)
+	}
+	head, headErr := a.readHead()
+	entry.Seq = 1
+	if last != nil {
+		entry.Seq, entry.Prev = last.Seq+1, last.Hash
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+	if entry.Hash, err = entry.computeHash(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(a.path(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, secureFilePermissions)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if headErr != nil {
+		return headErr
+	}
+	if !headMatches(head, last) {
+		return fmt.Errorf("%s does not end where %s says; run 'simple-secrets audit verify'", auditLogFileName, auditHeadFileName)
+	}
+	next := &auditHeadFile{Seq: entry.Seq, Hash: entry.Hash}
+	if head != nil {
+		next.PrunedSeq, next.PrunedHash = head.PrunedSeq, head.PrunedHash
+	}
+	if err := a.writeHead(next); err != nil {
+		return err
+	}
+	if rotated && a.policy.Retention > 0 {
+		if _, err := a.pruneLocked(time.Now().Add(-a.policy.Retention)); err != nil {
+			return fmt.Errorf("apply audit_retention: %w", err)
+		}
+	}
+	return nil
+}
+
+// readHead reads and verifies the sealed head. A missing head is nil before logging
+// started, and refused once the head's first sealed copy records that it has.
+func (a *auditLog) readHead() (*auditHeadFile, error) {
+	var head auditHeadFile
+	err := readConfigFile(a.headPath(), auditHeadFormat, &head)
+	if os.IsNotExist(err) {
+		return nil, checkMissingSealedFile(a.headPath())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s is unreadable: %w", auditHeadFileName, err)
+	}
+	if head.MAC == "" {
+		return nil, fmt.Errorf("%s has no integrity seal", auditHeadFileName)
+	}
+	if err := verifyDocument(a.headPath(), &head); err != nil {
+		return nil, err
+	}
+	return &head, nil
+}
+
+// headMatches reports whether the head was sealed at the last entry. A head one entry
+// behind also matches: that entry was written but the process stopped before sealing it.
+func headMatches(head *auditHeadFile, last *AuditEntry) bool {
+	switch {
+	case head == nil:
+		return last == nil
+	case last == nil:
+		return false
+	case head.Seq == last.Seq:
+		return strings.EqualFold(head.Hash, last.Hash)
+	}
+	return head.Seq+1 == last.Seq && strings.EqualFold(head.Hash, last.Prev)
+}
+
+// writeHead seals the position of the last entry and of the last pruned one. Unlike the
+// other sealed files its sealed copy is not kept up to date, as it changes with every
+// entry: the first head is copied once, as a sealed record that logging has started, so
+// that deleting audit.log together with the head is detected. An installation that was
+// never sealed gets the copy once 'simple-secrets migrate' has sealed it, as writing it
+// earlier would make its unsealed files look tampered with.
+func (a *auditLog) writeHead(head *auditHeadFile) error {
+	key, err := loadIntegrityKey(a.dir, true)
+	if err != nil {
+		return fmt.Errorf("load integrity key: %w", err)
+	}
+	head.Version = AuditHeadFormatVersion
+	if err := sealDocument(key, auditHeadFileName, head); err != nil {
+		return err
+	}
+	if err := writeConfigFileSecurely(a.headPath(), head); err != nil {
+		return err
+	}
+	if !installationSealed(a.dir) || fileExists(sealedCopyPath(a.dir, auditHeadFileName)) {
+		return nil
+	}
+	return writeSealedCopy(a.dir, auditHeadFileName, head)
+}
+
+// lastAuditEntry reads the last line of the log; a missing or empty log has none
+func lastAuditEntry(path string) (*AuditEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// Read backwards in growing chunks until the chunk holds a whole last line
+	for size := int64(4096); ; size *= 2 {
+		offset := max(info.Size()-size, 0)
+		chunk := make([]byte, info.Size()-offset)
+		if _, err := file.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		chunk = bytes.TrimRight(chunk, "\n")
+		if len(chunk) == 0 {
+			return nil, nil
+		}
+		start := bytes.LastIndexByte(chunk, '\n')
+		if start < 0 && offset > 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(chunk[start+1:], &entry); err != nil {
+			return nil, fmt.Errorf("the last line of %s is damaged (%v); run 'simple-secrets audit verify'", auditLogFileName, err)
+		}
+		return &entry, nil
+	}
+}
+
+// rotatedAuditFile is a rotated log in the archive directory
+type rotatedAuditFile struct {
+	path    string
+	lastSeq uint64
+}
+
+// rotatedFiles lists the rotated logs in chain order. Files not named by rotation are ignored.
+func (a *auditLog) rotatedFiles() ([]rotatedAuditFile, error) {
+	paths, err := filepath.Glob(filepath.Join(a.archiveDir(), "audit-*.log"))
+	if err != nil {
+		return nil, err
+	}
+	var files []rotatedAuditFile
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".log")
+		seq, err := strconv.ParseUint(name[strings.LastIndexByte(name, '-')+1:], 10, 64)
+		if err != nil {
+			continue
+		}
+		files = append(files, rotatedAuditFile{path: path, lastSeq: seq})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].lastSeq < files[j].lastSeq })
+	return files, nil
+}
+
+// files lists the rotated logs and audit.log, oldest first
+func (a *auditLog) files() ([]string, error) {
+	rotated, err := a.rotatedFiles()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, file := range rotated {
+		paths = append(paths, file.path)
+	}
+	if fileExists(a.path()) {
+		paths = append(paths, a.path())
+	}
+	return paths, nil
+}
+
+// lastEntry returns the last entry written: the last line of audit.log or, right after a
+// rotation, of the newest rotated log
+func (a *auditLog) lastEntry() (*AuditEntry, error) {
+	last, err := lastAuditEntry(a.path())
+	if err != nil || last != nil {
+		return last, err
+	}
+	rotated, err := a.rotatedFiles()
+	if err != nil || len(rotated) == 0 {
+		return nil, err
+	}
+	return lastAuditEntry(rotated[len(rotated)-1].path)
+}
+
+// rotateIfDue moves audit.log into the archive directory once it reaches the size limit
+// or its first entry the age limit. The next entry starts a new audit.log and chains to
+// the last one in the rotated file.
+func (a *auditLog) rotateIfDue(last *AuditEntry, now time.Time) (bool, error) {
+	info, err := os.Stat(a.path())
+	if os.IsNotExist(err) || (err == nil && info.Size() == 0) || last == nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	due := a.policy.MaxSize > 0 && info.Size() >= a.policy.MaxSize
+	if !due && a.policy.RotateAfter > 0 {
+		first, err := firstAuditEntry(a.path())
+		due = err == nil && first != nil && now.Sub(first.Time) >= a.policy.RotateAfter
+	}
+	if !due {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(a.archiveDir(), secureDirectoryPermissions); err != nil {
+		return false, err
+	}
+	name := fmt.Sprintf("audit-%s-%d.log", now.UTC().Format("20060102T150405Z"), last.Seq)
+	return true, os.Rename(a.path(), filepath.Join(a.archiveDir(), name))
+}
+
+// firstAuditEntry reads the first line of a log; a missing or empty log has none
+func firstAuditEntry(path string) (*AuditEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if len(bytes.TrimSpace(line)) == 0 {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entry AuditEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// verify checks every entry's hash and link to the one before, through the rotated logs
+// and audit.log, and that the chain starts after the pruned entries and ends at the
+// sealed head
+func (a *auditLog) verify() (*AuditVerification, error) {
+	result := &AuditVerification{Path: a.path(), Problems: []string{}}
+	head, headErr := a.readHead()
+	chain := &auditChain{result: result, startSeq: 1}
+	if head != nil {
+		chain.startSeq, chain.startPrev = head.PrunedSeq+1, head.PrunedHash
+	}
+
+	paths, err := a.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		name, _ := filepath.Rel(a.dir, path)
+		err = chain.check(name, file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		result.Files++
+	}
+	a.verifyHead(result, head, headErr)
+	return result, nil
+}
+
+// auditChain checks entries in order across files, continuing after a broken link so
+// that every problem is reported
+type auditChain struct {
+	result    *AuditVerification
+	startSeq  uint64 // the first entry expected, after any pruned ones
+	startPrev string // the hash it chains to
+	prev      *AuditEntry
+}
+
+func (c *auditChain) check(name string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		where := fmt.Sprintf("%s line %d", name, line)
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			c.problem("%s: not a valid entry: %v", where, err)
+			continue
+		}
+		c.result.Entries++
+
+		if hash, err := entry.computeHash(); err != nil || hash != entry.Hash {
+			c.problem("%s (entry %d): contents do not match its hash; the entry was edited", where, entry.Seq)
+		}
+		switch prev := c.prev; {
+		case prev == nil && entry.Seq > c.startSeq:
+			c.problem("%s: the log starts at entry %d instead of %d; earlier entries were removed", where, entry.Seq, c.startSeq)
+		case prev == nil && (entry.Seq != c.startSeq || entry.Prev != c.startPrev):
+			c.problem("%s: entry %d does not follow the last pruned entry", where, entry.Seq)
+		case prev != nil && entry.Seq != prev.Seq+1:
+			c.problem("%s: entry %d follows entry %d; entries are missing or out of order", where, entry.Seq, prev.Seq)
+		case prev != nil && entry.Prev != prev.Hash:
+			c.problem("%s (entry %d): does not chain to the entry before it", where, entry.Seq)
+		}
+		c.prev = &entry
+		c.result.LastSeq = entry.Seq
+	}
+	return scanner.Err()
+}
+
+func (c *auditChain) problem(format string, args ...any) {
+	c.result.Problems = append(c.result.Problems, fmt.Sprintf(format, args...))
+}
+
+// verifyHead compares the end of the log with the sealed head
+func (a *auditLog) verifyHead(result *AuditVerification, head *auditHeadFile, headErr error) {
+	if headErr != nil {
+		result.Problems = append(result.Problems, headErr.Error())
+		return
+	}
+	if head == nil {
+		if result.Entries > 0 {
+			result.Problems = append(result.Problems, fmt.Sprintf("%s is missing, so removal of the last entries cannot be ruled out", auditHeadFileName))
+		}
+		return
+	}
+
+	last, err := a.lastEntry()
+	if err != nil || headMatches(head, last) {
+		return // an unreadable last line is already reported by the chain check
+	}
+	switch {
+	case head.Seq > result.LastSeq:
+		result.Problems = append(result.Problems, fmt.Sprintf("%s was sealed at entry %d but the log ends at entry %d; entries were removed", auditHeadFileName, head.Seq, result.LastSeq))
+	case head.Seq < result.LastSeq:
+		result.Problems = append(result.Problems, fmt.Sprintf("entries after %d were not written by simple-secrets", head.Seq))
+	default:
+		result.Problems = append(result.Problems, fmt.Sprintf("entry %d is not the entry that was written", head.Seq))
+	}
+}
+
+// auditResult classifies an operation's error for the audit log
+func auditResult(err error) string {
+	switch {
+	case err == nil:
+		return AuditSuccess
+	case errors.Is(err, ErrInvalidToken), errors.Is(err, ErrMalformedToken), errors.Is(err, ErrAuthLockedOut),
+		errors.Is(err, ErrTokenExpired), errors.Is(err, ErrUserDisabled), errors.Is(err, ErrUserLocked),
+		errors.Is(err, ErrInvalidRecoveryCode):
+		return AuditAuthFailed
+	case errors.Is(err, ErrPermissionDenied), errors.Is(err, ErrAccessDenied), errors.Is(err, ErrApprovalRequired):
+		return AuditDenied
+	}
+	return AuditError
+}
+
+// auditEvent is an operation being recorded, started before it runs so that the user is
+// identified by the token it was called with, which the operation may rotate or revoke
+type auditEvent struct {
+	log   *auditLog
+	entry AuditEntry
+}
+
+// track starts recording an operation called with a token
+func (a *auditLog) track(token, op, key string) *auditEvent {
+	user := ""
+	if a.userStore != nil && token != "" {
+		user = a.userStore.auditIdentity(token)
+	}
+	return a.trackUser(user, op, key)
+}
+
+// trackUser starts recording an operation by a user who is already authenticated
+func (a *auditLog) trackUser(username, op, key string) *auditEvent {
+	return &auditEvent{log: a, entry: AuditEntry{User: username, Client: LocalAuthClient(), Label: ClientLabel(), Op: op, Key: key}}
+}
+
+// done records the operation's result; use it as 'defer a.track(...).done(&err)'
+func (e *auditEvent) done(err *error) {
+	e.entry.Time = time.Now()
+	e.entry.Result = auditResult(*err)
+	e.log.record(e.entry)
+}
+
+// failed records the operation only if it did not succeed
+func (e *auditEvent) failed(err *error) {
+	if *err != nil {
+		e.done(err)
+	}
+}
+
+// auditIdentity returns the user a token names: its owner by token ID, or for tokens
+// without an ID, the user it authenticates. Failed attempts are not counted.
+func (us *UserStore) auditIdentity(token string) string {
+	if owner := us.tokenOwner(token); owner != "" {
+		return owner
+	}
+	if user, _, err := us.verifyToken(token, time.Now()); err == nil {
+		return user.Username
+	}
+	return ""
+}
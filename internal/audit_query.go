@@ -0,0 +1,191 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// auditResults lists the results an entry can record
+var auditResults = []string{AuditSuccess, AuditDenied, AuditAuthFailed, AuditError}
+
+// AuditFilter selects audit entries. Empty fields match every entry; Key may be a
+// pattern such as "prod-*", matched like policy keys.
+type AuditFilter struct {
+	User   string
+	Key    string
+	Op     string
+	Result string
+	Since  time.Time // zero means from the first entry
+	Until  time.Time // zero means up to now
+}
+
+// validate checks the key pattern and the result before any file is read
+func (f AuditFilter) validate() error {
+	if _, err := path.Match(f.Key, ""); err != nil {
+		return fmt.Errorf("invalid key pattern %q: %w", f.Key, err)
+	}
+	if f.Result != "" && !slices.Contains(auditResults, f.Result) {
+		return fmt.Errorf("invalid result %q: must be one of %v", f.Result, auditResults)
+	}
+	if !f.Since.IsZero() && !f.Until.IsZero() && f.Until.Before(f.Since) {
+		return fmt.Errorf("--until must not be before --since")
+	}
+	return nil
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	if f.User != "" && entry.User != f.User {
+		return false
+	}
+	if f.Op != "" && entry.Op != f.Op {
+		return false
+	}
+	if f.Result != "" && entry.Result != f.Result {
+		return false
+	}
+	if f.Key != "" {
+		if ok, _ := path.Match(f.Key, entry.Key); !ok {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	return f.Until.IsZero() || !entry.Time.After(f.Until)
+}
+
+// ParseAuditTime parses a --since or --until value: a date, an RFC 3339 time, or a
+// duration before now such as 7d or 12h
+func ParseAuditTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if ago, err := ParseLifetime(value); err == nil {
+		return now.Add(-ago), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use a date (2025-06-01), an RFC 3339 time or a duration ago such as 7d", value)
+}
+
+// query returns the entries the filter selects, oldest first, from the rotated logs and
+// audit.log. Lines that are not valid entries are skipped; 'audit verify' reports them.
+func (a *auditLog) query(filter AuditFilter) ([]AuditEntry, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+	paths, err := a.files()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []AuditEntry{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry AuditEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && filter.matches(entry) {
+				entries = append(entries, entry)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// AuditPruneResult describes the rotated logs removed by 'audit prune'
+type AuditPruneResult struct {
+	Files   []string `json:"files"`   // relative to the config directory
+	Entries uint64   `json:"entries"` // entries removed with them
+	Through uint64   `json:"through"` // the last entry removed; the log now starts after it
+}
+
+// prune removes the rotated logs whose entries are all older than cutoff
+func (a *auditLog) prune(cutoff time.Time) (*AuditPruneResult, error) {
+	lock, err := LockFile(a.path())
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	return a.pruneLocked(cutoff)
+}
+
+// pruneLocked removes rotated logs from the oldest on, stopping at the first with an entry
+// newer than cutoff, so the remaining chain is unbroken. The head records the last entry
+// removed so that the chain can still be verified from its new start. audit.log itself is
+// never pruned.
+func (a *auditLog) pruneLocked(cutoff time.Time) (*AuditPruneResult, error) {
+	head, err := a.readHead()
+	if err != nil {
+		return nil, fmt.Errorf("refusing to prune: %w", err)
+	}
+	rotated, err := a.rotatedFiles()
+	if err != nil {
+		return nil, err
+	}
+	result := &AuditPruneResult{Files: []string{}}
+	if len(rotated) == 0 {
+		return result, nil
+	}
+	if head == nil {
+		return nil, fmt.Errorf("refusing to prune: %s is missing; run 'simple-secrets audit verify'", auditHeadFileName)
+	}
+
+	var through *AuditEntry
+	for _, file := range rotated {
+		last, err := lastAuditEntry(file.path)
+		if err != nil {
+			return nil, err
+		}
+		if last == nil || !last.Time.Before(cutoff) {
+			break
+		}
+		if err := os.Remove(file.path); err != nil {
+			return nil, err
+		}
+		name, _ := filepath.Rel(a.dir, file.path)
+		result.Files = append(result.Files, name)
+		through = last
+	}
+	if through == nil {
+		return result, nil
+	}
+
+	result.Entries, result.Through = through.Seq-head.PrunedSeq, through.Seq
+	head.PrunedSeq, head.PrunedHash = through.Seq, through.Hash
+	if err := a.writeHead(head); err != nil {
+		return nil, fmt.Errorf("pruned %d file(s) but could not record it in %s: %w", len(result.Files), auditHeadFileName, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,434 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readAuditEntries returns the entries in a config directory's audit log
+func readAuditEntries(t *testing.T, dir string) []AuditEntry {
+	t.Helper()
+	file, err := os.Open(filepath.Join(dir, auditLogFileName))
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("parse entry %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func newAuditedService(t *testing.T, dir string) *Service {
+	t.Helper()
+	service, err := NewService(WithStorageBackend(NewFilesystemBackend()), WithConfigDir(dir))
+	if err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+	return service
+}
+
+func TestAuditLogRecordsOperations(t *testing.T) {
+	dir := newSealedInstallation(t)
+	service := newAuditedService(t, dir)
+
+	if err := service.Secrets().Put("admin-token", "db_password", "hunter2-value"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err := service.Secrets().Get("bob-token", "db_password"); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if err := service.Secrets().Delete("bob-token", "db_password"); err == nil {
+		t.Fatal("a reader must not delete")
+	}
+	if _, err := service.Auth().ValidateToken("not-a-token"); err == nil {
+		t.Fatal("an unknown token must not authenticate")
+	}
+
+	entries := readAuditEntries(t, dir)
+	want := []struct{ user, op, key, result string }{
+		{"admin", "put", "db_password", AuditSuccess},
+		{"bob", "get", "db_password", AuditSuccess},
+		{"bob", "delete", "db_password", AuditDenied},
+		{"", "authenticate", "", AuditAuthFailed},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d entries, got %d: %+v", len(want), len(entries), entries)
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.User != w.user || e.Op != w.op || e.Key != w.key || e.Result != w.result {
+			t.Errorf("entry %d: got %s/%s/%s/%s, want %s/%s/%s/%s", i+1, e.User, e.Op, e.Key, e.Result, w.user, w.op, w.key, w.result)
+		}
+		if e.Seq != uint64(i+1) || e.Time.IsZero() || e.Client == "" {
+			t.Errorf("entry %d is incomplete: %+v", i+1, e)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, auditLogFileName))
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	if strings.Contains(string(data), "hunter2-value") || strings.Contains(string(data), "bob-token") {
+		t.Fatalf("the audit log must hold neither values nor tokens:\n%s", data)
+	}
+
+	result, err := service.Audit().Verify("admin-token")
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !result.Intact() || result.Entries != len(want) {
+		t.Fatalf("an untouched log should verify: %+v", result)
+	}
+	if _, err := service.Audit().Verify("bob-token"); err == nil {
+		t.Fatal("only admins may verify the audit log")
+	}
+}
+
+func TestAuditRecordsPolicyDenials(t *testing.T) {
+	dir := newSealedInstallation(t)
+	service := newAuditedService(t, dir)
+
+	if err := service.Secrets().Put("admin-token", "hr-db", "value"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err := service.Policies().AddPolicy("admin-token", Policy{Effect: PolicyDeny, Subject: UserSubject("bob"), Actions: []string{ActionRead}, Keys: []string{"hr-*"}}); err != nil {
+		t.Fatalf("add policy: %v", err)
+	}
+	if _, err := service.Secrets().Get("bob-token", "hr-db"); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("expected a policy denial, got %v", err)
+	}
+	if _, err := service.Audit().Verify("bob-token"); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected a permission denial, got %v", err)
+	}
+
+	denied, err := service.Audit().Query("admin-token", AuditFilter{Result: AuditDenied})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(denied) != 2 || denied[0].Op != "get" || denied[0].Key != "hr-db" || denied[1].User != "bob" {
+		t.Fatalf("expected the policy and permission denials, got %+v", denied)
+	}
+}
+
+func TestAuditVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, dir string, lines []string)
+		want   string
+	}{
+		{
+			name: "edited entry",
+			tamper: func(t *testing.T, dir string, lines []string) {
+				editFile(t, filepath.Join(dir, auditLogFileName), `"result":"denied"`, `"result":"success"`)
+			},
+			want: "the entry was edited",
+		},
+		{
+			name: "removed entry",
+			tamper: func(t *testing.T, dir string, lines []string) {
+				writeAuditLines(t, dir, append(lines[:1:1], lines[2:]...))
+			},
+			want: "entries are missing",
+		},
+		{
+			name: "truncated log",
+			tamper: func(t *testing.T, dir string, lines []string) {
+				writeAuditLines(t, dir, lines[:2])
+			},
+			want: "entries were removed",
+		},
+		{
+			name: "rewritten head",
+			tamper: func(t *testing.T, dir string, lines []string) {
+				editFile(t, filepath.Join(dir, auditHeadFileName), `"seq": 3`, `"seq": 2`)
+			},
+			want: "was modified outside simple-secrets",
+		},
+		{
+			name: "removed head",
+			tamper: func(t *testing.T, dir string, lines []string) {
+				if err := os.Remove(filepath.Join(dir, auditHeadFileName)); err != nil {
+					t.Fatalf("remove head: %v", err)
+				}
+			},
+			want: "is missing",
+		},
+		{
+			name: "removed log and head",
+			tamper: func(t *testing.T, dir string, lines []string) {
+				for _, name := range []string{auditLogFileName, auditHeadFileName} {
+					if err := os.Remove(filepath.Join(dir, name)); err != nil {
+						t.Fatalf("remove %s: %v", name, err)
+					}
+				}
+			},
+			want: "audit_head.json is missing, but a sealed copy exists",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newSealedInstallation(t)
+			service := newAuditedService(t, dir)
+			for _, token := range []string{"admin-token", "bob-token", "admin-token"} {
+				service.Secrets().Put(token, "api_key", "value")
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, auditLogFileName))
+			if err != nil {
+				t.Fatalf("read audit log: %v", err)
+			}
+			tt.tamper(t, dir, strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n"))
+
+			result, err := newAuditLog(dir, nil).verify()
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if result.Intact() || !strings.Contains(strings.Join(result.Problems, "\n"), tt.want) {
+				t.Fatalf("expected a problem mentioning %q, got %q", tt.want, result.Problems)
+			}
+		})
+	}
+}
+
+func writeAuditLines(t *testing.T, dir string, lines []string) {
+	t.Helper()
+	content := strings.TrimSuffix(strings.Join(lines, ""), "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(dir, auditLogFileName), []byte(content), 0600); err != nil {
+		t.Fatalf("write audit log: %v", err)
+	}
+}
+
+func TestAuditTruncationSurvivesLaterWrites(t *testing.T) {
+	dir := newSealedInstallation(t)
+	log := newAuditLog(dir, nil)
+	for i := range 3 {
+		if err := log.append(AuditEntry{User: "admin", Op: "get", Key: fmt.Sprintf("key%d", i), Result: AuditSuccess}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(log.path())
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	writeAuditLines(t, dir, strings.SplitAfter(string(data), "\n")[:1])
+
+	// The next entry is written, but the head keeps the end that was removed
+	if err := log.append(AuditEntry{User: "admin", Op: "get", Key: "after", Result: AuditSuccess}); err == nil {
+		t.Fatal("appending after a truncation should report it")
+	}
+	result, err := log.verify()
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if result.Intact() || result.Entries != 2 {
+		t.Fatalf("the truncation must still be detected: %+v", result)
+	}
+}
+
+// TestConcurrentAuditAppends writes from separate services at once, each standing in for a
+// process; the lock must keep the chain unbroken
+func TestConcurrentAuditAppends(t *testing.T) {
+	dir := newSealedInstallation(t)
+
+	const numServices, perService = 6, 5
+	var wg sync.WaitGroup
+	for i := range numServices {
+		service := newAuditedService(t, dir)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range perService {
+				if err := service.Secrets().Put("admin-token", fmt.Sprintf("key%d_%d", i, j), "value"); err != nil {
+					t.Errorf("service %d: put: %v", i, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	result, err := newAuditLog(dir, nil).verify()
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !result.Intact() || result.Entries != numServices*perService {
+		t.Fatalf("expected an intact log of %d entries: %+v", numServices*perService, result)
+	}
+}
+
+// appendAt writes an entry with the given time through a log that rotates after every entry
+func appendAt(t *testing.T, log *auditLog, at time.Time, user, op, key, result string) {
+	t.Helper()
+	if err := log.append(AuditEntry{Time: at, User: user, Op: op, Key: key, Result: result}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+}
+
+func TestAuditRotationKeepsChain(t *testing.T) {
+	dir := newSealedInstallation(t)
+	log := newAuditLog(dir, nil)
+	log.policy = AuditPolicy{MaxSize: 1} // every append rotates the entry before it
+
+	now := time.Now()
+	for i := range 4 {
+		appendAt(t, log, now.Add(time.Duration(i)*time.Minute), "admin", "get", fmt.Sprintf("key%d", i), AuditSuccess)
+	}
+	rotated, err := log.rotatedFiles()
+	if err != nil || len(rotated) != 3 {
+		t.Fatalf("expected 3 rotated logs, got %d: %v", len(rotated), err)
+	}
+
+	result, err := log.verify()
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !result.Intact() || result.Entries != 4 || result.Files != 4 {
+		t.Fatalf("the chain should run through the rotated logs: %+v", result)
+	}
+
+	// Removing a rotated log breaks the chain
+	if err := os.Remove(rotated[1].path); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if result, _ := log.verify(); result.Intact() {
+		t.Fatal("a missing rotated log must be detected")
+	}
+}
+
+func TestAuditPrune(t *testing.T) {
+	dir := newSealedInstallation(t)
+	log := newAuditLog(dir, nil)
+	log.policy = AuditPolicy{MaxSize: 1}
+
+	now := time.Now()
+	for _, age := range []time.Duration{400, 300, 200, 10, 0} {
+		appendAt(t, log, now.Add(-age*24*time.Hour), "admin", "get", "api_key", AuditSuccess)
+	}
+
+	result, err := log.prune(now.Add(-100 * 24 * time.Hour))
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if len(result.Files) != 3 || result.Entries != 3 || result.Through != 3 {
+		t.Fatalf("the three old logs should be pruned: %+v", result)
+	}
+
+	verification, err := log.verify()
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !verification.Intact() || verification.Entries != 2 {
+		t.Fatalf("the pruned log should still verify from its new start: %+v", verification)
+	}
+
+	// Deleting the next rotated log by hand is not a prune
+	rotated, _ := log.rotatedFiles()
+	if err := os.Remove(rotated[0].path); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	verification, _ = log.verify()
+	if verification.Intact() || !strings.Contains(strings.Join(verification.Problems, "\n"), "earlier entries were removed") {
+		t.Fatalf("removing a log outside prune must be detected: %+v", verification)
+	}
+}
+
+func TestAuditRetentionOnRotation(t *testing.T) {
+	dir := newSealedInstallation(t)
+	log := newAuditLog(dir, nil)
+	log.policy = AuditPolicy{MaxSize: 1, Retention: 30 * 24 * time.Hour}
+
+	now := time.Now()
+	appendAt(t, log, now.Add(-90*24*time.Hour), "admin", "get", "old", AuditSuccess)
+	appendAt(t, log, now.Add(-time.Hour), "admin", "get", "recent", AuditSuccess)
+	appendAt(t, log, now, "admin", "get", "now", AuditSuccess)
+
+	entries, err := log.query(AuditFilter{})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Key != "recent" {
+		t.Fatalf("the log older than audit_retention should be pruned on rotation: %+v", entries)
+	}
+	if result, _ := log.verify(); !result.Intact() {
+		t.Fatalf("the log should verify after retention: %+v", result)
+	}
+}
+
+func TestAuditQuery(t *testing.T) {
+	dir := newSealedInstallation(t)
+	log := newAuditLog(dir, nil)
+	log.policy = AuditPolicy{MaxSize: 1}
+
+	now := time.Now()
+	appendAt(t, log, now.Add(-10*24*time.Hour), "alice", "get", "prod-db_password", AuditSuccess)
+	appendAt(t, log, now.Add(-2*24*time.Hour), "bob", "get", "prod-db_password", AuditSuccess)
+	appendAt(t, log, now.Add(-24*time.Hour), "bob", "put", "prod-api_key", AuditDenied)
+	appendAt(t, log, now, "", "authenticate", "", AuditAuthFailed)
+
+	since, err := ParseAuditTime("7d", now)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   int
+	}{
+		{"everything", AuditFilter{}, 4},
+		{"reads of a key last week", AuditFilter{Key: "prod-db_password", Op: "get", Since: since}, 1},
+		{"key pattern", AuditFilter{Key: "prod-*"}, 3},
+		{"user", AuditFilter{User: "bob"}, 2},
+		{"result", AuditFilter{Result: AuditAuthFailed}, 1},
+		{"until", AuditFilter{Until: now.Add(-5 * 24 * time.Hour)}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := log.query(tt.filter)
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			if len(entries) != tt.want {
+				t.Fatalf("expected %d entries, got %d: %+v", tt.want, len(entries), entries)
+			}
+		})
+	}
+
+	if _, err := log.query(AuditFilter{Result: "ok"}); err == nil {
+		t.Fatal("an unknown result should be rejected")
+	}
+	if _, err := ParseAuditTime("last tuesday", now); err == nil {
+		t.Fatal("an unparsable time should be rejected")
+	}
+}
@@ -0,0 +1,425 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// authFailuresFileName holds the failed-authentication counters, outside the sealed users.json
+const authFailuresFileName = "auth_failures.json"
+
+// Failed-authentication defaults: backoff from the third failure in a row, lockout at the tenth
+const (
+	DefaultAuthBackoffAfter    = 3
+	DefaultAuthLockoutAttempts = 10
+	DefaultAuthLockoutDuration = 15 * time.Minute
+)
+
+// authBackoffBase is the first backoff delay; each further failure doubles it
+const authBackoffBase = time.Second
+
+// ErrAuthLockedOut indicates authentication was refused because of earlier failed attempts
+var ErrAuthLockedOut = errors.New("too many failed authentication attempts")
+
+// AuthFailure counts the consecutive failed authentications of one user or client
+type AuthFailure struct {
+	Failures    int        `json:"failures"`
+	LastFailure time.Time  `json:"last_failure"`
+	LastClient  string     `json:"last_client,omitempty"`  // for users, where the last failure came from
+	RetryAt     *time.Time `json:"retry_at,omitempty"`     // backoff: attempts before this time are refused
+	LockedUntil *time.Time `json:"locked_until,omitempty"` // lockout: attempts before this time are refused
+}
+
+// Blocked returns until when attempts are refused, and whether that is a lockout rather
+// than a backoff. It returns nil when attempts are accepted.
+func (f *AuthFailure) Blocked(now time.Time) (*time.Time, bool) {
+	if f.LockedUntil != nil && now.Before(*f.LockedUntil) {
+		return f.LockedUntil, true
+	}
+	if f.RetryAt != nil && now.Before(*f.RetryAt) {
+		return f.RetryAt, false
+	}
+	return nil, false
+}
+
+// authFailuresFile is the on-disk layout of auth_failures.json
+type authFailuresFile struct {
+	Version int                     `json:"version"`
+	Users   map[string]*AuthFailure `json:"users"`
+	Clients map[string]*AuthFailure `json:"clients"`
+}
+
+// AuthLockoutPolicy configures backoff and lockout after failed authentications, from config.json
+type AuthLockoutPolicy struct {
+	BackoffAfter int           // failures in a row before backoff starts
+	Attempts     int           // failures in a row that lock out; 0 disables tracking
+	Duration     time.Duration // how long a lockout lasts, and how long failures are remembered
+}
+
+// fail counts one more failure on the record and sets its backoff or lockout
+func (p AuthLockoutPolicy) fail(f *AuthFailure, now time.Time) {
+	if now.Sub(f.LastFailure) >= p.Duration || (f.LockedUntil != nil && !now.Before(*f.LockedUntil)) {
+		*f = AuthFailure{LastClient: f.LastClient}
+	}
+	f.Failures++
+	f.LastFailure = now.UTC()
+	f.RetryAt = nil
+
+	if f.Failures >= p.Attempts {
+		until := now.Add(p.Duration).UTC()
+		f.LockedUntil = &until
+		return
+	}
+	if p.BackoffAfter > 0 && f.Failures >= p.BackoffAfter {
+		delay := min(authBackoffBase<<min(f.Failures-p.BackoffAfter, 20), p.Duration)
+		retry := now.Add(delay).UTC()
+		f.RetryAt = &retry
+	}
+}
+
+// expired reports whether the record no longer blocks or counts toward a lockout
+func (p AuthLockoutPolicy) expired(f *AuthFailure, now time.Time) bool {
+	until, _ := f.Blocked(now)
+	return until == nil && now.Sub(f.LastFailure) >= p.Duration
+}
+
+// authLockoutConfig is the part of config.json that configures failed-authentication handling
+type authLockoutConfig struct {
+	AuthBackoffAfter     *int   `json:"auth_backoff_after,omitempty"`     // e.g. 3 (default); 0 disables backoff
+	AuthLockoutThreshold *int   `json:"auth_lockout_threshold,omitempty"` // e.g. 10 (default); 0 disables tracking
+	AuthLockoutDuration  string `json:"auth_lockout_duration,omitempty"`  // e.g. "15m" (default)
+}
+
+// parse validates the configured thresholds and duration
+func (c authLockoutConfig) parse() (AuthLockoutPolicy, error) {
+	policy := AuthLockoutPolicy{BackoffAfter: DefaultAuthBackoffAfter, Attempts: DefaultAuthLockoutAttempts, Duration: DefaultAuthLockoutDuration}
+	if c.AuthBackoffAfter != nil {
+		if *c.AuthBackoffAfter < 0 {
+			return policy, fmt.Errorf("auth_backoff_after: must not be negative, got %d", *c.AuthBackoffAfter)
+		}
+		policy.BackoffAfter = *c.AuthBackoffAfter
+	}
+	if c.AuthLockoutThreshold != nil {
+		if *c.AuthLockoutThreshold < 0 {
+			return policy, fmt.Errorf("auth_lockout_threshold: must not be negative, got %d", *c.AuthLockoutThreshold)
+		}
+		policy.Attempts = *c.AuthLockoutThreshold
+	}
+	if c.AuthLockoutDuration != "" {
+		duration, err := ParseLifetime(c.AuthLockoutDuration)
+		if err != nil {
+			return policy, fmt.Errorf("auth_lockout_duration: %w", err)
+		}
+		policy.Duration = duration
+	}
+	return policy, nil
+}
+
+// loadAuthLockoutPolicy reads the failed-authentication settings from configDir's config.json.
+// A missing file means the defaults; an invalid setting is reported and the valid part applied.
+func loadAuthLockoutPolicy(configDir string) AuthLockoutPolicy {
+	defaults, _ := authLockoutConfig{}.parse()
+
+	data, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		return defaults
+	}
+	if err := configFormat.checkReadable(data); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v. Lockout settings are ignored\n", err)
+		return defaults
+	}
+
+	var config authLockoutConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return defaults // ResolveToken reports a corrupted config.json
+	}
+	policy, err := config.parse()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: config.json %v; the setting is ignored\n", err)
+	}
+	return policy
+}
+
+// LocalAuthClient identifies this process as a client of failed-authentication tracking:
+// the operating system user and host running the CLI. Servers pass the remote address instead.
+var LocalAuthClient = sync.OnceValue(func() string {
+	name := os.Getenv("USER")
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("local:%s@%s", name, host)
+})
+
+// authFailures tracks failed authentications in auth_failures.json. The file is shared by
+// every process using the config directory, so the CLI and a server see the same counters.
+type authFailures struct {
+	path   string
+	policy AuthLockoutPolicy
+}
+
+func newAuthFailures(configDir string) *authFailures {
+	return &authFailures{path: filepath.Join(configDir, authFailuresFileName), policy: loadAuthLockoutPolicy(configDir)}
+}
+
+// enabled reports whether failures are tracked at all
+func (af *authFailures) enabled() bool {
+	return af != nil && af.policy.Attempts > 0
+}
+
+// load returns the recorded failures; a missing file records none. A file that cannot be
+// read is an error rather than no failures, so that damaging it does not lift a lockout.
+func (af *authFailures) load() (*authFailuresFile, error) {
+	var file authFailuresFile
+	err := readConfigFile(af.path, authFailuresFormat, &file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w; authentication is refused until it is fixed or removed", authFailuresFileName, err)
+	}
+	if file.Users == nil {
+		file.Users = map[string]*AuthFailure{}
+	}
+	if file.Clients == nil {
+		file.Clients = map[string]*AuthFailure{}
+	}
+	return &file, nil
+}
+
+// update applies a change to the recorded failures under the file lock, dropping expired
+// records, and saves them if the change reports it made one
+func (af *authFailures) update(now time.Time, change func(file *authFailuresFile) bool) error {
+	lock, err := LockFile(af.path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	file, err := af.load()
+	if err != nil {
+		return err
+	}
+	if !change(file) {
+		return nil
+	}
+	for _, records := range []map[string]*AuthFailure{file.Users, file.Clients} {
+		maps.DeleteFunc(records, func(_ string, f *AuthFailure) bool { return af.policy.expired(f, now) })
+	}
+
+	file.Version = AuthFailuresFormatVersion
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return AtomicWriteFile(af.path, data, secureFilePermissions)
+}
+
+// check refuses an attempt from a client in backoff or lockout, or for a locked-out user
+func (af *authFailures) check(file *authFailuresFile, client, username string, now time.Time) error {
+	if err := af.checkClient(file, client, now); err != nil {
+		return err
+	}
+	return af.checkUser(file, username, now)
+}
+
+// checkClient refuses an attempt from a client in backoff or lockout
+func (af *authFailures) checkClient(file *authFailuresFile, client string, now time.Time) error {
+	if f, ok := file.Clients[client]; ok {
+		if until, _ := f.Blocked(now); until != nil {
+			return fmt.Errorf("%w from %s: try again in %s", ErrAuthLockedOut, client, FormatRemaining(until.Sub(now)))
+		}
+	}
+	return nil
+}
+
+// checkUser refuses an attempt for a user in backoff or lockout
+func (af *authFailures) checkUser(file *authFailuresFile, username string, now time.Time) error {
+	if f, ok := file.Users[username]; ok && username != "" {
+		if until, locked := f.Blocked(now); until != nil {
+			if locked {
+				return fmt.Errorf("%w for user %q: locked out for %s; an admin can run 'simple-secrets user unlock %s'",
+					ErrAuthLockedOut, username, FormatRemaining(until.Sub(now)), username)
+			}
+			return fmt.Errorf("%w for user %q: try again in %s", ErrAuthLockedOut, username, FormatRemaining(until.Sub(now)))
+		}
+	}
+	return nil
+}
+
+// failed counts a failed authentication from the client, and against the user whose live
+// token the attempt named, if any. Recording is best effort: failing to write it must not hide the error.
+func (af *authFailures) failed(client, username string, now time.Time) {
+	err := af.update(now, func(file *authFailuresFile) bool {
+		af.record(file.Clients, client, now)
+		if username != "" {
+			af.record(file.Users, username, now).LastClient = client
+		}
+		return true
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record the failed authentication: %v\n", err)
+	}
+}
+
+// record counts a failure on the named record, creating it if needed
+func (af *authFailures) record(records map[string]*AuthFailure, name string, now time.Time) *AuthFailure {
+	f, ok := records[name]
+	if !ok {
+		f = &AuthFailure{}
+		records[name] = f
+	}
+	af.policy.fail(f, now)
+	return f
+}
+
+// succeeded clears the failures of the client and user after a successful authentication.
+// A client in backoff or lockout keeps its record, so that signing in with one valid token
+// does not let it go on guessing others. The file is only locked and written when there
+// is something to clear.
+func (af *authFailures) succeeded(file *authFailuresFile, client, username string, now time.Time) {
+	clientFailed := file.Clients[client] != nil && af.checkClient(file, client, now) == nil
+	_, userFailed := file.Users[username]
+	if !clientFailed && !userFailed {
+		return
+	}
+	_ = af.update(now, func(file *authFailuresFile) bool {
+		if clientFailed {
+			delete(file.Clients, client)
+		}
+		delete(file.Users, username)
+		return true
+	})
+}
+
+// unlock clears the failures recorded for a user or client, reporting whether there were any
+func (af *authFailures) unlock(records func(file *authFailuresFile) map[string]*AuthFailure, name string, now time.Time) (bool, error) {
+	found := false
+	err := af.update(now, func(file *authFailuresFile) bool {
+		_, found = records(file)[name]
+		delete(records(file), name)
+		return found
+	})
+	return found, err
+}
+
+// authenticateFrom authenticates a token presented by a client, refusing clients and
+// users with too many failed attempts and counting this attempt if it fails
+func (us *UserStore) authenticateFrom(token, client string, now time.Time) (*User, tokenMatch, error) {
+	if !us.failures.enabled() || token == "" {
+		return us.verifyToken(token, now)
+	}
+
+	file, err := us.failures.load()
+	if err != nil {
+		return nil, tokenMatch{}, err
+	}
+	if err := us.failures.checkUser(file, us.tokenOwner(token), now); err != nil {
+		return nil, tokenMatch{}, err
+	}
+
+	// The client is checked only once the token fails, so that a valid token gets through
+	// even when another process of the same OS user on this host keeps failing
+	user, match, err := us.verifyToken(token, now)
+	if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrMalformedToken) {
+		if blocked := us.failures.checkClient(file, client, now); blocked != nil {
+			return nil, match, blocked
+		}
+		us.failures.failed(client, us.liveTokenOwner(token, now), now)
+		return nil, match, err
+	}
+	if err != nil {
+		return nil, match, err
+	}
+
+	// Legacy and derived tokens only name their user once verified
+	if err := us.failures.checkUser(file, user.Username, now); err != nil {
+		return nil, tokenMatch{}, err
+	}
+	us.failures.succeeded(file, client, user.Username, now)
+	return user, match, nil
+}
+
+// tokenOwner returns the user whose token ID the token carries, or "" if it names none
+func (us *UserStore) tokenOwner(token string) string {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+	if u, ok := us.index[tokenLookupID(token)]; ok {
+		return u.Username
+	}
+	return ""
+}
+
+// liveTokenOwner returns the user holding a current token, or a previous token still in its
+// grace period, with the token's ID. Only a failed attempt at such a token counts against
+// the user: an ID that names no live token says nothing about who was being guessed.
+func (us *UserStore) liveTokenOwner(token string, now time.Time) string {
+	id := tokenLookupID(token)
+	if id == "" {
+		return ""
+	}
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+	u, ok := us.index[id]
+	if !ok {
+		return ""
+	}
+	live := u.TokenLookupID == id ||
+		slices.ContainsFunc(u.Tokens, func(t *Token) bool { return t.LookupID == id }) ||
+		slices.ContainsFunc(u.PreviousTokens, func(p *PreviousToken) bool { return p.LookupID == id && now.Before(p.ExpiresAt) })
+	if !live {
+		return ""
+	}
+	return u.Username
+}
+
+// FailedAuthentications returns the recorded failures by username and by client
+func (us *UserStore) FailedAuthentications() (map[string]*AuthFailure, map[string]*AuthFailure, error) {
+	if !us.failures.enabled() {
+		return nil, nil, nil
+	}
+	file, err := us.failures.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	return file.Users, file.Clients, nil
+}
+
+// UnlockUser clears a user's failed authentications and lockout, reporting whether there were any
+func (us *UserStore) UnlockUser(username string) (bool, error) {
+	if _, err := us.FindUser(username); err != nil {
+		return false, err
+	}
+	if us.failures == nil {
+		return false, nil
+	}
+	return us.failures.unlock(func(file *authFailuresFile) map[string]*AuthFailure { return file.Users }, username, time.Now())
+}
+
+// UnlockClient clears a client's failed authentications and lockout, reporting whether there were any
+func (us *UserStore) UnlockClient(client string) (bool, error) {
+	if us.failures == nil {
+		return false, nil
+	}
+	return us.failures.unlock(func(file *authFailuresFile) map[string]*AuthFailure { return file.Clients }, client, time.Now())
+}
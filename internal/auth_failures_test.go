@@ -0,0 +1,188 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthBackoffAndLockout(t *testing.T) {
+	dir := newSealedInstallation(t)
+	config := `{"version": 1, "auth_backoff_after": 2, "auth_lockout_threshold": 4, "auth_lockout_duration": "1h"}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatalf("write config.json: %v", err)
+	}
+	service, err := NewService(WithStorageBackend(NewFilesystemBackend()), WithConfigDir(dir))
+	if err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+	carolToken, err := service.Users().CreateUser("admin-token", "carol", string(RoleReader))
+	if err != nil {
+		t.Fatalf("create carol: %v", err)
+	}
+	// A guess at carol's secret carries her token ID and a valid checksum
+	body := carolToken[:len(TokenPrefix)+13] + strings.Repeat("a", 52)
+	guess := body + "_" + tokenChecksum(body)
+
+	store, err := loadUserStoreFromConfigDir(dir)
+	if err != nil {
+		t.Fatalf("load users: %v", err)
+	}
+	now := time.Now()
+	attempt := func(token, client string, at time.Duration) error {
+		_, _, err := store.authenticateFrom(token, client, now.Add(at))
+		return err
+	}
+
+	for range 2 {
+		if err := attempt(guess, "10.0.0.1", 0); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("expected invalid token, got %v", err)
+		}
+	}
+	if err := attempt("nope", "10.0.0.1", 0); !errors.Is(err, ErrAuthLockedOut) {
+		t.Fatalf("the client should back off after two failures, got %v", err)
+	}
+	// A valid token gets through, so one failing script cannot lock out its whole host
+	if err := attempt("admin-token", "10.0.0.1", 0); err != nil {
+		t.Fatalf("a valid token should get through the client's backoff: %v", err)
+	}
+	if err := attempt("nope", "10.0.0.1", time.Second/2); !errors.Is(err, ErrAuthLockedOut) {
+		t.Fatalf("a valid token must not end the client's backoff, got %v", err)
+	}
+	if err := attempt("admin-token", "10.0.0.1", 2*time.Second); err != nil {
+		t.Fatalf("the client should be let in once its backoff ends: %v", err)
+	}
+	if err := attempt(carolToken, "10.0.0.2", 0); !errors.Is(err, ErrAuthLockedOut) {
+		t.Fatalf("failures against carol should also back off her other clients, got %v", err)
+	}
+
+	// Two more guesses from another client lock carol out
+	for _, at := range []time.Duration{2 * time.Second, 5 * time.Second} {
+		if err := attempt(guess, "10.0.0.2", at); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("expected invalid token, got %v", err)
+		}
+	}
+	if err := attempt(carolToken, "10.0.0.3", time.Minute); !errors.Is(err, ErrAuthLockedOut) || !strings.Contains(err.Error(), "user unlock carol") {
+		t.Fatalf("carol should be locked out even with her own token, got %v", err)
+	}
+	users, _, _ := store.FailedAuthentications()
+	if f := users["carol"]; f == nil || f.Failures != 4 || f.LastClient != "10.0.0.2" {
+		t.Fatalf("carol's failures should be recorded, got %+v", f)
+	}
+
+	if cleared, err := service.Users().UnlockUser("admin-token", "carol"); err != nil || !cleared {
+		t.Fatalf("unlock: %v, %v", cleared, err)
+	}
+	if err := attempt(carolToken, "10.0.0.3", time.Minute); err != nil {
+		t.Fatalf("carol should be let in after the unlock: %v", err)
+	}
+	if err := attempt(carolToken, "10.0.0.3", 2*time.Hour); err != nil {
+		t.Fatalf("a lockout should end after auth_lockout_duration: %v", err)
+	}
+}
+
+func TestAuthLockoutPolicyConfig(t *testing.T) {
+	negative := -1
+	if _, err := (authLockoutConfig{AuthLockoutThreshold: &negative}).parse(); err == nil {
+		t.Fatal("a negative threshold should be rejected")
+	}
+
+	disabled := 0
+	policy, err := authLockoutConfig{AuthLockoutThreshold: &disabled, AuthLockoutDuration: "30m"}.parse()
+	if err != nil || policy.Attempts != 0 || policy.Duration != 30*time.Minute || policy.BackoffAfter != DefaultAuthBackoffAfter {
+		t.Fatalf("unexpected policy %+v, %v", policy, err)
+	}
+	if (&authFailures{policy: policy}).enabled() {
+		t.Fatal("a threshold of 0 should disable tracking")
+	}
+}
+
+func TestAuthFailuresCountOnlyLiveTokens(t *testing.T) {
+	dir := newSealedInstallation(t)
+	config := `{"version": 1, "auth_backoff_after": 0, "auth_lockout_threshold": 2, "auth_lockout_duration": "1h"}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatalf("write config.json: %v", err)
+	}
+	service, err := NewService(WithStorageBackend(NewFilesystemBackend()), WithConfigDir(dir))
+	if err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+	carolToken, err := service.Users().CreateUser("admin-token", "carol", string(RoleReader))
+	if err != nil {
+		t.Fatalf("create carol: %v", err)
+	}
+	rotated, err := service.Tokens().RotateToken("admin-token", "carol", DefaultTokenName, TokenGrace{Period: time.Minute, Access: GraceFull})
+	if err != nil {
+		t.Fatalf("rotate carol: %v", err)
+	}
+	guessAt := func(token string) string {
+		body := token[:len(TokenPrefix)+13] + strings.Repeat("a", 52)
+		return body + "_" + tokenChecksum(body)
+	}
+
+	store, err := loadUserStoreFromConfigDir(dir)
+	if err != nil {
+		t.Fatalf("load users: %v", err)
+	}
+	now := time.Now().Add(time.Hour)
+	// The ID of a token whose grace period has ended names no live token
+	for _, client := range []string{"10.0.0.1", "10.0.0.2"} {
+		if _, _, err := store.authenticateFrom(guessAt(carolToken), client, now); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("expected invalid token, got %v", err)
+		}
+	}
+	if _, _, err := store.authenticateFrom(rotated, "10.0.0.3", now); err != nil {
+		t.Fatalf("guesses at a retired token ID must not lock carol out: %v", err)
+	}
+
+	for _, client := range []string{"10.0.0.4", "10.0.0.5"} {
+		if _, _, err := store.authenticateFrom(guessAt(rotated), client, now); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("expected invalid token, got %v", err)
+		}
+	}
+	if _, _, err := store.authenticateFrom(rotated, "10.0.0.3", now); !errors.Is(err, ErrAuthLockedOut) {
+		t.Fatalf("guesses at carol's live token should lock her out, got %v", err)
+	}
+}
+
+func TestCorruptAuthFailuresKeepLockout(t *testing.T) {
+	dir := newSealedInstallation(t)
+	if err := os.WriteFile(filepath.Join(dir, authFailuresFileName), []byte("{not json"), 0600); err != nil {
+		t.Fatalf("write auth_failures.json: %v", err)
+	}
+	store, err := loadUserStoreFromConfigDir(dir)
+	if err != nil {
+		t.Fatalf("load users: %v", err)
+	}
+	if _, _, err := store.authenticateFrom("admin-token", "10.0.0.1", time.Now()); err == nil || !strings.Contains(err.Error(), authFailuresFileName) {
+		t.Fatalf("a corrupt %s should refuse authentication, got %v", authFailuresFileName, err)
+	}
+	if _, _, err := store.FailedAuthentications(); err == nil {
+		t.Fatal("a corrupt failures file should be reported, not read as no failures")
+	}
+
+	if err := os.Remove(filepath.Join(dir, authFailuresFileName)); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, _, err := store.authenticateFrom("admin-token", "10.0.0.1", time.Now()); err != nil {
+		t.Fatalf("removing the file should reset the counters: %v", err)
+	}
+}
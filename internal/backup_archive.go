@@ -0,0 +1,639 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A backup archive (.ssbak) is a JSON header line followed by the AES-256-GCM encryption
+// of a gzipped tar holding manifest.json and every store file. The header says how the
+// archive key is derived and is authenticated as additional data, so it can't be altered.
+const (
+	BackupArchiveFormatVersion = 1
+	backupArchiveFormat        = "simple-secrets-backup"
+	backupManifestName         = "manifest.json"
+
+	BackupEncryptionPassphrase = "passphrase"
+	BackupEncryptionRecipient  = "recipient"
+
+	backupPassphraseIterations    = 600_000
+	backupMinPassphraseIterations = 100_000 // refuse archives whose header asks for a weak derivation
+	MinBackupPassphraseLength     = 12
+
+	backupPublicKeyPrefix  = "ssbak-pub-"
+	backupPrivateKeyPrefix = "ssbak-key-"
+	backupRecipientInfo    = "simple-secrets backup archive v1"
+
+	preRestorePrefix = "pre-restore-"
+)
+
+// ErrBackupKey indicates the passphrase or identity does not open the archive
+var ErrBackupKey = errors.New("wrong passphrase or key, or the archive is damaged")
+
+// BackupKey encrypts or opens an archive: a passphrase, or a recipient's key pair.
+// Archives are created for a Recipient (public key) and opened with its Identity (private key).
+type BackupKey struct {
+	Passphrase string
+	Recipient  string
+	Identity   string
+}
+
+// backupHeader is the plaintext first line of an archive
+type backupHeader struct {
+	Format       string `json:"format"`
+	Version      int    `json:"version"`
+	Encryption   string `json:"encryption"`
+	Iterations   int    `json:"iterations,omitempty"`
+	Salt         []byte `json:"salt"`
+	EphemeralKey []byte `json:"ephemeral_key,omitempty"`
+	Recipient    string `json:"recipient,omitempty"` // the public key the archive was created for
+}
+
+// BackupManifest lists the files of an archive with their checksums
+type BackupManifest struct {
+	Version   int          `json:"version"` // BackupArchiveFormatVersion of the archive
+	CreatedAt time.Time    `json:"created_at"`
+	CreatedBy string       `json:"created_by,omitempty"`
+	Secrets   int          `json:"secrets"`
+	Files     []BackupFile `json:"files"`
+}
+
+// BackupFile is one store file in an archive
+type BackupFile struct {
+	Path          string `json:"path"` // slash-separated, relative to the store directory
+	Size          int64  `json:"size"`
+	SHA256        string `json:"sha256"`
+	FormatVersion *int   `json:"format_version,omitempty"` // for versioned files such as users.json
+}
+
+// BackupVerification is the result of checking an archive against its manifest
+type BackupVerification struct {
+	Encryption string          `json:"encryption"`
+	Manifest   *BackupManifest `json:"manifest"`
+	Problems   []string        `json:"problems,omitempty"`
+}
+
+// OK reports whether the archive can be restored
+func (v *BackupVerification) OK() bool {
+	return len(v.Problems) == 0
+}
+
+// BackupRestore is the outcome of restoring an archive
+type BackupRestore struct {
+	Manifest *BackupManifest `json:"manifest"`
+	Snapshot string          `json:"snapshot"` // the pre-restore copy of the store
+	Removed  []string        `json:"removed,omitempty"`
+}
+
+// GenerateBackupKeyPair returns a new recipient public key and the matching private key
+func GenerateBackupKeyPair() (public, private string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return encodeBackupKey(backupPublicKeyPrefix, key.PublicKey().Bytes()),
+		encodeBackupKey(backupPrivateKeyPrefix, key.Bytes()), nil
+}
+
+func encodeBackupKey(prefix string, key []byte) string {
+	return prefix + base64.RawURLEncoding.EncodeToString(key)
+}
+
+// parseBackupKey decodes a key written by GenerateBackupKeyPair
+func parseBackupKey(prefix, value string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(value), prefix)
+	if !ok {
+		return nil, fmt.Errorf("not a backup key: expected a value starting with %q", prefix)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup key: %w", err)
+	}
+	return key, nil
+}
+
+// sealKey returns the header and archive key for a new archive
+func (k BackupKey) sealKey() (*backupHeader, []byte, error) {
+	header := &backupHeader{Format: backupArchiveFormat, Version: BackupArchiveFormatVersion, Salt: make([]byte, 16)}
+	if _, err := rand.Read(header.Salt); err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case k.Passphrase != "" && k.Recipient != "":
+		return nil, nil, fmt.Errorf("use either a passphrase or a recipient key, not both")
+	case k.Passphrase != "":
+		if len(k.Passphrase) < MinBackupPassphraseLength {
+			return nil, nil, fmt.Errorf("backup passphrase must be at least %d characters", MinBackupPassphraseLength)
+		}
+		header.Encryption, header.Iterations = BackupEncryptionPassphrase, backupPassphraseIterations
+		key, err := pbkdf2.Key(sha256.New, k.Passphrase, header.Salt, header.Iterations, AES256KeySize)
+		return header, key, err
+	case k.Recipient != "":
+		public, err := parseBackupKey(backupPublicKeyPrefix, k.Recipient)
+		if err != nil {
+			return nil, nil, err
+		}
+		recipient, err := ecdh.X25519().NewPublicKey(public)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid recipient key: %w", err)
+		}
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		shared, err := ephemeral.ECDH(recipient)
+		if err != nil {
+			return nil, nil, err
+		}
+		header.Encryption = BackupEncryptionRecipient
+		header.EphemeralKey = ephemeral.PublicKey().Bytes()
+		header.Recipient = encodeBackupKey(backupPublicKeyPrefix, public)
+		key, err := hkdf.Key(sha256.New, shared, header.Salt, backupRecipientInfo, AES256KeySize)
+		return header, key, err
+	default:
+		return nil, nil, fmt.Errorf("a backup passphrase or recipient key is required")
+	}
+}
+
+// openKey derives the archive key described by header
+func (k BackupKey) openKey(header *backupHeader) ([]byte, error) {
+	switch header.Encryption {
+	case BackupEncryptionPassphrase:
+		if k.Passphrase == "" {
+			return nil, fmt.Errorf("this archive is encrypted with a passphrase")
+		}
+		if header.Iterations < backupMinPassphraseIterations {
+			return nil, fmt.Errorf("archive header asks for %d key derivation iterations, fewer than the minimum of %d", header.Iterations, backupMinPassphraseIterations)
+		}
+		return pbkdf2.Key(sha256.New, k.Passphrase, header.Salt, header.Iterations, AES256KeySize)
+	case BackupEncryptionRecipient:
+		if k.Identity == "" {
+			return nil, fmt.Errorf("this archive is encrypted for %s; pass the matching private key", header.Recipient)
+		}
+		private, err := parseBackupKey(backupPrivateKeyPrefix, k.Identity)
+		if err != nil {
+			return nil, err
+		}
+		identity, err := ecdh.X25519().NewPrivateKey(private)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+		if encodeBackupKey(backupPublicKeyPrefix, identity.PublicKey().Bytes()) != header.Recipient {
+			return nil, fmt.Errorf("this archive is encrypted for %s, not for the given private key", header.Recipient)
+		}
+		ephemeral, err := ecdh.X25519().NewPublicKey(header.EphemeralKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive header: %w", err)
+		}
+		shared, err := identity.ECDH(ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		return hkdf.Key(sha256.New, shared, header.Salt, backupRecipientInfo, AES256KeySize)
+	default:
+		return nil, fmt.Errorf("unsupported archive encryption %q", header.Encryption)
+	}
+}
+
+// isBackupStoreFile reports whether a path, relative to the store directory, belongs in an
+// archive. Rotation, manual and pre-restore snapshots and migration backups are left out,
+// as are lock and temporary files.
+func isBackupStoreFile(rel string) bool {
+	name := path.Base(rel)
+	if strings.HasSuffix(name, ".lock") || strings.HasSuffix(name, ".tmp") || strings.Contains(name, ".tmp.") ||
+		strings.HasSuffix(name, ".ssbak") {
+		return false
+	}
+	dir, rest, nested := strings.Cut(rel, "/")
+	if !nested || dir != "backups" {
+		return true
+	}
+	// backups/<key>.bak are the per-secret backups; backups/sealed/ the trusted user files
+	sub, _, inSubdir := strings.Cut(rest, "/")
+	return !inSubdir || sub == sealedDirName
+}
+
+// backupStoreFiles returns the store files of configDir, sorted, as slash-separated relative paths
+func backupStoreFiles(configDir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(configDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(configDir, p)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); isBackupStoreFile(rel) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	slices.Sort(files)
+	return files, err
+}
+
+// backupFileFormat returns the versioned format of a top-level store file, if it has one
+func backupFileFormat(rel string) *persistedFormat {
+	for _, format := range persistedFormats {
+		if format.fileName == rel {
+			return format
+		}
+	}
+	return nil
+}
+
+// CreateBackupArchive writes every store file in configDir to an encrypted archive at out.
+// secrets.json and users.json are locked while they are read, so the archive is consistent.
+func CreateBackupArchive(configDir, out string, key BackupKey, createdBy string) (*BackupManifest, error) {
+	header, archiveKey, err := key.sealKey()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"secrets.json", "users.json"} {
+		lock, err := LockFile(filepath.Join(configDir, name))
+		if err != nil {
+			return nil, err
+		}
+		defer lock.Unlock()
+	}
+
+	files, err := backupStoreFiles(configDir)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(files, "master.key") {
+		return nil, fmt.Errorf("no master.key in %s: nothing to back up", configDir)
+	}
+
+	manifest := &BackupManifest{Version: BackupArchiveFormatVersion, CreatedAt: time.Now().UTC(), CreatedBy: createdBy}
+	contents := make(map[string][]byte, len(files))
+	for _, rel := range files {
+		data, err := os.ReadFile(filepath.Join(configDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, err
+		}
+		contents[rel] = data
+		sum := sha256.Sum256(data)
+		file := BackupFile{Path: rel, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+		if format := backupFileFormat(rel); format != nil {
+			if version, err := detectFormatVersion(data); err == nil {
+				file.FormatVersion = &version
+			}
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+	if data, ok := contents["secrets.json"]; ok {
+		if secrets, _, err := decodeSecretsFile(data); err == nil {
+			manifest.Secrets = len(secrets)
+		}
+	}
+
+	payload, err := packBackupPayload(manifest, files, contents)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := sealBackupPayload(header, archiveKey, payload)
+	if err != nil {
+		return nil, err
+	}
+	if dir := filepath.Dir(out); dir != "." {
+		if err := os.MkdirAll(dir, secureDirectoryPermissions); err != nil {
+			return nil, err
+		}
+	}
+	if err := AtomicWriteFile(out, archive, secureFilePermissions); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", out, err)
+	}
+	return manifest, nil
+}
+
+// packBackupPayload tars and gzips the manifest, then each file
+func packBackupPayload(manifest *BackupManifest, files []string, contents map[string][]byte) ([]byte, error) {
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: int64(secureFilePermissions), Size: int64(len(data)), ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add(backupManifestName, manifestData); err != nil {
+		return nil, err
+	}
+	for _, rel := range files {
+		if err := add("store/"+rel, contents[rel]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sealBackupPayload encrypts the payload, binding the header to it
+func sealBackupPayload(header *backupHeader, key, payload []byte) ([]byte, error) {
+	headerLine, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newBackupCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	archive := append(headerLine, '\n')
+	archive = append(archive, nonce...)
+	return gcm.Seal(archive, nonce, payload, headerLine), nil
+}
+
+func newBackupCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// openBackupArchive decrypts an archive and returns its header and files, keyed by
+// relative path, with the manifest under backupManifestName
+func openBackupArchive(archivePath string, key BackupKey) (*backupHeader, map[string][]byte, error) {
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	headerLine, sealed, found := bytes.Cut(data, []byte{'\n'})
+	var header backupHeader
+	if !found || json.Unmarshal(headerLine, &header) != nil || header.Format != backupArchiveFormat {
+		return nil, nil, fmt.Errorf("%s is not a simple-secrets backup archive", archivePath)
+	}
+	if header.Version > BackupArchiveFormatVersion {
+		return nil, nil, fmt.Errorf("%w: archive is format version %d, but this binary supports up to version %d",
+			ErrNewerFormat, header.Version, BackupArchiveFormatVersion)
+	}
+
+	archiveKey, err := key.openKey(&header)
+	if err != nil {
+		return &header, nil, err
+	}
+	gcm, err := newBackupCipher(archiveKey)
+	if err != nil {
+		return &header, nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return &header, nil, ErrBackupKey
+	}
+	payload, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], headerLine)
+	if err != nil {
+		return &header, nil, ErrBackupKey
+	}
+
+	files, err := unpackBackupPayload(payload)
+	return &header, files, err
+}
+
+// unpackBackupPayload reads the tar entries of a decrypted archive
+func unpackBackupPayload(payload []byte) (map[string][]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("archive payload is damaged: %w", err)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("archive payload is damaged: %w", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("archive payload is damaged: %w", err)
+		}
+		name := hdr.Name
+		if name != backupManifestName {
+			rel, ok := strings.CutPrefix(name, "store/")
+			if !ok || !filepath.IsLocal(filepath.FromSlash(rel)) {
+				return nil, fmt.Errorf("archive holds an unexpected entry %q", name)
+			}
+			name = rel
+		}
+		files[name] = data
+	}
+}
+
+// VerifyBackupArchive decrypts an archive and checks every file against the manifest.
+// A key that does not open the archive is an error; problems with its contents are listed.
+func VerifyBackupArchive(archivePath string, key BackupKey) (*BackupVerification, map[string][]byte, error) {
+	header, files, err := openBackupArchive(archivePath, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &BackupVerification{Encryption: header.Encryption}
+
+	manifestData, ok := files[backupManifestName]
+	if !ok {
+		return nil, nil, fmt.Errorf("archive has no %s", backupManifestName)
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("archive %s is invalid: %w", backupManifestName, err)
+	}
+	result.Manifest = &manifest
+	delete(files, backupManifestName)
+
+	if manifest.Version != header.Version {
+		result.Problems = append(result.Problems, fmt.Sprintf("manifest version %d does not match the archive header's %d", manifest.Version, header.Version))
+	}
+	listed := map[string]bool{}
+	for _, file := range manifest.Files {
+		listed[file.Path] = true
+		data, ok := files[file.Path]
+		if !ok {
+			result.Problems = append(result.Problems, fmt.Sprintf("%s is listed in the manifest but missing", file.Path))
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != file.Size || hex.EncodeToString(sum[:]) != file.SHA256 {
+			result.Problems = append(result.Problems, fmt.Sprintf("%s does not match its checksum", file.Path))
+			continue
+		}
+		if format := backupFileFormat(file.Path); format != nil {
+			if err := format.checkReadable(data); err != nil {
+				result.Problems = append(result.Problems, err.Error())
+			}
+		}
+	}
+	for _, rel := range sortedKeys(files) {
+		if !listed[rel] {
+			result.Problems = append(result.Problems, fmt.Sprintf("%s is not listed in the manifest", rel))
+		}
+	}
+	for _, required := range []string{"master.key", "secrets.json"} {
+		if !listed[required] {
+			result.Problems = append(result.Problems, fmt.Sprintf("archive has no %s", required))
+		}
+	}
+	if result.OK() {
+		result.Problems = append(result.Problems, checkArchivedSecrets(files["master.key"], files["secrets.json"])...)
+	}
+	return result, files, nil
+}
+
+// checkArchivedSecrets checks that every archived secret decrypts with the archived master key
+func checkArchivedSecrets(keyData, secretsData []byte) []string {
+	masterKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(keyData)))
+	if err != nil || len(masterKey) != AES256KeySize {
+		return []string{"master.key in the archive is not a valid key"}
+	}
+	secrets, _, err := decodeSecretsFile(secretsData)
+	if err != nil {
+		return []string{fmt.Sprintf("secrets.json in the archive is invalid: %v", err)}
+	}
+	var problems []string
+	for _, key := range sortedKeys(secrets) {
+		if _, err := decrypt(masterKey, secrets[key].Value); err != nil {
+			problems = append(problems, fmt.Sprintf("secret %q does not decrypt with the archived master key", key))
+		}
+	}
+	return problems
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// RestoreBackupArchive replaces the store in configDir with the archive's files. The archive
+// is verified first, and the current store files are copied to backups/pre-restore-<time>/.
+// Store files that the archive does not hold are removed, so the store matches the archive.
+func RestoreBackupArchive(configDir, archivePath string, key BackupKey) (*BackupRestore, error) {
+	verification, files, err := VerifyBackupArchive(archivePath, key)
+	if err != nil {
+		return nil, err
+	}
+	if !verification.OK() {
+		return nil, fmt.Errorf("archive failed verification: %s", strings.Join(verification.Problems, "; "))
+	}
+
+	if err := os.MkdirAll(configDir, secureDirectoryPermissions); err != nil {
+		return nil, err
+	}
+	lock, err := LockFile(filepath.Join(configDir, "secrets.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	current, err := backupStoreFiles(configDir)
+	if err != nil {
+		return nil, err
+	}
+	result := &BackupRestore{Manifest: verification.Manifest}
+	if len(current) > 0 {
+		result.Snapshot = filepath.Join(configDir, "backups", preRestorePrefix+time.Now().Format("20060102-150405"))
+		if err := copyStoreFiles(configDir, result.Snapshot, current); err != nil {
+			return nil, fmt.Errorf("failed to snapshot the current store: %w", err)
+		}
+	}
+
+	for _, file := range verification.Manifest.Files {
+		target := filepath.Join(configDir, filepath.FromSlash(file.Path))
+		if err := os.MkdirAll(filepath.Dir(target), secureDirectoryPermissions); err != nil {
+			return nil, err
+		}
+		if err := AtomicWriteFile(target, files[file.Path], secureFilePermissions); err != nil {
+			return nil, fmt.Errorf("failed to restore %s (the previous store is in %s): %w", file.Path, result.Snapshot, err)
+		}
+	}
+	for _, rel := range current {
+		if _, restored := files[rel]; restored {
+			continue
+		}
+		if err := os.Remove(filepath.Join(configDir, filepath.FromSlash(rel))); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		result.Removed = append(result.Removed, rel)
+	}
+	return result, nil
+}
+
+// copyStoreFiles copies the given store files from configDir to dir, keeping their layout
+func copyStoreFiles(configDir, dir string, files []string) error {
+	for _, rel := range files {
+		data, err := os.ReadFile(filepath.Join(configDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(target), secureDirectoryPermissions); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, data, secureFilePermissions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBackupArchiveRoundTrip(t *testing.T) {
+	service, dir := newRoleTestService(t)
+	if err := service.Secrets().Put("admin-token", "db-password", "before"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	key := BackupKey{Passphrase: "correct horse battery"}
+	archive := filepath.Join(t.TempDir(), "store.ssbak")
+
+	if _, err := service.Backups().Create("bob-token", archive, key); err == nil {
+		t.Fatal("only admins may create backup archives")
+	}
+	manifest, err := service.Backups().Create("admin-token", archive, key)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	var paths []string
+	for _, file := range manifest.Files {
+		paths = append(paths, file.Path)
+	}
+	for _, want := range []string{"master.key", "secrets.json", "users.json", "roles.json"} {
+		if !slices.Contains(paths, want) {
+			t.Errorf("archive should hold %s, got %v", want, paths)
+		}
+	}
+	if manifest.Secrets != 1 || manifest.CreatedBy != "admin" {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+
+	result, _, err := VerifyBackupArchive(archive, key)
+	if err != nil || !result.OK() {
+		t.Fatalf("a fresh archive should verify: %+v (%v)", result, err)
+	}
+	if _, _, err := VerifyBackupArchive(archive, BackupKey{Passphrase: "not the passphrase"}); !errors.Is(err, ErrBackupKey) {
+		t.Fatalf("expected ErrBackupKey for a wrong passphrase, got %v", err)
+	}
+
+	// Change the store, then restore the archive over it
+	if err := service.Secrets().Put("admin-token", "db-password", "after"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err := service.Policies().AddPolicy("admin-token", Policy{Effect: PolicyDeny, Subject: UserSubject("bob"), Actions: []string{ActionRead}, Keys: []string{"db-*"}}); err != nil {
+		t.Fatalf("add policy: %v", err)
+	}
+	restored, err := service.Backups().Restore("admin-token", archive, key)
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if !slices.Contains(restored.Removed, "policies.json") {
+		t.Errorf("policies.json was created after the backup and should be removed: %v", restored.Removed)
+	}
+	if _, err := os.Stat(filepath.Join(restored.Snapshot, "policies.json")); err != nil {
+		t.Errorf("the pre-restore snapshot should hold the replaced store: %v", err)
+	}
+	if value, err := service.Secrets().Get("admin-token", "db-password"); err != nil || value != "before" {
+		t.Fatalf("expected the archived value, got %q (%v)", value, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "policies.json")); !os.IsNotExist(err) {
+		t.Errorf("policies.json should be gone after the restore, got %v", err)
+	}
+}
+
+func TestBackupArchiveRecipient(t *testing.T) {
+	service, _ := newRoleTestService(t)
+	if err := service.Secrets().Put("admin-token", "api-key", "value"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	public, private, err := GenerateBackupKeyPair()
+	if err != nil {
+		t.Fatalf("keygen: %v", err)
+	}
+	archive := filepath.Join(t.TempDir(), "store.ssbak")
+	if _, err := service.Backups().Create("admin-token", archive, BackupKey{Recipient: public}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	if _, _, err := VerifyBackupArchive(archive, BackupKey{}); err == nil {
+		t.Fatal("an archive for a recipient should need the private key")
+	}
+	_, other, _ := GenerateBackupKeyPair()
+	if _, _, err := VerifyBackupArchive(archive, BackupKey{Identity: other}); err == nil || !strings.Contains(err.Error(), "not for the given private key") {
+		t.Fatalf("expected another private key to be refused, got %v", err)
+	}
+	result, _, err := VerifyBackupArchive(archive, BackupKey{Identity: private})
+	if err != nil || !result.OK() || result.Encryption != BackupEncryptionRecipient {
+		t.Fatalf("the private key should open the archive: %+v (%v)", result, err)
+	}
+
+	// Restoring on a machine that was never set up
+	fresh := t.TempDir()
+	if _, err := RestoreBackupArchive(fresh, archive, BackupKey{Identity: private}); err != nil {
+		t.Fatalf("restore into an empty directory: %v", err)
+	}
+	store, err := LoadSecretsStoreFromDir(NewFilesystemBackend(), fresh)
+	if err != nil {
+		t.Fatalf("load restored store: %v", err)
+	}
+	if value, err := store.Get("api-key"); err != nil || value != "value" {
+		t.Fatalf("expected the archived secret, got %q (%v)", value, err)
+	}
+}
+
+func TestBackupArchiveTampering(t *testing.T) {
+	service, _ := newRoleTestService(t)
+	key := BackupKey{Passphrase: "correct horse battery"}
+	archive := filepath.Join(t.TempDir(), "store.ssbak")
+	if _, err := service.Backups().Create("admin-token", archive, key); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	// Flipping a byte of the ciphertext fails authentication
+	damaged := slices.Clone(data)
+	damaged[len(damaged)-1] ^= 0xff
+	if err := os.WriteFile(archive, damaged, 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, _, err := VerifyBackupArchive(archive, key); !errors.Is(err, ErrBackupKey) {
+		t.Fatalf("expected a damaged archive to be refused, got %v", err)
+	}
+
+	// The header is authenticated too, so weakening its derivation is caught
+	edited := strings.Replace(string(data), `"iterations":600000`, `"iterations":600001`, 1)
+	if err := os.WriteFile(archive, []byte(edited), 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, _, err := VerifyBackupArchive(archive, key); err == nil {
+		t.Fatal("an edited header should be refused")
+	}
+
+	if _, _, err := (BackupKey{Passphrase: "short"}).sealKey(); err == nil {
+		t.Error("short passphrases should be rejected")
+	}
+}
+
+func TestIsBackupStoreFile(t *testing.T) {
+	tests := map[string]bool{
+		"secrets.json":                                     true,
+		"audit/audit-20250101-000000-42.log":               true,
+		"backups/db-password.bak":                          true,
+		"backups/sealed/users.json":                        true,
+		"backups/rotate-20250101-000000/a":                 false,
+		"backups/pre-restore-20250101-000000/secrets.json": false,
+		"backups/migrations/users.json.v1-x":               false,
+		"secrets.json.lock":                                false,
+		"users.json.tmp.123.456":                           false,
+		"nightly.ssbak":                                    false,
+	}
+	for rel, want := range tests {
+		if got := isBackupStoreFile(rel); got != want {
+			t.Errorf("isBackupStoreFile(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
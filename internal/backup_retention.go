@@ -0,0 +1,317 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backup directory types, named <type>-<time> under backups/
+const (
+	BackupTypeRotate     = "rotate"
+	BackupTypeManual     = "manual"
+	BackupTypePreRestore = "pre-restore"
+)
+
+var backupTypes = []string{BackupTypeRotate, BackupTypeManual, BackupTypePreRestore}
+
+// Why a backup is kept, as shown by 'backup prune'
+const (
+	keepNewest      = "newest"
+	keepMinAge      = "min-age"
+	keepUnknownTime = "unknown-time"
+	keepByCount     = "rotation_backup_count"
+	keepNoPolicy    = "no-policy"
+)
+
+// BackupRetentionRule keeps the newest backup of each of the last Hourly hours, Daily days,
+// Weekly weeks and Monthly months that have one. Backups younger than MinAge are always kept.
+type BackupRetentionRule struct {
+	Hourly  int    `json:"hourly,omitempty"`
+	Daily   int    `json:"daily,omitempty"`
+	Weekly  int    `json:"weekly,omitempty"`
+	Monthly int    `json:"monthly,omitempty"`
+	MinAge  string `json:"min_age,omitempty"` // e.g. "7d"
+}
+
+// backupRetentionConfig is the part of config.json that sets how long backups are kept
+type backupRetentionConfig struct {
+	RotationBackupCount *int                     `json:"rotation_backup_count,omitempty"`
+	BackupRetention     *backupRetentionSettings `json:"backup_retention,omitempty"`
+}
+
+// backupRetentionSettings is backup_retention: a rule for every type, and rules for
+// single types that replace it
+type backupRetentionSettings struct {
+	BackupRetentionRule
+	Types map[string]BackupRetentionRule `json:"types,omitempty"`
+}
+
+// BackupRetentionPolicy is the parsed retention rule of each backup type
+type BackupRetentionPolicy struct {
+	rules map[string]retentionRule
+}
+
+type retentionRule struct {
+	BackupRetentionRule
+	minAge time.Duration
+}
+
+// parse validates the rule; the field name prefixes errors
+func (r BackupRetentionRule) parse(field string) (retentionRule, error) {
+	rule := retentionRule{BackupRetentionRule: r}
+	for name, count := range map[string]int{"hourly": r.Hourly, "daily": r.Daily, "weekly": r.Weekly, "monthly": r.Monthly} {
+		if count < 0 {
+			return rule, fmt.Errorf("%s.%s: must not be negative, got %d", field, name, count)
+		}
+	}
+	if r.MinAge != "" {
+		minAge, err := ParseLifetime(r.MinAge)
+		if err != nil {
+			return rule, fmt.Errorf("%s.min_age: %w", field, err)
+		}
+		rule.minAge = minAge
+	}
+	return rule, nil
+}
+
+// parse returns the retention policy, or nil when backup_retention is not set
+func (c backupRetentionConfig) parse() (*BackupRetentionPolicy, error) {
+	settings := c.BackupRetention
+	if settings == nil {
+		return nil, nil
+	}
+	defaults, err := settings.BackupRetentionRule.parse("backup_retention")
+	if err != nil {
+		return nil, err
+	}
+	policy := &BackupRetentionPolicy{rules: map[string]retentionRule{}}
+	for _, backupType := range backupTypes {
+		policy.rules[backupType] = defaults
+	}
+	for backupType, rule := range settings.Types {
+		if !slices.Contains(backupTypes, backupType) {
+			return nil, fmt.Errorf("backup_retention.types: unknown backup type %q (use %s)", backupType, strings.Join(backupTypes, ", "))
+		}
+		if policy.rules[backupType], err = rule.parse("backup_retention.types." + backupType); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// keepCount returns rotation_backup_count, or the default
+func (c backupRetentionConfig) keepCount() int {
+	if c.RotationBackupCount != nil && *c.RotationBackupCount > 0 {
+		return *c.RotationBackupCount
+	}
+	return DefaultRotationBackupCount
+}
+
+// loadBackupRetention reads the retention settings from configDir's config.json
+func loadBackupRetention(configDir string) (backupRetentionConfig, *BackupRetentionPolicy, error) {
+	var config backupRetentionConfig
+	data, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if os.IsNotExist(err) {
+		return config, nil, nil
+	}
+	if err != nil {
+		return config, nil, err
+	}
+	if err := configFormat.checkReadable(data); err != nil {
+		return config, nil, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, nil, fmt.Errorf("config.json is corrupted: %w", err)
+	}
+	policy, err := config.parse()
+	if err != nil {
+		return config, nil, fmt.Errorf("config.json %w", err)
+	}
+	return config, policy, nil
+}
+
+// BackupPruneEntry is the decision made for one backup directory
+type BackupPruneEntry struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`              // zero if the name holds no valid time
+	Reasons []string  `json:"reasons,omitempty"` // why it is kept; none if it is removed
+}
+
+// Keep reports whether the backup is kept
+func (e BackupPruneEntry) Keep() bool {
+	return len(e.Reasons) > 0
+}
+
+// BackupPrunePlan lists every backup directory, newest first, with what pruning does to it
+type BackupPrunePlan struct {
+	Policy  string             `json:"policy"` // "backup_retention", or "rotation_backup_count" when it is not set
+	DryRun  bool               `json:"dry_run"`
+	Backups []BackupPruneEntry `json:"backups"`
+}
+
+// Removed returns the backups that pruning removes
+func (p *BackupPrunePlan) Removed() []BackupPruneEntry {
+	var removed []BackupPruneEntry
+	for _, entry := range p.Backups {
+		if !entry.Keep() {
+			removed = append(removed, entry)
+		}
+	}
+	return removed
+}
+
+// scanBackupDirectories returns the rotate-, manual- and pre-restore- directories in backupRoot
+func scanBackupDirectories(backupRoot string) ([]BackupPruneEntry, error) {
+	entries, err := os.ReadDir(backupRoot)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []BackupPruneEntry
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		for _, backupType := range backupTypes {
+			if strings.HasPrefix(entry.Name(), backupType+"-") {
+				backups = append(backups, BackupPruneEntry{Name: entry.Name(), Type: backupType, Time: parseBackupTimestamp(entry.Name())})
+				break
+			}
+		}
+	}
+	// Newest first; names without a time sort last
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return backups, nil
+}
+
+// planBackupPrune decides which backups to keep. Without a policy, the keepCount newest
+// rotate- and manual- backups are kept, as before backup_retention existed, and
+// pre-restore- backups are left alone.
+func planBackupPrune(backups []BackupPruneEntry, policy *BackupRetentionPolicy, keepCount int, now time.Time) *BackupPrunePlan {
+	plan := &BackupPrunePlan{Policy: "backup_retention", Backups: backups}
+	if policy == nil {
+		plan.Policy = "rotation_backup_count"
+		kept := 0
+		for i := range backups {
+			switch {
+			case backups[i].Type == BackupTypePreRestore:
+				backups[i].Reasons = []string{keepNoPolicy}
+			case kept < keepCount:
+				backups[i].Reasons = []string{keepByCount}
+				kept++
+			}
+		}
+		return plan
+	}
+
+	for _, backupType := range backupTypes {
+		var indexes []int
+		for i := range backups {
+			if backups[i].Type == backupType {
+				indexes = append(indexes, i)
+			}
+		}
+		applyRetentionRule(backups, indexes, policy.rules[backupType], now)
+	}
+	return plan
+}
+
+// retentionPeriods are the GFS periods, each with the key of the period a time falls in
+var retentionPeriods = []struct {
+	name  string
+	count func(retentionRule) int
+	key   func(time.Time) string
+}{
+	{"hourly", func(r retentionRule) int { return r.Hourly }, func(t time.Time) string { return t.Format("2006-01-02T15") }},
+	{"daily", func(r retentionRule) int { return r.Daily }, func(t time.Time) string { return t.Format("2006-01-02") }},
+	{"weekly", func(r retentionRule) int { return r.Weekly }, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}},
+	{"monthly", func(r retentionRule) int { return r.Monthly }, func(t time.Time) string { return t.Format("2006-01") }},
+}
+
+// applyRetentionRule marks the backups of one type, given newest first by index, that the rule keeps
+func applyRetentionRule(backups []BackupPruneEntry, indexes []int, rule retentionRule, now time.Time) {
+	for n, i := range indexes {
+		switch {
+		case backups[i].Time.IsZero():
+			backups[i].Reasons = append(backups[i].Reasons, keepUnknownTime)
+			continue
+		case n == 0:
+			backups[i].Reasons = append(backups[i].Reasons, keepNewest)
+		}
+		if now.Sub(backups[i].Time) < rule.minAge {
+			backups[i].Reasons = append(backups[i].Reasons, keepMinAge)
+		}
+	}
+
+	for _, period := range retentionPeriods {
+		count := period.count(rule)
+		seen := map[string]bool{}
+		for _, i := range indexes {
+			if len(seen) >= count || backups[i].Time.IsZero() {
+				break
+			}
+			key := period.key(backups[i].Time.Local())
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			backups[i].Reasons = append(backups[i].Reasons, period.name)
+		}
+	}
+}
+
+// PruneBackups removes the backup directories in configDir/backups that the retention
+// policy in config.json does not keep. With dryRun nothing is removed.
+func PruneBackups(configDir string, dryRun bool, now time.Time) (*BackupPrunePlan, error) {
+	config, policy, err := loadBackupRetention(configDir)
+	if err != nil {
+		return nil, err
+	}
+	return pruneBackupDirectories(filepath.Join(configDir, "backups"), policy, config.keepCount(), dryRun, now)
+}
+
+func pruneBackupDirectories(backupRoot string, policy *BackupRetentionPolicy, keepCount int, dryRun bool, now time.Time) (*BackupPrunePlan, error) {
+	backups, err := scanBackupDirectories(backupRoot)
+	if err != nil {
+		return nil, err
+	}
+	plan := planBackupPrune(backups, policy, keepCount, now)
+	plan.DryRun = dryRun
+	if dryRun {
+		return plan, nil
+	}
+	for _, entry := range plan.Removed() {
+		if err := os.RemoveAll(filepath.Join(backupRoot, entry.Name)); err != nil {
+			return plan, fmt.Errorf("failed to remove old backup %s: %w", entry.Name, err)
+		}
+	}
+	return plan, nil
+}
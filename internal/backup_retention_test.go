@@ -0,0 +1,177 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makeBackupDirs creates <type>-<time> directories for backups taken the given hours before now
+func makeBackupDirs(t *testing.T, root string, now time.Time, backupType string, hoursAgo ...int) []string {
+	t.Helper()
+	var names []string
+	for _, hours := range hoursAgo {
+		name := backupType + "-" + now.Add(-time.Duration(hours)*time.Hour).Format("20060102-150405")
+		if err := os.MkdirAll(filepath.Join(root, name), 0700); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func keptNames(plan *BackupPrunePlan) []string {
+	var kept []string
+	for _, entry := range plan.Backups {
+		if entry.Keep() {
+			kept = append(kept, entry.Name)
+		}
+	}
+	return kept
+}
+
+func TestBackupRetentionPolicy(t *testing.T) {
+	root := t.TempDir()
+	now := time.Date(2025, 6, 15, 12, 30, 0, 0, time.Local)
+	// One backup a day for 90 days, and a second one in the hour before today's
+	var hours []int
+	for day := range 90 {
+		hours = append(hours, day*24+1)
+	}
+	rotate := makeBackupDirs(t, root, now, BackupTypeRotate, append(hours, 2)...)
+
+	policy, err := backupRetentionConfig{BackupRetention: &backupRetentionSettings{
+		BackupRetentionRule: BackupRetentionRule{Hourly: 2, Daily: 7, Weekly: 4, Monthly: 3},
+	}}.parse()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	plan, err := pruneBackupDirectories(root, policy, DefaultRotationBackupCount, true, now)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	kept := keptNames(plan)
+	// 7 days, with both hourly ones on the newest day, and a few older weeks and months
+	if len(kept) < 7 || len(kept) > 7+4+3 {
+		t.Fatalf("unexpected number of kept backups %d: %v", len(kept), kept)
+	}
+	if !slices.Contains(kept, rotate[0]) || !slices.Contains(kept, rotate[len(rotate)-1]) {
+		t.Errorf("both backups of the last two hours should be kept: %v", kept)
+	}
+	if slices.Contains(kept, rotate[89]) {
+		t.Errorf("a backup 89 days old is beyond 3 months of monthly backups: %v", kept)
+	}
+	for _, entry := range plan.Backups {
+		if entry.Name == rotate[0] && !slices.Contains(entry.Reasons, keepNewest) {
+			t.Errorf("the newest backup should be kept as newest: %+v", entry)
+		}
+	}
+
+	// A dry run leaves every directory in place
+	entries, _ := os.ReadDir(root)
+	if len(entries) != len(rotate) {
+		t.Fatalf("dry run removed backups: %d left of %d", len(entries), len(rotate))
+	}
+
+	if _, err := pruneBackupDirectories(root, policy, DefaultRotationBackupCount, false, now); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	entries, _ = os.ReadDir(root)
+	if len(entries) != len(kept) {
+		t.Fatalf("expected %d backups after pruning, got %d", len(kept), len(entries))
+	}
+}
+
+func TestBackupRetentionTypesAndMinAge(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	rotate := makeBackupDirs(t, root, now, BackupTypeRotate, 1, 30, 24*5, 24*10)
+	restore := makeBackupDirs(t, root, now, BackupTypePreRestore, 3, 24*20)
+
+	policy, err := backupRetentionConfig{BackupRetention: &backupRetentionSettings{
+		BackupRetentionRule: BackupRetentionRule{MinAge: "7d"},
+		Types:               map[string]BackupRetentionRule{BackupTypePreRestore: {}},
+	}}.parse()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	plan, err := pruneBackupDirectories(root, policy, DefaultRotationBackupCount, true, now)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	kept := keptNames(plan)
+	want := []string{rotate[0], restore[0], rotate[1], rotate[2]}
+	if !slices.Equal(kept, want) {
+		t.Fatalf("expected rotate backups under 7 days and the newest pre-restore, got %v", kept)
+	}
+	if len(plan.Removed()) != 2 {
+		t.Fatalf("expected 2 removals, got %+v", plan.Removed())
+	}
+}
+
+func TestBackupRetentionWithoutPolicy(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	rotate := makeBackupDirs(t, root, now, BackupTypeRotate, 1, 48)
+	manual := makeBackupDirs(t, root, now, BackupTypeManual, 24, 72)
+	restore := makeBackupDirs(t, root, now, BackupTypePreRestore, 24*400)
+
+	plan, err := pruneBackupDirectories(root, nil, 2, false, now)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if plan.Policy != "rotation_backup_count" {
+		t.Errorf("unexpected policy %q", plan.Policy)
+	}
+	// The newest by time, not by name, and pre-restore backups are left alone
+	want := []string{rotate[0], manual[0], restore[0]}
+	if got := keptNames(plan); !slices.Equal(got, want) {
+		t.Fatalf("expected %v to be kept, got %v", want, got)
+	}
+	for _, name := range []string{rotate[1], manual[1]} {
+		if _, err := os.Stat(filepath.Join(root, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should have been removed", name)
+		}
+	}
+}
+
+func TestBackupRetentionConfigErrors(t *testing.T) {
+	tests := map[string]backupRetentionSettings{
+		"backup_retention.daily: must not be negative": {BackupRetentionRule: BackupRetentionRule{Daily: -1}},
+		"backup_retention.min_age":                     {BackupRetentionRule: BackupRetentionRule{MinAge: "soon"}},
+		"unknown backup type \"nightly\"":              {Types: map[string]BackupRetentionRule{"nightly": {}}},
+		"backup_retention.types.manual.weekly":         {Types: map[string]BackupRetentionRule{BackupTypeManual: {Weekly: -2}}},
+	}
+	for want, settings := range tests {
+		_, err := backupRetentionConfig{BackupRetention: &settings}.parse()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected an error containing %q, got %v", want, err)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"backup_retention": {"monthly": -1}}`), 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := PruneBackups(dir, true, time.Now()); err == nil {
+		t.Fatal("an invalid backup_retention should stop pruning")
+	}
+}
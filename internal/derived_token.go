@@ -0,0 +1,391 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DerivedTokenPrefix starts every derived token value, so it is never mistaken for a user token
+const DerivedTokenPrefix = "ssd_"
+
+// derivedTokenIDPrefix starts the ID of a derived token, as shown by 'token issue'
+const derivedTokenIDPrefix = "dt_"
+
+// MaxDerivedTokenTTL is the longest lifetime a derived token can be issued with
+const MaxDerivedTokenTTL = 24 * time.Hour
+
+// derivedTokenKeyLabel separates the derived token signing key from every other use of the master key
+const derivedTokenKeyLabel = "simple-secrets/derived-tokens/v1"
+
+// revokedTokensFileName lists derived tokens revoked before their expiry
+const revokedTokensFileName = "revoked_tokens.json"
+
+// TokenScope limits a derived token to one action on keys matching a glob pattern
+type TokenScope struct {
+	Action  string
+	Pattern string
+}
+
+// ParseTokenScope parses a scope written as action:pattern, e.g. read:payments-*
+func ParseTokenScope(value string) (TokenScope, error) {
+	action, pattern, ok := strings.Cut(value, ":")
+	if !ok || pattern == "" {
+		return TokenScope{}, fmt.Errorf("invalid scope %q: use action:pattern, e.g. read:payments-*", value)
+	}
+	if err := validatePolicyAction(action); err != nil {
+		return TokenScope{}, fmt.Errorf("invalid scope %q: %w", value, err)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return TokenScope{}, fmt.Errorf("invalid scope %q: %w", value, err)
+	}
+	return TokenScope{Action: action, Pattern: pattern}, nil
+}
+
+// String renders the scope as action:pattern
+func (s TokenScope) String() string {
+	return s.Action + ":" + s.Pattern
+}
+
+// matches reports whether the scope covers the action on the key
+func (s TokenScope) matches(action, key string) bool {
+	if s.Action != action {
+		return false
+	}
+	ok, _ := path.Match(s.Pattern, key)
+	return ok
+}
+
+// DerivedClaims is the signed payload of a derived token
+type DerivedClaims struct {
+	ID        string    `json:"jti"`
+	Issuer    string    `json:"iss"`
+	Scopes    []string  `json:"scp"`
+	IssuedAt  time.Time `json:"iat"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// parseScopes parses the claims' scopes
+func (c *DerivedClaims) parseScopes() ([]TokenScope, error) {
+	scopes := make([]TokenScope, 0, len(c.Scopes))
+	for _, value := range c.Scopes {
+		scope, err := ParseTokenScope(value)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// IsDerivedToken reports whether a token value is a derived token
+func IsDerivedToken(token string) bool {
+	return strings.HasPrefix(token, DerivedTokenPrefix)
+}
+
+// newDerivedTokenID returns a random identifier for a derived token
+func newDerivedTokenID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return derivedTokenIDPrefix + hex.EncodeToString(b), nil
+}
+
+// derivedTokens signs and verifies derived tokens with a key derived from configDir's
+// master key, and keeps the list of revoked ones
+type derivedTokens struct {
+	configDir string
+}
+
+func newDerivedTokens(configDir string) *derivedTokens {
+	return &derivedTokens{configDir: configDir}
+}
+
+// signingKey derives the signing key from the master key. Rotating the master key
+// therefore invalidates every derived token.
+func (d *derivedTokens) signingKey() ([]byte, error) {
+	masterKey, err := readMasterKeyFile(filepath.Join(d.configDir, "master.key"))
+	if err != nil {
+		return nil, fmt.Errorf("load derived token key: %w", err)
+	}
+	return deriveSubkey(masterKey, derivedTokenKeyLabel), nil
+}
+
+// sign encodes the claims and appends their signature
+func (d *derivedTokens) sign(claims *DerivedClaims) (string, error) {
+	key, err := d.signingKey()
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return DerivedTokenPrefix + encoded + "." + signDerivedPayload(key, encoded), nil
+}
+
+// signDerivedPayload computes the signature of an encoded claims payload
+func signDerivedPayload(key []byte, encoded string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parse checks a derived token's signature and returns its claims, expired or not
+func (d *derivedTokens) parse(token string) (*DerivedClaims, error) {
+	invalid := ErrInvalidToken
+	encoded, signature, ok := strings.Cut(strings.TrimPrefix(token, DerivedTokenPrefix), ".")
+	if !ok {
+		return nil, invalid
+	}
+
+	key, err := d.signingKey()
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(signature), []byte(signDerivedPayload(key, encoded))) {
+		return nil, invalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, invalid
+	}
+	var claims DerivedClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.ID == "" || len(claims.Scopes) == 0 {
+		return nil, invalid
+	}
+	return &claims, nil
+}
+
+// verify parses a derived token and rejects it once expired or revoked
+func (d *derivedTokens) verify(token string, now time.Time) (*DerivedClaims, error) {
+	claims, err := d.parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if !now.Before(claims.ExpiresAt) {
+		return nil, fmt.Errorf("%w: derived token %s of user %q expired at %s; issue a new one with 'simple-secrets token issue'",
+			ErrTokenExpired, claims.ID, claims.Issuer, claims.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+	}
+
+	revoked, err := d.loadRevoked()
+	if err != nil {
+		return nil, err
+	}
+	if slices.ContainsFunc(revoked, func(r RevokedToken) bool { return r.ID == claims.ID }) {
+		return nil, fmt.Errorf("invalid token: derived token %s has been revoked", claims.ID)
+	}
+	return claims, nil
+}
+
+// ====================================
+// Derived Token Revocation
+// ====================================
+
+// RevokedToken is a derived token revoked before its expiry. Entries are dropped
+// once the token would have expired anyway.
+type RevokedToken struct {
+	ID        string    `json:"id"`
+	RevokedAt time.Time `json:"revoked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// revokedTokensFile is the on-disk layout of revoked_tokens.json
+type revokedTokensFile struct {
+	Version  int            `json:"version"`
+	Revoked  []RevokedToken `json:"revoked"`
+	Revision uint64         `json:"revision,omitempty"` // sealed write counter, see integrity.go
+	MAC      string         `json:"mac,omitempty"`      // integrity seal, see integrity.go
+}
+
+func (d *derivedTokens) revokedPath() string {
+	return filepath.Join(d.configDir, revokedTokensFileName)
+}
+
+// loadRevoked reads and verifies the revocation list. A missing file means nothing is revoked,
+// unless it was sealed before.
+func (d *derivedTokens) loadRevoked() ([]RevokedToken, error) {
+	var file revokedTokensFile
+	if err := readConfigFile(d.revokedPath(), revokedTokensFormat, &file); err != nil {
+		if os.IsNotExist(err) {
+			return nil, checkMissingSealedFile(d.revokedPath())
+		}
+		return nil, fmt.Errorf("%s is corrupted or invalid: %w", revokedTokensFileName, err)
+	}
+	if err := verifyDocument(d.revokedPath(), &file); err != nil {
+		return nil, err
+	}
+	return file.Revoked, nil
+}
+
+// revoke adds a derived token to the revocation list, pruning entries that have expired
+func (d *derivedTokens) revoke(claims *DerivedClaims, now time.Time) error {
+	lock, err := LockFile(d.revokedPath())
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	revoked, err := d.loadRevoked()
+	if err != nil {
+		return err
+	}
+	revoked = slices.DeleteFunc(revoked, func(r RevokedToken) bool {
+		return !now.Before(r.ExpiresAt) || r.ID == claims.ID
+	})
+	revoked = append(revoked, RevokedToken{ID: claims.ID, RevokedAt: now.UTC(), ExpiresAt: claims.ExpiresAt})
+
+	return writeSealedFile(d.revokedPath(), &revokedTokensFile{Version: RevokedTokensFormatVersion, Revoked: revoked})
+}
+
+// ====================================
+// UserStore Derived Tokens
+// ====================================
+
+// authenticateDerived verifies a derived token and returns a copy of its issuer limited
+// to the token's scopes. The issuer must still exist and hold an active token.
+// Callers must hold the lock.
+func (us *UserStore) authenticateDerived(token string, now time.Time) (*User, tokenMatch, error) {
+	if us.derived == nil {
+		return nil, tokenMatch{}, ErrInvalidToken
+	}
+	claims, err := us.derived.verify(token, now)
+	if err != nil {
+		return nil, tokenMatch{}, err
+	}
+
+	issuer, err := us.userByName(claims.Issuer)
+	if err != nil {
+		return nil, tokenMatch{}, fmt.Errorf("invalid token: the issuer of derived token %s no longer exists", claims.ID)
+	}
+	if len(issuer.tokenHashes()) == 0 {
+		return nil, tokenMatch{}, fmt.Errorf("invalid token: the issuer of derived token %s is disabled", claims.ID)
+	}
+	if err := issuer.checkActive(); err != nil {
+		return nil, tokenMatch{}, fmt.Errorf("derived token %s: %w", claims.ID, err)
+	}
+	scopes, err := claims.parseScopes()
+	if err != nil {
+		return nil, tokenMatch{}, ErrInvalidToken
+	}
+
+	scoped := *issuer
+	scoped.Tokens = nil
+	scoped.Scopes = scopes
+	expiresAt := claims.ExpiresAt
+	return &scoped, tokenMatch{Name: claims.ID, ExpiresAt: &expiresAt, Derived: true}, nil
+}
+
+// IssueDerivedToken signs a token for the user limited to the scopes, expiring after ttl
+// or when the issuing token expires, whichever comes first. Each scope must be within
+// the permissions the user's role grants.
+func (us *UserStore) IssueDerivedToken(issuer *User, issuerExpiry *time.Time, scopes []string, ttl time.Duration) (string, *DerivedClaims, error) {
+	if us.derived == nil {
+		return "", nil, errors.New("derived tokens need a configuration directory")
+	}
+	if issuer.Scopes != nil {
+		return "", nil, fmt.Errorf("%w: a derived token cannot issue further tokens", ErrPermissionDenied)
+	}
+	if ttl <= 0 || ttl > MaxDerivedTokenTTL {
+		return "", nil, fmt.Errorf("invalid ttl %s: derived tokens must expire within 24h", ttl)
+	}
+	if len(scopes) == 0 {
+		return "", nil, errors.New("a derived token needs at least one scope")
+	}
+
+	for _, value := range scopes {
+		scope, err := ParseTokenScope(value)
+		if err != nil {
+			return "", nil, err
+		}
+		permission := actionPermission(scope.Action)
+		if !issuer.Can(permission, us.Permissions()) {
+			return "", nil, fmt.Errorf("cannot issue scope %q: %w", value, NewPermissionDeniedError(permission))
+		}
+	}
+
+	id, err := newDerivedTokenID()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl)
+	if issuerExpiry != nil && issuerExpiry.Before(expiresAt) {
+		expiresAt = issuerExpiry.UTC()
+	}
+
+	claims := &DerivedClaims{
+		ID:        id,
+		Issuer:    issuer.Username,
+		Scopes:    slices.Clone(scopes),
+		IssuedAt:  now,
+		ExpiresAt: expiresAt,
+	}
+	value, err := us.derived.sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return value, claims, nil
+}
+
+// ParseDerivedToken checks a derived token's signature and returns its claims without
+// checking expiry or revocation
+func (us *UserStore) ParseDerivedToken(token string) (*DerivedClaims, error) {
+	if us.derived == nil {
+		return nil, errors.New("invalid token")
+	}
+	return us.derived.parse(token)
+}
+
+// RevokeDerivedToken adds a derived token to the revocation list
+func (us *UserStore) RevokeDerivedToken(claims *DerivedClaims) error {
+	if us.derived == nil {
+		return errors.New("derived tokens need a configuration directory")
+	}
+	return us.derived.revoke(claims, time.Now())
+}
+
+// RevokeDerivedTokenID revokes a derived token by ID. Without the token its expiry is
+// unknown, so the entry is kept for the longest lifetime a derived token can have.
+func (us *UserStore) RevokeDerivedTokenID(id string) error {
+	if !strings.HasPrefix(id, derivedTokenIDPrefix) {
+		return fmt.Errorf("invalid derived token ID %q", id)
+	}
+	now := time.Now()
+	return us.RevokeDerivedToken(&DerivedClaims{ID: id, ExpiresAt: now.Add(MaxDerivedTokenTTL).UTC()})
+}
+
+// IsDerivedTokenID reports whether a value names a derived token rather than a named token
+func IsDerivedTokenID(value string) bool {
+	return strings.HasPrefix(value, derivedTokenIDPrefix)
+}
@@ -0,0 +1,142 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDerivedTokens(t *testing.T) {
+	service, _ := newRoleTestService(t)
+	for key, value := range map[string]string{"payments-db": "pdb", "hr-db": "hdb"} {
+		if err := service.Secrets().Put("admin-token", key, value); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+
+	derived, claims, err := service.Tokens().IssueToken("admin-token", []string{"read:payments-*", "list:payments-*"}, time.Hour)
+	if err != nil {
+		t.Fatalf("issue derived token: %v", err)
+	}
+	if !strings.HasPrefix(derived, DerivedTokenPrefix) || claims.Issuer != "admin" {
+		t.Fatalf("unexpected derived token %q with claims %+v", derived, claims)
+	}
+
+	t.Run("limited_to_scopes", func(t *testing.T) {
+		if value, err := service.Secrets().Get(derived, "payments-db"); err != nil || value != "pdb" {
+			t.Fatalf("derived token should read payments-db, got %q (%v)", value, err)
+		}
+		if _, err := service.Secrets().Get(derived, "hr-db"); !errors.Is(err, ErrAccessDenied) {
+			t.Fatalf("derived token must not read outside its scope, got %v", err)
+		}
+		if err := service.Secrets().Put(derived, "payments-db", "changed"); err == nil {
+			t.Fatal("derived token without a write scope must not write")
+		}
+		keys, err := service.Secrets().List(derived)
+		if err != nil || len(keys) != 1 || keys[0] != "payments-db" {
+			t.Fatalf("expected only payments-db to be listed, got %v (%v)", keys, err)
+		}
+	})
+
+	t.Run("never_exceeds_issuer", func(t *testing.T) {
+		if _, _, err := service.Tokens().IssueToken("bob-token", []string{"write:payments-*"}, time.Hour); err == nil {
+			t.Fatal("a reader must not issue a write scope")
+		}
+		if _, _, err := service.Tokens().IssueToken(derived, []string{"read:payments-*"}, time.Hour); err == nil {
+			t.Fatal("a derived token must not issue further tokens")
+		}
+		if _, err := service.Users().CreateUser(derived, "mallory", "admin"); err == nil {
+			t.Fatal("a derived token of an admin must not manage users")
+		}
+		if _, err := service.Users().RotateToken(derived, "admin"); err == nil {
+			t.Fatal("a derived token must not rotate its issuer's token")
+		}
+		if _, _, err := service.Tokens().IssueToken("admin-token", []string{"read:*"}, 48*time.Hour); err == nil {
+			t.Fatal("ttl beyond 24h should be rejected")
+		}
+	})
+
+	t.Run("revocation", func(t *testing.T) {
+		if _, err := service.Tokens().RevokeToken("bob-token", "", claims.ID); err == nil {
+			t.Fatal("revoking by ID needs rotate-tokens")
+		}
+		if _, err := service.Tokens().RevokeToken("bob-token", "", derived); err == nil {
+			t.Fatal("only the issuer may revoke by value without rotate-tokens")
+		}
+		if id, err := service.Tokens().RevokeToken("admin-token", "", derived); err != nil || id != claims.ID {
+			t.Fatalf("revoke derived token: %q %v", id, err)
+		}
+		if _, err := service.Auth().ValidateToken(derived); err == nil {
+			t.Fatal("revoked derived token must not authenticate")
+		}
+	})
+
+	t.Run("issuer_disabled", func(t *testing.T) {
+		bobDerived, _, err := service.Tokens().IssueToken("bob-token", []string{"read:payments-db"}, time.Hour)
+		if err != nil {
+			t.Fatalf("issue for bob: %v", err)
+		}
+		if err := service.Users().DisableUser("admin-token", "bob", ""); err != nil {
+			t.Fatalf("disable bob: %v", err)
+		}
+		if _, err := service.Auth().ValidateToken(bobDerived); err == nil {
+			t.Fatal("a disabled issuer's derived tokens must stop working")
+		}
+	})
+}
+
+func TestDerivedTokenVerification(t *testing.T) {
+	dir := newSealedInstallation(t)
+	store, err := loadUserStoreFromConfigDir(dir)
+	if err != nil {
+		t.Fatalf("load users: %v", err)
+	}
+	admin, _ := store.FindUser("admin")
+
+	value, _, err := store.IssueDerivedToken(admin, nil, []string{"read:app-*"}, time.Hour)
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+
+	encoded, signature, _ := strings.Cut(strings.TrimPrefix(value, DerivedTokenPrefix), ".")
+	if _, err := store.Lookup(DerivedTokenPrefix + encoded + "." + strings.Repeat("A", len(signature))); err == nil {
+		t.Fatal("a token with a forged signature must not authenticate")
+	}
+
+	user, match, err := store.authenticate(value, time.Now().Add(2*time.Hour))
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected ErrTokenExpired after the ttl, got %v (%v)", user, err)
+	}
+	if match.Derived {
+		t.Fatal("an expired token should not report a match")
+	}
+
+	expiry := time.Now().Add(10 * time.Minute)
+	_, claims, err := store.IssueDerivedToken(admin, &expiry, []string{"read:app-*"}, time.Hour)
+	if err != nil || claims.ExpiresAt.After(expiry) {
+		t.Fatalf("a derived token must not outlive its issuing token, got %+v (%v)", claims, err)
+	}
+
+	if _, err := ParseTokenScope("delete:app-*"); err == nil {
+		t.Fatal("unknown scope actions should be rejected")
+	}
+	if _, err := ParseTokenScope("read"); err == nil {
+		t.Fatal("a scope needs a pattern")
+	}
+}
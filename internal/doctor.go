@@ -0,0 +1,585 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DoctorSeverity ranks how serious a finding is
+type DoctorSeverity string
+
+const (
+	DoctorOK      DoctorSeverity = "ok"
+	DoctorWarning DoctorSeverity = "warning"
+	DoctorError   DoctorSeverity = "error"
+)
+
+// Doctor check names, in the order they are run
+const (
+	CheckPermissions     = "permissions"
+	CheckMasterKey       = "master-key"
+	CheckSecrets         = "secrets"
+	CheckSecretBackups   = "secret-backups"
+	CheckRotationBackups = "rotation-backups"
+	CheckOrphanedFiles   = "orphaned-files"
+	CheckUsers           = "users"
+	CheckIntegrity       = "integrity"
+	CheckConfig          = "config"
+)
+
+// DoctorFinding is a single problem found by a check
+type DoctorFinding struct {
+	Check    string         `json:"check"`
+	Severity DoctorSeverity `json:"severity"`
+	Path     string         `json:"path,omitempty"`
+	Message  string         `json:"message"`
+	Fixable  bool           `json:"fixable,omitempty"`
+	Fixed    bool           `json:"fixed,omitempty"`
+}
+
+// DoctorReport collects the findings of every check
+type DoctorReport struct {
+	ConfigDir string          `json:"config_dir"`
+	Checks    []string        `json:"checks"`
+	Findings  []DoctorFinding `json:"findings"`
+}
+
+// Status returns the most severe outstanding finding; fixed findings no longer count
+func (r *DoctorReport) Status() DoctorSeverity {
+	status := DoctorOK
+	for _, finding := range r.Findings {
+		if finding.Fixed {
+			continue
+		}
+		if finding.Severity == DoctorError {
+			return DoctorError
+		}
+		status = DoctorWarning
+	}
+	return status
+}
+
+// FindingsFor returns the findings reported by one check
+func (r *DoctorReport) FindingsFor(check string) []DoctorFinding {
+	var findings []DoctorFinding
+	for _, finding := range r.Findings {
+		if finding.Check == check {
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+// doctor runs health checks against one configuration directory
+type doctor struct {
+	configDir string
+	fix       bool
+	report    *DoctorReport
+	masterKey []byte
+}
+
+// RunDoctor checks the health of the store in configDir. It reads files directly
+// instead of loading the stores, so it never migrates or rewrites anything itself.
+// With fix, safe repairs (permissions and orphaned temp/lock files) are applied.
+func RunDoctor(configDir string, fix bool) (*DoctorReport, error) {
+	if _, err := os.Stat(configDir); err != nil {
+		return nil, fmt.Errorf("cannot inspect %s: %w", configDir, err)
+	}
+
+	d := &doctor{
+		configDir: configDir,
+		fix:       fix,
+		report:    &DoctorReport{ConfigDir: configDir, Findings: []DoctorFinding{}},
+	}
+
+	checks := []struct {
+		name string
+		run  func()
+	}{
+		{CheckPermissions, d.checkPermissions},
+		{CheckMasterKey, d.checkMasterKey},
+		{CheckSecrets, d.checkSecrets},
+		{CheckSecretBackups, d.checkSecretBackups},
+		{CheckRotationBackups, d.checkRotationBackups},
+		{CheckOrphanedFiles, d.checkOrphanedFiles},
+		{CheckUsers, d.checkUsers},
+		{CheckIntegrity, d.checkIntegrity},
+		{CheckConfig, d.checkConfig},
+	}
+	for _, check := range checks {
+		d.report.Checks = append(d.report.Checks, check.name)
+		check.run()
+	}
+
+	return d.report, nil
+}
+
+func (d *doctor) add(check string, severity DoctorSeverity, path, format string, args ...any) *DoctorFinding {
+	d.report.Findings = append(d.report.Findings, DoctorFinding{
+		Check:    check,
+		Severity: severity,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+	return &d.report.Findings[len(d.report.Findings)-1]
+}
+
+// repair applies a safe fix to a fixable finding when --fix was requested
+func (d *doctor) repair(finding *DoctorFinding, apply func() error) {
+	finding.Fixable = true
+	if !d.fix {
+		return
+	}
+	if err := apply(); err != nil {
+		finding.Message += fmt.Sprintf(" (fix failed: %v)", err)
+		return
+	}
+	finding.Fixed = true
+}
+
+func (d *doctor) path(name string) string {
+	return filepath.Join(d.configDir, name)
+}
+
+// checkPermissions requires 0600 files and 0700 directories throughout the config directory
+func (d *doctor) checkPermissions() {
+	_ = filepath.WalkDir(d.configDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			d.add(CheckPermissions, DoctorError, path, "cannot be inspected: %v", err)
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || info.Mode()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		want := fs.FileMode(secureFilePermissions)
+		if info.IsDir() {
+			want = secureDirectoryPermissions
+		}
+		if info.Mode().Perm()&^want == 0 {
+			return nil
+		}
+
+		finding := d.add(CheckPermissions, DoctorError, path, "permissions are %04o, expected %04o", info.Mode().Perm(), want)
+		d.repair(finding, func() error { return os.Chmod(path, want) })
+		return nil
+	})
+}
+
+// checkMasterKey loads the master key that the decryption checks depend on
+func (d *doctor) checkMasterKey() {
+	path := d.path("master.key")
+	key, err := readMasterKeyFile(path)
+	if os.IsNotExist(err) {
+		if fileExists(d.path("secrets.json")) {
+			d.add(CheckMasterKey, DoctorError, path, "master key is missing but secrets.json exists; restore it with 'simple-secrets restore-database'")
+		}
+		return
+	}
+	if err != nil {
+		d.add(CheckMasterKey, DoctorError, path, "%v; restore it with 'simple-secrets restore-database'", err)
+		return
+	}
+	d.masterKey = key
+}
+
+// readMasterKeyFile reads and validates a base64 AES-256 key file
+func readMasterKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(key) != AES256KeySize {
+		return nil, fmt.Errorf("master key is %d bytes, expected %d", len(key), AES256KeySize)
+	}
+	return key, nil
+}
+
+// checkSecrets verifies secrets.json parses, its disabled entries are well formed
+// and every secret decrypts with the current master key
+func (d *doctor) checkSecrets() {
+	path := d.path("secrets.json")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		d.add(CheckSecrets, DoctorError, path, "cannot be read: %v", err)
+		return
+	}
+
+	d.checkLegacyDisabledEntries(path, data)
+
+	records, outdated, err := decodeSecretsFile(data)
+	if err != nil {
+		d.add(CheckSecrets, DoctorError, path, "cannot be decoded: %v", err)
+		return
+	}
+	if outdated {
+		d.add(CheckSecrets, DoctorWarning, path, "uses an older format; run 'simple-secrets migrate'")
+	}
+
+	for _, key := range sortedRecordKeys(records) {
+		record := records[key]
+		if record.State != SecretStateEnabled && record.State != SecretStateDisabled {
+			d.add(CheckSecrets, DoctorError, path, "secret %q has unknown state %q", key, record.State)
+		}
+		if strings.HasPrefix(key, legacyDisabledPrefix) {
+			d.add(CheckSecrets, DoctorError, path, "secret %q uses the reserved %s prefix and cannot be accessed", key, legacyDisabledPrefix)
+		}
+		if d.masterKey == nil {
+			continue
+		}
+		if _, err := decrypt(d.masterKey, record.Value); err != nil {
+			d.add(CheckSecrets, DoctorError, path, "secret %q does not decrypt with the current master key: %v", key, err)
+		}
+	}
+}
+
+// checkLegacyDisabledEntries reports __DISABLED_ entries of an unmigrated file that cannot be parsed
+func (d *doctor) checkLegacyDisabledEntries(path string, data []byte) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || !isLegacySecretsLayout(fields) {
+		return
+	}
+	for storedKey := range fields {
+		if !strings.HasPrefix(storedKey, legacyDisabledPrefix) {
+			continue
+		}
+		if key, _ := parseLegacyDisabledKey(storedKey); key == "" {
+			d.add(CheckSecrets, DoctorError, path, "disabled entry %q cannot be parsed", storedKey)
+		}
+	}
+}
+
+func sortedRecordKeys(records map[string]secretRecord) []string {
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkSecretBackups verifies every individual .bak file decrypts with the current key
+func (d *doctor) checkSecretBackups() {
+	backupRoot := d.path("backups")
+	if d.masterKey == nil || !fileExists(backupRoot) {
+		return
+	}
+
+	_ = filepath.WalkDir(backupRoot, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(path) != ".bak" {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			d.add(CheckSecretBackups, DoctorWarning, path, "cannot be read: %v", err)
+			return nil
+		}
+		if _, err := decrypt(d.masterKey, string(data)); err != nil {
+			d.add(CheckSecretBackups, DoctorWarning, path, "does not decrypt with the current master key: %v", err)
+		}
+		return nil
+	})
+}
+
+// checkRotationBackups verifies each rotation backup is complete and decrypts with its own key
+func (d *doctor) checkRotationBackups() {
+	backupRoot := d.path("backups")
+	if !fileExists(backupRoot) {
+		return
+	}
+
+	s := &SecretsStore{KeyPath: d.path("master.key"), SecretsPath: d.path("secrets.json"), storage: NewFilesystemBackend()}
+	dirs, err := s.scanRotationBackupDirectories(backupRoot)
+	if err != nil {
+		d.add(CheckRotationBackups, DoctorWarning, backupRoot, "cannot be scanned: %v", err)
+		return
+	}
+
+	for _, dirName := range dirs {
+		backupPath := filepath.Join(backupRoot, dirName)
+		if !s.validateBackupIntegrity(backupPath) {
+			d.add(CheckRotationBackups, DoctorWarning, backupPath, "is missing master.key or secrets.json and cannot be restored")
+			continue
+		}
+		if err := verifyRotationBackup(backupPath); err != nil {
+			d.add(CheckRotationBackups, DoctorWarning, backupPath, "cannot be restored: %v", err)
+		}
+	}
+}
+
+// verifyRotationBackup decrypts every secret in a rotation backup with the key saved alongside it
+func verifyRotationBackup(backupPath string) error {
+	key, err := readMasterKeyFile(filepath.Join(backupPath, "master.key"))
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(backupPath, "secrets.json"))
+	if err != nil {
+		return err
+	}
+	records, _, err := decodeSecretsFile(data)
+	if err != nil {
+		return fmt.Errorf("secrets.json cannot be decoded: %w", err)
+	}
+
+	for _, name := range sortedRecordKeys(records) {
+		if _, err := decrypt(key, records[name].Value); err != nil {
+			return fmt.Errorf("secret %q does not decrypt with the backup's master key: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// checkOrphanedFiles finds temp files left by interrupted atomic writes and lock files nobody holds
+func (d *doctor) checkOrphanedFiles() {
+	_ = filepath.WalkDir(d.configDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+
+		name := entry.Name()
+		if pid, ok := atomicTempFileOwner(name); ok && !processRunning(pid) {
+			finding := d.add(CheckOrphanedFiles, DoctorWarning, path, "temporary file left behind by an interrupted write")
+			d.repair(finding, func() error { return os.Remove(path) })
+			return nil
+		}
+		if strings.HasSuffix(name, ".lock") && !lockFileHeld(path) {
+			finding := d.add(CheckOrphanedFiles, DoctorWarning, path, "lock file is not held by any process")
+			d.repair(finding, func() error { return os.Remove(path) })
+		}
+		return nil
+	})
+}
+
+// atomicTempFileOwner parses the pid out of an AtomicWriteFile temp name (<file>.tmp.<pid>.<nanos>)
+func atomicTempFileOwner(name string) (int, bool) {
+	_, suffix, found := strings.Cut(name, ".tmp.")
+	if !found {
+		return 0, false
+	}
+	pidText, _, found := strings.Cut(suffix, ".")
+	if !found {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(pidText)
+	return pid, err == nil
+}
+
+// processRunning reports whether a process with the given pid exists
+func processRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// lockFileHeld reports whether another process currently holds the lock
+func lockFileHeld(path string) bool {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0600)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return true
+	}
+	_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	return false
+}
+
+// checkUsers reports every users.json and roles.json rule violation, not just the first
+func (d *doctor) checkUsers() {
+	usersPath := d.path("users.json")
+	if !fileExists(usersPath) {
+		d.add(CheckUsers, DoctorWarning, usersPath, "does not exist; run 'simple-secrets setup'")
+		return
+	}
+
+	var file usersFile
+	if err := readConfigFile(usersPath, usersFormat, &file); err != nil {
+		d.add(CheckUsers, DoctorError, usersPath, "cannot be decoded: %v", err)
+		return
+	}
+	if data, err := os.ReadFile(usersPath); err == nil && usersFormat.isOutdated(data) {
+		d.add(CheckUsers, DoctorWarning, usersPath, "uses an older format; run 'simple-secrets migrate'")
+	}
+
+	rolesPath := d.path("roles.json")
+	roles, err := loadRoles(rolesPath)
+	if err != nil {
+		d.add(CheckUsers, DoctorError, rolesPath, "cannot be decoded: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, user := range file.Users {
+		if user == nil || strings.TrimSpace(user.Username) == "" {
+			d.add(CheckUsers, DoctorError, usersPath, "user #%d has no username", i+1)
+			continue
+		}
+		if seen[user.Username] {
+			d.add(CheckUsers, DoctorError, usersPath, "duplicate username %q", user.Username)
+		}
+		seen[user.Username] = true
+
+		if roles != nil {
+			if _, ok := roles[user.Role]; !ok {
+				d.add(CheckUsers, DoctorError, usersPath, "user %q has role %q, which is not defined in roles.json", user.Username, user.Role)
+			}
+		}
+	}
+
+	d.checkGroups(file.Users, roles)
+	if err := ensureAdminExists(file.Users); err != nil {
+		d.add(CheckUsers, DoctorError, usersPath, "%v", err)
+	}
+}
+
+// checkGroups reports groups.json entries naming unknown users or roles, and applies
+// the groups to the users so admins through a group are counted
+func (d *doctor) checkGroups(users []*User, roles RolePermissions) {
+	groupsPath := d.path(groupsFileName)
+	var file groupsFile
+	if err := readConfigFile(groupsPath, groupsFormat, &file); err != nil {
+		if !os.IsNotExist(err) {
+			d.add(CheckUsers, DoctorError, groupsPath, "cannot be decoded: %v", err)
+		}
+		return
+	}
+
+	for _, group := range file.Groups {
+		for _, member := range group.Members {
+			if !slices.ContainsFunc(users, func(u *User) bool { return u != nil && u.Username == member }) {
+				d.add(CheckUsers, DoctorWarning, groupsPath, "group %q lists user %q, who does not exist", group.Name, member)
+			}
+		}
+		for _, role := range group.Roles {
+			if _, ok := roles[role]; roles != nil && !ok {
+				d.add(CheckUsers, DoctorError, groupsPath, "group %q has role %q, which is not defined in roles.json", group.Name, role)
+			}
+		}
+	}
+	applyGroups(slices.DeleteFunc(slices.Clone(users), func(u *User) bool { return u == nil }), file.Groups)
+}
+
+// checkIntegrity verifies the seals of users.json, roles.json, policies.json and the other sealed files
+func (d *doctor) checkIntegrity() {
+	for _, name := range sealedFileNames {
+		path := d.path(name)
+		doc, format := newSealedDocument(name)
+		if err := readConfigFile(path, format, doc); err != nil {
+			// A missing policies.json is normal unless it was sealed before; user file errors are reported by the users check
+			if os.IsNotExist(err) {
+				if err := checkMissingSealedFile(path); err != nil {
+					d.add(CheckIntegrity, DoctorError, path, "%v", err)
+				}
+			}
+			continue
+		}
+		if *doc.macField() == "" {
+			if err := checkUnsealedDocument(path, doc); err != nil {
+				d.add(CheckIntegrity, DoctorError, path, "%v", err)
+			} else {
+				d.add(CheckIntegrity, DoctorWarning, path, "has no integrity seal; run 'simple-secrets migrate'")
+			}
+			continue
+		}
+		if d.masterKey == nil {
+			d.add(CheckIntegrity, DoctorError, path, "seal cannot be verified without a valid master key")
+			continue
+		}
+		if _, err := checkDocumentSeal(deriveIntegrityKey(d.masterKey), name, sealedContents(path, doc)); err != nil {
+			d.add(CheckIntegrity, DoctorError, path, "%v", err)
+		} else if err := checkLatestRevision(path, doc); err != nil {
+			d.add(CheckIntegrity, DoctorError, path, "%v", err)
+		}
+	}
+}
+
+// checkConfig validates the optional config.json
+func (d *doctor) checkConfig() {
+	path := d.path("config.json")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		d.add(CheckConfig, DoctorError, path, "cannot be read: %v", err)
+		return
+	}
+
+	if err := configFormat.checkReadable(data); err != nil {
+		d.add(CheckConfig, DoctorError, path, "%v", err)
+		return
+	}
+
+	var config struct {
+		Token               *string `json:"token"`
+		RotationBackupCount *int    `json:"rotation_backup_count"`
+		tokenLifetimeConfig
+		auditConfig
+		backupRetentionConfig
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		d.add(CheckConfig, DoctorError, path, "is not valid: %v", err)
+		return
+	}
+
+	if configFormat.isOutdated(data) {
+		d.add(CheckConfig, DoctorWarning, path, "uses an older format; run 'simple-secrets migrate'")
+	}
+	if config.RotationBackupCount != nil && *config.RotationBackupCount <= 0 {
+		d.add(CheckConfig, DoctorError, path, "rotation_backup_count must be a positive integer, got %d", *config.RotationBackupCount)
+	}
+	if _, err := config.tokenLifetimeConfig.parse(); err != nil {
+		d.add(CheckConfig, DoctorError, path, "%v", err)
+	}
+	if _, err := config.auditConfig.parse(); err != nil {
+		d.add(CheckConfig, DoctorError, path, "%v", err)
+	}
+	if _, err := config.backupRetentionConfig.parse(); err != nil {
+		d.add(CheckConfig, DoctorError, path, "%v", err)
+	}
+	if config.Token != nil && strings.TrimSpace(*config.Token) == "" {
+		d.add(CheckConfig, DoctorWarning, path, "token is set but empty")
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
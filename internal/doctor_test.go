@@ -0,0 +1,230 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newDoctorStore creates a healthy store with one secret, a .bak and users
+func newDoctorStore(t *testing.T) (*SecretsStore, string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	t.Setenv("SIMPLE_SECRETS_CONFIG_DIR", dir)
+
+	store, err := LoadSecretsStoreFromDir(NewFilesystemBackend(), dir)
+	if err != nil {
+		t.Fatalf("load store: %v", err)
+	}
+	if err := store.Put("api-key", "one"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := store.Put("api-key", "two"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	users := []*User{{Username: "admin", TokenHash: HashToken("token"), Role: RoleAdmin}}
+	roles := RolePermissions{RoleAdmin: {"read", "write"}, RoleReader: {"read"}}
+	if err := writeConfigFiles(filepath.Join(dir, "users.json"), filepath.Join(dir, "roles.json"), users, roles); err != nil {
+		t.Fatalf("write users: %v", err)
+	}
+	return store, dir
+}
+
+func findingMessages(report *DoctorReport, check string) string {
+	var messages []string
+	for _, finding := range report.FindingsFor(check) {
+		messages = append(messages, fmt.Sprintf("[%s fixed=%v] %s", finding.Severity, finding.Fixed, finding.Message))
+	}
+	return strings.Join(messages, "\n")
+}
+
+func TestRunDoctor_HealthyStore(t *testing.T) {
+	_, dir := newDoctorStore(t)
+
+	report, err := RunDoctor(dir, false)
+	if err != nil {
+		t.Fatalf("RunDoctor: %v", err)
+	}
+	if report.Status() != DoctorOK {
+		t.Fatalf("expected a healthy store, got %+v", report.Findings)
+	}
+}
+
+func TestRunDoctor_DetectsUndecryptableData(t *testing.T) {
+	store, dir := newDoctorStore(t)
+
+	// A .bak and a secret that were encrypted with some other key
+	otherKey := make([]byte, AES256KeySize)
+	foreign, err := encrypt(otherKey, []byte("foreign"))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if err := os.WriteFile(store.GetBackupPath("api-key"), []byte(foreign), 0600); err != nil {
+		t.Fatalf("write .bak: %v", err)
+	}
+	store.mu.Lock()
+	store.secrets["stale"] = secretRecord{Value: foreign, State: SecretStateEnabled}
+	err = store.saveSecretsLocked()
+	store.mu.Unlock()
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// A rotation backup whose key does not match its secrets
+	rotation := filepath.Join(dir, "backups", "rotate-20250101-000000")
+	if err := store.backupCurrent(rotation); err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	// And one that is missing its key entirely
+	if err := os.MkdirAll(filepath.Join(dir, "backups", "manual-20250102-000000"), 0700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	report, err := RunDoctor(dir, false)
+	if err != nil {
+		t.Fatalf("RunDoctor: %v", err)
+	}
+
+	if got := findingMessages(report, CheckSecrets); !strings.Contains(got, `secret "stale" does not decrypt`) {
+		t.Errorf("expected undecryptable secret to be reported, got:\n%s", got)
+	}
+	if got := findingMessages(report, CheckSecretBackups); !strings.Contains(got, "does not decrypt") {
+		t.Errorf("expected undecryptable .bak to be reported, got:\n%s", got)
+	}
+	rotationFindings := findingMessages(report, CheckRotationBackups)
+	if !strings.Contains(rotationFindings, `secret "stale" does not decrypt`) || !strings.Contains(rotationFindings, "missing master.key") {
+		t.Errorf("expected both rotation backups to be reported, got:\n%s", rotationFindings)
+	}
+	if report.Status() != DoctorError {
+		t.Errorf("expected error status, got %s", report.Status())
+	}
+}
+
+func TestRunDoctor_UserAndConfigRules(t *testing.T) {
+	_, dir := newDoctorStore(t)
+
+	users := `{"version": 1, "users": [
+		{"username": "bob", "token_hash": "x", "role": "reader"},
+		{"username": "bob", "token_hash": "y", "role": "auditor"}
+	]}`
+	if err := os.WriteFile(filepath.Join(dir, "users.json"), []byte(users), 0600); err != nil {
+		t.Fatalf("write users: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"version": 1, "rotation_backup_count": 0}`), 0600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	report, err := RunDoctor(dir, false)
+	if err != nil {
+		t.Fatalf("RunDoctor: %v", err)
+	}
+
+	got := findingMessages(report, CheckUsers)
+	for _, want := range []string{`duplicate username "bob"`, `role "auditor"`, "no admin user"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected users finding %q, got:\n%s", want, got)
+		}
+	}
+	if got := findingMessages(report, CheckConfig); !strings.Contains(got, "rotation_backup_count must be a positive integer") {
+		t.Errorf("expected config finding, got:\n%s", got)
+	}
+}
+
+func TestRunDoctor_LegacyDisabledEntries(t *testing.T) {
+	_, dir := newDoctorStore(t)
+
+	legacy := `{"__DISABLED_nounderscore": "x"}`
+	if err := os.WriteFile(filepath.Join(dir, "secrets.json"), []byte(legacy), 0600); err != nil {
+		t.Fatalf("write secrets: %v", err)
+	}
+
+	report, err := RunDoctor(dir, false)
+	if err != nil {
+		t.Fatalf("RunDoctor: %v", err)
+	}
+	if got := findingMessages(report, CheckSecrets); !strings.Contains(got, "cannot be parsed") {
+		t.Errorf("expected unparseable disabled entry to be reported, got:\n%s", got)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "secrets.json")); string(data) != legacy {
+		t.Errorf("doctor must not migrate or rewrite secrets.json: %s", data)
+	}
+}
+
+func TestRunDoctor_FixesPermissionsAndOrphans(t *testing.T) {
+	_, dir := newDoctorStore(t)
+
+	secretsPath := filepath.Join(dir, "secrets.json")
+	if err := os.Chmod(secretsPath, 0644); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "backups"), 0755); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	orphanTmp := secretsPath + ".tmp.999999999.1"
+	orphanLock := filepath.Join(dir, "users.json.lock")
+	for _, path := range []string{orphanTmp, orphanLock} {
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+
+	// A lock that is held right now must be left alone
+	held, err := LockFile(filepath.Join(dir, "roles.json"))
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	defer held.Unlock()
+
+	report, err := RunDoctor(dir, false)
+	if err != nil {
+		t.Fatalf("RunDoctor: %v", err)
+	}
+	if len(report.FindingsFor(CheckPermissions)) != 2 || len(report.FindingsFor(CheckOrphanedFiles)) != 2 {
+		t.Fatalf("expected 2 permission and 2 orphan findings, got %+v", report.Findings)
+	}
+	if _, err := os.Stat(orphanTmp); err != nil {
+		t.Fatalf("doctor without --fix must not remove files: %v", err)
+	}
+
+	report, err = RunDoctor(dir, true)
+	if err != nil {
+		t.Fatalf("RunDoctor --fix: %v", err)
+	}
+	if report.Status() != DoctorOK {
+		t.Fatalf("expected every finding to be fixed, got %+v", report.Findings)
+	}
+
+	info, _ := os.Stat(secretsPath)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("secrets.json permissions not fixed: %04o", info.Mode().Perm())
+	}
+	for _, path := range []string{orphanTmp, orphanLock} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("orphan %s not removed", path)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "roles.json.lock")); err != nil {
+		t.Errorf("held lock must not be removed: %v", err)
+	}
+}
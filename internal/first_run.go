@@ -21,7 +21,6 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
-	"time"
 )
 
 // ErrFirstRunRequired indicates that first-run setup is needed
@@ -152,13 +151,8 @@ func generateDefaultAdmin() (string, *User, error) {
 		return "", nil, fmt.Errorf("generate token: %w", err)
 	}
 
-	now := time.Now()
-	user := &User{
-		Username:       "admin",
-		TokenHash:      HashToken(token),
-		Role:           RoleAdmin,
-		TokenRotatedAt: &now,
-	}
+	user := &User{Username: "admin", Role: RoleAdmin, Status: StatusActive}
+	user.SetToken(token)
 
 	return token, user, nil
 }
@@ -166,8 +160,8 @@ func generateDefaultAdmin() (string, *User, error) {
 // createDefaultRoles returns the default role permissions structure
 func createDefaultRoles() RolePermissions {
 	return RolePermissions{
-		RoleAdmin:  {"read", "write", "rotate-tokens", "manage-users", "rotate-own-token"},
-		RoleReader: {"read", "rotate-own-token"},
+		RoleAdmin:  {PermRead, PermWrite, PermRotateTokens, PermManageUsers, PermRotateOwnToken},
+		RoleReader: {PermRead, PermRotateOwnToken},
 	}
 }
 
@@ -209,8 +203,25 @@ func createDefaultUserFileWithToken(usersPath, rolesPath string) (*UserStore, st
 	}
 
 	defaultRoles := createDefaultRoles()
+	codes, hashes, err := newRecoveryCodeSet()
+	if err != nil {
+		return nil, "", err
+	}
 
-	if err := writeConfigFiles(usersPath, rolesPath, []*User{user}, defaultRoles); err != nil {
+	// The recovery codes are written under the same lock as the users, and setup fails
+	// as a whole if they cannot be, like SetupPlan.Apply
+	if err := ensureConfigDirectory(usersPath); err != nil {
+		return nil, "", err
+	}
+	lock, err := LockFile(usersPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to acquire users lock: %w", err)
+	}
+	defer lock.Unlock()
+	if err := checkSetupEligible(usersPath); err != nil {
+		return nil, "", err
+	}
+	if err := writeInstallation(usersPath, rolesPath, []*User{user}, defaultRoles, hashes); err != nil {
 		return nil, "", err
 	}
 
@@ -236,14 +247,22 @@ func createDefaultUserFileWithToken(usersPath, rolesPath string) (*UserStore, st
 	}
 
 	store := createUserStore(users, permissions)
+	store.setupCodes = codes
 	return store, token, nil
 }
 
+// SetupRecoveryCodes returns the recovery codes first-run setup created along with the
+// store, to be shown once; a store loaded from an existing installation has none
+func (us *UserStore) SetupRecoveryCodes() []string {
+	return us.setupCodes
+}
+
 // createDefaultConfigFile creates a minimal config.json with sensible defaults
 // Documentation and examples are available via 'simple-secrets help config'
 func createDefaultConfigFile() error {
 	configContent := `{
   "_comment": "For complete configuration documentation and examples, run: simple-secrets config",
+  "version": 1,
 
   "rotation_backup_count": 1
 }`
@@ -0,0 +1,331 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// groupsFileName holds the groups, next to users.json
+const groupsFileName = "groups.json"
+
+// Group gives its members its roles and the policies written for it.
+// A user's own role still applies; group roles only add to it.
+type Group struct {
+	Name    string   `json:"name"`
+	Roles   []Role   `json:"roles,omitempty"`
+	Members []string `json:"members,omitempty"`
+}
+
+// groupsFile is the on-disk layout of groups.json
+type groupsFile struct {
+	Version  int      `json:"version"`
+	Groups   []*Group `json:"groups"`
+	Revision uint64   `json:"revision,omitempty"` // sealed write counter, see integrity.go
+	MAC      string   `json:"mac,omitempty"`      // integrity seal, see integrity.go
+}
+
+// ValidateGroupName checks a new group name, which follows the rules for role names
+func ValidateGroupName(name string) error {
+	if !roleNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid group name %q: use lowercase letters, digits, '-' and '_', starting with a letter", name)
+	}
+	return nil
+}
+
+// loadGroups reads and verifies groups.json. A missing file means no groups, unless it was
+// sealed before.
+func loadGroups(path string) ([]*Group, error) {
+	var file groupsFile
+	if err := readConfigFile(path, groupsFormat, &file); err != nil {
+		if os.IsNotExist(err) {
+			return nil, checkMissingSealedFile(path)
+		}
+		if errors.Is(err, ErrNewerFormat) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s is corrupted or invalid: %w", groupsFileName, err)
+	}
+	if err := verifyDocument(path, &file); err != nil {
+		return nil, err
+	}
+	return file.Groups, nil
+}
+
+// saveGroups writes groups to groups.json in the current format and seals it
+func saveGroups(path string, groups []*Group) error {
+	if groups == nil {
+		groups = []*Group{}
+	}
+	return writeSealedFile(path, &groupsFile{Version: GroupsFormatVersion, Groups: groups})
+}
+
+// groupsPathFor returns the groups.json next to a users.json
+func groupsPathFor(usersPath string) string {
+	return filepath.Join(filepath.Dir(usersPath), groupsFileName)
+}
+
+// applyGroups sets every user's groups and the roles inherited from them
+func applyGroups(users []*User, groups []*Group) {
+	for _, u := range users {
+		u.Groups, u.GroupRoles = nil, nil
+		for _, g := range groups {
+			if !slices.Contains(g.Members, u.Username) {
+				continue
+			}
+			u.Groups = append(u.Groups, g.Name)
+			for _, role := range g.Roles {
+				if role != u.Role && !slices.Contains(u.GroupRoles, role) {
+					u.GroupRoles = append(u.GroupRoles, role)
+				}
+			}
+		}
+	}
+}
+
+// countAdmins returns the number of users who would be admins with the given groups
+func countAdmins(users []*User, groups []*Group) int {
+	count := 0
+	for _, u := range users {
+		if u.Role == RoleAdmin || slices.ContainsFunc(groups, func(g *Group) bool {
+			return slices.Contains(g.Members, u.Username) && slices.Contains(g.Roles, RoleAdmin)
+		}) {
+			count++
+		}
+	}
+	return count
+}
+
+// cloneGroups deep-copies groups so a change can be checked before it is kept
+func cloneGroups(groups []*Group) []*Group {
+	clone := make([]*Group, len(groups))
+	for i, g := range groups {
+		clone[i] = &Group{Name: g.Name, Roles: slices.Clone(g.Roles), Members: slices.Clone(g.Members)}
+	}
+	return clone
+}
+
+// ====================================
+// User Group Membership
+// ====================================
+
+// HasRole reports whether the user has the role, directly or through a group
+func (u *User) HasRole(role Role) bool {
+	return u.Role == role || slices.Contains(u.GroupRoles, role)
+}
+
+// IsAdmin reports whether the user has the admin role, directly or through a group
+func (u *User) IsAdmin() bool {
+	return u.HasRole(RoleAdmin)
+}
+
+// EffectiveRoles returns the user's own role followed by the roles of their groups
+func (u *User) EffectiveRoles() []Role {
+	return append([]Role{u.Role}, u.GroupRoles...)
+}
+
+// EffectivePermissions returns the permissions the user holds through any of their roles
+func (u *User) EffectivePermissions(perms RolePermissions) []string {
+	var effective []string
+	for _, info := range PermissionCatalog {
+		if slices.ContainsFunc(u.EffectiveRoles(), func(r Role) bool { return perms.Has(r, info.Name) }) {
+			effective = append(effective, info.Name)
+		}
+	}
+	return effective
+}
+
+// grantingRole returns the first of the user's roles holding the permission
+func (u *User) grantingRole(perm string, perms RolePermissions) Role {
+	for _, role := range u.EffectiveRoles() {
+		if perms.Has(role, perm) {
+			return role
+		}
+	}
+	return u.Role
+}
+
+// describeRoles names the user's roles for policy decisions
+func (u *User) describeRoles() string {
+	if len(u.GroupRoles) == 0 {
+		return fmt.Sprintf("role %q", u.Role)
+	}
+	return "roles " + quoteAll(u.EffectiveRoles())
+}
+
+// describeSubjects names the user, their groups and their roles for policy decisions
+func (u *User) describeSubjects() string {
+	if len(u.Groups) == 0 {
+		return fmt.Sprintf("user %q or %s", u.Username, u.describeRoles())
+	}
+	return fmt.Sprintf("user %q, groups %s or %s", u.Username, quoteAll(u.Groups), u.describeRoles())
+}
+
+// quoteAll quotes and joins names for display
+func quoteAll[T ~string](names []T) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// ====================================
+// UserStore Groups
+// ====================================
+
+// withGroups sets the store's groups and applies them to its users
+func (us *UserStore) withGroups(groups []*Group) *UserStore {
+	us.groups = groups
+	applyGroups(us.users, groups)
+	return us
+}
+
+// Groups returns a copy of the groups
+func (us *UserStore) Groups() []*Group {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+	return cloneGroups(us.groups)
+}
+
+// CreateGroup adds an empty group with the given roles
+func (us *UserStore) CreateGroup(name string, roles []string) error {
+	if err := ValidateGroupName(name); err != nil {
+		return err
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if us.groupIndex(name) >= 0 {
+		return fmt.Errorf("group %q already exists", name)
+	}
+	group := &Group{Name: name}
+	for _, role := range roles {
+		resolved, err := us.permissions.resolveRole(role)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(group.Roles, resolved) {
+			group.Roles = append(group.Roles, resolved)
+		}
+	}
+	us.groups = append(us.groups, group)
+	return nil
+}
+
+// DeleteGroup removes a group, unless its members include the only admins
+func (us *UserStore) DeleteGroup(name string) error {
+	lastAdmin := fmt.Sprintf("cannot delete group %q: it makes the last admin user an admin", name)
+	return us.changeGroups(name, lastAdmin, func(groups []*Group, i int) ([]*Group, error) {
+		return slices.Delete(groups, i, i+1), nil
+	})
+}
+
+// AddGroupMember adds a user to a group
+func (us *UserStore) AddGroupMember(name, username string) error {
+	return us.changeGroups(name, "", func(groups []*Group, i int) ([]*Group, error) {
+		if _, err := us.userByName(username); err != nil {
+			return nil, err
+		}
+		if slices.Contains(groups[i].Members, username) {
+			return nil, fmt.Errorf("user %q is already a member of group %q", username, name)
+		}
+		groups[i].Members = append(groups[i].Members, username)
+		return groups, nil
+	})
+}
+
+// RemoveGroupMember removes a user from a group, unless the group makes them the last admin
+func (us *UserStore) RemoveGroupMember(name, username string) error {
+	lastAdmin := fmt.Sprintf("cannot remove %q from group %q: it makes them the last admin user", username, name)
+	return us.changeGroups(name, lastAdmin, func(groups []*Group, i int) ([]*Group, error) {
+		index := slices.Index(groups[i].Members, username)
+		if index < 0 {
+			return nil, fmt.Errorf("user %q is not a member of group %q", username, name)
+		}
+		groups[i].Members = slices.Delete(groups[i].Members, index, index+1)
+		return groups, nil
+	})
+}
+
+// changeGroups applies a change to a copy of the named group's list and keeps it
+// only if an admin is left, failing with lastAdmin otherwise
+func (us *UserStore) changeGroups(name, lastAdmin string, change func(groups []*Group, i int) ([]*Group, error)) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	i := us.groupIndex(name)
+	if i < 0 {
+		return fmt.Errorf("group %q not found", name)
+	}
+	groups, err := change(cloneGroups(us.groups), i)
+	if err != nil {
+		return err
+	}
+	if countAdmins(us.users, groups) == 0 && countAdmins(us.users, us.groups) > 0 {
+		return errors.New(lastAdmin)
+	}
+	us.groups = groups
+	applyGroups(us.users, us.groups)
+	return nil
+}
+
+// GroupGrantsPrivilege reports whether the named group grants a role holding a
+// privileged permission, see RolePermissions.grantsPrivilege
+func (us *UserStore) GroupGrantsPrivilege(name string) bool {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+	i := us.groupIndex(name)
+	return i >= 0 && us.permissions.grantsPrivilege(us.groups[i].Roles...)
+}
+
+// groupIndex returns the index of the named group, or -1. Callers must hold the lock.
+func (us *UserStore) groupIndex(name string) int {
+	return slices.IndexFunc(us.groups, func(g *Group) bool { return g.Name == name })
+}
+
+// removeFromGroups drops a deleted user from every group. Callers must hold the lock.
+func (us *UserStore) removeFromGroups(username string) {
+	for _, g := range us.groups {
+		g.Members = slices.DeleteFunc(g.Members, func(m string) bool { return m == username })
+	}
+}
+
+// renameInGroups updates group memberships of a renamed user. Callers must hold the lock.
+func (us *UserStore) renameInGroups(oldName, newName string) {
+	for _, g := range us.groups {
+		if i := slices.Index(g.Members, oldName); i >= 0 {
+			g.Members[i] = newName
+		}
+	}
+}
+
+// groupsWithRole returns the groups granting a role. Callers must hold the lock.
+func (us *UserStore) groupsWithRole(role Role) []string {
+	var names []string
+	for _, g := range us.groups {
+		if slices.Contains(g.Roles, role) {
+			names = append(names, g.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,103 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestGroupsGrantRolesAndPolicies(t *testing.T) {
+	service, dir := newRoleTestService(t)
+	if err := service.Roles().CreateRole("admin-token", "ci-writer", []string{PermRead, PermWrite}); err != nil {
+		t.Fatalf("create role: %v", err)
+	}
+	if err := service.Groups().CreateGroup("admin-token", "payments", []string{"ci-writer"}); err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+	if err := service.Groups().AddMember("admin-token", "payments", "bob"); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	if err := service.Groups().AddMember("admin-token", "payments", "bob"); err == nil {
+		t.Fatal("adding a member twice should fail")
+	}
+	if _, err := service.Policies().AddPolicy("admin-token", Policy{Effect: PolicyAllow, Subject: GroupSubject("payments"), Actions: []string{ActionRead, ActionWrite}, Keys: []string{"payments-*"}}); err != nil {
+		t.Fatalf("add policy: %v", err)
+	}
+
+	if err := service.Secrets().Put("bob-token", "payments-db", "v1"); err != nil {
+		t.Fatalf("bob should write through the group's role and policy: %v", err)
+	}
+	if err := service.Secrets().Put("bob-token", "hr-db", "v1"); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("the group's allow policy should scope bob to payments-*, got %v", err)
+	}
+
+	store, err := loadUserStoreFromConfigDir(dir)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	bob, _ := store.FindUser("bob")
+	if !slices.Equal(bob.Groups, []string{"payments"}) || !slices.Equal(bob.EffectivePermissions(store.Permissions()), []string{PermRead, PermWrite, PermRotateOwnToken}) {
+		t.Fatalf("groups should persist, got groups %v and permissions %v", bob.Groups, bob.EffectivePermissions(store.Permissions()))
+	}
+	if err := service.Roles().DeleteRole("admin-token", "ci-writer"); err == nil {
+		t.Fatal("a role a group grants must not be deleted")
+	}
+
+	if err := service.Users().RenameUser("admin-token", "bob", "robert"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	groups, _ := service.Groups().ListGroups("admin-token")
+	if !slices.Equal(groups[0].Members, []string{"robert"}) {
+		t.Fatalf("the rename should follow into the group, got %v", groups[0].Members)
+	}
+	if err := service.Groups().RemoveMember("admin-token", "payments", "robert"); err != nil {
+		t.Fatalf("remove member: %v", err)
+	}
+	if err := service.Secrets().Put("bob-token", "payments-db", "v2"); err == nil {
+		t.Fatal("a removed member should lose the group's role")
+	}
+}
+
+func TestGroupAdminsCountForLastAdminProtection(t *testing.T) {
+	service, _ := newRoleTestService(t)
+	if err := service.Groups().CreateGroup("admin-token", "ops", []string{string(RoleAdmin)}); err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+	if err := service.Groups().AddMember("admin-token", "ops", "bob"); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+
+	// bob is an admin through ops, so the only direct admin may be demoted and deleted
+	reader := string(RoleReader)
+	if err := service.Users().UpdateUser("admin-token", "admin", UserUpdate{Role: &reader}); err != nil {
+		t.Fatalf("demoting admin should be allowed while bob is an admin through ops: %v", err)
+	}
+	if err := service.Users().DeleteUser("bob-token", "admin"); err != nil {
+		t.Fatalf("deleting admin should be allowed while bob is an admin through ops: %v", err)
+	}
+
+	if err := service.Groups().RemoveMember("bob-token", "ops", "bob"); err == nil {
+		t.Fatal("removing the last admin from their admin group must fail")
+	}
+	if err := service.Groups().DeleteGroup("bob-token", "ops"); err == nil {
+		t.Fatal("deleting the group granting the last admin must fail")
+	}
+	if err := service.Users().DeleteUser("bob-token", "bob"); err == nil {
+		t.Fatal("deleting the last admin through a group must fail")
+	}
+}
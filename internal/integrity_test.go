@@ -0,0 +1,294 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSealedInstallation writes sealed user files with an admin and a reader
+func newSealedInstallation(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("SIMPLE_SECRETS_CONFIG_DIR", dir)
+
+	users := []*User{
+		{Username: "admin", TokenHash: HashToken("admin-token"), Role: RoleAdmin},
+		{Username: "bob", TokenHash: HashToken("bob-token"), Role: RoleReader},
+	}
+	if err := writeConfigFiles(filepath.Join(dir, "users.json"), filepath.Join(dir, "roles.json"), users, createDefaultRoles()); err != nil {
+		t.Fatalf("write config files: %v", err)
+	}
+	return dir
+}
+
+// editFile applies a manual edit, as someone with write access to the directory could
+func editFile(t *testing.T, path, old, new string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if !strings.Contains(string(data), old) {
+		t.Fatalf("%s does not contain %q: %s", path, old, data)
+	}
+	if err := os.WriteFile(path, []byte(strings.Replace(string(data), old, new, 1)), 0600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestUserFilesAreSealed(t *testing.T) {
+	dir := newSealedInstallation(t)
+
+	if _, err := loadUsers(filepath.Join(dir, "users.json")); err != nil {
+		t.Fatalf("sealed users.json should load: %v", err)
+	}
+	if _, err := loadRoles(filepath.Join(dir, "roles.json")); err != nil {
+		t.Fatalf("sealed roles.json should load: %v", err)
+	}
+
+	t.Run("role_escalation_in_users_json", func(t *testing.T) {
+		dir := newSealedInstallation(t)
+		editFile(t, filepath.Join(dir, "users.json"), `"role": "reader"`, `"role": "admin"`)
+
+		if _, err := loadUsers(filepath.Join(dir, "users.json")); !errors.Is(err, ErrTampered) {
+			t.Fatalf("expected ErrTampered, got %v", err)
+		}
+		if _, err := LoadUsersForAuth(); !errors.Is(err, ErrTampered) {
+			t.Fatalf("authentication must be refused, got %v", err)
+		}
+	})
+
+	t.Run("permission_grant_in_roles_json", func(t *testing.T) {
+		dir := newSealedInstallation(t)
+		editFile(t, filepath.Join(dir, "roles.json"), `"read",`, `"read", "write",`)
+
+		if _, err := loadRoles(filepath.Join(dir, "roles.json")); !errors.Is(err, ErrTampered) {
+			t.Fatalf("expected ErrTampered, got %v", err)
+		}
+	})
+
+	t.Run("seal_copied_between_files", func(t *testing.T) {
+		dir := newSealedInstallation(t)
+		var users usersFile
+		if err := readConfigFile(filepath.Join(dir, "users.json"), usersFormat, &users); err != nil {
+			t.Fatalf("read users: %v", err)
+		}
+		var roles rolesFile
+		if err := readConfigFile(filepath.Join(dir, "roles.json"), rolesFormat, &roles); err != nil {
+			t.Fatalf("read roles: %v", err)
+		}
+		editFile(t, filepath.Join(dir, "roles.json"), roles.MAC, users.MAC)
+
+		if _, err := loadRoles(filepath.Join(dir, "roles.json")); !errors.Is(err, ErrTampered) {
+			t.Fatalf("expected ErrTampered, got %v", err)
+		}
+	})
+
+	t.Run("seal_removed", func(t *testing.T) {
+		dir := newSealedInstallation(t)
+		usersPath := filepath.Join(dir, "users.json")
+		var users usersFile
+		if err := readConfigFile(usersPath, usersFormat, &users); err != nil {
+			t.Fatalf("read users: %v", err)
+		}
+		users.MAC = ""
+		users.Users[1].Role = RoleAdmin
+		if err := writeConfigFileSecurely(usersPath, &users); err != nil {
+			t.Fatalf("write users: %v", err)
+		}
+
+		if _, err := loadUsers(usersPath); !errors.Is(err, ErrTampered) {
+			t.Fatalf("expected ErrTampered for a file whose seal was removed, got %v", err)
+		}
+	})
+
+	t.Run("older_sealed_version_put_back", func(t *testing.T) {
+		dir := newSealedInstallation(t)
+		revokedPath := filepath.Join(dir, revokedTokensFileName)
+		if err := writeSealedFile(revokedPath, &revokedTokensFile{Version: RevokedTokensFormatVersion}); err != nil {
+			t.Fatalf("write revoked tokens: %v", err)
+		}
+		before, err := os.ReadFile(revokedPath)
+		if err != nil {
+			t.Fatalf("read revoked tokens: %v", err)
+		}
+		revoked := []RevokedToken{{ID: "d1", ExpiresAt: time.Now().Add(time.Hour)}}
+		if err := writeSealedFile(revokedPath, &revokedTokensFile{Version: RevokedTokensFormatVersion, Revoked: revoked}); err != nil {
+			t.Fatalf("write revoked tokens: %v", err)
+		}
+		if err := os.WriteFile(revokedPath, before, 0600); err != nil {
+			t.Fatalf("put back revoked tokens: %v", err)
+		}
+
+		var file revokedTokensFile
+		if err := readConfigFile(revokedPath, revokedTokensFormat, &file); err != nil {
+			t.Fatalf("read revoked tokens: %v", err)
+		}
+		if err := verifyDocument(revokedPath, &file); !errors.Is(err, ErrTampered) || !strings.Contains(err.Error(), "earlier copy") {
+			t.Fatalf("an older sealed copy must not revive a revoked token, got %v", err)
+		}
+	})
+
+	t.Run("sealed_file_removed", func(t *testing.T) {
+		dir := newSealedInstallation(t)
+		policiesPath := filepath.Join(dir, "policies.json")
+		deny := Policy{ID: "p1", Effect: PolicyDeny, Subject: UserSubject("bob"), Actions: []string{ActionRead}, Keys: []string{"*"}}
+		if err := savePolicies(policiesPath, []Policy{deny}); err != nil {
+			t.Fatalf("save policies: %v", err)
+		}
+		if err := os.Remove(policiesPath); err != nil {
+			t.Fatalf("remove policies: %v", err)
+		}
+
+		if _, err := loadPolicies(policiesPath); !errors.Is(err, ErrTampered) {
+			t.Fatalf("expected ErrTampered for a removed policies.json, got %v", err)
+		}
+		// An admin can accept the removal
+		if err := ResealUserFiles(dir, "admin-token"); err != nil {
+			t.Fatalf("reseal: %v", err)
+		}
+		if policies, err := loadPolicies(policiesPath); err != nil || len(policies) != 0 {
+			t.Fatalf("expected no policies after the reseal, got %v (%v)", policies, err)
+		}
+	})
+}
+
+func TestUnsealedFilesStillLoad(t *testing.T) {
+	dir := t.TempDir()
+	usersPath := filepath.Join(dir, "users.json")
+	if err := os.WriteFile(usersPath, []byte(`{"version": 1, "users": [{"username": "admin", "token_hash": "x", "role": "admin"}]}`), 0600); err != nil {
+		t.Fatalf("write users: %v", err)
+	}
+
+	if _, err := loadUsers(usersPath); err != nil {
+		t.Fatalf("files written before sealing existed should load: %v", err)
+	}
+
+	// The current format was always written sealed
+	rolesPath := filepath.Join(dir, "roles.json")
+	if err := os.WriteFile(rolesPath, []byte(`{"version": 1, "roles": {"admin": ["read"]}}`), 0600); err != nil {
+		t.Fatalf("write roles: %v", err)
+	}
+	if _, err := loadRoles(rolesPath); !errors.Is(err, ErrTampered) {
+		t.Fatalf("expected ErrTampered for an unsealed file in the current format, got %v", err)
+	}
+
+	// So was every file once the installation was sealed
+	if err := writeSealedCopy(dir, "roles.json", &rolesFile{}); err != nil {
+		t.Fatalf("write sealed copy: %v", err)
+	}
+	if _, err := loadUsers(usersPath); !errors.Is(err, ErrTampered) {
+		t.Fatalf("expected ErrTampered for an unsealed file in a sealed installation, got %v", err)
+	}
+}
+
+func TestResealUserFiles(t *testing.T) {
+	t.Run("admin_accepts_manual_edit", func(t *testing.T) {
+		dir := newSealedInstallation(t)
+		editFile(t, filepath.Join(dir, "users.json"), `"username": "bob"`, `"username": "robert"`)
+
+		if err := ResealUserFiles(dir, "admin-token"); err != nil {
+			t.Fatalf("reseal: %v", err)
+		}
+		users, err := loadUsers(filepath.Join(dir, "users.json"))
+		if err != nil {
+			t.Fatalf("resealed users.json should load: %v", err)
+		}
+		if users[1].Username != "robert" {
+			t.Errorf("edit was not kept: %+v", users[1])
+		}
+	})
+
+	t.Run("edit_cannot_authorize_itself", func(t *testing.T) {
+		dir := newSealedInstallation(t)
+		editFile(t, filepath.Join(dir, "users.json"), `"role": "reader"`, `"role": "admin"`)
+
+		if err := ResealUserFiles(dir, "bob-token"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+			t.Fatalf("a reader who edited their own role must not be able to reseal, got %v", err)
+		}
+		if _, err := loadUsers(filepath.Join(dir, "users.json")); !errors.Is(err, ErrTampered) {
+			t.Fatalf("users.json must stay untrusted, got %v", err)
+		}
+	})
+
+	t.Run("requires_admin", func(t *testing.T) {
+		dir := t.TempDir()
+		t.Setenv("SIMPLE_SECRETS_CONFIG_DIR", dir)
+		users := []*User{
+			{Username: "admin", TokenHash: HashToken("admin-token"), Role: RoleAdmin},
+			{Username: "carol", TokenHash: HashToken("carol-token"), Role: "user-admin"},
+		}
+		roles := createDefaultRoles()
+		roles["user-admin"] = []string{PermRead, PermManageUsers}
+		if err := writeConfigFiles(filepath.Join(dir, "users.json"), filepath.Join(dir, "roles.json"), users, roles); err != nil {
+			t.Fatalf("write config files: %v", err)
+		}
+
+		if err := ResealUserFiles(dir, "carol-token"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+			t.Fatalf("manage-users without admin must not reseal, got %v", err)
+		}
+	})
+
+	t.Run("tampered_sealed_copy_is_refused", func(t *testing.T) {
+		dir := newSealedInstallation(t)
+		editFile(t, sealedCopyPath(dir, "users.json"), `"role": "reader"`, `"role": "admin"`)
+
+		if err := ResealUserFiles(dir, "bob-token"); !errors.Is(err, ErrTampered) {
+			t.Fatalf("expected ErrTampered, got %v", err)
+		}
+	})
+}
+
+func TestSealsFollowMasterKeyChanges(t *testing.T) {
+	dir := newSealedInstallation(t)
+	store, err := LoadSecretsStoreFromDir(NewFilesystemBackend(), dir)
+	if err != nil {
+		t.Fatalf("load store: %v", err)
+	}
+	if err := store.Put("k", "v"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	assertLoads := func(step string) {
+		t.Helper()
+		if _, err := loadUsers(filepath.Join(dir, "users.json")); err != nil {
+			t.Fatalf("users.json after %s: %v", step, err)
+		}
+		if _, err := loadRoles(filepath.Join(dir, "roles.json")); err != nil {
+			t.Fatalf("roles.json after %s: %v", step, err)
+		}
+		if err := ResealUserFiles(dir, "admin-token"); err != nil {
+			t.Fatalf("sealed copies after %s: %v", step, err)
+		}
+	}
+
+	backupDir := filepath.Join(dir, "backups", "rotate-20250101-000000")
+	if err := store.RotateMasterKey(backupDir); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	assertLoads("rotation")
+
+	if err := store.RestoreFromBackup("rotate-20250101-000000"); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	assertLoads("restore")
+}
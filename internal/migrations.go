@@ -0,0 +1,490 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Current on-disk format versions. Files without a "version" field are version 0.
+// Bump a version only together with a migration step from the previous one.
+const (
+	SecretsFormatVersion       = 1
+	UsersFormatVersion         = 2
+	RolesFormatVersion         = 1
+	ConfigFormatVersion        = 1
+	PoliciesFormatVersion      = 1
+	TokenUsageFormatVersion    = 1
+	RevokedTokensFormatVersion = 1
+	GroupsFormatVersion        = 1
+	ApprovalsFormatVersion     = 1
+	AuthFailuresFormatVersion  = 1
+	RecoveryCodesFormatVersion = 1
+	AuditHeadFormatVersion     = 1
+	SecretUsageFormatVersion   = 1
+)
+
+// ErrNewerFormat indicates a file was written by a newer simple-secrets than this binary
+var ErrNewerFormat = errors.New("file uses a newer format than this version of simple-secrets supports")
+
+// migrationStep upgrades a file from one format version to the next
+type migrationStep struct {
+	description string
+	apply       func(ctx *migrationContext, data []byte) ([]byte, error)
+}
+
+// persistedFormat describes a versioned file and the steps that upgrade it
+type persistedFormat struct {
+	fileName       string
+	currentVersion int
+	steps          map[int]migrationStep // keyed by the version the step upgrades from
+}
+
+// migrationContext lets a step persist data it cannot carry forward in the file itself.
+// A nil context means the upgrade is in memory only and must have no side effects.
+type migrationContext struct {
+	backupDir string
+}
+
+// saveBackup writes an auxiliary backup file; it is a no-op for in-memory upgrades
+func (ctx *migrationContext) saveBackup(name string, data []byte) error {
+	if ctx == nil {
+		return nil
+	}
+	if err := os.MkdirAll(ctx.backupDir, secureDirectoryPermissions); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(ctx.backupDir, name), data, secureFilePermissions)
+}
+
+var (
+	secretsFormat = &persistedFormat{
+		fileName:       "secrets.json",
+		currentVersion: SecretsFormatVersion,
+		steps: map[int]migrationStep{
+			0: {description: "store disabled state per secret and add a format version", apply: migrateSecretsToV1},
+		},
+	}
+	usersFormat = &persistedFormat{
+		fileName:       "users.json",
+		currentVersion: UsersFormatVersion,
+		steps: map[int]migrationStep{
+			0: {description: "wrap the user list in a versioned document", apply: wrapInVersionedDocument("users")},
+			1: {description: "record each user's account status; users without tokens become disabled", apply: migrateUsersToV2},
+		},
+	}
+	rolesFormat = &persistedFormat{
+		fileName:       "roles.json",
+		currentVersion: RolesFormatVersion,
+		steps: map[int]migrationStep{
+			0: {description: "wrap role permissions in a versioned document", apply: wrapInVersionedDocument("roles")},
+		},
+	}
+	configFormat = &persistedFormat{
+		fileName:       "config.json",
+		currentVersion: ConfigFormatVersion,
+		steps: map[int]migrationStep{
+			0: {description: "add a format version", apply: addVersionField},
+		},
+	}
+	policiesFormat = &persistedFormat{
+		fileName:       "policies.json",
+		currentVersion: PoliciesFormatVersion,
+	}
+	tokenUsageFormat = &persistedFormat{
+		fileName:       tokenUsageFileName,
+		currentVersion: TokenUsageFormatVersion,
+	}
+	revokedTokensFormat = &persistedFormat{
+		fileName:       revokedTokensFileName,
+		currentVersion: RevokedTokensFormatVersion,
+	}
+	groupsFormat = &persistedFormat{
+		fileName:       groupsFileName,
+		currentVersion: GroupsFormatVersion,
+	}
+	approvalsFormat = &persistedFormat{
+		fileName:       approvalsFileName,
+		currentVersion: ApprovalsFormatVersion,
+	}
+	authFailuresFormat = &persistedFormat{
+		fileName:       authFailuresFileName,
+		currentVersion: AuthFailuresFormatVersion,
+	}
+	recoveryCodesFormat = &persistedFormat{
+		fileName:       recoveryCodesFileName,
+		currentVersion: RecoveryCodesFormatVersion,
+	}
+	auditHeadFormat = &persistedFormat{
+		fileName:       auditHeadFileName,
+		currentVersion: AuditHeadFormatVersion,
+	}
+	secretUsageFormat = &persistedFormat{
+		fileName:       secretUsageFileName,
+		currentVersion: SecretUsageFormatVersion,
+	}
+
+	// persistedFormats is the migration registry, in the order files are migrated
+	persistedFormats = []*persistedFormat{secretsFormat, usersFormat, rolesFormat, configFormat, policiesFormat, tokenUsageFormat, revokedTokensFormat, groupsFormat, approvalsFormat, authFailuresFormat, recoveryCodesFormat, auditHeadFormat, secretUsageFormat}
+)
+
+// FileMigration describes the migration of one file, planned or applied
+type FileMigration struct {
+	File        string   `json:"file"`
+	FromVersion int      `json:"from_version"`
+	ToVersion   int      `json:"to_version"`
+	Steps       []string `json:"steps,omitempty"`
+	Backups     []string `json:"backups,omitempty"`
+}
+
+// UpToDate reports whether the file needed no migration
+func (m FileMigration) UpToDate() bool {
+	return m.FromVersion == m.ToVersion
+}
+
+// MigrateConfigDir upgrades every versioned file in configDir to the current format.
+// A backup of the file is written before each step. With dryRun, every step is
+// still run in memory to validate it, but nothing is written.
+func MigrateConfigDir(configDir string, dryRun bool) ([]FileMigration, error) {
+	// Files of an installation that was never sealed are sealed as they are migrated.
+	// Sealing the first one creates the sealed copies, so this is decided up front.
+	neverSealed := !installationSealed(configDir)
+
+	var results []FileMigration
+	for _, format := range persistedFormats {
+		path := filepath.Join(configDir, format.fileName)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		result, err := migrateFileLocked(format, path, filepath.Join(configDir, "backups"), dryRun, neverSealed)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+// migrateFileLocked migrates a single file while holding its lock
+func migrateFileLocked(format *persistedFormat, path, backupDir string, dryRun, neverSealed bool) (*FileMigration, error) {
+	lock, err := LockFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock for %s: %w", format.fileName, err)
+	}
+	defer lock.Unlock()
+
+	sealed, unsealed, err := checkSealBeforeMigration(format, path, neverSealed)
+	if err != nil {
+		return nil, err
+	}
+	result, err := migrateFile(format, path, backupDir, dryRun)
+	if err != nil || dryRun || !unsealed && (!sealed || result.UpToDate()) {
+		return result, err
+	}
+	return result, resealMigratedFile(path)
+}
+
+// checkSealBeforeMigration reports whether a sealed file carries a seal, or has none and
+// is to be sealed. A file whose seal does not match is refused: the migrated file is
+// sealed again, which would otherwise accept an edit made outside simple-secrets. An
+// unsealed file is accepted only from an installation that was never sealed.
+func checkSealBeforeMigration(format *persistedFormat, path string, neverSealed bool) (sealed, unsealed bool, err error) {
+	if !slices.Contains(sealedFileNames, format.fileName) {
+		return false, false, nil
+	}
+	doc, _ := newSealedDocument(format.fileName)
+	if err := readConfigFile(path, format, doc); err != nil {
+		return false, false, nil // unreadable files are reported by migrateFile
+	}
+	if *doc.macField() == "" {
+		if !neverSealed {
+			return false, false, fmt.Errorf("refusing to migrate %s: %w: it has no integrity seal, but the installation was sealed before",
+				format.fileName, ErrTampered)
+		}
+		return false, true, nil
+	}
+	if err := verifyDocument(path, doc); err != nil {
+		return false, false, fmt.Errorf("refusing to migrate %s: %w", format.fileName, err)
+	}
+	return true, false, nil
+}
+
+// resealMigratedFile seals a migrated file, whose old seal covered its previous contents
+func resealMigratedFile(path string) error {
+	doc, format := newSealedDocument(filepath.Base(path))
+	if err := readConfigFile(path, format, doc); err != nil {
+		return fmt.Errorf("failed to read migrated %s: %w", format.fileName, err)
+	}
+	if err := writeSealedFile(path, doc); err != nil {
+		return fmt.Errorf("failed to seal migrated %s: %w", format.fileName, err)
+	}
+	return nil
+}
+
+// migrateFile applies each pending step to the file on disk, backing up the
+// previous contents before every step. Callers must hold the file's lock.
+func migrateFile(format *persistedFormat, path, backupDir string, dryRun bool) (*FileMigration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", format.fileName, err)
+	}
+
+	version, err := format.readableVersion(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &FileMigration{File: format.fileName, FromVersion: version, ToVersion: format.currentVersion}
+	timestamp := time.Now().Format("20060102-150405")
+
+	for ; version < format.currentVersion; version++ {
+		step, err := format.stepFrom(version)
+		if err != nil {
+			return nil, err
+		}
+		result.Steps = append(result.Steps, fmt.Sprintf("v%d -> v%d: %s", version, version+1, step.description))
+
+		if dryRun {
+			if data, err = step.apply(nil, data); err != nil {
+				return nil, fmt.Errorf("%s: migration from version %d failed: %w", format.fileName, version, err)
+			}
+			continue
+		}
+
+		backupPath := filepath.Join(backupDir, "migrations", fmt.Sprintf("%s.v%d-%s", format.fileName, version, timestamp))
+		if err := writeMigrationBackup(backupPath, data); err != nil {
+			return nil, fmt.Errorf("failed to back up %s before migration: %w", format.fileName, err)
+		}
+		result.Backups = append(result.Backups, backupPath)
+
+		if data, err = step.apply(&migrationContext{backupDir: backupDir}, data); err != nil {
+			return nil, fmt.Errorf("%s: migration from version %d failed: %w", format.fileName, version, err)
+		}
+		if err := AtomicWriteFile(path, data, secureFilePermissions); err != nil {
+			return nil, fmt.Errorf("failed to write migrated %s: %w", format.fileName, err)
+		}
+	}
+
+	return result, nil
+}
+
+func writeMigrationBackup(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), secureDirectoryPermissions); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, secureFilePermissions)
+}
+
+// upgrade runs every pending step in memory and returns data in the current format
+func (f *persistedFormat) upgrade(data []byte) ([]byte, error) {
+	version, err := f.readableVersion(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for ; version < f.currentVersion; version++ {
+		step, err := f.stepFrom(version)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = step.apply(nil, data); err != nil {
+			return nil, fmt.Errorf("%s: upgrade from version %d failed: %w", f.fileName, version, err)
+		}
+	}
+	return data, nil
+}
+
+// isOutdated reports whether data predates the current format version
+func (f *persistedFormat) isOutdated(data []byte) bool {
+	version, err := detectFormatVersion(data)
+	return err == nil && version < f.currentVersion
+}
+
+// readableVersion returns the file's version, refusing formats newer than this binary
+func (f *persistedFormat) readableVersion(data []byte) (int, error) {
+	version, err := detectFormatVersion(data)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not valid JSON: %w", f.fileName, err)
+	}
+	if err := f.checkNotNewer(version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// checkReadable rejects data in a newer format; unparseable data is left to the caller
+func (f *persistedFormat) checkReadable(data []byte) error {
+	version, err := detectFormatVersion(data)
+	if err != nil {
+		return nil
+	}
+	return f.checkNotNewer(version)
+}
+
+// checkNotNewer returns ErrNewerFormat when version is beyond what this binary understands
+func (f *persistedFormat) checkNotNewer(version int) error {
+	if version <= f.currentVersion {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is format version %d, but this binary supports up to version %d; "+
+		"upgrade simple-secrets to open this installation", ErrNewerFormat, f.fileName, version, f.currentVersion)
+}
+
+func (f *persistedFormat) stepFrom(version int) (migrationStep, error) {
+	step, ok := f.steps[version]
+	if !ok {
+		return migrationStep{}, fmt.Errorf("%s: no migration registered from format version %d", f.fileName, version)
+	}
+	return step, nil
+}
+
+// detectFormatVersion reads the top-level "version" field. Top-level arrays and
+// objects without a numeric version predate versioning and are version 0.
+func detectFormatVersion(data []byte) (int, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		return 0, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &fields); err != nil {
+		return 0, err
+	}
+
+	raw, ok := fields["version"]
+	if !ok {
+		return 0, nil
+	}
+
+	// A legacy secrets file may hold a secret named "version"; its value is a string
+	var version int
+	if err := json.Unmarshal(raw, &version); err != nil {
+		return 0, nil
+	}
+	if version < 0 {
+		return 0, fmt.Errorf("invalid format version %d", version)
+	}
+	return version, nil
+}
+
+// ====================================
+// Migration Steps
+// ====================================
+
+// migrateSecretsToV1 converts both pre-versioning layouts of secrets.json: the flat
+// key -> ciphertext map (with __DISABLED_ keys) and the unversioned records document.
+func migrateSecretsToV1(ctx *migrationContext, data []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	if !isLegacySecretsLayout(fields) {
+		var file secretsFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+		return marshalSecretsFile(file.Secrets)
+	}
+
+	records, displaced, err := convertLegacySecrets(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep values displaced by key collisions as that key's individual backup
+	for key, value := range displaced {
+		if err := ctx.saveBackup(key+".bak", []byte(value)); err != nil {
+			return nil, fmt.Errorf("failed to back up displaced value of %q: %w", key, err)
+		}
+	}
+
+	return marshalSecretsFile(records)
+}
+
+// migrateUsersToV2 records an explicit status for every user. Before it, a user was
+// disabled only by having no token left; other user fields are kept as they are.
+func migrateUsersToV2(_ *migrationContext, data []byte) ([]byte, error) {
+	var file map[string]json.RawMessage
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	var users []map[string]json.RawMessage
+	if err := json.Unmarshal(file["users"], &users); err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		if _, ok := user["status"]; ok {
+			continue
+		}
+		status := StatusActive
+		if !hasField(user, "token_hash") && !hasField(user, "tokens") {
+			status = StatusDisabled
+		}
+		encoded, err := json.Marshal(status)
+		if err != nil {
+			return nil, err
+		}
+		user["status"] = encoded
+	}
+
+	encoded, err := json.Marshal(users)
+	if err != nil {
+		return nil, err
+	}
+	file["users"] = encoded
+	file["version"] = json.RawMessage("2")
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// hasField reports whether a JSON object holds a non-empty value for the field
+func hasField(object map[string]json.RawMessage, field string) bool {
+	switch string(bytes.TrimSpace(object[field])) {
+	case "", "null", `""`, "[]":
+		return false
+	}
+	return true
+}
+
+// wrapInVersionedDocument moves a bare top-level value under field in a versioned document
+func wrapInVersionedDocument(field string) func(*migrationContext, []byte) ([]byte, error) {
+	return func(_ *migrationContext, data []byte) ([]byte, error) {
+		var value json.RawMessage
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(map[string]any{"version": 1, field: value}, "", "  ")
+	}
+}
+
+// addVersionField stamps an object with version 1, keeping every other field
+func addVersionField(_ *migrationContext, data []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	fields["version"] = json.RawMessage("1")
+	return json.MarshalIndent(fields, "", "  ")
+}
@@ -0,0 +1,215 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeUnversionedInstallation writes the pre-versioning layout of every persisted file
+func writeUnversionedInstallation(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{
+		"secrets.json": `{"api-key": "ciphertext", "__DISABLED_{\"timestamp\":1,\"key\":\"old\"}": "other"}`,
+		"users.json":   `[{"username": "admin", "token_hash": "hash", "role": "admin"}]`,
+		"roles.json":   `{"admin": ["read", "write"], "reader": ["read"]}`,
+		"config.json":  `{"_comment": "keep me", "rotation_backup_count": 2}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	return files
+}
+
+func TestMigrateConfigDir_DryRunWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	original := writeUnversionedInstallation(t, dir)
+
+	results, err := MigrateConfigDir(dir, true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected a plan for 4 files, got %+v", results)
+	}
+	for _, result := range results {
+		if result.FromVersion != 0 || result.ToVersion < 1 || len(result.Steps) != result.ToVersion {
+			t.Errorf("unexpected plan for %s: %+v", result.File, result)
+		}
+		if len(result.Backups) != 0 {
+			t.Errorf("dry run should not write backups: %+v", result)
+		}
+	}
+
+	for name, content := range original {
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		if string(data) != content {
+			t.Errorf("dry run modified %s: %s", name, data)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "backups")); !os.IsNotExist(err) {
+		t.Errorf("dry run should not create a backups directory")
+	}
+}
+
+func TestMigrateConfigDir_UpgradesEveryFile(t *testing.T) {
+	dir := t.TempDir()
+	writeUnversionedInstallation(t, dir)
+
+	results, err := MigrateConfigDir(dir, false)
+	if err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	for _, result := range results {
+		if len(result.Backups) != len(result.Steps) {
+			t.Fatalf("expected one backup per step for %s, got %v", result.File, result.Backups)
+		}
+		if _, err := os.Stat(result.Backups[0]); err != nil {
+			t.Errorf("backup for %s missing: %v", result.File, err)
+		}
+
+		data, _ := os.ReadFile(filepath.Join(dir, result.File))
+		version, err := detectFormatVersion(data)
+		if err != nil || version != result.ToVersion {
+			t.Errorf("%s not at version %d after migration (version %d, err %v): %s", result.File, result.ToVersion, version, err, data)
+		}
+	}
+
+	users, err := loadUsers(filepath.Join(dir, "users.json"))
+	if err != nil || len(users) != 1 || users[0].Username != "admin" {
+		t.Errorf("users not readable after migration: %v, %v", users, err)
+	}
+	roles, err := loadRoles(filepath.Join(dir, "roles.json"))
+	if err != nil || !roles.Has(RoleReader, "read") {
+		t.Errorf("roles not readable after migration: %v, %v", roles, err)
+	}
+	config, _ := os.ReadFile(filepath.Join(dir, "config.json"))
+	if !strings.Contains(string(config), "keep me") || !strings.Contains(string(config), `"rotation_backup_count": 2`) {
+		t.Errorf("config fields lost during migration: %s", config)
+	}
+
+	// A second run has nothing to do
+	again, err := MigrateConfigDir(dir, false)
+	if err != nil {
+		t.Fatalf("second migrate failed: %v", err)
+	}
+	for _, result := range again {
+		if !result.UpToDate() || len(result.Backups) != 0 {
+			t.Errorf("expected %s to be up to date, got %+v", result.File, result)
+		}
+	}
+}
+
+func TestNewerFormatIsRefused(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SIMPLE_SECRETS_CONFIG_DIR", dir)
+
+	usersPath := filepath.Join(dir, "users.json")
+	newer := `{"version": 99, "users": []}`
+	if err := os.WriteFile(usersPath, []byte(newer), 0600); err != nil {
+		t.Fatalf("write users.json: %v", err)
+	}
+
+	if _, err := loadUsers(usersPath); !errors.Is(err, ErrNewerFormat) {
+		t.Errorf("expected ErrNewerFormat from loadUsers, got %v", err)
+	}
+	if _, err := MigrateConfigDir(dir, false); !errors.Is(err, ErrNewerFormat) {
+		t.Errorf("expected migrate to refuse a newer format, got %v", err)
+	}
+	if data, _ := os.ReadFile(usersPath); string(data) != newer {
+		t.Errorf("newer file must not be rewritten: %s", data)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "secrets.json"), []byte(`{"version": 2, "secrets": {}}`), 0600); err != nil {
+		t.Fatalf("write secrets.json: %v", err)
+	}
+	if _, err := LoadSecretsStoreFromDir(NewFilesystemBackend(), dir); !errors.Is(err, ErrNewerFormat) {
+		t.Errorf("expected ErrNewerFormat from secrets store, got %v", err)
+	}
+}
+
+func TestDetectFormatVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"bare_array", `[{"username": "admin"}]`, 0},
+		{"object_without_version", `{"admin": ["read"]}`, 0},
+		{"legacy_secret_named_version", `{"version": "Y2lwaGVydGV4dA=="}`, 0},
+		{"versioned", `{"version": 3, "users": []}`, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := detectFormatVersion([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("detectFormatVersion: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("detectFormatVersion(%s) = %d, want %d", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSealedUsersFileMigratesToV2(t *testing.T) {
+	dir := newSealedInstallation(t)
+	usersPath := filepath.Join(dir, "users.json")
+	v1 := &usersFile{Version: 1, Users: []*User{
+		{Username: "admin", TokenHash: HashToken("admin-token"), Role: RoleAdmin},
+		{Username: "carol", Role: RoleReader},
+	}}
+	if err := writeSealedFile(usersPath, v1); err != nil {
+		t.Fatalf("write v1 users.json: %v", err)
+	}
+
+	// An older sealed file is upgraded in memory and its seal still verifies
+	users, err := loadUsers(usersPath)
+	if err != nil {
+		t.Fatalf("load v1 users.json: %v", err)
+	}
+	if users[0].Status != StatusActive || users[1].Status != StatusDisabled {
+		t.Fatalf("expected admin active and carol disabled, got %q and %q", users[0].Status, users[1].Status)
+	}
+
+	if _, err := MigrateConfigDir(dir, false); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	data, _ := os.ReadFile(usersPath)
+	if version, _ := detectFormatVersion(data); version != 2 {
+		t.Fatalf("expected users.json at version 2, got %s", data)
+	}
+	if _, err := loadUsers(usersPath); err != nil {
+		t.Fatalf("migrated users.json should carry a valid seal: %v", err)
+	}
+
+	// A tampered older file is not migrated, which would seal the edit
+	if err := writeSealedFile(usersPath, v1); err != nil {
+		t.Fatalf("write v1 users.json: %v", err)
+	}
+	editFile(t, usersPath, `"role": "reader"`, `"role": "admin"`)
+	if _, err := MigrateConfigDir(dir, false); !errors.Is(err, ErrTampered) {
+		t.Fatalf("expected a tampered file to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Permissions that can be granted to a role
+const (
+	PermRead           = "read"
+	PermWrite          = "write"
+	PermRotateTokens   = "rotate-tokens"
+	PermManageUsers    = "manage-users"
+	PermRotateOwnToken = "rotate-own-token"
+)
+
+// PermissionInfo describes a permission in the catalog
+type PermissionInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// PermissionCatalog lists every permission a role can be granted
+var PermissionCatalog = []PermissionInfo{
+	{PermRead, "read secrets, their fields and backups, and list keys"},
+	{PermWrite, "create, update, delete, disable, enable and restore secrets; rotate the master key"},
+	{PermRotateTokens, "create, rotate, revoke and disable other users' tokens"},
+	{PermManageUsers, "create, delete, enable and list users; manage roles"},
+	{PermRotateOwnToken, "create, rotate and revoke your own tokens"},
+}
+
+// roleNamePattern restricts role names to lowercase identifiers such as ci-writer
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// ErrPermissionDenied indicates the caller lacks a permission the operation needs
+var ErrPermissionDenied = errors.New("permission denied")
+
+// NewPermissionDeniedError reports a missing named permission
+func NewPermissionDeniedError(permission string) error {
+	return fmt.Errorf("%w: need '%s' permission", ErrPermissionDenied, permission)
+}
+
+// IsKnownPermission reports whether name is in the permission catalog
+func IsKnownPermission(name string) bool {
+	for _, perm := range PermissionCatalog {
+		if perm.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRoleName checks that a role name is a lowercase identifier
+func ValidateRoleName(name string) error {
+	if !roleNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid role name %q: use lowercase letters, digits, '-' and '_', starting with a letter", name)
+	}
+	return nil
+}
+
+// normalizePermissions validates permissions against the catalog and removes duplicates
+func normalizePermissions(perms []string) ([]string, error) {
+	if len(perms) == 0 {
+		return nil, fmt.Errorf("a role needs at least one permission")
+	}
+
+	var normalized []string
+	for _, perm := range perms {
+		perm = strings.TrimSpace(perm)
+		if !IsKnownPermission(perm) {
+			return nil, fmt.Errorf("unknown permission %q: valid permissions are %s", perm, strings.Join(permissionNames(), ", "))
+		}
+		if !slices.Contains(normalized, perm) {
+			normalized = append(normalized, perm)
+		}
+	}
+	return normalized, nil
+}
+
+func permissionNames() []string {
+	names := make([]string, len(PermissionCatalog))
+	for i, perm := range PermissionCatalog {
+		names[i] = perm.Name
+	}
+	return names
+}
+
+// RoleNames returns the defined roles in sorted order
+func (rp RolePermissions) RoleNames() []string {
+	names := make([]string, 0, len(rp))
+	for role := range rp {
+		names = append(names, string(role))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveRole returns the role if it is defined in roles.json
+func (rp RolePermissions) resolveRole(name string) (Role, error) {
+	if _, ok := rp[Role(name)]; !ok {
+		return "", fmt.Errorf("invalid role %q: must be one of %s", name, strings.Join(rp.RoleNames(), ", "))
+	}
+	return Role(name), nil
+}
+
+// privilegedPermissions are the permissions granting which needs grant-admin approval:
+// managing users reaches every other permission, and write covers deleting secrets and
+// rotating the master key
+var privilegedPermissions = []string{PermManageUsers, PermWrite}
+
+// grantsPrivilege reports whether any of the roles holds a privileged permission
+func (rp RolePermissions) grantsPrivilege(roles ...Role) bool {
+	return slices.ContainsFunc(roles, func(r Role) bool {
+		return slices.ContainsFunc(privilegedPermissions, func(perm string) bool { return rp.Has(r, perm) })
+	})
+}
+
+// RoleGrantsPrivilege reports whether the named role holds a privileged permission,
+// so that giving it to a user needs grant-admin approval
+func (us *UserStore) RoleGrantsPrivilege(name string) bool {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+	return us.permissions.grantsPrivilege(Role(name))
+}
+
+// Roles returns a copy of the role permissions
+func (us *UserStore) Roles() RolePermissions {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	roles := make(RolePermissions, len(us.permissions))
+	for role, perms := range us.permissions {
+		roles[role] = slices.Clone(perms)
+	}
+	return roles
+}
+
+// CreateRole defines a new role with the given permissions
+func (us *UserStore) CreateRole(name string, perms []string) error {
+	if err := ValidateRoleName(name); err != nil {
+		return err
+	}
+	normalized, err := normalizePermissions(perms)
+	if err != nil {
+		return err
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if _, exists := us.permissions[Role(name)]; exists {
+		return fmt.Errorf("role %q already exists", name)
+	}
+	us.permissions[Role(name)] = normalized
+	return nil
+}
+
+// UpdateRole replaces the permissions of an existing role. The built-in admin
+// role can't be changed, so there is always a role that can manage users.
+func (us *UserStore) UpdateRole(name string, perms []string) error {
+	if Role(name) == RoleAdmin {
+		return fmt.Errorf("the built-in %q role cannot be changed", RoleAdmin)
+	}
+	normalized, err := normalizePermissions(perms)
+	if err != nil {
+		return err
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if _, exists := us.permissions[Role(name)]; !exists {
+		return fmt.Errorf("role %q not found", name)
+	}
+	us.permissions[Role(name)] = normalized
+	return nil
+}
+
+// DeleteRole removes a role that no user or group is assigned to
+func (us *UserStore) DeleteRole(name string) error {
+	if Role(name) == RoleAdmin {
+		return fmt.Errorf("the built-in %q role cannot be deleted", RoleAdmin)
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if _, exists := us.permissions[Role(name)]; !exists {
+		return fmt.Errorf("role %q not found", name)
+	}
+
+	var assigned []string
+	for _, u := range us.users {
+		if u.Role == Role(name) {
+			assigned = append(assigned, u.Username)
+		}
+	}
+	for _, group := range us.groupsWithRole(Role(name)) {
+		assigned = append(assigned, "group "+group)
+	}
+	if len(assigned) > 0 {
+		return fmt.Errorf("role %q is still assigned to: %s", name, strings.Join(assigned, ", "))
+	}
+
+	delete(us.permissions, Role(name))
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newRoleTestService creates a service over an installation with an admin and a reader
+func newRoleTestService(t *testing.T) (*Service, string) {
+	t.Helper()
+	dir := newSealedInstallation(t)
+
+	service, err := NewService(WithStorageBackend(NewFilesystemBackend()), WithConfigDir(dir))
+	if err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+	return service, dir
+}
+
+func TestRoleCRUD(t *testing.T) {
+	store := createUserStore(nil, createDefaultRoles())
+
+	if err := store.CreateRole("ci-writer", []string{PermRead, PermWrite, PermRead}); err != nil {
+		t.Fatalf("create role: %v", err)
+	}
+	if got := store.Roles()["ci-writer"]; !slices.Equal(got, []string{PermRead, PermWrite}) {
+		t.Fatalf("duplicate permissions should be dropped, got %v", got)
+	}
+	if err := store.CreateRole("ci-writer", []string{PermRead}); err == nil {
+		t.Fatal("creating an existing role should fail")
+	}
+
+	if err := store.UpdateRole("ci-writer", []string{PermRead}); err != nil {
+		t.Fatalf("update role: %v", err)
+	}
+	if got := store.Roles()["ci-writer"]; !slices.Equal(got, []string{PermRead}) {
+		t.Fatalf("expected [read] after update, got %v", got)
+	}
+
+	if err := store.DeleteRole("ci-writer"); err != nil {
+		t.Fatalf("delete role: %v", err)
+	}
+	if _, exists := store.Roles()["ci-writer"]; exists {
+		t.Fatal("role should be gone after delete")
+	}
+}
+
+func TestRoleValidation(t *testing.T) {
+	store := createUserStore([]*User{{Username: "bob", Role: RoleReader}}, createDefaultRoles())
+
+	tests := []struct {
+		name string
+		run  func() error
+		want string
+	}{
+		{"unknown_permission", func() error { return store.CreateRole("ops", []string{"deploy"}) }, "unknown permission"},
+		{"no_permissions", func() error { return store.CreateRole("ops", nil) }, "at least one permission"},
+		{"invalid_name", func() error { return store.CreateRole("Ops!", []string{PermRead}) }, "invalid role name"},
+		{"update_admin", func() error { return store.UpdateRole("admin", []string{PermRead}) }, "cannot be changed"},
+		{"delete_admin", func() error { return store.DeleteRole("admin") }, "cannot be deleted"},
+		{"delete_assigned", func() error { return store.DeleteRole("reader") }, "still assigned to: bob"},
+		{"update_missing", func() error { return store.UpdateRole("ops", []string{PermRead}) }, "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestCustomRoleGrantsNamedPermissions(t *testing.T) {
+	service, dir := newRoleTestService(t)
+
+	if err := service.Roles().CreateRole("admin-token", "ci-writer", []string{PermRead, PermWrite}); err != nil {
+		t.Fatalf("create role: %v", err)
+	}
+	ciToken, err := service.Users().CreateUser("admin-token", "ci", "ci-writer")
+	if err != nil {
+		t.Fatalf("create user with custom role: %v", err)
+	}
+
+	if _, err := service.Auth().Authorize(ciToken, PermWrite); err != nil {
+		t.Fatalf("ci-writer should have write: %v", err)
+	}
+	if _, err := service.Auth().Authorize(ciToken, PermManageUsers); err == nil || !strings.Contains(err.Error(), "need 'manage-users'") {
+		t.Fatalf("ci-writer should lack manage-users, got %v", err)
+	}
+
+	// The role survives a reload from disk, sealed
+	roles, err := loadRoles(filepath.Join(dir, "roles.json"))
+	if err != nil {
+		t.Fatalf("reload roles: %v", err)
+	}
+	if !slices.Equal(roles["ci-writer"], []string{PermRead, PermWrite}) {
+		t.Fatalf("expected persisted ci-writer role, got %v", roles["ci-writer"])
+	}
+
+	if _, err := service.Users().CreateUser("admin-token", "eve", "nonexistent"); err == nil {
+		t.Fatal("creating a user with an undefined role should fail")
+	}
+}
+
+func TestRoleManagementRequiresManageUsers(t *testing.T) {
+	service, _ := newRoleTestService(t)
+
+	if err := service.Roles().CreateRole("bob-token", "ops", []string{PermRead}); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("reader must not create roles, got %v", err)
+	}
+	if _, err := service.Roles().ListRoles("bob-token"); err == nil {
+		t.Fatal("reader must not list roles")
+	}
+}
@@ -0,0 +1,412 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Actions a policy can allow or deny on the keys it matches
+const (
+	ActionRead  = "read"
+	ActionWrite = "write"
+	ActionList  = "list"
+)
+
+// Policy effects. A matching deny always wins over a matching allow.
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// Policy subjects are written as "user:<name>", "group:<name>" or "role:<name>"
+const (
+	subjectUser  = "user"
+	subjectGroup = "group"
+	subjectRole  = "role"
+)
+
+// ErrAccessDenied indicates a policy does not let the caller act on a key
+var ErrAccessDenied = errors.New("access denied")
+
+// policyActions lists the valid actions in display order
+var policyActions = []string{ActionRead, ActionWrite, ActionList}
+
+// Policy allows or denies actions on keys matching its patterns to a user, a group or a role.
+// Patterns use shell glob syntax: *, ? and [...].
+type Policy struct {
+	ID      string   `json:"id"`
+	Effect  string   `json:"effect"`
+	Subject string   `json:"subject"`
+	Actions []string `json:"actions"`
+	Keys    []string `json:"keys"`
+}
+
+// String renders a policy on one line, as shown by 'policy list' and 'policy test'
+func (p Policy) String() string {
+	return fmt.Sprintf("%s %s %s on %s", p.Effect, p.Subject, strings.Join(p.Actions, ","), strings.Join(p.Keys, ", "))
+}
+
+// appliesTo reports whether the policy's subject is the user, one of the user's groups
+// or one of the user's roles, including roles inherited from a group
+func (p Policy) appliesTo(user *User) bool {
+	kind, name, _ := strings.Cut(p.Subject, ":")
+	switch kind {
+	case subjectUser:
+		return name == user.Username
+	case subjectGroup:
+		return slices.Contains(user.Groups, name)
+	case subjectRole:
+		return user.HasRole(Role(name))
+	}
+	return false
+}
+
+// matches reports whether the policy covers the action on the key
+func (p Policy) matches(action, key string) bool {
+	if !slices.Contains(p.Actions, action) {
+		return false
+	}
+	for _, pattern := range p.Keys {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks every field of a policy other than its ID
+func (p Policy) validate() error {
+	if p.Effect != PolicyAllow && p.Effect != PolicyDeny {
+		return fmt.Errorf("invalid policy effect %q: must be %q or %q", p.Effect, PolicyAllow, PolicyDeny)
+	}
+
+	kind, name, _ := strings.Cut(p.Subject, ":")
+	if (kind != subjectUser && kind != subjectGroup && kind != subjectRole) || name == "" {
+		return fmt.Errorf("invalid policy subject %q: must be user:<name>, group:<name> or role:<name>", p.Subject)
+	}
+
+	if len(p.Actions) == 0 {
+		return fmt.Errorf("a policy needs at least one action")
+	}
+	for _, action := range p.Actions {
+		if err := validatePolicyAction(action); err != nil {
+			return err
+		}
+	}
+
+	if len(p.Keys) == 0 {
+		return fmt.Errorf("a policy needs at least one key pattern")
+	}
+	for _, pattern := range p.Keys {
+		if pattern == "" {
+			return fmt.Errorf("key patterns cannot be empty")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid key pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// validatePolicyAction rejects actions outside read, write and list
+func validatePolicyAction(action string) error {
+	if !slices.Contains(policyActions, action) {
+		return fmt.Errorf("unknown policy action %q: valid actions are %s", action, strings.Join(policyActions, ", "))
+	}
+	return nil
+}
+
+// UserSubject returns the policy subject for a user
+func UserSubject(username string) string {
+	return subjectUser + ":" + username
+}
+
+// GroupSubject returns the policy subject for a group
+func GroupSubject(group string) string {
+	return subjectGroup + ":" + group
+}
+
+// RoleSubject returns the policy subject for a role
+func RoleSubject(role string) string {
+	return subjectRole + ":" + role
+}
+
+// PolicyDecision explains the outcome of evaluating an action on a key
+type PolicyDecision struct {
+	Action  string  `json:"action"`
+	Key     string  `json:"key"`
+	Allowed bool    `json:"allowed"`
+	Reason  string  `json:"reason"`
+	Policy  *Policy `json:"policy,omitempty"` // the deciding policy, if any
+}
+
+// policiesFile is the on-disk layout of policies.json
+type policiesFile struct {
+	Version  int      `json:"version"`
+	Policies []Policy `json:"policies"`
+	Revision uint64   `json:"revision,omitempty"` // sealed write counter, see integrity.go
+	MAC      string   `json:"mac,omitempty"`      // integrity seal, see integrity.go
+}
+
+// PolicyEngine evaluates the path-scoped access policies in policies.json
+type PolicyEngine struct {
+	path     string
+	policies []Policy
+	mu       sync.RWMutex
+}
+
+// LoadPolicyEngine loads policies.json from configDir. A missing file means no policies.
+func LoadPolicyEngine(configDir string) (*PolicyEngine, error) {
+	policyPath := filepath.Join(configDir, "policies.json")
+	policies, err := loadPolicies(policyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &PolicyEngine{path: policyPath, policies: policies}, nil
+}
+
+// loadPolicies reads and verifies policies.json. A missing file means no policies, unless
+// it was sealed before.
+func loadPolicies(path string) ([]Policy, error) {
+	var file policiesFile
+	if err := readConfigFile(path, policiesFormat, &file); err != nil {
+		if os.IsNotExist(err) {
+			return nil, checkMissingSealedFile(path)
+		}
+		if errors.Is(err, ErrNewerFormat) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("policies.json is corrupted or invalid: %w", err)
+	}
+	if err := verifyDocument(path, &file); err != nil {
+		return nil, err
+	}
+	return file.Policies, nil
+}
+
+// savePolicies writes policies to policies.json in the current format and seals it
+func savePolicies(path string, policies []Policy) error {
+	if policies == nil {
+		policies = []Policy{}
+	}
+	return writeSealedFile(path, &policiesFile{Version: PoliciesFormatVersion, Policies: policies})
+}
+
+// Policies returns a copy of the defined policies
+func (pe *PolicyEngine) Policies() []Policy {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+	return slices.Clone(pe.policies)
+}
+
+// Add validates a policy, assigns it the next ID and saves it
+func (pe *PolicyEngine) Add(policy Policy) (Policy, error) {
+	if err := policy.validate(); err != nil {
+		return Policy{}, err
+	}
+
+	err := pe.update(func(policies []Policy) ([]Policy, error) {
+		policy.ID = pe.nextID()
+		return append(policies, policy), nil
+	})
+	if err != nil {
+		return Policy{}, err
+	}
+	return policy, nil
+}
+
+// Remove deletes the policy with the given ID and saves the rest
+func (pe *PolicyEngine) Remove(id string) error {
+	return pe.update(func(policies []Policy) ([]Policy, error) {
+		index := slices.IndexFunc(policies, func(p Policy) bool { return p.ID == id })
+		if index < 0 {
+			return nil, fmt.Errorf("policy %q not found", id)
+		}
+		return slices.Delete(policies, index, index+1), nil
+	})
+}
+
+// RenameUser points the policies of a renamed user at the new username
+func (pe *PolicyEngine) RenameUser(oldName, newName string) error {
+	return pe.update(func(policies []Policy) ([]Policy, error) {
+		renamed := false
+		for i, p := range policies {
+			if p.Subject == subjectUser+":"+oldName {
+				policies[i].Subject = subjectUser + ":" + newName
+				renamed = true
+			}
+		}
+		if !renamed {
+			return nil, nil
+		}
+		return policies, nil
+	})
+}
+
+// update applies a change to the policies under policies.json's lock, reloading them from
+// disk first so that two processes changing policies at once cannot drop each other's
+// change. The change gets a copy to modify; a nil result leaves the file as it is.
+func (pe *PolicyEngine) update(change func(policies []Policy) ([]Policy, error)) error {
+	lock, err := LockFile(pe.path)
+	if err != nil {
+		return fmt.Errorf("failed to acquire policies lock: %w", err)
+	}
+	defer lock.Unlock()
+
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	current, err := loadPolicies(pe.path)
+	if err != nil {
+		return fmt.Errorf("failed to reload policies: %w", err)
+	}
+	pe.policies = current
+
+	policies, err := change(slices.Clone(current))
+	if err != nil || policies == nil {
+		return err
+	}
+	if err := savePolicies(pe.path, policies); err != nil {
+		return fmt.Errorf("failed to save policies: %w", err)
+	}
+	pe.policies = policies
+	return nil
+}
+
+// nextID returns an ID one above the highest numbered policy. Callers must hold the lock.
+func (pe *PolicyEngine) nextID() string {
+	highest := 0
+	for _, p := range pe.policies {
+		if n, err := strconv.Atoi(strings.TrimPrefix(p.ID, "p")); err == nil && n > highest {
+			highest = n
+		}
+	}
+	return "p" + strconv.Itoa(highest+1)
+}
+
+// Evaluate decides whether the user may perform the action on the key.
+//
+// The user's role must first hold the matching permission (read for read and list,
+// write for write), and a scoped token must have a scope covering the key. A matching
+// deny policy then always wins. Users with no allow
+// policy for themselves, their groups or their roles are not scoped, so their role decides; once
+// any allow policy applies to them, only keys it matches are accessible.
+func (pe *PolicyEngine) Evaluate(user *User, perms RolePermissions, action, key string) PolicyDecision {
+	decision := PolicyDecision{Action: action, Key: key}
+
+	permission := actionPermission(action)
+	if !user.Can(permission, perms) {
+		decision.Reason = fmt.Sprintf("%s lacks the '%s' permission", user.describeRoles(), permission)
+		return decision
+	}
+	if !user.InScope(action, key) {
+		decision.Reason = fmt.Sprintf("outside the token's scopes (%s)", formatScopes(user.Scopes))
+		return decision
+	}
+
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	var allow *Policy
+	scoped := false
+	for i := range pe.policies {
+		policy := pe.policies[i]
+		if !policy.appliesTo(user) {
+			continue
+		}
+		if policy.Effect == PolicyAllow {
+			scoped = true
+		}
+		if !policy.matches(action, key) {
+			continue
+		}
+		if policy.Effect == PolicyDeny {
+			decision.Reason = fmt.Sprintf("denied by policy %s", policy.ID)
+			decision.Policy = &policy
+			return decision
+		}
+		if allow == nil {
+			allow = &policy
+		}
+	}
+
+	if allow != nil {
+		decision.Allowed = true
+		decision.Reason = fmt.Sprintf("allowed by policy %s", allow.ID)
+		decision.Policy = allow
+		return decision
+	}
+	if scoped {
+		decision.Reason = fmt.Sprintf("no allow policy for %s matches", user.describeSubjects())
+		return decision
+	}
+
+	decision.Allowed = true
+	decision.Reason = fmt.Sprintf("no allow policies apply to %s; role %q grants '%s'",
+		user.describeSubjects(), user.grantingRole(permission, perms), permission)
+	return decision
+}
+
+// formatScopes joins scopes for display
+func formatScopes(scopes []TokenScope) string {
+	values := make([]string, len(scopes))
+	for i, scope := range scopes {
+		values[i] = scope.String()
+	}
+	return strings.Join(values, ", ")
+}
+
+// actionPermission returns the role permission a policy action requires
+func actionPermission(action string) string {
+	if action == ActionWrite {
+		return PermWrite
+	}
+	return PermRead
+}
+
+// Check returns an ErrAccessDenied error unless the user may perform the action on the key
+func (pe *PolicyEngine) Check(user *User, perms RolePermissions, action, key string) error {
+	decision := pe.Evaluate(user, perms, action, key)
+	if !decision.Allowed {
+		return fmt.Errorf("%w: cannot %s %q: %s", ErrAccessDenied, action, key, decision.Reason)
+	}
+	return nil
+}
+
+// Filter returns the keys the user may perform the action on, in their original order
+func (pe *PolicyEngine) Filter(user *User, perms RolePermissions, action string, keys []string) []string {
+	var visible []string
+	for _, key := range keys {
+		if pe.Evaluate(user, perms, action, key).Allowed {
+			visible = append(visible, key)
+		}
+	}
+	return visible
+}
+
+// PolicyActions returns the actions a policy can cover
+func PolicyActions() []string {
+	return slices.Clone(policyActions)
+}
@@ -0,0 +1,190 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	perms := createDefaultRoles()
+	perms["ci"] = []string{PermRead, PermWrite}
+
+	engine := &PolicyEngine{policies: []Policy{
+		{ID: "p1", Effect: PolicyAllow, Subject: "role:ci", Actions: []string{ActionRead, ActionList}, Keys: []string{"payments-*"}},
+		{ID: "p2", Effect: PolicyAllow, Subject: "user:deployer", Actions: []string{ActionWrite}, Keys: []string{"payments-*"}},
+		{ID: "p3", Effect: PolicyDeny, Subject: "user:deployer", Actions: []string{ActionRead}, Keys: []string{"payments-root"}},
+		{ID: "p4", Effect: PolicyDeny, Subject: "role:reader", Actions: []string{ActionRead, ActionList}, Keys: []string{"hr-*"}},
+	}}
+
+	ci := &User{Username: "build", Role: "ci"}
+	deployer := &User{Username: "deployer", Role: "ci"}
+	bob := &User{Username: "bob", Role: RoleReader}
+
+	tests := []struct {
+		name    string
+		user    *User
+		action  string
+		key     string
+		allowed bool
+		reason  string
+	}{
+		{"allow_matches", ci, ActionRead, "payments-db", true, "allowed by policy p1"},
+		{"scoped_user_outside_allow", ci, ActionRead, "hr-db", false, "no allow policy"},
+		{"scoped_user_action_not_granted", ci, ActionWrite, "payments-db", false, "no allow policy"},
+		{"user_policy_adds_to_role_policy", deployer, ActionWrite, "payments-db", true, "allowed by policy p2"},
+		{"deny_overrides_allow", deployer, ActionRead, "payments-root", false, "denied by policy p3"},
+		{"role_allow_still_applies_to_user", deployer, ActionRead, "payments-db", true, "allowed by policy p1"},
+		{"unscoped_role_keeps_access", bob, ActionRead, "payments-db", true, "no allow policies apply"},
+		{"deny_without_allow", bob, ActionRead, "hr-db", false, "denied by policy p4"},
+		{"role_permission_checked_first", bob, ActionWrite, "payments-db", false, "lacks the 'write' permission"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := engine.Evaluate(tt.user, perms, tt.action, tt.key)
+			if decision.Allowed != tt.allowed {
+				t.Fatalf("expected allowed=%v, got %+v", tt.allowed, decision)
+			}
+			if !strings.Contains(decision.Reason, tt.reason) {
+				t.Fatalf("expected reason containing %q, got %q", tt.reason, decision.Reason)
+			}
+		})
+	}
+
+	if err := engine.Check(bob, perms, ActionRead, "hr-db"); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("Check should return ErrAccessDenied, got %v", err)
+	}
+	if got := engine.Filter(ci, perms, ActionList, []string{"hr-db", "payments-db", "payments-api"}); !slices.Equal(got, []string{"payments-db", "payments-api"}) {
+		t.Fatalf("unexpected filtered keys: %v", got)
+	}
+}
+
+func TestPolicyValidation(t *testing.T) {
+	valid := Policy{Effect: PolicyAllow, Subject: "role:reader", Actions: []string{ActionRead}, Keys: []string{"prod-*"}}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("valid policy rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(p *Policy)
+		want   string
+	}{
+		{"bad_effect", func(p *Policy) { p.Effect = "maybe" }, "invalid policy effect"},
+		{"bad_subject", func(p *Policy) { p.Subject = "team:ops" }, "invalid policy subject"},
+		{"empty_subject_name", func(p *Policy) { p.Subject = "user:" }, "invalid policy subject"},
+		{"unknown_action", func(p *Policy) { p.Actions = []string{"delete"} }, "unknown policy action"},
+		{"no_keys", func(p *Policy) { p.Keys = nil }, "at least one key pattern"},
+		{"bad_pattern", func(p *Policy) { p.Keys = []string{"prod-["} }, "invalid key pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := valid
+			tt.modify(&policy)
+			if err := policy.validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestPolicyEnginePersistence(t *testing.T) {
+	dir := newSealedInstallation(t)
+
+	engine, err := LoadPolicyEngine(dir)
+	if err != nil {
+		t.Fatalf("load without policies.json: %v", err)
+	}
+
+	first, err := engine.Add(Policy{Effect: PolicyAllow, Subject: RoleSubject("reader"), Actions: []string{ActionRead}, Keys: []string{"app-*"}})
+	if err != nil {
+		t.Fatalf("add policy: %v", err)
+	}
+	second, err := engine.Add(Policy{Effect: PolicyDeny, Subject: UserSubject("bob"), Actions: []string{ActionRead}, Keys: []string{"app-root"}})
+	if err != nil {
+		t.Fatalf("add policy: %v", err)
+	}
+	if first.ID != "p1" || second.ID != "p2" {
+		t.Fatalf("expected IDs p1 and p2, got %s and %s", first.ID, second.ID)
+	}
+	if err := engine.Remove("p1"); err != nil {
+		t.Fatalf("remove policy: %v", err)
+	}
+
+	reloaded, err := LoadPolicyEngine(dir)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	policies := reloaded.Policies()
+	if len(policies) != 1 || policies[0].ID != "p2" {
+		t.Fatalf("expected only p2 after reload, got %+v", policies)
+	}
+	if third, _ := reloaded.Add(Policy{Effect: PolicyAllow, Subject: RoleSubject("reader"), Actions: []string{ActionList}, Keys: []string{"*"}}); third.ID != "p3" {
+		t.Fatalf("IDs must not be reused, got %s", third.ID)
+	}
+
+	editFile(t, filepath.Join(dir, "policies.json"), `"effect": "deny"`, `"effect": "allow"`)
+	if _, err := LoadPolicyEngine(dir); !errors.Is(err, ErrTampered) {
+		t.Fatalf("edited policies.json must be refused, got %v", err)
+	}
+}
+
+func TestServiceAppliesPolicies(t *testing.T) {
+	service, _ := newRoleTestService(t)
+
+	for _, key := range []string{"app-db", "hr-db"} {
+		if err := service.Secrets().Put("admin-token", key, "value"); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+	if _, err := service.Policies().AddPolicy("admin-token", Policy{Effect: PolicyDeny, Subject: RoleSubject("reader"), Actions: []string{ActionRead, ActionList}, Keys: []string{"hr-*"}}); err != nil {
+		t.Fatalf("add policy: %v", err)
+	}
+
+	keys, err := service.Secrets().List("bob-token")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if !slices.Equal(keys, []string{"app-db"}) {
+		t.Fatalf("reader should only see app-db, got %v", keys)
+	}
+	if _, err := service.Secrets().Get("bob-token", "hr-db"); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("reader must not read hr-db, got %v", err)
+	}
+	if _, err := service.Secrets().Get("bob-token", "app-db"); err != nil {
+		t.Fatalf("reader should still read app-db: %v", err)
+	}
+
+	keys, err = service.Secrets().List("admin-token")
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("admin should see both keys, got %v (%v)", keys, err)
+	}
+
+	decisions, err := service.Policies().TestPolicy("admin-token", "bob", "hr-db", []string{ActionRead})
+	if err != nil {
+		t.Fatalf("test policy: %v", err)
+	}
+	if decisions[0].Allowed || decisions[0].Policy == nil || decisions[0].Policy.ID != "p1" {
+		t.Fatalf("expected denial by p1, got %+v", decisions[0])
+	}
+	if _, err := service.Policies().TestPolicy("bob-token", "bob", "hr-db", []string{ActionRead}); err == nil {
+		t.Fatal("policy test requires manage-users")
+	}
+}
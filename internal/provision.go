@@ -0,0 +1,202 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrAlreadySetUp indicates setup found an existing installation and left it unchanged
+var ErrAlreadySetUp = errors.New("simple-secrets is already set up")
+
+// ProvisionFile lists the roles and users 'setup --provision' creates along with the admin
+type ProvisionFile struct {
+	Roles []ProvisionRole `json:"roles,omitempty"`
+	Users []ProvisionUser `json:"users,omitempty"`
+}
+
+// ProvisionRole is a custom role to create at setup
+type ProvisionRole struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// ProvisionUser is a user to create at setup. Its token is written to TokenOut if given.
+type ProvisionUser struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	TokenOut string `json:"token_out,omitempty"`
+}
+
+// ParseProvisionFile reads a provisioning file, rejecting unknown fields so typos are not ignored
+func ParseProvisionFile(data []byte) (*ProvisionFile, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var file ProvisionFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid provisioning file: %w", err)
+	}
+	return &file, nil
+}
+
+// SetupUser is a user created by setup, with the only copy of their token
+type SetupUser struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Token    string `json:"token,omitempty"`
+	TokenOut string `json:"token_file,omitempty"` // where the token is to be written instead of shown
+}
+
+// SetupPlan is a new installation built in memory by PlanSetup. Nothing is written until
+// Apply, so tokens can be delivered first and a failed plan leaves no partial installation.
+type SetupPlan struct {
+	ConfigDir      string
+	Users          []SetupUser // the admin first
+	Roles          RolePermissions
+	RecoveryCodes  []string // admin recovery codes, see recovery.go
+	usersPath      string
+	rolesPath      string
+	store          *UserStore
+	recoveryHashes []recoveryCode
+}
+
+// PlanSetup builds an installation with an admin named adminUsername ("admin" if empty) and
+// the provisioned roles and users. It returns ErrAlreadySetUp if users.json exists.
+func PlanSetup(adminUsername string, provision *ProvisionFile) (*SetupPlan, error) {
+	usersPath, rolesPath, err := resolveConfigPaths()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSetupEligible(usersPath); err != nil {
+		return nil, err
+	}
+
+	if adminUsername == "" {
+		adminUsername = "admin"
+	}
+	token, admin, err := generateDefaultAdmin()
+	if err != nil {
+		return nil, err
+	}
+	admin.Username = adminUsername
+
+	codes, hashes, err := newRecoveryCodeSet()
+	if err != nil {
+		return nil, err
+	}
+
+	store := createUserStore([]*User{admin}, createDefaultRoles())
+	plan := &SetupPlan{
+		ConfigDir:      filepath.Dir(usersPath),
+		Users:          []SetupUser{{Username: adminUsername, Role: string(RoleAdmin), Token: token}},
+		RecoveryCodes:  codes,
+		usersPath:      usersPath,
+		rolesPath:      rolesPath,
+		store:          store,
+		recoveryHashes: hashes,
+	}
+	if provision == nil {
+		plan.Roles = store.Roles()
+		return plan, nil
+	}
+
+	for _, role := range provision.Roles {
+		if err := store.CreateRole(role.Name, role.Permissions); err != nil {
+			return nil, fmt.Errorf("provisioning role %q: %w", role.Name, err)
+		}
+	}
+	for _, user := range provision.Users {
+		token, err := store.CreateUser(user.Username, user.Role)
+		if err != nil {
+			return nil, fmt.Errorf("provisioning user %q: %w", user.Username, err)
+		}
+		plan.Users = append(plan.Users, SetupUser{Username: user.Username, Role: user.Role, Token: token, TokenOut: user.TokenOut})
+	}
+	plan.Roles = store.Roles()
+	return plan, nil
+}
+
+// Apply writes the planned installation. users.json is locked while it is checked and
+// written, so of two setups running at once one returns ErrAlreadySetUp.
+func (p *SetupPlan) Apply() error {
+	if err := ensureConfigDirectory(p.usersPath); err != nil {
+		return err
+	}
+	lock, err := LockFile(p.usersPath)
+	if err != nil {
+		return fmt.Errorf("failed to acquire users lock: %w", err)
+	}
+	defer lock.Unlock()
+
+	if err := checkSetupEligible(p.usersPath); err != nil {
+		return err
+	}
+	if err := writeInstallation(p.usersPath, p.rolesPath, p.store.Users(), p.store.Roles(), p.recoveryHashes); err != nil {
+		return err
+	}
+	if err := createDefaultConfigFile(); err != nil {
+		// Don't fail setup if config.json creation fails, just warn
+		fmt.Fprintf(os.Stderr, "Warning: failed to create default config.json: %v\n", err)
+	}
+	return nil
+}
+
+// writeInstallation writes the users, roles and recovery codes of a new installation.
+// Callers hold users.json's lock. If any of them cannot be written, the files already
+// written are removed again, so that a failed setup leaves no installation without
+// recovery codes behind and can simply be run again.
+func writeInstallation(usersPath, rolesPath string, users []*User, roles RolePermissions, codes []recoveryCode) (err error) {
+	configDir := filepath.Dir(usersPath)
+	codesPath := filepath.Join(configDir, recoveryCodesFileName)
+	backupsDir := filepath.Join(configDir, "backups") // holds the sealed copies
+	backupsExisted := fileExists(backupsDir)
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, path := range []string{usersPath, rolesPath, codesPath} {
+			_ = os.Remove(path)
+		}
+		if !backupsExisted {
+			_ = os.RemoveAll(backupsDir)
+		}
+	}()
+
+	if err := writeConfigFiles(usersPath, rolesPath, users, roles); err != nil {
+		return err
+	}
+	file := &recoveryCodesFile{Version: RecoveryCodesFormatVersion, GeneratedAt: time.Now().UTC(), Codes: codes}
+	if err := writeSealedFile(codesPath, file); err != nil {
+		return fmt.Errorf("failed to save recovery codes: %w; setup was rolled back", err)
+	}
+	return nil
+}
+
+// checkSetupEligible returns ErrAlreadySetUp for an installation, or the first-run
+// protection error for a partial one
+func checkSetupEligible(usersPath string) error {
+	if _, err := os.Stat(usersPath); err == nil {
+		return fmt.Errorf("%w in %s", ErrAlreadySetUp, filepath.Dir(usersPath))
+	}
+	return validateFirstRunEligibility()
+}
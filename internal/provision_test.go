@@ -0,0 +1,117 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanSetupWithProvisioning(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "config")
+	t.Setenv("SIMPLE_SECRETS_CONFIG_DIR", dir)
+
+	provision, err := ParseProvisionFile([]byte(`{
+		"roles": [{"name": "ci-writer", "permissions": ["read", "write"]}],
+		"users": [{"username": "ci", "role": "ci-writer", "token_out": "/tmp/ci.token"}, {"username": "bob", "role": "reader"}]
+	}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	plan, err := PlanSetup("ops", provision)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("planning must not write anything, stat: %v", err)
+	}
+	if len(plan.Users) != 3 || plan.Users[0].Username != "ops" || plan.Users[0].Role != string(RoleAdmin) {
+		t.Fatalf("unexpected users: %+v", plan.Users)
+	}
+	if plan.Users[1].TokenOut != "/tmp/ci.token" || plan.Users[1].Token == "" {
+		t.Fatalf("ci should carry its token and token file: %+v", plan.Users[1])
+	}
+
+	if err := plan.Apply(); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	store, err := loadUserStoreFromConfigDir(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	for _, user := range plan.Users {
+		found, err := store.Lookup(user.Token)
+		if err != nil || found.Username != user.Username {
+			t.Fatalf("token of %s should authenticate: %+v, %v", user.Username, found, err)
+		}
+	}
+	if !store.Permissions().Has(Role("ci-writer"), PermWrite) {
+		t.Fatal("the provisioned role should be saved")
+	}
+
+	if _, err := PlanSetup("ops", nil); !errors.Is(err, ErrAlreadySetUp) {
+		t.Fatalf("expected ErrAlreadySetUp, got %v", err)
+	}
+	if err := plan.Apply(); !errors.Is(err, ErrAlreadySetUp) {
+		t.Fatalf("a second apply must not overwrite the installation, got %v", err)
+	}
+}
+
+func TestPlanSetupRejectsInvalidProvisioning(t *testing.T) {
+	t.Setenv("SIMPLE_SECRETS_CONFIG_DIR", t.TempDir())
+
+	if _, err := ParseProvisionFile([]byte(`{"users": [{"username": "ci", "rol": "reader"}]}`)); err == nil {
+		t.Fatal("unknown fields should be rejected")
+	}
+
+	cases := map[string]string{
+		"unknown role":   `{"users": [{"username": "ci", "role": "writer"}]}`,
+		"duplicate user": `{"users": [{"username": "admin", "role": "reader"}]}`,
+		"bad permission": `{"roles": [{"name": "ci", "permissions": ["fly"]}]}`,
+		"built-in role":  `{"roles": [{"name": "admin", "permissions": ["read"]}]}`,
+	}
+	for name, data := range cases {
+		provision, err := ParseProvisionFile([]byte(data))
+		if err != nil {
+			t.Fatalf("%s: parse: %v", name, err)
+		}
+		if _, err := PlanSetup("", provision); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWriteInstallationRollsBack(t *testing.T) {
+	dir := t.TempDir()
+	usersPath := filepath.Join(dir, "users.json")
+	rolesPath := filepath.Join(dir, "roles.json")
+	// A directory in the way makes saving the recovery codes fail after the users are written
+	if err := os.MkdirAll(filepath.Join(dir, recoveryCodesFileName, "in-the-way"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	users := []*User{{Username: "admin", TokenHash: HashToken("admin-token"), Role: RoleAdmin}}
+	if err := writeInstallation(usersPath, rolesPath, users, createDefaultRoles(), nil); err == nil {
+		t.Fatal("setup should fail when the recovery codes cannot be saved")
+	}
+	for _, path := range []string{usersPath, rolesPath, filepath.Join(dir, "backups")} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s should have been removed by the rollback: %v", filepath.Base(path), err)
+		}
+	}
+}
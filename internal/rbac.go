@@ -16,7 +16,6 @@ limitations under the License.
 package internal
 
 import (
-	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -27,11 +26,24 @@ import (
 	"time"
 )
 
+// ErrInvalidToken indicates a token matches no user's token. Such failures count toward
+// the lockout in auth_failures.go.
+var ErrInvalidToken = errors.New("invalid token")
+
 // UserStore manages users, their permissions, and authentication
 type UserStore struct {
 	users       []*User
 	permissions RolePermissions
-	mu          sync.RWMutex // protects users slice and permissions
+	usage       *tokenUsage // records token last-used times; nil disables tracking
+	lifetime    TokenLifetimePolicy
+	lifetimeErr error            // invalid token lifetime settings, which refuse every sign-in
+	derived     *derivedTokens   // verifies derived tokens; nil rejects them
+	failures    *authFailures    // counts failed authentications; nil disables tracking
+	groups      []*Group         // groups from groups.json, see groups.go
+	index       map[string]*User // token lookup ID to user, see token_format.go
+	revision    string           // users.json as last reloaded or saved, see user_sync.go
+	setupCodes  []string         // recovery codes created by first-run setup, see first_run.go
+	mu          sync.RWMutex     // protects users slice and permissions
 }
 
 // Users returns the list of users (for first-run detection)
@@ -46,6 +58,16 @@ func LoadUsersList(path string) ([]*User, error) {
 	return loadUsers(path)
 }
 
+// SaveUsersList writes the user list to users.json in the current format and seals it.
+func SaveUsersList(path string, users []*User) error {
+	return writeSealedFile(path, &usersFile{Version: UsersFormatVersion, Users: users})
+}
+
+// saveRoles writes role permissions to roles.json in the current format and seals it.
+func saveRoles(path string, roles RolePermissions) error {
+	return writeSealedFile(path, &rolesFile{Version: RolesFormatVersion, Roles: roles})
+}
+
 // LoadUsersOrShowFirstRunMessage loads users or returns a first-run error with helpful message
 // This should be used by most commands instead of LoadUsers to avoid unexpected auto-setup
 func LoadUsersOrShowFirstRunMessage() (*UserStore, error) {
@@ -54,7 +76,7 @@ func LoadUsersOrShowFirstRunMessage() (*UserStore, error) {
 		return nil, err
 	}
 
-	users, err := loadUsers(usersPath)
+	users, groups, err := loadUsersAndGroups(usersPath)
 	if os.IsNotExist(err) {
 		// Return first-run error instead of auto-triggering setup
 		return nil, ErrFirstRunRequired
@@ -68,7 +90,7 @@ func LoadUsersOrShowFirstRunMessage() (*UserStore, error) {
 		return nil, fmt.Errorf("load roles.json: %w", err)
 	}
 
-	store := createUserStore(users, permissions)
+	store := createUserStore(users, permissions).withGroups(groups).withConfigDir(filepath.Dir(usersPath))
 	return store, nil
 }
 
@@ -80,7 +102,7 @@ func LoadUsers() (*UserStore, bool, string, error) {
 		return nil, false, "", err
 	}
 
-	users, err := loadUsers(usersPath)
+	users, groups, err := loadUsersAndGroups(usersPath)
 	if os.IsNotExist(err) {
 		// Check first-run eligibility
 		if err := validateFirstRunEligibility(); err != nil {
@@ -101,7 +123,7 @@ func LoadUsers() (*UserStore, bool, string, error) {
 		return nil, false, "", fmt.Errorf("load roles.json: %w", err)
 	}
 
-	store := createUserStore(users, permissions)
+	store := createUserStore(users, permissions).withGroups(groups).withConfigDir(filepath.Dir(usersPath))
 	return store, false, "", nil
 }
 
@@ -113,9 +135,9 @@ func LoadUsersForAuth() (*UserStore, error) {
 		return nil, err
 	}
 
-	users, err := loadUsers(usersPath)
+	users, groups, err := loadUsersAndGroups(usersPath)
 	if os.IsNotExist(err) {
-		return nil, fmt.Errorf("authentication failed: invalid token or no users configured")
+		return nil, fmt.Errorf("authentication failed: %w or no users configured", ErrInvalidToken)
 	}
 	if err != nil {
 		return nil, err
@@ -126,27 +148,100 @@ func LoadUsersForAuth() (*UserStore, error) {
 		return nil, fmt.Errorf("load roles.json: %w", err)
 	}
 
-	store := createUserStore(users, permissions)
+	store := createUserStore(users, permissions).withGroups(groups).withConfigDir(filepath.Dir(usersPath))
 	return store, nil
 }
 
 // UserStore methods for runtime operations
 
-// Lookup finds a user by token
+// Lookup finds a user by token, rejecting expired tokens with ErrTokenExpired
 func (us *UserStore) Lookup(token string) (*User, error) {
+	return us.LookupFrom(token, LocalAuthClient())
+}
+
+// LookupFrom finds a user by a token presented by the named client, such as a server's
+// remote address. Failed attempts are counted against the client, see auth_failures.go.
+func (us *UserStore) LookupFrom(token, client string) (*User, error) {
+	user, _, err := us.authenticateFrom(token, client, time.Now())
+	return user, err
+}
+
+// authenticate finds the user and token matching a token value presented by this process
+func (us *UserStore) authenticate(token string, now time.Time) (*User, tokenMatch, error) {
+	return us.authenticateFrom(token, LocalAuthClient(), now)
+}
+
+// verifyToken finds the user and token matching a token value and checks the token's expiry
+func (us *UserStore) verifyToken(token string, now time.Time) (*User, tokenMatch, error) {
 	if token == "" {
-		return nil, errors.New("empty token")
+		return nil, tokenMatch{}, errors.New("empty token")
+	}
+	if us.lifetimeErr != nil {
+		return nil, tokenMatch{}, us.lifetimeErr
 	}
+
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+	if IsDerivedToken(token) {
+		return us.authenticateDerived(token, now)
+	}
+
+	// Structured tokens are found by their ID; legacy tokens are checked against every user.
+	// A value that fails the structured parse may be a legacy token that happens to start
+	// with ss_, so it is checked too and reported as malformed only if nothing matches.
+	lookupID, malformed := ParseStructuredToken(token)
+	candidates := us.users
+	if u, ok := us.index[lookupID]; ok && malformed == nil {
+		candidates = []*User{u}
+	}
+
 	tokenHash := HashToken(token)
+	for _, u := range candidates {
+		id, ok := u.matchToken(tokenHash)
+		if !ok {
+			if previous := u.matchPreviousToken(tokenHash); previous != nil {
+				return authenticatePrevious(u, previous, now)
+			}
+			continue
+		}
+		match := us.lifetime.describeToken(u, id)
+		if err := u.checkActive(); err != nil {
+			return nil, match, err
+		}
+		if err := checkExpiry(u, match, now); err != nil {
+			return nil, match, err
+		}
+		us.recordTokenUse(tokenHash, now)
+		return u, match, nil
+	}
+	if malformed != nil {
+		return nil, tokenMatch{}, malformed
+	}
+	return nil, tokenMatch{}, ErrInvalidToken
+}
 
+// recordTokenUse notes the token's last use. Callers must hold the lock.
+func (us *UserStore) recordTokenUse(tokenHash string, now time.Time) {
+	if us.usage == nil {
+		return
+	}
+	var live []string
+	for _, u := range us.users {
+		live = append(live, u.tokenHashes()...)
+	}
+	us.usage.record(tokenHash, now, live)
+}
+
+// FindUser returns the user with the given username
+func (us *UserStore) FindUser(username string) (*User, error) {
 	us.mu.RLock()
 	defer us.mu.RUnlock()
 	for _, u := range us.users {
-		if subtle.ConstantTimeCompare([]byte(tokenHash), []byte(u.TokenHash)) == 1 {
+		if u.Username == username {
 			return u, nil
 		}
 	}
-	return nil, errors.New("invalid token")
+	return nil, fmt.Errorf("user %q not found", username)
 }
 
 // Permissions returns the role permissions
@@ -168,15 +263,9 @@ func (us *UserStore) CreateUser(username, role string) (string, error) {
 		}
 	}
 
-	// Parse role string
-	var userRole Role
-	switch role {
-	case "admin":
-		userRole = RoleAdmin
-	case "reader":
-		userRole = RoleReader
-	default:
-		return "", fmt.Errorf("invalid role %q: must be 'admin' or 'reader'", role)
+	userRole, err := us.permissions.resolveRole(role)
+	if err != nil {
+		return "", err
 	}
 
 	// Generate secure token
@@ -185,17 +274,11 @@ func (us *UserStore) CreateUser(username, role string) (string, error) {
 		return "", fmt.Errorf("failed to generate token: %w", err)
 	}
 
-	tokenHash := HashToken(token)
-	now := time.Now()
-
-	newUser := &User{
-		Username:       username,
-		TokenHash:      tokenHash,
-		Role:           userRole,
-		TokenRotatedAt: &now,
-	}
+	newUser := &User{Username: username, Role: userRole, Status: StatusActive}
+	newUser.SetToken(token)
 
 	us.users = append(us.users, newUser)
+	us.indexUser(newUser)
 	return token, nil
 }
 
@@ -206,13 +289,15 @@ func (us *UserStore) DeleteUser(username string) error {
 
 	for i, u := range us.users {
 		if u.Username == username {
-			// Prevent deleting the last admin user
-			if u.Role == RoleAdmin && us.countAdminUsers() <= 1 {
+			// Prevent deleting the last admin user, counting admins granted by groups
+			if u.IsAdmin() && us.countAdminUsers() <= 1 {
 				return fmt.Errorf("cannot delete the last admin user")
 			}
 
-			// Remove user from slice
+			// Remove user from slice and from their groups
 			us.users = slices.Delete(us.users, i, i+1)
+			us.removeFromGroups(username)
+			us.rebuildIndex()
 			return nil
 		}
 	}
@@ -225,25 +310,20 @@ func (us *UserStore) UpdateUserRole(username, newRole string) error {
 	us.mu.Lock()
 	defer us.mu.Unlock()
 
-	// Parse role string
-	var role Role
-	switch newRole {
-	case "admin":
-		role = RoleAdmin
-	case "reader":
-		role = RoleReader
-	default:
-		return fmt.Errorf("invalid role %q: must be 'admin' or 'reader'", newRole)
+	role, err := us.permissions.resolveRole(newRole)
+	if err != nil {
+		return err
 	}
 
 	for _, u := range us.users {
 		if u.Username == username {
-			// Prevent changing the last admin user to a non-admin role
-			if u.Role == RoleAdmin && role != RoleAdmin && us.countAdminUsers() <= 1 {
+			// Prevent changing the last admin user to a non-admin role, unless a group keeps them admin
+			if u.Role == RoleAdmin && role != RoleAdmin && !slices.Contains(u.GroupRoles, RoleAdmin) && us.countAdminUsers() <= 1 {
 				return fmt.Errorf("cannot change role of the last admin user")
 			}
 
 			u.Role = role
+			applyGroups(us.users, us.groups)
 			return nil
 		}
 	}
@@ -264,10 +344,10 @@ func (us *UserStore) RotateUserToken(username string) (string, error) {
 				return "", fmt.Errorf("failed to generate token: %w", err)
 			}
 
-			// Update the user's token hash
-			u.TokenHash = HashToken(token)
-			now := time.Now()
-			u.TokenRotatedAt = &now
+			// Update the user's token hash, ending any grace period of the previous one
+			u.RetireToken(DefaultTokenName, TokenGrace{})
+			u.SetToken(token)
+			us.indexUser(u)
 
 			return token, nil
 		}
@@ -276,13 +356,15 @@ func (us *UserStore) RotateUserToken(username string) (string, error) {
 	return "", fmt.Errorf("user %q not found", username)
 }
 
-func (us *UserStore) DisableUserToken(username string) error {
+// DisableUserToken disables a user, revoking every token. by is the username of the
+// admin disabling the account and reason is recorded with it.
+func (us *UserStore) DisableUserToken(username, by, reason string) error {
 	us.mu.Lock()
 	defer us.mu.Unlock()
 
 	for _, u := range us.users {
 		if u.Username == username {
-			u.DisableToken()
+			u.DisableToken(by, reason)
 			return nil
 		}
 	}
@@ -290,17 +372,27 @@ func (us *UserStore) DisableUserToken(username string) error {
 	return fmt.Errorf("user %q not found", username)
 }
 
-// DisableUserByToken disables a user by their token value and returns the username (no authentication - used by service layer)
-func (us *UserStore) DisableUserByToken(tokenValue string) (string, error) {
+// DisableUserByToken revokes the token with the given value and returns its user's username
+// (no authentication - used by service layer). A user left without tokens is disabled.
+func (us *UserStore) DisableUserByToken(tokenValue, by string) (string, error) {
 	us.mu.Lock()
 	defer us.mu.Unlock()
 
 	tokenHash := HashToken(tokenValue)
 	for _, u := range us.users {
-		if u.TokenHash == tokenHash {
-			u.DisableToken()
+		if previous := u.matchPreviousToken(tokenHash); previous != nil {
+			u.dropPreviousToken(previous.Token)
 			return u.Username, nil
 		}
+		id, ok := u.matchToken(tokenHash)
+		if !ok {
+			continue
+		}
+		u.revokeToken(id)
+		if !u.hasTokens() {
+			u.disable(by, "its last token was disabled")
+		}
+		return u.Username, nil
 	}
 	return "", fmt.Errorf("token not found or already disabled")
 }
@@ -323,8 +415,13 @@ func (us *UserStore) EnableUserToken(username string) (string, error) {
 		return "", fmt.Errorf("user '%s' not found", username)
 	}
 
-	// Check if user is actually disabled (has empty token hash)
-	if targetUser.TokenHash != "" {
+	// Only disabled accounts are enabled; an active user whose default token was revoked
+	// still holds their other tokens, and a locked one is unlocked with 'user update'
+	switch targetUser.AccountStatus() {
+	case StatusDisabled:
+	case StatusLocked:
+		return "", fmt.Errorf("user '%s' is locked, not disabled - unlock it with 'simple-secrets user update %s --status active'", username, username)
+	default:
 		return "", fmt.Errorf("user '%s' is not disabled - use 'rotate token' to generate a new token for active users", username)
 	}
 
@@ -334,10 +431,10 @@ func (us *UserStore) EnableUserToken(username string) (string, error) {
 		return "", fmt.Errorf("generate token: %w", err)
 	}
 
-	// Update user with new token hash
-	targetUser.TokenHash = HashToken(newToken)
-	now := time.Now()
-	targetUser.TokenRotatedAt = &now
+	// Issue the token and clear the disabled status and its record
+	targetUser.SetToken(newToken)
+	targetUser.setStatus(StatusActive, "", "")
+	us.indexUser(targetUser)
 
 	return newToken, nil
 }
@@ -346,17 +443,57 @@ func (us *UserStore) EnableUserToken(username string) (string, error) {
 
 // createUserStore constructs a UserStore with the given users and permissions
 func createUserStore(users []*User, permissions RolePermissions) *UserStore {
-	return &UserStore{
+	store := &UserStore{
 		users:       users,
 		permissions: permissions,
+		lifetime:    TokenLifetimePolicy{WarningPeriod: DefaultTokenExpiryWarning},
 	}
+	store.rebuildIndex()
+	return store
 }
 
-// countAdminUsers returns the number of admin users (helper for validation)
+// rebuildIndex maps every token lookup ID to its user. Callers must hold the lock.
+func (us *UserStore) rebuildIndex() {
+	us.index = make(map[string]*User)
+	for _, u := range us.users {
+		us.indexUser(u)
+	}
+}
+
+// indexUser adds the lookup IDs of a user's tokens to the index. IDs of replaced tokens
+// may stay behind; they still lead to the right user and fail the hash check.
+// Callers must hold the lock.
+func (us *UserStore) indexUser(u *User) {
+	if u.TokenLookupID != "" {
+		us.index[u.TokenLookupID] = u
+	}
+	for _, t := range u.Tokens {
+		if t.LookupID != "" {
+			us.index[t.LookupID] = u
+		}
+	}
+	for _, p := range u.PreviousTokens {
+		if p.LookupID != "" {
+			us.index[p.LookupID] = u
+		}
+	}
+}
+
+// withConfigDir records token last-used times and failed authentications in configDir,
+// enforces its token lifetime settings and accepts derived tokens signed with its master key
+func (us *UserStore) withConfigDir(configDir string) *UserStore {
+	us.usage = newTokenUsage(configDir)
+	us.failures = newAuthFailures(configDir)
+	us.lifetime, us.lifetimeErr = loadTokenLifetimePolicy(configDir)
+	us.derived = newDerivedTokens(configDir)
+	return us
+}
+
+// countAdminUsers returns the number of admin users, including admins through a group
 func (us *UserStore) countAdminUsers() int {
 	count := 0
 	for _, u := range us.users {
-		if u.Role == RoleAdmin {
+		if u.IsAdmin() {
 			count++
 		}
 	}
@@ -380,35 +517,79 @@ func resolveConfigPaths() (string, string, error) {
 	return usersPath, rolesPath, nil
 }
 
+// usersFile is the on-disk layout of users.json
+type usersFile struct {
+	Version  int     `json:"version"`
+	Users    []*User `json:"users"`
+	Revision uint64  `json:"revision,omitempty"` // sealed write counter, see integrity.go
+	MAC      string  `json:"mac,omitempty"`      // integrity seal, see integrity.go
+}
+
+// rolesFile is the on-disk layout of roles.json
+type rolesFile struct {
+	Version  int             `json:"version"`
+	Roles    RolePermissions `json:"roles"`
+	Revision uint64          `json:"revision,omitempty"` // sealed write counter, see integrity.go
+	MAC      string          `json:"mac,omitempty"`      // integrity seal, see integrity.go
+}
+
 // loadUsers reads and validates users from the specified JSON file
 func loadUsers(path string) ([]*User, error) {
-	var users []*User
-	if err := readConfigFile(path, &users); err != nil {
-		if os.IsNotExist(err) {
-			return nil, err
+	users, _, err := loadUsersAndGroups(path)
+	return users, err
+}
+
+// loadUsersAndGroups reads users and the groups.json next to them, applying the groups
+// to the users before they are validated, so an admin through a group counts as an admin
+func loadUsersAndGroups(path string) ([]*User, []*Group, error) {
+	var file usersFile
+	if err := readConfigFile(path, usersFormat, &file); err != nil {
+		if os.IsNotExist(err) || errors.Is(err, ErrNewerFormat) {
+			return nil, nil, err
 		}
-		return nil, fmt.Errorf("users.json is corrupted or invalid: %w; please fix or delete the file", err)
+		return nil, nil, fmt.Errorf("users.json is corrupted or invalid: %w; please fix or delete the file", err)
+	}
+	if err := verifyDocument(path, &file); err != nil {
+		return nil, nil, err
+	}
+
+	groups, err := loadGroups(groupsPathFor(path))
+	if err != nil {
+		return nil, nil, err
 	}
+	applyGroups(file.Users, groups)
 
-	return validateUsersList(users)
+	users, err := validateUsersList(file.Users)
+	return users, groups, err
 }
 
 // loadRoles reads role permissions from the specified JSON file
 func loadRoles(path string) (RolePermissions, error) {
-	var perms RolePermissions
-	if err := readConfigFile(path, &perms); err != nil {
+	var file rolesFile
+	if err := readConfigFile(path, rolesFormat, &file); err != nil {
+		if errors.Is(err, ErrNewerFormat) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("unmarshal roles.json: %w", err)
 	}
-	return perms, nil
+	if err := verifyDocument(path, &file); err != nil {
+		return nil, err
+	}
+	return file.Roles, nil
 }
 
-// readConfigFile reads and unmarshals a JSON config file
-func readConfigFile(path string, target any) error {
+// readConfigFile reads a versioned JSON config file, upgrading older formats in memory
+func readConfigFile(path string, format *persistedFormat, target any) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, target)
+
+	current, err := format.upgrade(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(current, target)
 }
 
 // writeConfigFiles writes users and roles to their respective JSON files
@@ -417,11 +598,11 @@ func writeConfigFiles(usersPath, rolesPath string, users []*User, roles RolePerm
 		return err
 	}
 
-	if err := writeConfigFileSecurely(usersPath, users); err != nil {
+	if err := SaveUsersList(usersPath, users); err != nil {
 		return err
 	}
 
-	return writeConfigFileSecurely(rolesPath, roles)
+	return saveRoles(rolesPath, roles)
 }
 
 // writeConfigFileSecurely marshals and writes any config data to JSON with secure permissions
@@ -472,10 +653,10 @@ func checkForDuplicateUsernames(users []*User) error {
 	return nil
 }
 
-// ensureAdminExists validates that at least one admin user exists
+// ensureAdminExists validates that at least one admin user exists, directly or through a group
 func ensureAdminExists(users []*User) error {
 	for _, u := range users {
-		if u.Role == RoleAdmin {
+		if u.IsAdmin() {
 			return nil
 		}
 	}
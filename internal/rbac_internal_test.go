@@ -16,7 +16,6 @@ limitations under the License.
 package internal
 
 import (
-	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
@@ -128,15 +127,9 @@ func TestCreateDefaultRoles(t *testing.T) {
 	}
 
 	// Verify roles.json exists and contains expected roles
-	rolesData, err := os.ReadFile(rolesPath)
+	roles, err := loadRoles(rolesPath)
 	if err != nil {
-		t.Fatalf("failed to read roles.json: %v", err)
-	}
-
-	var roles RolePermissions
-	err = json.Unmarshal(rolesData, &roles)
-	if err != nil {
-		t.Fatalf("failed to unmarshal roles.json: %v", err)
+		t.Fatalf("failed to load roles.json: %v", err)
 	}
 
 	// Check admin role
@@ -285,12 +278,8 @@ func TestMethodExtractionConsistency(t *testing.T) {
 	}
 
 	// Read and compare structure (not tokens, which should be different)
-	rolesData1, _ := os.ReadFile(rolesPath1)
-	rolesData2, _ := os.ReadFile(rolesPath2)
-
-	var roles1, roles2 RolePermissions
-	json.Unmarshal(rolesData1, &roles1)
-	json.Unmarshal(rolesData2, &roles2)
+	roles1, _ := loadRoles(rolesPath1)
+	roles2, _ := loadRoles(rolesPath2)
 
 	// Roles should be identical in structure
 	if len(roles1) != len(roles2) {
@@ -0,0 +1,254 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// recoveryCodesFileName holds the hashes of the admin recovery codes and every recovery made
+const recoveryCodesFileName = "recovery_codes.json"
+
+const (
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 10 // 80 bits, 16 base32 characters shown in groups of four
+)
+
+// ErrInvalidRecoveryCode indicates a recovery code that is unknown or already used
+var ErrInvalidRecoveryCode = errors.New("invalid or already used recovery code")
+
+// recoveryCode is a recovery code as stored: only its hash, and when it was used
+type recoveryCode struct {
+	Hash   string     `json:"hash"`
+	UsedAt *time.Time `json:"used_at,omitempty"`
+}
+
+// RecoveryEvent records one use of a recovery code
+type RecoveryEvent struct {
+	At       time.Time `json:"at"`
+	Username string    `json:"username"` // the admin who was given a new token
+	Client   string    `json:"client"`
+}
+
+// recoveryCodesFile is the on-disk layout of recovery_codes.json
+type recoveryCodesFile struct {
+	Version     int             `json:"version"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	GeneratedBy string          `json:"generated_by,omitempty"` // empty for codes created by setup
+	Codes       []recoveryCode  `json:"codes"`
+	Recoveries  []RecoveryEvent `json:"recoveries,omitempty"`
+	Revision    uint64          `json:"revision,omitempty"` // sealed write counter, see integrity.go
+	MAC         string          `json:"mac,omitempty"`      // integrity seal, see integrity.go
+}
+
+// RecoveryStatus summarizes the recovery codes for 'recovery-codes status'
+type RecoveryStatus struct {
+	GeneratedAt *time.Time      `json:"generated_at,omitempty"`
+	GeneratedBy string          `json:"generated_by,omitempty"`
+	Remaining   int             `json:"remaining"`
+	Recoveries  []RecoveryEvent `json:"recoveries"`
+}
+
+// recoveryCodes keeps the admin recovery codes in recovery_codes.json
+type recoveryCodes struct {
+	path string
+}
+
+func newRecoveryCodes(configDir string) *recoveryCodes {
+	return &recoveryCodes{path: filepath.Join(configDir, recoveryCodesFileName)}
+}
+
+// load reads and verifies recovery_codes.json. A missing file means no codes, unless it was
+// sealed before. An unsealed file is refused whatever its format, since it was always
+// written sealed and adding a code to it would give admin access.
+func (rc *recoveryCodes) load() (*recoveryCodesFile, error) {
+	var file recoveryCodesFile
+	if err := readConfigFile(rc.path, recoveryCodesFormat, &file); err != nil {
+		if os.IsNotExist(err) {
+			if err := checkMissingSealedFile(rc.path); err != nil {
+				return nil, err
+			}
+			return &recoveryCodesFile{}, nil
+		}
+		return nil, fmt.Errorf("%s is corrupted or invalid: %w", recoveryCodesFileName, err)
+	}
+	if file.MAC == "" {
+		return nil, fmt.Errorf("%w: %s has no integrity seal", ErrTampered, recoveryCodesFileName)
+	}
+	if err := verifyDocument(rc.path, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// update applies a change to the codes under the file lock and saves them
+func (rc *recoveryCodes) update(change func(file *recoveryCodesFile) error) error {
+	lock, err := LockFile(rc.path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	file, err := rc.load()
+	if err != nil {
+		return err
+	}
+	if err := change(file); err != nil {
+		return err
+	}
+	file.Version = RecoveryCodesFormatVersion
+	return writeSealedFile(rc.path, file)
+}
+
+// issue replaces every code with a new set and returns the codes, which are not stored.
+// The record of past recoveries is kept.
+func (rc *recoveryCodes) issue(by string, now time.Time) ([]string, error) {
+	codes, hashes, err := newRecoveryCodeSet()
+	if err != nil {
+		return nil, err
+	}
+	err = rc.update(func(file *recoveryCodesFile) error {
+		file.GeneratedAt, file.GeneratedBy, file.Codes = now.UTC(), by, hashes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// consume marks an unused code as used and records the recovery. The code is compared
+// with every stored hash in constant time.
+func (rc *recoveryCodes) consume(code, username, client string, now time.Time) (remaining int, err error) {
+	hash := HashToken(normalizeRecoveryCode(code))
+	err = rc.update(func(file *recoveryCodesFile) error {
+		var match *recoveryCode
+		for i := range file.Codes {
+			c := &file.Codes[i]
+			if subtle.ConstantTimeCompare([]byte(c.Hash), []byte(hash)) == 1 && c.UsedAt == nil {
+				match = c
+			}
+		}
+		if match == nil {
+			return ErrInvalidRecoveryCode
+		}
+		at := now.UTC()
+		match.UsedAt = &at
+		file.Recoveries = append(file.Recoveries, RecoveryEvent{At: at, Username: username, Client: client})
+		remaining = file.unused()
+		return nil
+	})
+	return remaining, err
+}
+
+// status summarizes the codes and past recoveries
+func (rc *recoveryCodes) status() (*RecoveryStatus, error) {
+	file, err := rc.load()
+	if err != nil {
+		return nil, err
+	}
+	status := &RecoveryStatus{GeneratedBy: file.GeneratedBy, Remaining: file.unused(), Recoveries: file.Recoveries}
+	if len(file.Codes) > 0 {
+		status.GeneratedAt = &file.GeneratedAt
+	}
+	return status, nil
+}
+
+// unused counts the codes that can still be used
+func (f *recoveryCodesFile) unused() int {
+	count := 0
+	for _, c := range f.Codes {
+		if c.UsedAt == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// newRecoveryCodeSet generates a set of codes and their hashes
+func newRecoveryCodeSet() ([]string, []recoveryCode, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]recoveryCode, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+			return nil, nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+		encoded := tokenSecretEncoding.EncodeToString(raw)
+		codes[i] = fmt.Sprintf("%s-%s-%s-%s", encoded[:4], encoded[4:8], encoded[8:12], encoded[12:])
+		hashes[i] = recoveryCode{Hash: HashToken(encoded)}
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts a code with or without its dashes, in either case
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
+
+// recoveryTarget picks the admin a recovery is for: the named user, or the only admin.
+// Admins are counted like countAdminUsers does, including those given the role by a group.
+func (us *UserStore) recoveryTarget(username string) (*User, error) {
+	if username != "" {
+		user, err := us.FindUser(username)
+		if err != nil {
+			return nil, err
+		}
+		if !user.IsAdmin() {
+			return nil, fmt.Errorf("user %q does not have the admin role, directly or through a group", username)
+		}
+		return user, nil
+	}
+
+	var admins []string
+	for _, u := range us.Users() {
+		if u.IsAdmin() {
+			admins = append(admins, u.Username)
+		}
+	}
+	switch len(admins) {
+	case 0:
+		return nil, fmt.Errorf("no user has the admin role")
+	case 1:
+		return us.FindUser(admins[0])
+	}
+	return nil, fmt.Errorf("there are several admins (%s); choose one with --user", strings.Join(admins, ", "))
+}
+
+// recoverAdmin gives an admin a new token, ending their previous one, and reactivates a
+// disabled or locked account. Callers must hold users.json's lock.
+func (us *UserStore) recoverAdmin(user *User) (string, error) {
+	token, err := generateSecureToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	user.RetireToken(DefaultTokenName, TokenGrace{})
+	user.SetToken(token)
+	user.setStatus(StatusActive, "", "")
+	us.indexUser(user)
+	return token, nil
+}
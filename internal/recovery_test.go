@@ -0,0 +1,160 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecoverAdmin(t *testing.T) {
+	dir := newSealedInstallation(t)
+	codes, err := newRecoveryCodes(dir).issue("", time.Now())
+	if err != nil {
+		t.Fatalf("issue codes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("expected %d codes, got %d", recoveryCodeCount, len(codes))
+	}
+
+	service, err := NewService(WithStorageBackend(NewFilesystemBackend()), WithConfigDir(dir))
+	if err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+	if _, err := service.Recovery().RecoverAdmin("aaaa-bbbb-cccc-dddd", ""); !errors.Is(err, ErrInvalidRecoveryCode) {
+		t.Fatalf("expected ErrInvalidRecoveryCode, got %v", err)
+	}
+
+	// Codes are accepted without dashes and in upper case
+	recovery, err := service.Recovery().RecoverAdmin(strings.ToUpper(strings.ReplaceAll(codes[0], "-", "")), "")
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	if recovery.Username != "admin" || recovery.Remaining != recoveryCodeCount-1 {
+		t.Fatalf("unexpected recovery: %+v", recovery)
+	}
+	if _, err := service.Recovery().RecoverAdmin(codes[0], ""); !errors.Is(err, ErrInvalidRecoveryCode) {
+		t.Fatalf("a used code must not work again, got %v", err)
+	}
+
+	store, err := loadUserStoreFromConfigDir(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if user, err := store.Lookup(recovery.Token); err != nil || user.Username != "admin" {
+		t.Fatalf("the new token should belong to admin: %+v, %v", user, err)
+	}
+	if _, err := store.Lookup("admin-token"); err == nil {
+		t.Fatal("the previous admin token should stop working")
+	}
+
+	status, err := service.Recovery().Status(recovery.Token)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if len(status.Recoveries) != 1 || status.Recoveries[0].Username != "admin" || status.Remaining != recoveryCodeCount-1 {
+		t.Fatalf("the recovery should be recorded: %+v", status)
+	}
+	if _, err := service.Recovery().RegenerateCodes("bob-token"); err == nil {
+		t.Fatal("only admins may regenerate recovery codes")
+	}
+
+	regenerated, err := service.Recovery().RegenerateCodes(recovery.Token)
+	if err != nil {
+		t.Fatalf("regenerate: %v", err)
+	}
+	if _, err := service.Recovery().RecoverAdmin(codes[1], ""); !errors.Is(err, ErrInvalidRecoveryCode) {
+		t.Fatalf("codes replaced by regenerate must not work, got %v", err)
+	}
+	status, err = service.Recovery().Status(recovery.Token)
+	if err != nil || status.Remaining != recoveryCodeCount || len(status.Recoveries) != 1 || status.GeneratedBy != "admin" {
+		t.Fatalf("regenerate should keep the record of recoveries: %+v, %v", status, err)
+	}
+	if _, err := service.Recovery().RecoverAdmin(regenerated[0], "bob"); err == nil {
+		t.Fatal("recovery must be refused for a user without the admin role")
+	}
+}
+
+func TestRecoveryCodesRefuseEdits(t *testing.T) {
+	dir := newSealedInstallation(t)
+	if _, err := newRecoveryCodes(dir).issue("", time.Now()); err != nil {
+		t.Fatalf("issue codes: %v", err)
+	}
+	path := filepath.Join(dir, recoveryCodesFileName)
+
+	// A code of one's own added to the file, with its seal removed
+	file, err := newRecoveryCodes(dir).load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	file.Codes = append(file.Codes, recoveryCode{Hash: HashToken("attackercode")})
+	file.MAC = ""
+	if err := writeConfigFileSecurely(path, file); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	service, err := NewService(WithStorageBackend(NewFilesystemBackend()), WithConfigDir(dir))
+	if err != nil {
+		t.Fatalf("create service: %v", err)
+	}
+	if _, err := service.Recovery().RecoverAdmin("attackercode", ""); !errors.Is(err, ErrTampered) {
+		t.Fatalf("expected ErrTampered, got %v", err)
+	}
+}
+
+func TestRecoveryTargetWithSeveralAdmins(t *testing.T) {
+	store := createUserStore([]*User{
+		{Username: "admin", Role: RoleAdmin},
+		{Username: "ops", Role: RoleAdmin},
+		{Username: "bob", Role: RoleReader},
+	}, createDefaultRoles())
+
+	if _, err := store.recoveryTarget(""); err == nil || !strings.Contains(err.Error(), "--user") {
+		t.Fatalf("several admins should require --user, got %v", err)
+	}
+	if user, err := store.recoveryTarget("ops"); err != nil || user.Username != "ops" {
+		t.Fatalf("expected ops: %+v, %v", user, err)
+	}
+}
+
+func TestRecoveryTargetCountsGroupAdmins(t *testing.T) {
+	groups := []*Group{{Name: "ops", Roles: []Role{RoleAdmin}, Members: []string{"carol"}}}
+	store := createUserStore([]*User{
+		{Username: "admin", TokenHash: HashToken("admin-token"), Role: RoleAdmin},
+		{Username: "carol", TokenHash: HashToken("carol-token"), Role: RoleReader},
+	}, createDefaultRoles()).withGroups(groups)
+
+	if _, err := store.recoveryTarget(""); err == nil || !strings.Contains(err.Error(), "choose one with --user") {
+		t.Fatalf("an admin through a group should count as one of several admins, got %v", err)
+	}
+	if user, err := store.recoveryTarget("carol"); err != nil || user.Username != "carol" {
+		t.Fatalf("an admin through a group should be recoverable: %+v, %v", user, err)
+	}
+
+	store = createUserStore([]*User{
+		{Username: "bob", TokenHash: HashToken("bob-token"), Role: RoleReader},
+		{Username: "carol", TokenHash: HashToken("carol-token"), Role: RoleReader},
+	}, createDefaultRoles()).withGroups(groups)
+	if user, err := store.recoveryTarget(""); err != nil || user.Username != "carol" {
+		t.Fatalf("the only admin, through a group, needs no --user: %+v, %v", user, err)
+	}
+	if _, err := store.recoveryTarget("bob"); err == nil {
+		t.Fatal("recovery must be refused for a user without the admin role")
+	}
+}
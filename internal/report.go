@@ -0,0 +1,234 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"time"
+)
+
+// Token states shown by 'report access'
+const (
+	TokenStatusActive   = "active"
+	TokenStatusExpiring = "expiring" // within token_expiry_warning of its limit
+	TokenStatusExpired  = "expired"
+	TokenStatusGrace    = "grace" // a rotated-out token still accepted in its grace period
+)
+
+// AccessReport is the effective-permissions matrix of 'report access': what each user
+// may do on each key or namespace, and the state of the tokens they sign in with
+type AccessReport struct {
+	GeneratedAt time.Time    `json:"generated_at"`
+	Columns     []string     `json:"columns"` // keys, or the namespace patterns asked for
+	Namespaces  bool         `json:"namespaces"`
+	Users       []UserAccess `json:"users"`
+}
+
+// UserAccess is one user's row of an AccessReport
+type UserAccess struct {
+	Username string        `json:"username"`
+	Roles    []Role        `json:"roles"` // including roles inherited from groups
+	Groups   []string      `json:"groups,omitempty"`
+	Status   UserStatus    `json:"status"`
+	Tokens   []TokenAccess `json:"tokens"`
+	// CanSignIn is false for disabled and locked users and users without a usable
+	// token; they have no effective access, whatever their roles and policies grant
+	CanSignIn bool                  `json:"can_sign_in"`
+	Access    map[string]AccessCell `json:"access"` // by column
+}
+
+// TokenAccess describes one of a user's tokens in an AccessReport
+type TokenAccess struct {
+	Name       string     `json:"name"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"` // unknown for default tokens issued by early releases
+	AgeDays    *int       `json:"age_days,omitempty"`
+	Status     string     `json:"status"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// AccessCell lists the actions a user may take on one column of the report
+type AccessCell struct {
+	Actions []string `json:"actions"`           // allowed on every key of the column
+	Partial []string `json:"partial,omitempty"` // allowed on only some keys of a namespace
+}
+
+// Allows reports whether the action is allowed on every key of the cell's column
+func (c AccessCell) Allows(action string) bool {
+	return slices.Contains(c.Actions, action)
+}
+
+// AllowsSome reports whether the action is allowed on only some keys of the cell's column
+func (c AccessCell) AllowsSome(action string) bool {
+	return slices.Contains(c.Partial, action)
+}
+
+// accessColumn is a column of the report and the keys it is evaluated on
+type accessColumn struct {
+	name string
+	keys []string
+}
+
+// accessColumns returns one column per key, or one per namespace pattern. A namespace
+// is evaluated on the keys it matches; one that matches none is evaluated on the pattern
+// itself, standing for keys yet to be created.
+func accessColumns(keys, namespaces []string) ([]accessColumn, error) {
+	if len(namespaces) == 0 {
+		columns := make([]accessColumn, len(keys))
+		for i, key := range keys {
+			columns[i] = accessColumn{name: key, keys: []string{key}}
+		}
+		return columns, nil
+	}
+
+	columns := make([]accessColumn, 0, len(namespaces))
+	for _, pattern := range namespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q: %w", pattern, err)
+		}
+		column := accessColumn{name: pattern}
+		for _, key := range keys {
+			if ok, _ := path.Match(pattern, key); ok {
+				column.keys = append(column.keys, key)
+			}
+		}
+		if len(column.keys) == 0 {
+			column.keys = []string{pattern}
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// AccessReport builds the effective-permissions matrix of every user over keys, or over
+// the namespace patterns when any are given
+func (us *UserStore) AccessReport(engine *PolicyEngine, keys, namespaces []string, now time.Time) (*AccessReport, error) {
+	columns, err := accessColumns(keys, namespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := map[string]time.Time{}
+	if us.usage != nil {
+		usage = us.usage.load()
+	}
+
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	report := &AccessReport{GeneratedAt: now.UTC(), Columns: []string{}, Namespaces: len(namespaces) > 0, Users: []UserAccess{}}
+	for _, column := range columns {
+		report.Columns = append(report.Columns, column.name)
+	}
+
+	for _, u := range us.users {
+		row := UserAccess{
+			Username: u.Username,
+			Roles:    u.EffectiveRoles(),
+			Groups:   u.Groups,
+			Status:   u.AccountStatus(),
+			Tokens:   us.tokenAccess(u, usage, now),
+			Access:   make(map[string]AccessCell, len(columns)),
+		}
+		row.CanSignIn = row.Status == StatusActive && slices.ContainsFunc(row.Tokens, func(t TokenAccess) bool {
+			return t.Status != TokenStatusExpired
+		})
+		for _, column := range columns {
+			cell := AccessCell{Actions: []string{}}
+			if row.CanSignIn {
+				cell = evaluateColumn(engine, u, us.permissions, column.keys)
+			}
+			row.Access[column.name] = cell
+		}
+		report.Users = append(report.Users, row)
+	}
+	return report, nil
+}
+
+// evaluateColumn decides each action on every key of a column
+func evaluateColumn(engine *PolicyEngine, u *User, perms RolePermissions, keys []string) AccessCell {
+	cell := AccessCell{Actions: []string{}}
+	for _, action := range policyActions {
+		allowed := 0
+		for _, key := range keys {
+			if engine.Evaluate(u, perms, action, key).Allowed {
+				allowed++
+			}
+		}
+		switch {
+		case allowed == len(keys):
+			cell.Actions = append(cell.Actions, action)
+		case allowed > 0:
+			cell.Partial = append(cell.Partial, action)
+		}
+	}
+	return cell
+}
+
+// tokenAccess describes a user's tokens, the default token first and tokens in a grace
+// period last. Callers must hold the lock.
+func (us *UserStore) tokenAccess(u *User, usage map[string]time.Time, now time.Time) []TokenAccess {
+	tokens := []TokenAccess{}
+	describe := func(id, hash string, createdAt *time.Time) {
+		match := us.lifetime.describeToken(u, id)
+		token := TokenAccess{
+			Name:       match.Name,
+			CreatedAt:  createdAt,
+			AgeDays:    ageDays(createdAt, now),
+			Status:     TokenStatusActive,
+			LastUsedAt: lastUsedAt(usage, hash),
+			ExpiresAt:  match.ExpiresAt,
+		}
+		if match.ExpiresAt != nil {
+			switch {
+			case !now.Before(*match.ExpiresAt):
+				token.Status = TokenStatusExpired
+			case match.ExpiresAt.Sub(now) <= us.lifetime.WarningPeriod:
+				token.Status = TokenStatusExpiring
+			}
+		}
+		tokens = append(tokens, token)
+	}
+
+	if u.TokenHash != "" {
+		describe(DefaultTokenName, u.TokenHash, u.TokenRotatedAt)
+	}
+	for _, t := range u.Tokens {
+		created := t.CreatedAt
+		describe(t.ID, t.Hash, &created)
+	}
+	for _, p := range u.PendingGrace(now) {
+		expires := p.ExpiresAt
+		tokens = append(tokens, TokenAccess{
+			Name:       u.TokenDisplayName(p.Token) + " (previous)",
+			Status:     TokenStatusGrace,
+			LastUsedAt: lastUsedAt(usage, p.Hash),
+			ExpiresAt:  &expires,
+		})
+	}
+	return tokens
+}
+
+// ageDays returns the whole days since t, or nil when t is unknown
+func ageDays(t *time.Time, now time.Time) *int {
+	if t == nil {
+		return nil
+	}
+	days := int(now.Sub(*t) / (24 * time.Hour))
+	return &days
+}
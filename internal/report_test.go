@@ -0,0 +1,91 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAccessReport(t *testing.T) {
+	service, _ := newRoleTestService(t)
+
+	for _, key := range []string{"app-db", "hr-db", "hr-payroll"} {
+		if err := service.Secrets().Put("admin-token", key, "value"); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+	if _, err := service.Policies().AddPolicy("admin-token", Policy{Effect: PolicyDeny, Subject: UserSubject("bob"), Actions: []string{ActionRead}, Keys: []string{"hr-payroll"}}); err != nil {
+		t.Fatalf("add policy: %v", err)
+	}
+
+	report, err := service.Reports().Access("admin-token", nil)
+	if err != nil {
+		t.Fatalf("access report: %v", err)
+	}
+	if !slices.Equal(report.Columns, []string{"app-db", "hr-db", "hr-payroll"}) {
+		t.Fatalf("expected one column per key, got %v", report.Columns)
+	}
+	bob := report.Users[slices.IndexFunc(report.Users, func(u UserAccess) bool { return u.Username == "bob" })]
+	if !bob.CanSignIn || len(bob.Tokens) != 1 || bob.Tokens[0].Status != TokenStatusActive {
+		t.Fatalf("bob should sign in with one active token: %+v", bob)
+	}
+	if got := bob.Access["hr-db"].Actions; !slices.Equal(got, []string{ActionRead, ActionList}) {
+		t.Errorf("a reader should read and list hr-db, got %v", got)
+	}
+	if got := bob.Access["hr-payroll"].Actions; !slices.Equal(got, []string{ActionList}) {
+		t.Errorf("the deny policy should leave bob only list on hr-payroll, got %v", got)
+	}
+
+	report, err = service.Reports().Access("admin-token", []string{"hr-*", "new-*"})
+	if err != nil {
+		t.Fatalf("access report by namespace: %v", err)
+	}
+	bob = report.Users[slices.IndexFunc(report.Users, func(u UserAccess) bool { return u.Username == "bob" })]
+	if cell := bob.Access["hr-*"]; !cell.Allows(ActionList) || !cell.AllowsSome(ActionRead) || cell.Allows(ActionWrite) {
+		t.Errorf("bob reads only some hr-* keys: %+v", cell)
+	}
+	if cell := bob.Access["new-*"]; !cell.Allows(ActionRead) {
+		t.Errorf("an empty namespace should be evaluated for keys yet to be created: %+v", cell)
+	}
+
+	if _, err := service.Reports().Access("admin-token", []string{"[oops"}); err == nil {
+		t.Error("an invalid namespace pattern should be rejected")
+	}
+	if _, err := service.Reports().Access("bob-token", nil); err == nil {
+		t.Error("a reader must not see the access report")
+	}
+}
+
+func TestAccessReportInactiveUsers(t *testing.T) {
+	service, _ := newRoleTestService(t)
+	if err := service.Secrets().Put("admin-token", "app-db", "value"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	locked := StatusLocked
+	if err := service.Users().UpdateUser("admin-token", "bob", UserUpdate{Status: &locked}); err != nil {
+		t.Fatalf("lock bob: %v", err)
+	}
+
+	report, err := service.Reports().Access("admin-token", nil)
+	if err != nil {
+		t.Fatalf("access report: %v", err)
+	}
+	bob := report.Users[slices.IndexFunc(report.Users, func(u UserAccess) bool { return u.Username == "bob" })]
+	if bob.CanSignIn || bob.Status != StatusLocked || len(bob.Access["app-db"].Actions) != 0 {
+		t.Fatalf("a locked user has no effective access: %+v", bob)
+	}
+}
@@ -16,7 +16,6 @@ limitations under the License.
 package internal
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -32,11 +31,11 @@ const (
 	DefaultRotationBackupCount = 1
 )
 
-// decryptAllSecrets decrypts all secrets with the current master key
+// decryptAllSecrets decrypts all secrets, enabled or disabled, with the current master key
 func (s *SecretsStore) decryptAllSecrets() (map[string][]byte, error) {
 	plaintexts := make(map[string][]byte, len(s.secrets))
-	for key, encValue := range s.secrets {
-		pt, err := decrypt(s.masterKey, encValue)
+	for key, record := range s.secrets {
+		pt, err := decrypt(s.masterKey, record.Value)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decrypt secret %q: %w", key, err)
 		}
@@ -45,15 +44,17 @@ func (s *SecretsStore) decryptAllSecrets() (map[string][]byte, error) {
 	return plaintexts, nil
 }
 
-// reencryptAllSecrets re-encrypts all plaintext secrets with a new key
-func (s *SecretsStore) reencryptAllSecrets(plaintexts map[string][]byte, newKey []byte) (map[string]string, error) {
-	newSecrets := make(map[string]string, len(plaintexts))
+// reencryptAllSecrets re-encrypts all plaintext secrets with a new key, preserving each secret's state
+func (s *SecretsStore) reencryptAllSecrets(plaintexts map[string][]byte, newKey []byte) (map[string]secretRecord, error) {
+	newSecrets := make(map[string]secretRecord, len(plaintexts))
 	for key, pt := range plaintexts {
 		enc, err := encrypt(newKey, pt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to encrypt secret %q: %w", key, err)
 		}
-		newSecrets[key] = enc
+		record := s.secrets[key]
+		record.Value = enc
+		newSecrets[key] = record
 	}
 	return newSecrets, nil
 }
@@ -117,7 +118,7 @@ func (s *SecretsStore) RotateMasterKey(backupDir string) error {
 	}
 	defer os.Remove(tmpKeyPath) // Clean up on error
 
-	newSecretsData, err := json.MarshalIndent(newSecrets, "", "  ")
+	newSecretsData, err := marshalSecretsFile(newSecrets)
 	if err != nil {
 		return fmt.Errorf("failed to marshal new secrets: %w", err)
 	}
@@ -139,10 +140,12 @@ func (s *SecretsStore) RotateMasterKey(backupDir string) error {
 	if err := s.reencryptBackups(oldKey, newKey); err != nil {
 		fmt.Printf("Warning: failed to re-encrypt some backups: %v\n", err)
 	}
+	if err := rekeyUserFileSeals(filepath.Dir(s.KeyPath), oldKey, newKey); err != nil {
+		fmt.Printf("Warning: failed to reseal user files with the new key: %v\n", err)
+	}
 
-	// 9) Clean up old backups
-	retentionCount := getRotationBackupCount()
-	if err := s.cleanupOldBackups(retentionCount); err != nil {
+	// 9) Clean up old backups, as backup_retention or rotation_backup_count says
+	if _, err := PruneBackups(filepath.Dir(s.KeyPath), false, time.Now()); err != nil {
 		fmt.Printf("Warning: failed to clean up old backups: %v\n", err)
 	}
 
@@ -223,29 +226,8 @@ func (s *SecretsStore) backupCurrent(dir string) error {
 // cleanupOldBackups removes old rotation backup directories, keeping only the most recent 'keep' number
 func (s *SecretsStore) cleanupOldBackups(keep int) error {
 	backupRoot := filepath.Join(filepath.Dir(s.KeyPath), "backups")
-	if _, err := os.Stat(backupRoot); os.IsNotExist(err) {
-		return nil // No backup directory exists
-	}
-
-	rotationDirs, err := s.scanRotationBackupDirectories(backupRoot)
-	if err != nil {
-		return err
-	}
-
-	// Sort by name (which includes timestamp) - newest first
-	sort.Slice(rotationDirs, func(i, j int) bool {
-		return rotationDirs[i] > rotationDirs[j]
-	})
-
-	// Remove old backups beyond the keep limit
-	for _, dirName := range rotationDirs[keep:] {
-		oldDir := filepath.Join(backupRoot, dirName)
-		if err := os.RemoveAll(oldDir); err != nil {
-			fmt.Printf("Warning: failed to remove old backup %s: %v\n", dirName, err)
-		}
-	}
-
-	return nil
+	_, err := pruneBackupDirectories(backupRoot, nil, keep, false, time.Now())
+	return err
 }
 
 // copyFileSecurely copies a file from src to dst with secure permissions
@@ -342,6 +324,9 @@ func (s *SecretsStore) RestoreFromBackup(backupName string) error {
 		return fmt.Errorf("failed to backup current state: %w", err)
 	}
 
+	// The user files are sealed with the current key and must follow the restored one
+	previousKey := s.masterKey
+
 	// Copy backup files to current locations
 	backupKeyPath := filepath.Join(backupPath, "master.key")
 	backupSecretsPath := filepath.Join(backupPath, "secrets.json")
@@ -358,6 +343,9 @@ func (s *SecretsStore) RestoreFromBackup(backupName string) error {
 	if err := s.loadOrCreateKey(); err != nil {
 		return fmt.Errorf("failed to load restored key: %w", err)
 	}
+	if err := rekeyUserFileSeals(filepath.Dir(s.KeyPath), previousKey, s.masterKey); err != nil {
+		return fmt.Errorf("failed to reseal user files with the restored key: %w", err)
+	}
 
 	if err := s.loadSecrets(); err != nil {
 		return fmt.Errorf("failed to load restored secrets: %w", err)
@@ -411,34 +399,6 @@ func (s *SecretsStore) validateSpecifiedBackup(backupName string) (string, error
 	return backupPath, nil
 }
 
-// getRotationBackupCount returns the configured rotation backup count or default
-func getRotationBackupCount() int {
-	// Try to load from config file
-	configPath, err := DefaultUserConfigPath("config.json")
-	if err != nil {
-		return DefaultRotationBackupCount
-	}
-
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return DefaultRotationBackupCount // Config file doesn't exist or can't be read
-	}
-
-	var config struct {
-		RotationBackupCount *int `json:"rotation_backup_count,omitempty"`
-	}
-	if err := json.Unmarshal(data, &config); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: config.json is corrupted (%v). Using default rotation_backup_count=%d\n", err, DefaultRotationBackupCount)
-		return DefaultRotationBackupCount // Invalid JSON, use default
-	}
-
-	if config.RotationBackupCount != nil && *config.RotationBackupCount > 0 {
-		return *config.RotationBackupCount
-	}
-
-	return DefaultRotationBackupCount
-}
-
 // parseBackupTimestamp extracts timestamp from backup directory names
 func parseBackupTimestamp(dirName string) time.Time {
 	timestampStr := extractTimestampString(dirName)
@@ -446,7 +406,8 @@ func parseBackupTimestamp(dirName string) time.Time {
 		return time.Time{} // Zero time for unparseable names
 	}
 
-	timestamp, err := time.Parse("20060102-150405", timestampStr)
+	// Names are written in local time
+	timestamp, err := time.ParseInLocation("20060102-150405", timestampStr, time.Local)
 	if err != nil {
 		return time.Time{} // Zero time for invalid timestamps
 	}
@@ -464,5 +425,9 @@ func extractTimestampString(dirName string) string {
 		return strings.TrimPrefix(dirName, "manual-")
 	}
 
+	if strings.HasPrefix(dirName, preRestorePrefix) {
+		return strings.TrimPrefix(dirName, preRestorePrefix)
+	}
+
 	return "" // Unknown format
 }
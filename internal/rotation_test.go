@@ -111,7 +111,7 @@ func TestRotateMasterKey_FailsCleanlyOnCorruption(t *testing.T) {
 	}
 
 	// Manually corrupt on-disk secrets to simulate unreadable/undecodable entry
-	onDisk := make(map[string]string)
+	var onDisk secretsFile
 	b, err := os.ReadFile(s.SecretsPath)
 	if err != nil {
 		t.Fatalf("read secrets: %v", err)
@@ -120,7 +120,7 @@ func TestRotateMasterKey_FailsCleanlyOnCorruption(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unmarshal: %v", err)
 	}
-	onDisk["bad"] = "!!!not-base64!!!"
+	onDisk.Secrets["bad"] = secretRecord{Value: "!!!not-base64!!!", State: SecretStateEnabled}
 
 	bad, _ := json.MarshalIndent(onDisk, "", "  ")
 	err = os.WriteFile(s.SecretsPath, bad, 0o600)
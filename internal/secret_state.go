@@ -0,0 +1,387 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SecretState is the lifecycle state of a stored secret
+type SecretState string
+
+const (
+	SecretStateEnabled  SecretState = "enabled"
+	SecretStateDisabled SecretState = "disabled"
+)
+
+// legacyDisabledPrefix marked disabled secrets before state was stored explicitly.
+// Keys with this prefix are migrated on load and can no longer be created.
+const legacyDisabledPrefix = "__DISABLED_"
+
+// ErrReservedKey indicates a secret key uses the reserved legacy disabled prefix
+var ErrReservedKey = fmt.Errorf("secret keys cannot start with the reserved prefix %q", legacyDisabledPrefix)
+
+// ErrSecretDisabled indicates a write was attempted against a disabled secret
+var ErrSecretDisabled = errors.New("secret is disabled; enable it before updating")
+
+// secretRecord is the persisted form of a single secret
+type secretRecord struct {
+	Value      string      `json:"value"` // base64(ciphertext)
+	State      SecretState `json:"state"`
+	DisabledAt *time.Time  `json:"disabled_at,omitempty"`
+	DisabledBy string      `json:"disabled_by,omitempty"`
+	Reason     string      `json:"reason,omitempty"`
+}
+
+// isEnabled reports whether the secret is available for normal operations
+func (r secretRecord) isEnabled() bool {
+	return r.State != SecretStateDisabled
+}
+
+// secretsFile is the on-disk layout of secrets.json
+type secretsFile struct {
+	Version int                     `json:"version"`
+	Secrets map[string]secretRecord `json:"secrets"`
+}
+
+// marshalSecretsFile encodes secret records as a current-version secrets.json
+func marshalSecretsFile(records map[string]secretRecord) ([]byte, error) {
+	return json.MarshalIndent(secretsFile{Version: SecretsFormatVersion, Secrets: records}, "", "  ")
+}
+
+// DisabledSecret describes a disabled secret and why it was disabled
+type DisabledSecret struct {
+	Key        string     `json:"key"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	DisabledBy string     `json:"disabled_by,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+}
+
+// validateSecretKey rejects keys that collide with the legacy disabled encoding
+func validateSecretKey(key string) error {
+	if strings.HasPrefix(key, legacyDisabledPrefix) {
+		return ErrReservedKey
+	}
+	return nil
+}
+
+// DisableSecret marks a secret as disabled without recording who disabled it or why
+func (s *SecretsStore) DisableSecret(key string) error {
+	return s.DisableSecretWithReason(key, "", "")
+}
+
+// DisableSecretWithReason marks a secret as disabled and records when, by whom and why
+func (s *SecretsStore) DisableSecretWithReason(key, disabledBy, reason string) error {
+	// Acquire file lock to prevent concurrent writes from other processes
+	lock, err := LockFile(s.SecretsPath)
+	if err != nil {
+		return fmt.Errorf("failed to acquire database lock: %w", err)
+	}
+	defer lock.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mergeWithDiskState(); err != nil {
+		return fmt.Errorf("failed to merge disk state: %w", err)
+	}
+
+	record, ok := s.secrets[key]
+	if !ok {
+		return ErrNotFound
+	}
+	if !record.isEnabled() {
+		return fmt.Errorf("secret %q is already disabled", key)
+	}
+
+	// Create backup before disabling
+	s.backupSecret(key, record.Value)
+
+	disabledAt := time.Now().UTC()
+	record.State = SecretStateDisabled
+	record.DisabledAt = &disabledAt
+	record.DisabledBy = disabledBy
+	record.Reason = strings.TrimSpace(reason)
+	s.secrets[key] = record
+
+	return s.saveSecretsLocked()
+}
+
+// EnableSecret re-enables a previously disabled secret
+func (s *SecretsStore) EnableSecret(key string) error {
+	// Acquire file lock to prevent concurrent writes from other processes
+	lock, err := LockFile(s.SecretsPath)
+	if err != nil {
+		return fmt.Errorf("failed to acquire database lock: %w", err)
+	}
+	defer lock.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mergeWithDiskState(); err != nil {
+		return fmt.Errorf("failed to merge disk state: %w", err)
+	}
+
+	record, ok := s.secrets[key]
+	if !ok || record.isEnabled() {
+		return fmt.Errorf("disabled secret not found")
+	}
+
+	s.secrets[key] = secretRecord{Value: record.Value, State: SecretStateEnabled}
+	return s.saveSecretsLocked()
+}
+
+// RenameDisabledBy updates the recorded user of secrets disabled by a renamed user
+func (s *SecretsStore) RenameDisabledBy(oldName, newName string) error {
+	lock, err := LockFile(s.SecretsPath)
+	if err != nil {
+		return fmt.Errorf("failed to acquire database lock: %w", err)
+	}
+	defer lock.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mergeWithDiskState(); err != nil {
+		return fmt.Errorf("failed to merge disk state: %w", err)
+	}
+
+	renamed := false
+	for key, record := range s.secrets {
+		if record.DisabledBy == oldName {
+			record.DisabledBy = newName
+			s.secrets[key] = record
+			renamed = true
+		}
+	}
+	if !renamed {
+		return nil
+	}
+	return s.saveSecretsLocked()
+}
+
+// ListDisabledSecrets returns a list of disabled secret keys
+func (s *SecretsStore) ListDisabledSecrets() []string {
+	details := s.ListDisabledSecretDetails()
+
+	disabled := make([]string, 0, len(details))
+	for _, detail := range details {
+		disabled = append(disabled, detail.Key)
+	}
+	return disabled
+}
+
+// ListDisabledSecretDetails returns disabled secrets with their disable metadata, sorted by key
+func (s *SecretsStore) ListDisabledSecretDetails() []DisabledSecret {
+	s.mu.RLock()
+	details := make([]DisabledSecret, 0)
+	for key, record := range s.secrets {
+		if record.isEnabled() {
+			continue
+		}
+		details = append(details, DisabledSecret{
+			Key:        key,
+			DisabledAt: record.DisabledAt,
+			DisabledBy: record.DisabledBy,
+			Reason:     record.Reason,
+		})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(details, func(i, j int) bool {
+		return details[i].Key < details[j].Key
+	})
+	return details
+}
+
+// SecretDescription is what 'describe' shows about a secret: its state and usage, never its value
+type SecretDescription struct {
+	Key          string      `json:"key"`
+	State        SecretState `json:"state"`
+	DisabledAt   *time.Time  `json:"disabled_at,omitempty"`
+	DisabledBy   string      `json:"disabled_by,omitempty"`
+	Reason       string      `json:"reason,omitempty"`
+	HasBackup    bool        `json:"has_backup"`
+	Usage        SecretUsage `json:"usage"`
+	TrackedSince time.Time   `json:"tracked_since"` // when usage tracking started
+}
+
+// Describe returns a secret's state, enabled or disabled, and whether it has a backup
+func (s *SecretsStore) Describe(key string) (*SecretDescription, error) {
+	s.mu.RLock()
+	record, ok := s.secrets[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	description := &SecretDescription{Key: key, State: SecretStateEnabled}
+	if !record.isEnabled() {
+		description.State = SecretStateDisabled
+		description.DisabledAt, description.DisabledBy, description.Reason = record.DisabledAt, record.DisabledBy, record.Reason
+	}
+	_, err := os.Stat(s.getBackupPath(key))
+	description.HasBackup = err == nil
+	return description, nil
+}
+
+// IsEnabled checks if a secret is enabled (not disabled)
+func (s *SecretsStore) IsEnabled(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, exists := s.secrets[key]
+	return exists && record.isEnabled()
+}
+
+// ====================================
+// Secrets File Decoding and Legacy Migration
+// ====================================
+
+// decodeSecretsFile parses secrets.json, upgrading older formats in memory.
+// The returned flag reports whether the file on disk still needs migrating.
+func decodeSecretsFile(data []byte) (map[string]secretRecord, bool, error) {
+	outdated := secretsFormat.isOutdated(data)
+
+	current, err := secretsFormat.upgrade(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var file secretsFile
+	if err := json.Unmarshal(current, &file); err != nil {
+		return nil, false, err
+	}
+	if file.Secrets == nil {
+		file.Secrets = make(map[string]secretRecord)
+	}
+	return file.Secrets, outdated, nil
+}
+
+// isLegacySecretsLayout reports whether secrets.json is the old flat key -> ciphertext map.
+// Legacy values are always strings, so a "secrets" object can only mean a records document.
+func isLegacySecretsLayout(fields map[string]json.RawMessage) bool {
+	raw, ok := fields["secrets"]
+	if !ok {
+		return len(fields) > 0
+	}
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) == 0 || trimmed[0] != '{'
+}
+
+// convertLegacySecrets converts the flat legacy layout into explicit secret records.
+// Both disabled encodings are understood: __DISABLED_{"timestamp":...,"key":...} and
+// the older __DISABLED_<tag>_<key>. When an enabled and a disabled copy of the same
+// key exist, the enabled copy wins and the other value is returned as displaced.
+func convertLegacySecrets(fields map[string]json.RawMessage) (map[string]secretRecord, map[string]string, error) {
+	records := make(map[string]secretRecord, len(fields))
+	displaced := make(map[string]string)
+
+	storedKeys := make([]string, 0, len(fields))
+	for storedKey := range fields {
+		storedKeys = append(storedKeys, storedKey)
+	}
+	sort.Strings(storedKeys)
+
+	var disabledKeys []string
+	for _, storedKey := range storedKeys {
+		if strings.HasPrefix(storedKey, legacyDisabledPrefix) {
+			disabledKeys = append(disabledKeys, storedKey)
+			continue
+		}
+		value, err := decodeLegacyValue(storedKey, fields[storedKey])
+		if err != nil {
+			return nil, nil, err
+		}
+		records[storedKey] = secretRecord{Value: value, State: SecretStateEnabled}
+	}
+
+	for _, storedKey := range disabledKeys {
+		value, err := decodeLegacyValue(storedKey, fields[storedKey])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		key, disabledAt := parseLegacyDisabledKey(storedKey)
+		if key == "" {
+			return nil, nil, fmt.Errorf("unrecognized legacy disabled secret entry %q", storedKey)
+		}
+
+		candidate := secretRecord{Value: value, State: SecretStateDisabled, DisabledAt: disabledAt}
+		existing, exists := records[key]
+		if exists && !supersedesLegacyRecord(candidate, existing) {
+			displaced[key] = value
+			continue
+		}
+		if exists {
+			displaced[key] = existing.Value
+		}
+		records[key] = candidate
+	}
+
+	return records, displaced, nil
+}
+
+// supersedesLegacyRecord reports whether a migrated disabled record should replace an existing one.
+// Enabled records always win; between disabled copies the most recent one wins.
+func supersedesLegacyRecord(candidate, existing secretRecord) bool {
+	if existing.isEnabled() {
+		return false
+	}
+	if existing.DisabledAt == nil {
+		return true
+	}
+	return candidate.DisabledAt != nil && candidate.DisabledAt.After(*existing.DisabledAt)
+}
+
+func decodeLegacyValue(storedKey string, raw json.RawMessage) (string, error) {
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("legacy secret %q does not hold an encrypted string: %w", storedKey, err)
+	}
+	return value, nil
+}
+
+// parseLegacyDisabledKey extracts the original key, and the disable time when recorded
+func parseLegacyDisabledKey(storedKey string) (string, *time.Time) {
+	data := strings.TrimPrefix(storedKey, legacyDisabledPrefix)
+
+	var keyData struct {
+		Timestamp int64  `json:"timestamp"`
+		Key       string `json:"key"`
+	}
+	if err := json.Unmarshal([]byte(data), &keyData); err == nil && keyData.Key != "" {
+		if keyData.Timestamp == 0 {
+			return keyData.Key, nil
+		}
+		disabledAt := time.Unix(0, keyData.Timestamp).UTC()
+		return keyData.Key, &disabledAt
+	}
+
+	_, key, found := strings.Cut(data, "_")
+	if !found {
+		return "", nil
+	}
+	return key, nil
+}
@@ -0,0 +1,169 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDisableSecretWithReason_RecordsMetadata(t *testing.T) {
+	s := newTempStore(t)
+
+	if err := s.Put("api-key", "value"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	before := time.Now().UTC().Add(-time.Second)
+	if err := s.DisableSecretWithReason("api-key", "alice", "  leaked in CI logs "); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+
+	details := s.ListDisabledSecretDetails()
+	if len(details) != 1 {
+		t.Fatalf("expected 1 disabled secret, got %v", details)
+	}
+	detail := details[0]
+	if detail.Key != "api-key" || detail.DisabledBy != "alice" || detail.Reason != "leaked in CI logs" {
+		t.Errorf("unexpected disable metadata: %+v", detail)
+	}
+	if detail.DisabledAt == nil || detail.DisabledAt.Before(before) {
+		t.Errorf("expected disabled_at to be recorded, got %v", detail.DisabledAt)
+	}
+
+	if err := s.DisableSecretWithReason("api-key", "alice", ""); err == nil {
+		t.Error("expected error disabling an already disabled secret")
+	}
+
+	// Metadata must survive a reload from disk
+	reloaded, err := LoadSecretsStore(NewFilesystemBackend())
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := reloaded.ListDisabledSecretDetails(); !reflect.DeepEqual(got, details) {
+		t.Errorf("disable metadata not persisted: got %+v, want %+v", got, details)
+	}
+
+	// Enabling clears the metadata
+	if err := reloaded.EnableSecret("api-key"); err != nil {
+		t.Fatalf("enable: %v", err)
+	}
+	if err := reloaded.DisableSecret("api-key"); err != nil {
+		t.Fatalf("disable without reason: %v", err)
+	}
+	if got := reloaded.ListDisabledSecretDetails()[0]; got.Reason != "" || got.DisabledBy != "" {
+		t.Errorf("expected metadata from the previous disable to be cleared, got %+v", got)
+	}
+}
+
+func TestPut_RejectsReservedAndDisabledKeys(t *testing.T) {
+	s := newTempStore(t)
+
+	if err := s.Put(legacyDisabledPrefix+"sneaky", "value"); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("expected ErrReservedKey, got %v", err)
+	}
+
+	if err := s.Put("paused", "value"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := s.DisableSecret("paused"); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	if err := s.Put("paused", "new-value"); !errors.Is(err, ErrSecretDisabled) {
+		t.Errorf("expected ErrSecretDisabled, got %v", err)
+	}
+	if err := s.SetFields("paused", map[string]string{"a": "b"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for field update on disabled secret, got %v", err)
+	}
+}
+
+func TestLoadSecretsStore_MigratesLegacyDisabledFormats(t *testing.T) {
+	s := newTempStore(t)
+
+	encryptValue := func(plaintext string) string {
+		t.Helper()
+		enc, err := encrypt(s.masterKey, []byte(plaintext))
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		return enc
+	}
+
+	disabledAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	jsonKey, _ := json.Marshal(map[string]any{"timestamp": disabledAt.UnixNano(), "key": "json_disabled"})
+
+	legacy := map[string]string{
+		"plain":                                encryptValue("plain-value"),
+		legacyDisabledPrefix + string(jsonKey): encryptValue("json-value"),
+		legacyDisabledPrefix + "1700000000_my_key": encryptValue("underscore-value"),
+		// Re-created after being disabled: the enabled copy must win
+		"both": encryptValue("enabled-copy"),
+		legacyDisabledPrefix + `{"timestamp":1,"key":"both"}`: encryptValue("disabled-copy"),
+	}
+	data, _ := json.MarshalIndent(legacy, "", "  ")
+	if err := os.WriteFile(s.SecretsPath, data, 0600); err != nil {
+		t.Fatalf("write legacy file: %v", err)
+	}
+
+	migrated, err := LoadSecretsStore(NewFilesystemBackend())
+	if err != nil {
+		t.Fatalf("load legacy store: %v", err)
+	}
+
+	if keys := migrated.ListKeys(); !reflect.DeepEqual(keys, []string{"both", "plain"}) {
+		t.Errorf("unexpected enabled keys after migration: %v", keys)
+	}
+	if disabled := migrated.ListDisabledSecrets(); !reflect.DeepEqual(disabled, []string{"json_disabled", "my_key"}) {
+		t.Errorf("unexpected disabled keys after migration: %v", disabled)
+	}
+
+	details := migrated.ListDisabledSecretDetails()
+	if details[0].DisabledAt == nil || !details[0].DisabledAt.Equal(disabledAt) {
+		t.Errorf("expected JSON-format timestamp to be preserved, got %v", details[0].DisabledAt)
+	}
+
+	if err := migrated.EnableSecret("my_key"); err != nil {
+		t.Fatalf("enable migrated secret: %v", err)
+	}
+	if value, _ := migrated.Get("my_key"); value != "underscore-value" {
+		t.Errorf("expected migrated value to decrypt, got %q", value)
+	}
+
+	// The displaced disabled copy is kept as a backup
+	backup, err := os.ReadFile(migrated.GetBackupPath("both"))
+	if err != nil {
+		t.Fatalf("expected displaced value to be backed up: %v", err)
+	}
+	if value, _ := migrated.DecryptBackup(string(backup)); value != "disabled-copy" {
+		t.Errorf("unexpected displaced backup value %q", value)
+	}
+
+	// The file is rewritten once, and the original is preserved
+	rewritten, _ := os.ReadFile(s.SecretsPath)
+	if strings.Contains(string(rewritten), legacyDisabledPrefix) {
+		t.Errorf("legacy prefix should not survive migration: %s", rewritten)
+	}
+	copies, _ := filepath.Glob(filepath.Join(migrated.getBackupDirectory(), "migrations", "secrets.json.v0-*"))
+	if len(copies) != 1 {
+		t.Errorf("expected one preserved copy of the legacy file, got %v", copies)
+	}
+}
@@ -0,0 +1,292 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Per-secret usage is kept outside secrets.json: each read or write appends a line to
+// secret_usage.log, which is folded into secret_usage.json once it grows past
+// secretUsageCompactSize
+const (
+	secretUsageFileName    = "secret_usage.json"
+	secretUsageLogName     = "secret_usage.log"
+	secretUsageCompactSize = 256 * 1024
+)
+
+// Operations recorded in secret_usage.log
+const (
+	usageRead   = "read"
+	usageWrite  = "write"
+	usageDelete = "delete" // forgets the secret's usage, so a new secret of that name starts afresh
+)
+
+// clientLabelPattern restricts --client labels to short identifiers such as ci-deploy
+var clientLabelPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:@/-]{0,63}$`)
+
+// clientLabel names the job or host this process runs for, set with --client
+var clientLabel struct {
+	sync.RWMutex
+	value string
+}
+
+// SetClientLabel sets the label recorded with each access made by this process
+func SetClientLabel(label string) error {
+	if label != "" && !clientLabelPattern.MatchString(label) {
+		return fmt.Errorf("invalid client label %q: use up to 64 letters, digits and '.', '-', '_', ':', '@' or '/'", label)
+	}
+	clientLabel.Lock()
+	defer clientLabel.Unlock()
+	clientLabel.value = label
+	return nil
+}
+
+// ClientLabel returns the label set with SetClientLabel, or ""
+func ClientLabel() string {
+	clientLabel.RLock()
+	defer clientLabel.RUnlock()
+	return clientLabel.value
+}
+
+// SecretUsage is how a secret has been used since tracking started, as shown by 'describe'
+type SecretUsage struct {
+	Reads         uint64            `json:"reads"`
+	LastReadAt    *time.Time        `json:"last_read_at,omitempty"`
+	LastReadBy    string            `json:"last_read_by,omitempty"`
+	LastClient    string            `json:"last_client,omitempty"`     // the --client label of the last read
+	ReadsByClient map[string]uint64 `json:"reads_by_client,omitempty"` // reads made with a --client label
+	LastWrittenAt *time.Time        `json:"last_written_at,omitempty"`
+}
+
+// secretUsageEvent is one line of secret_usage.log
+type secretUsageEvent struct {
+	Time   time.Time `json:"time"`
+	Op     string    `json:"op"`
+	Key    string    `json:"key"`
+	User   string    `json:"user,omitempty"`
+	Client string    `json:"client,omitempty"`
+}
+
+// secretUsageFile is the on-disk layout of secret_usage.json
+type secretUsageFile struct {
+	Version int                     `json:"version"`
+	Since   time.Time               `json:"since"` // when tracking started
+	Secrets map[string]*SecretUsage `json:"secrets"`
+}
+
+// apply adds an event to the usage of its secret
+func (f *secretUsageFile) apply(event secretUsageEvent) {
+	usage := f.Secrets[event.Key]
+	if usage == nil {
+		usage = &SecretUsage{}
+		f.Secrets[event.Key] = usage
+	}
+	at := event.Time
+	switch event.Op {
+	case usageRead:
+		usage.Reads++
+		usage.LastReadAt, usage.LastReadBy, usage.LastClient = &at, event.User, event.Client
+		if event.Client != "" {
+			if usage.ReadsByClient == nil {
+				usage.ReadsByClient = map[string]uint64{}
+			}
+			usage.ReadsByClient[event.Client]++
+		}
+	case usageWrite:
+		usage.LastWrittenAt = &at
+	case usageDelete:
+		delete(f.Secrets, event.Key)
+	}
+}
+
+// secretUsage records reads and writes of secrets. Recording is best effort: a failed
+// write is reported once on stderr and the operation itself still succeeds.
+type secretUsage struct {
+	dir    string
+	warned sync.Once
+}
+
+func newSecretUsage(configDir string) *secretUsage {
+	return &secretUsage{dir: configDir}
+}
+
+func (su *secretUsage) path() string    { return filepath.Join(su.dir, secretUsageFileName) }
+func (su *secretUsage) logPath() string { return filepath.Join(su.dir, secretUsageLogName) }
+
+// used records a read, write or delete of a secret by the user, unless it failed
+func (su *secretUsage) used(op, key string, user *User, err error) {
+	if su == nil || err != nil {
+		return
+	}
+	event := secretUsageEvent{Time: time.Now().UTC(), Op: op, Key: key, User: user.Username, Client: ClientLabel()}
+	if err := su.append(event); err != nil {
+		su.warned.Do(func() {
+			fmt.Fprintf(os.Stderr, "Warning: secret usage tracking: %v\n", err)
+		})
+	}
+}
+
+// append adds the event to secret_usage.log, folding the log into secret_usage.json once
+// it is large. Both take the lock, so no event is lost while the log is folded.
+func (su *secretUsage) append(event secretUsageEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	lock, err := LockFile(su.path())
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	file, err := os.OpenFile(su.logPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, secureFilePermissions)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(su.logPath()); err == nil && info.Size() >= secretUsageCompactSize {
+		return su.compactLocked()
+	}
+	return nil
+}
+
+// load returns the usage of every secret, from secret_usage.json and the log
+func (su *secretUsage) load() (*secretUsageFile, error) {
+	lock, err := LockFile(su.path())
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	return su.loadLocked()
+}
+
+// loadLocked reads secret_usage.json and replays the log over it. A missing file starts
+// tracking now. Callers must hold the lock.
+func (su *secretUsage) loadLocked() (*secretUsageFile, error) {
+	usage := &secretUsageFile{Version: SecretUsageFormatVersion}
+	if err := readConfigFile(su.path(), secretUsageFormat, usage); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s is corrupted or invalid: %w", secretUsageFileName, err)
+	}
+	if usage.Secrets == nil {
+		usage.Secrets = map[string]*SecretUsage{}
+	}
+
+	file, err := os.Open(su.logPath())
+	if os.IsNotExist(err) {
+		if usage.Since.IsZero() {
+			usage.Since = time.Now().UTC()
+		}
+		return usage, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event secretUsageEvent
+		if json.Unmarshal(scanner.Bytes(), &event) != nil {
+			continue // a line cut short by a crash
+		}
+		if usage.Since.IsZero() {
+			usage.Since = event.Time
+		}
+		usage.apply(event)
+	}
+	if usage.Since.IsZero() {
+		usage.Since = time.Now().UTC()
+	}
+	return usage, scanner.Err()
+}
+
+// compactLocked folds the log into secret_usage.json and empties it. Callers must hold the lock.
+func (su *secretUsage) compactLocked() error {
+	usage, err := su.loadLocked()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := AtomicWriteFile(su.path(), data, secureFilePermissions); err != nil {
+		return err
+	}
+	return os.Remove(su.logPath())
+}
+
+// StaleSecret is a secret not read or written for a while, as listed by 'report stale'
+type StaleSecret struct {
+	Key           string     `json:"key"`
+	LastUsedAt    *time.Time `json:"last_used_at,omitempty"` // the last read or write; nil if neither since tracking started
+	LastReadAt    *time.Time `json:"last_read_at,omitempty"`
+	LastReadBy    string     `json:"last_read_by,omitempty"`
+	LastWrittenAt *time.Time `json:"last_written_at,omitempty"`
+	Reads         uint64     `json:"reads"`
+	IdleDays      int        `json:"idle_days"` // since the last use, or since tracking started
+}
+
+// stale returns the keys neither read nor written within unusedFor, longest idle first.
+// A secret with no recorded use counts as idle since tracking started.
+func (su *secretUsage) stale(keys []string, unusedFor time.Duration, now time.Time) ([]StaleSecret, error) {
+	usage, err := su.load()
+	if err != nil {
+		return nil, err
+	}
+
+	stale := []StaleSecret{}
+	for _, key := range keys {
+		entry := StaleSecret{Key: key}
+		idleSince := usage.Since
+		if u := usage.Secrets[key]; u != nil {
+			entry.LastReadAt, entry.LastReadBy, entry.LastWrittenAt, entry.Reads = u.LastReadAt, u.LastReadBy, u.LastWrittenAt, u.Reads
+			for _, t := range []*time.Time{u.LastReadAt, u.LastWrittenAt} {
+				if t != nil && (entry.LastUsedAt == nil || t.After(*entry.LastUsedAt)) {
+					entry.LastUsedAt = t
+				}
+			}
+			if entry.LastUsedAt != nil {
+				idleSince = *entry.LastUsedAt
+			}
+		}
+		if now.Sub(idleSince) < unusedFor {
+			continue
+		}
+		entry.IdleDays = int(now.Sub(idleSince) / (24 * time.Hour))
+		stale = append(stale, entry)
+	}
+
+	sort.SliceStable(stale, func(i, j int) bool { return stale[i].IdleDays > stale[j].IdleDays })
+	return stale, nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSecretUsageTracksReads(t *testing.T) {
+	service, dir := newRoleTestService(t)
+	secretsBefore := func() []byte {
+		data, err := os.ReadFile(filepath.Join(dir, "secrets.json"))
+		if err != nil {
+			t.Fatalf("read secrets.json: %v", err)
+		}
+		return data
+	}
+
+	if err := service.Secrets().Put("admin-token", "db-password", "value"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	before := secretsBefore()
+
+	if _, err := service.Secrets().Get("bob-token", "db-password"); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if err := SetClientLabel("ci-deploy"); err != nil {
+		t.Fatalf("set client label: %v", err)
+	}
+	defer SetClientLabel("")
+	for range 2 {
+		if _, err := service.Secrets().Get("admin-token", "db-password"); err != nil {
+			t.Fatalf("get: %v", err)
+		}
+	}
+	if _, err := service.Secrets().Get("bob-token", "missing"); err == nil {
+		t.Fatal("reading a missing secret should fail")
+	}
+
+	if !bytes.Equal(before, secretsBefore()) {
+		t.Fatal("reads must not rewrite secrets.json")
+	}
+
+	description, err := service.Secrets().Describe("bob-token", "db-password")
+	if err != nil {
+		t.Fatalf("describe: %v", err)
+	}
+	usage := description.Usage
+	if usage.Reads != 3 || usage.LastReadBy != "admin" || usage.LastClient != "ci-deploy" || usage.ReadsByClient["ci-deploy"] != 2 {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+	if usage.LastReadAt == nil || usage.LastWrittenAt == nil || description.State != SecretStateEnabled {
+		t.Fatalf("expected read and write times: %+v", description)
+	}
+
+	// Deleting forgets the usage, so a new secret of the same name starts afresh
+	if err := service.Secrets().Delete("admin-token", "db-password"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := service.Secrets().Put("admin-token", "db-password", "new"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	description, err = service.Secrets().Describe("admin-token", "db-password")
+	if err != nil || description.Usage.Reads != 0 {
+		t.Fatalf("expected no reads after recreating the secret: %+v (%v)", description, err)
+	}
+
+	if err := SetClientLabel("not a label"); err == nil {
+		t.Error("labels with spaces should be rejected")
+	}
+}
+
+func TestSecretUsageCompaction(t *testing.T) {
+	usage := newSecretUsage(t.TempDir())
+	reader := &User{Username: "bob"}
+	for range 3 {
+		usage.used(usageRead, "api-key", reader, nil)
+	}
+
+	if err := usage.compactLocked(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if _, err := os.Stat(usage.logPath()); !os.IsNotExist(err) {
+		t.Fatalf("the log should be removed once folded, got %v", err)
+	}
+	usage.used(usageRead, "api-key", reader, nil)
+	usage.used(usageRead, "api-key", reader, os.ErrNotExist) // failed reads are not counted
+
+	file, err := usage.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := file.Secrets["api-key"].Reads; got != 4 {
+		t.Fatalf("expected 4 reads across the file and the log, got %d", got)
+	}
+}
+
+func TestStaleSecrets(t *testing.T) {
+	usage := newSecretUsage(t.TempDir())
+	now := time.Now()
+	old := now.Add(-100 * 24 * time.Hour)
+	for _, event := range []secretUsageEvent{
+		{Time: old, Op: usageWrite, Key: "old-key", User: "admin"},
+		{Time: old, Op: usageWrite, Key: "read-key", User: "admin"},
+		{Time: now.Add(-time.Hour), Op: usageRead, Key: "read-key", User: "bob"},
+	} {
+		if err := usage.append(event); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	stale, err := usage.stale([]string{"never-used", "old-key", "read-key"}, 90*24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("stale: %v", err)
+	}
+	// Tracking started with the first event, 100 days ago, so a secret never used since is stale too
+	if len(stale) != 2 || stale[0].IdleDays != 100 || stale[1].IdleDays != 100 {
+		t.Fatalf("expected old-key and never-used, got %+v", stale)
+	}
+	for _, s := range stale {
+		if s.Key == "read-key" {
+			t.Fatalf("read-key was read an hour ago: %+v", stale)
+		}
+	}
+}
@@ -16,12 +16,11 @@ limitations under the License.
 package internal
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -108,7 +107,6 @@ func (fs *FilesystemBackend) ensureDirectoryExists(filePath string) error {
 }
 
 const (
-	disabledPrefix = "__DISABLED_"
 	// File permission constants for clarity
 	secureFilePermissions      = 0600 // Owner read/write only
 	secureDirectoryPermissions = 0700 // Owner read/write/execute only
@@ -118,9 +116,9 @@ type SecretsStore struct {
 	KeyPath     string
 	SecretsPath string
 	masterKey   []byte
-	secrets     map[string]string // key -> base64(ciphertext)
-	mu          sync.RWMutex      // protects secrets map and masterKey
-	storage     StorageBackend    // injectable storage backend
+	secrets     map[string]secretRecord // key -> encrypted value and state
+	mu          sync.RWMutex            // protects secrets map and masterKey
+	storage     StorageBackend          // injectable storage backend
 }
 
 // LoadSecretsStore creates ~/.simple-secrets, loads key + secrets
@@ -135,7 +133,7 @@ func LoadSecretsStore(backend StorageBackend) (*SecretsStore, error) {
 	s := &SecretsStore{
 		KeyPath:     filepath.Join(dir, "master.key"),
 		SecretsPath: filepath.Join(dir, "secrets.json"),
-		secrets:     make(map[string]string),
+		secrets:     make(map[string]secretRecord),
 		storage:     backend,
 	}
 
@@ -156,7 +154,7 @@ func LoadSecretsStoreFromDir(backend StorageBackend, configDir string) (*Secrets
 	s := &SecretsStore{
 		KeyPath:     filepath.Join(configDir, "master.key"),
 		SecretsPath: filepath.Join(configDir, "secrets.json"),
-		secrets:     make(map[string]string),
+		secrets:     make(map[string]secretRecord),
 		storage:     backend,
 	}
 
@@ -179,9 +177,37 @@ func getConfigDirectory() (string, error) {
 	return GetSimpleSecretsPath()
 }
 
-// loadSecrets loads secrets from disk and updates in-memory state
+// loadSecrets loads secrets from disk and updates in-memory state.
+// A store in an older format is migrated in place on first load.
 func (s *SecretsStore) loadSecrets() error {
-	secrets, err := s.loadSecretsFromDisk()
+	secrets, outdated, err := s.loadSecretsFromDisk()
+	if err != nil {
+		return err
+	}
+	if outdated {
+		return s.migrateSecretsFile()
+	}
+
+	s.mu.Lock()
+	s.secrets = secrets
+	s.mu.Unlock()
+	return nil
+}
+
+// migrateSecretsFile upgrades secrets.json on disk through the migration registry
+// and reloads it. Another process may have migrated it while we waited for the lock.
+func (s *SecretsStore) migrateSecretsFile() error {
+	lock, err := LockFile(s.SecretsPath)
+	if err != nil {
+		return fmt.Errorf("failed to acquire database lock: %w", err)
+	}
+	defer lock.Unlock()
+
+	if _, err := migrateFile(secretsFormat, s.SecretsPath, s.getBackupDirectory(), false); err != nil {
+		return fmt.Errorf("failed to migrate secrets database: %w", err)
+	}
+
+	secrets, _, err := s.loadSecretsFromDisk()
 	if err != nil {
 		return err
 	}
@@ -192,19 +218,23 @@ func (s *SecretsStore) loadSecrets() error {
 	return nil
 }
 
-// loadSecretsFromDisk loads secrets from disk without modifying in-memory state
-func (s *SecretsStore) loadSecretsFromDisk() (map[string]string, error) {
+// loadSecretsFromDisk loads secrets from disk without modifying in-memory state.
+// The returned flag reports whether the file is in an older format.
+func (s *SecretsStore) loadSecretsFromDisk() (map[string]secretRecord, bool, error) {
 	if !s.storage.Exists(s.SecretsPath) {
-		return make(map[string]string), nil
+		return make(map[string]secretRecord), false, nil
 	}
 	b, err := s.storage.ReadFile(s.SecretsPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read secrets database from %s: %w", s.SecretsPath, err)
+		return nil, false, fmt.Errorf("failed to read secrets database from %s: %w", s.SecretsPath, err)
 	}
 
-	var secrets map[string]string
-	if err := json.Unmarshal(b, &secrets); err != nil {
-		return nil, fmt.Errorf("secrets database appears to be corrupted (JSON parse error: %v). "+
+	secrets, outdated, err := decodeSecretsFile(b)
+	if errors.Is(err, ErrNewerFormat) {
+		return nil, false, err
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("secrets database appears to be corrupted (JSON parse error: %v). "+
 			"Recovery options: "+
 			"Restore from backup: ./simple-secrets restore-database; "+
 			"List available backups: ./simple-secrets list backups; "+
@@ -212,12 +242,12 @@ func (s *SecretsStore) loadSecretsFromDisk() (map[string]string, error) {
 			"Do not delete ~/.simple-secrets/ - your backups contain recoverable data", err)
 	}
 
-	return secrets, nil
+	return secrets, outdated, nil
 }
 
 // saveSecretsLocked saves secrets to disk, assumes caller holds lock
 func (s *SecretsStore) saveSecretsLocked() error {
-	b, err := json.MarshalIndent(s.secrets, "", "  ")
+	b, err := marshalSecretsFile(s.secrets)
 	if err != nil {
 		return fmt.Errorf("failed to serialize secrets for saving: %w", err)
 	}
@@ -229,7 +259,7 @@ func (s *SecretsStore) saveSecretsLocked() error {
 // This ensures consistency when multiple processes might be modifying the store.
 // Disk state takes precedence for conflicts.
 func (s *SecretsStore) mergeWithDiskState() error {
-	freshSecrets, err := s.loadSecretsFromDisk()
+	freshSecrets, _, err := s.loadSecretsFromDisk()
 	if err != nil {
 		return fmt.Errorf("failed to reload secrets for merge: %w", err)
 	}
@@ -249,6 +279,10 @@ func (s *SecretsStore) backupSecret(key, encryptedValue string) {
 }
 
 func (s *SecretsStore) Put(key, value string) error {
+	if err := validateSecretKey(key); err != nil {
+		return err
+	}
+
 	// Acquire file lock to prevent concurrent writes from other processes
 	lock, err := LockFile(s.SecretsPath)
 	if err != nil {
@@ -273,9 +307,13 @@ func (s *SecretsStore) Put(key, value string) error {
 		return err
 	}
 
+	if current, exists := s.secrets[key]; exists && !current.isEnabled() {
+		return ErrSecretDisabled
+	}
+
 	// Now perform the update with merged state
 	s.ensureBackupExists(key)
-	s.secrets[key] = encryptedValue
+	s.secrets[key] = secretRecord{Value: encryptedValue, State: SecretStateEnabled}
 	return s.saveSecretsLocked()
 }
 
@@ -288,8 +326,8 @@ func (s *SecretsStore) ensureBackupExists(key string) {
 	}
 
 	// Store current value as backup using existing backup system
-	if currentValue, exists := s.secrets[key]; exists {
-		s.backupSecret(key, currentValue)
+	if current, exists := s.secrets[key]; exists {
+		s.backupSecret(key, current.Value)
 	}
 }
 
@@ -297,14 +335,14 @@ func (s *SecretsStore) Get(key string) (string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	enc, ok := s.secrets[key]
-	if !ok {
+	record, ok := s.secrets[key]
+	if !ok || !record.isEnabled() {
 		return "", ErrNotFound
 	}
 
 	// Decrypt directly with master key while holding the read lock
 	// This prevents race conditions with key rotation
-	pt, err := decrypt(s.masterKey, enc)
+	pt, err := decrypt(s.masterKey, record.Value)
 	if err != nil {
 		return "", err
 	}
@@ -314,8 +352,8 @@ func (s *SecretsStore) Get(key string) (string, error) {
 func (s *SecretsStore) ListKeys() []string {
 	s.mu.RLock()
 	keys := make([]string, 0, len(s.secrets))
-	for k := range s.secrets {
-		if !strings.HasPrefix(k, disabledPrefix) {
+	for k, record := range s.secrets {
+		if record.isEnabled() {
 			keys = append(keys, k)
 		}
 	}
@@ -342,12 +380,12 @@ func (s *SecretsStore) Delete(key string) error {
 		return fmt.Errorf("failed to merge disk state: %w", err)
 	}
 
-	prevEnc, ok := s.secrets[key]
+	previous, ok := s.secrets[key]
 	if !ok {
 		return ErrNotFound
 	}
 	// Store the encrypted data as backup (not plaintext!)
-	s.backupSecret(key, prevEnc)
+	s.backupSecret(key, previous.Value)
 
 	delete(s.secrets, key)
 	return s.saveSecretsLocked()
@@ -367,155 +405,19 @@ func (s *SecretsStore) DecryptBackup(encryptedData string) (string, error) {
 	return string(decrypted), nil
 }
 
-// DisableSecret marks a secret as disabled by adding a special prefix
-func (s *SecretsStore) DisableSecret(key string) error {
-	// Acquire file lock to prevent concurrent writes from other processes
-	lock, err := LockFile(s.SecretsPath)
-	if err != nil {
-		return fmt.Errorf("failed to acquire database lock: %w", err)
-	}
-	defer lock.Unlock()
-
-	// Load fresh state but merge it with existing in-memory state
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	err = s.mergeWithDiskState()
-	if err != nil {
-		return fmt.Errorf("failed to merge disk state: %w", err)
-	}
-
-	enc, ok := s.secrets[key]
-	if !ok {
-		return ErrNotFound
-	}
-
-	// Create backup before disabling
-	s.backupSecret(key, enc)
-
-	// Mark as disabled using JSON encoding to handle keys with special characters
-	timestamp := time.Now().UnixNano()
-	keyData := map[string]any{
-		"timestamp": timestamp,
-		"key":       key,
-	}
-	keyJSON, _ := json.Marshal(keyData)
-	disabledKey := disabledPrefix + string(keyJSON)
-
-	s.secrets[disabledKey] = enc
-	delete(s.secrets, key)
-
-	return s.saveSecretsLocked()
-}
-
-// buildDisabledSecretsMap creates a map from original key names to their disabled key names
-// Assumes caller holds appropriate lock
-func (s *SecretsStore) buildDisabledSecretsMap() map[string]string {
-	disabledMap := make(map[string]string)
-	for disabledKey := range s.secrets {
-		if originalKey := s.extractOriginalKeyFromDisabled(disabledKey); originalKey != "" {
-			disabledMap[originalKey] = disabledKey
-		}
-	}
-	return disabledMap
-}
-
-func (s *SecretsStore) extractOriginalKeyFromDisabled(disabledKey string) string {
-	jsonData, isDisabled := strings.CutPrefix(disabledKey, disabledPrefix)
-	if !isDisabled {
-		return ""
-	}
-
-	if originalKey := s.parseJsonFormat(jsonData); originalKey != "" {
-		return originalKey
-	}
-
-	return s.parseLegacyFormat(jsonData)
-}
-
-func (s *SecretsStore) parseJsonFormat(jsonData string) string {
-	var keyData map[string]any
-	if err := json.Unmarshal([]byte(jsonData), &keyData); err != nil {
-		return ""
-	}
-
-	originalKey, ok := keyData["key"].(string)
-	if !ok {
-		return ""
-	}
-
-	return originalKey
-}
-
-func (s *SecretsStore) parseLegacyFormat(data string) string {
-	const (
-		notFound         = -1
-		underscoreLength = 1
-	)
-	underscorePosition := strings.Index(data, "_")
-	if underscorePosition == notFound {
-		return ""
-	}
-	positionAfterUnderscore := underscorePosition + underscoreLength
-	return data[positionAfterUnderscore:]
-}
-
-// EnableSecret re-enables a previously disabled secret
-func (s *SecretsStore) EnableSecret(key string) error {
-	// Acquire file lock to prevent concurrent writes from other processes
-	lock, err := LockFile(s.SecretsPath)
+// RestoreSecretFromBackup replaces a secret with the value in its backup file
+func (s *SecretsStore) RestoreSecretFromBackup(key string) error {
+	data, err := os.ReadFile(s.GetBackupPath(key))
 	if err != nil {
-		return fmt.Errorf("failed to acquire database lock: %w", err)
+		return fmt.Errorf("backup file not found for secret %q", key)
 	}
-	defer lock.Unlock()
 
-	// Load fresh state but merge it with existing in-memory state
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	err = s.mergeWithDiskState()
+	value, err := s.DecryptBackup(string(data))
 	if err != nil {
-		return fmt.Errorf("failed to merge disk state: %w", err)
-	}
-
-	// Build a map of original keys to their disabled keys for efficient lookup
-	disabledMap := s.buildDisabledSecretsMap()
-
-	disabledKey, found := disabledMap[key]
-	if !found {
-		return fmt.Errorf("disabled secret not found")
-	}
-
-	// Move back to original key
-	s.secrets[key] = s.secrets[disabledKey]
-	delete(s.secrets, disabledKey)
-
-	return s.saveSecretsLocked()
-}
-
-// ListDisabledSecrets returns a list of disabled secret keys
-func (s *SecretsStore) ListDisabledSecrets() []string {
-	s.mu.RLock()
-	disabledMap := s.buildDisabledSecretsMap()
-	s.mu.RUnlock()
-
-	disabled := make([]string, 0, len(disabledMap))
-	for originalKey := range disabledMap {
-		disabled = append(disabled, originalKey)
+		return fmt.Errorf("failed to decrypt backup for secret %q: %v", key, err)
 	}
 
-	sort.Strings(disabled)
-	return disabled
-}
-
-// IsEnabled checks if a secret is enabled (not disabled)
-func (s *SecretsStore) IsEnabled(key string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	// Check if the key exists in enabled state
-	_, exists := s.secrets[key]
-	return exists
+	return s.Put(key, value)
 }
 
 // CreateBackup creates a backup of the current secrets and master key
@@ -606,8 +508,19 @@ func LockFile(path string) (*FileLock, error) {
 	for attempt := 0; attempt < maxLockAttempts; attempt++ {
 		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
 		if err == nil {
-			// Lock acquired successfully
-			return &FileLock{file: file, path: lockPath}, nil
+			if isCurrentLockFile(file, lockPath) {
+				// Lock acquired successfully
+				return &FileLock{file: file, path: lockPath}, nil
+			}
+
+			// The previous holder removed the lock file after we opened it, so another
+			// process may already hold a lock on its replacement; lock that one instead
+			file.Close()
+			file, err = os.OpenFile(lockPath, os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create lock file: %w", err)
+			}
+			continue
 		}
 
 		if err != syscall.EWOULDBLOCK {
@@ -628,12 +541,27 @@ func LockFile(path string) (*FileLock, error) {
 	return nil, fmt.Errorf("timeout acquiring file lock after %d attempts", maxLockAttempts)
 }
 
+// isCurrentLockFile reports whether lockPath still names the locked file, which Unlock
+// removes once it releases it
+func isCurrentLockFile(file *os.File, lockPath string) bool {
+	locked, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(lockPath)
+	return err == nil && os.SameFile(locked, current)
+}
+
 // Unlock releases the file lock
 func (fl *FileLock) Unlock() error {
 	if fl.file == nil {
 		return nil
 	}
 
+	// Clean up the lock file while still holding it. Removing it after the release would
+	// let a waiter lock the old file just as another process creates and locks a new one.
+	_ = os.Remove(fl.path)
+
 	// Release the lock
 	err := syscall.Flock(int(fl.file.Fd()), syscall.LOCK_UN)
 
@@ -641,9 +569,6 @@ func (fl *FileLock) Unlock() error {
 	closeErr := fl.file.Close()
 	fl.file = nil
 
-	// Clean up lock file
-	_ = os.Remove(fl.path)
-
 	if err != nil {
 		return fmt.Errorf("failed to release file lock: %w", err)
 	}
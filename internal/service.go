@@ -17,10 +17,12 @@ limitations under the License.
 package internal
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"simple-secrets/pkg/api"
 )
@@ -71,13 +73,22 @@ type SecretOperations interface {
 	List(token string) ([]string, error)
 	ListDisabled(token string) ([]string, error)
 	Enable(token, key string) error
-	Disable(token, key string) error
+	Disable(token, key, reason string) error
+	ListDisabledDetails(token string) ([]DisabledSecret, error)
+	PutStructured(token, key string, value StructuredValue) error
+	GetField(token, key, field string) (string, error)
+	SetFields(token, key string, updates map[string]string) error
+	ListFields(token, key string) ([]string, error)
+	Restore(token, key string) error
+	// Describe returns a secret's state and how it has been read, without its value
+	Describe(token, key string) (*SecretDescription, error)
 }
 
 // AuthOperations defines operations for authentication
 type AuthOperations interface {
 	ValidateToken(token string) (*User, error)
-	ValidateAccess(token string, needWrite bool) error
+	ValidateTokenFrom(token, client string) (*User, error)
+	Authorize(token, permission string) (*User, error)
 }
 
 // UserOperations defines operations for user management
@@ -87,18 +98,125 @@ type UserOperations interface {
 	ListUsers(adminToken string) ([]*User, error)
 	RotateToken(token, username string) (string, error)
 	RotateSelfToken(currentUser *User) (string, error)
-	DisableUser(token, username string) error
+	DisableUser(token, username, reason string) error
 	DisableUserByToken(adminToken, targetToken string) (string, error)
 	EnableUser(token, username string) (string, error)
+	UpdateUser(adminToken, username string, update UserUpdate) error
+	RenameUser(adminToken, oldName, newName string) error
+	UnlockUser(adminToken, username string) (bool, error)
+	UnlockClient(adminToken, client string) (bool, error)
+}
+
+// RoleOperations defines operations for role management
+type RoleOperations interface {
+	ListRoles(token string) (RolePermissions, error)
+	CreateRole(token, name string, permissions []string) error
+	UpdateRole(token, name string, permissions []string) error
+	DeleteRole(token, name string) error
+}
+
+// GroupOperations defines operations for groups of users
+type GroupOperations interface {
+	ListGroups(token string) ([]*Group, error)
+	CreateGroup(token, name string, roles []string) error
+	DeleteGroup(token, name string) error
+	AddMember(token, group, username string) error
+	RemoveMember(token, group, username string) error
+}
+
+// ApprovalOperations defines the two-person approval workflow for the operations
+// approvals.json lists as required
+type ApprovalOperations interface {
+	// Settings returns the operations that need approval and how long requests stay open
+	Settings(token string) (ApprovalPolicy, error)
+	// Configure replaces the settings. While any operation needs approval, so does this.
+	Configure(token string, operations []string, expiry string) error
+	ListRequests(token string) ([]*ApprovalRequest, error)
+	Approve(token, id string) (*ApprovalRequest, error)
+	Reject(token, id, reason string) (*ApprovalRequest, error)
+	// Require gates an operation run outside the service, returning ErrApprovalRequired
+	// until another admin has approved it. The approval is claimed at once; the caller
+	// passes the operation's result to done, which gives it back if the operation failed.
+	Require(token, operation, target string) (done func(error), err error)
+}
+
+// PolicyOperations defines operations for path-scoped access policies
+type PolicyOperations interface {
+	ListPolicies(token string) ([]Policy, error)
+	AddPolicy(token string, policy Policy) (Policy, error)
+	RemovePolicy(token, id string) error
+	TestPolicy(token, username, key string, actions []string) ([]PolicyDecision, error)
+}
+
+// TokenOperations defines operations for a user's named tokens.
+// An empty username means the caller's own tokens.
+type TokenOperations interface {
+	CreateToken(token, username string, options TokenOptions) (string, *Token, error)
+	ListTokens(token, username string) ([]TokenInfo, error)
+	RevokeToken(token, username, nameOrID string) (string, error)
+	RotateToken(token, username, nameOrID string, grace TokenGrace) (string, error)
+	RevokePrevious(token, username, nameOrID string) (string, error)
+	IssueToken(token string, scopes []string, ttl time.Duration) (string, *DerivedClaims, error)
+}
+
+// RecoveryOperations defines admin recovery with the one-time codes created at setup
+type RecoveryOperations interface {
+	// RecoverAdmin uses a recovery code, without a token, to give an admin a new token.
+	// username may be empty if there is only one admin.
+	RecoverAdmin(code, username string) (*AdminRecovery, error)
+	RegenerateCodes(token string) ([]string, error)
+	Status(token string) (*RecoveryStatus, error)
+}
+
+// AuditOperations defines access to the audit log
+type AuditOperations interface {
+	// Record logs an operation the CLI runs outside the service, such as a restore
+	Record(user *User, op, key string, err error)
+	// Verify checks the log's hash chain; only admins may
+	Verify(token string) (*AuditVerification, error)
+	// Query returns the entries the filter selects, oldest first; only admins may
+	Query(token string, filter AuditFilter) ([]AuditEntry, error)
+	// Export is Query for entries copied out of simple-secrets, and is recorded as such
+	Export(token string, filter AuditFilter) ([]AuditEntry, error)
+	// Prune removes the rotated logs whose entries are all older than olderThan
+	Prune(token string, olderThan time.Duration) (*AuditPruneResult, error)
+}
+
+// ReportOperations defines the reports prepared for access reviews
+type ReportOperations interface {
+	// Access returns what every user may do on each key, or on each namespace pattern
+	Access(token string, namespaces []string) (*AccessReport, error)
+	// Stale returns the secrets neither read nor written within unusedFor
+	Stale(token string, unusedFor time.Duration) ([]StaleSecret, error)
+}
+
+// BackupOperations defines encrypted archives of the whole store. Archives are verified
+// with VerifyBackupArchive, which needs no token.
+type BackupOperations interface {
+	// Create writes every store file to an archive at out; only admins may
+	Create(token, out string, key BackupKey) (*BackupManifest, error)
+	// Restore replaces the store with a verified archive, snapshotting it first; only admins may
+	Restore(token, archivePath string, key BackupKey) (*BackupRestore, error)
+	// Prune removes the backup directories the retention policy does not keep; only admins may
+	Prune(token string, dryRun bool) (*BackupPrunePlan, error)
 }
 
 // Service provides composable operations for simple-secrets
 // Clean separation of concerns with injectable dependencies
 type Service struct {
-	secrets SecretOperations
-	auth    AuthOperations
-	users   UserOperations
-	admin   api.AdminOperations
+	secrets   SecretOperations
+	auth      AuthOperations
+	users     UserOperations
+	roles     RoleOperations
+	groups    GroupOperations
+	approvals ApprovalOperations
+	policies  PolicyOperations
+	tokens    TokenOperations
+	recovery  RecoveryOperations
+	audit     AuditOperations
+	reports   ReportOperations
+	backups   BackupOperations
+	admin     api.AdminOperations
 }
 
 // NewService creates a service with functional options
@@ -127,12 +245,6 @@ func NewService(options ...ServiceOption) (*Service, error) {
 		userStore: userStore,
 	}
 
-	// Create other operations using shared stores
-	secretOps := &secretOperations{
-		store: secretsStore,
-		auth:  authOps,
-	}
-
 	// Determine config directory for file paths
 	configDir := config.ConfigDir
 	if configDir == "" {
@@ -143,21 +255,118 @@ func NewService(options ...ServiceOption) (*Service, error) {
 		configDir = defaultDir
 	}
 
+	policyEngine, err := LoadPolicyEngine(configDir)
+	if err != nil {
+		return nil, err
+	}
+	approvalGate := newApprovals(configDir)
+	auditLog := newAuditLog(configDir, userStore)
+	secretUsage := newSecretUsage(configDir)
+
+	// Create other operations using shared stores
+	secretOps := &secretOperations{
+		store:     secretsStore,
+		auth:      authOps,
+		audit:     auditLog,
+		usage:     secretUsage,
+		userStore: userStore,
+		policies:  policyEngine,
+		approvals: approvalGate,
+	}
+
 	userOps := &userOperations{
+		userStore:  userStore,
+		auth:       authOps,
+		audit:      auditLog,
+		secrets:    secretsStore,
+		policies:   policyEngine,
+		approvals:  approvalGate,
+		usersPath:  filepath.Join(configDir, "users.json"),
+		rolesPath:  filepath.Join(configDir, "roles.json"),
+		groupsPath: filepath.Join(configDir, groupsFileName),
+	}
+
+	roleOps := &roleOperations{
+		userStore: userStore,
+		auth:      authOps,
+		audit:     auditLog,
+		usersPath: userOps.usersPath,
+		rolesPath: userOps.rolesPath,
+	}
+
+	groupOps := &groupOperations{
+		userStore: userStore,
+		auth:      authOps,
+		audit:     auditLog,
+		users:     userOps,
+	}
+
+	approvalOps := &approvalOperations{
+		auth:      authOps,
+		audit:     auditLog,
+		approvals: approvalGate,
+	}
+
+	policyOps := &policyOperations{
+		userStore: userStore,
+		auth:      authOps,
+		audit:     auditLog,
+		engine:    policyEngine,
+	}
+
+	tokenOps := &tokenOperations{
+		userStore: userStore,
+		auth:      authOps,
+		audit:     auditLog,
+		users:     userOps,
+	}
+
+	recoveryOps := &recoveryOperations{
+		userStore: userStore,
+		auth:      authOps,
+		audit:     auditLog,
+		users:     userOps,
+		codes:     newRecoveryCodes(configDir),
+	}
+
+	auditOps := &auditOperations{
+		auth: authOps,
+		log:  auditLog,
+	}
+
+	reportOps := &reportOperations{
 		userStore: userStore,
+		secrets:   secretsStore,
 		auth:      authOps,
-		usersPath: filepath.Join(configDir, "users.json"),
-		rolesPath: filepath.Join(configDir, "roles.json"),
+		audit:     auditLog,
+		usage:     secretUsage,
+		policies:  policyEngine,
+	}
+
+	backupOps := &backupOperations{
+		store:     secretsStore,
+		auth:      authOps,
+		audit:     auditLog,
+		configDir: configDir,
 	}
 
 	// Create admin operations using shared stores
 	adminOps := NewServiceAdapter(secretsStore, userStore)
 
 	return &Service{
-		secrets: secretOps,
-		auth:    authOps,
-		users:   userOps,
-		admin:   adminOps,
+		secrets:   secretOps,
+		auth:      &auditedAuth{AuthOperations: authOps, audit: auditLog},
+		users:     userOps,
+		roles:     roleOps,
+		groups:    groupOps,
+		approvals: approvalOps,
+		policies:  policyOps,
+		tokens:    tokenOps,
+		recovery:  recoveryOps,
+		audit:     auditOps,
+		reports:   reportOps,
+		backups:   backupOps,
+		admin:     adminOps,
 	}, nil
 }
 
@@ -176,6 +385,51 @@ func (s *Service) Users() UserOperations {
 	return s.users
 }
 
+// Roles returns the role operations interface
+func (s *Service) Roles() RoleOperations {
+	return s.roles
+}
+
+// Groups returns the group operations interface
+func (s *Service) Groups() GroupOperations {
+	return s.groups
+}
+
+// Approvals returns the approval workflow interface
+func (s *Service) Approvals() ApprovalOperations {
+	return s.approvals
+}
+
+// Policies returns the policy operations interface
+func (s *Service) Policies() PolicyOperations {
+	return s.policies
+}
+
+// Tokens returns the named token operations interface
+func (s *Service) Tokens() TokenOperations {
+	return s.tokens
+}
+
+// Recovery returns the admin recovery operations interface
+func (s *Service) Recovery() RecoveryOperations {
+	return s.recovery
+}
+
+// Audit returns the audit log operations interface
+func (s *Service) Audit() AuditOperations {
+	return s.audit
+}
+
+// Reports returns the access review report interface
+func (s *Service) Reports() ReportOperations {
+	return s.reports
+}
+
+// Backups returns the backup archive operations interface
+func (s *Service) Backups() BackupOperations {
+	return s.backups
+}
+
 // Admin returns the admin operations interface
 func (s *Service) Admin() api.AdminOperations {
 	return s.admin
@@ -193,8 +447,13 @@ func (s *Service) GetUserStore() *UserStore {
 
 // Implementation structs for the focused interfaces
 type secretOperations struct {
-	store *SecretsStore
-	auth  AuthOperations
+	store     *SecretsStore
+	auth      AuthOperations
+	audit     *auditLog
+	usage     *secretUsage
+	userStore *UserStore
+	policies  *PolicyEngine
+	approvals *approvals
 }
 
 type authOperations struct {
@@ -202,31 +461,110 @@ type authOperations struct {
 }
 
 type userOperations struct {
+	userStore  *UserStore
+	auth       AuthOperations
+	audit      *auditLog
+	secrets    *SecretsStore
+	policies   *PolicyEngine
+	approvals  *approvals
+	usersPath  string
+	rolesPath  string
+	groupsPath string
+}
+
+type roleOperations struct {
 	userStore *UserStore
 	auth      AuthOperations
+	audit     *auditLog
 	usersPath string
 	rolesPath string
 }
 
+type groupOperations struct {
+	userStore *UserStore
+	auth      AuthOperations
+	audit     *auditLog
+	users     *userOperations
+}
+
+type approvalOperations struct {
+	auth      AuthOperations
+	audit     *auditLog
+	approvals *approvals
+}
+
+type policyOperations struct {
+	userStore *UserStore
+	auth      AuthOperations
+	audit     *auditLog
+	engine    *PolicyEngine
+}
+
+type tokenOperations struct {
+	userStore *UserStore
+	auth      AuthOperations
+	audit     *auditLog
+	users     *userOperations
+}
+
+type recoveryOperations struct {
+	userStore *UserStore
+	auth      AuthOperations
+	audit     *auditLog
+	users     *userOperations
+	codes     *recoveryCodes
+}
+
+type auditOperations struct {
+	auth AuthOperations
+	log  *auditLog
+}
+
+type reportOperations struct {
+	userStore *UserStore
+	secrets   *SecretsStore
+	auth      AuthOperations
+	audit     *auditLog
+	usage     *secretUsage
+	policies  *PolicyEngine
+}
+
+type backupOperations struct {
+	store     *SecretsStore
+	auth      AuthOperations
+	audit     *auditLog
+	configDir string
+}
+
 // Implementation of SecretOperations interface
-func (s *secretOperations) Get(token, key string) (string, error) {
-	if _, err := s.auth.ValidateToken(token); err != nil {
+func (s *secretOperations) Get(token, key string) (_ string, err error) {
+	defer s.audit.track(token, "get", key).done(&err)
+	user, err := s.authorizeKey(token, ActionRead, key)
+	if err != nil {
 		return "", err
 	}
 
-	return s.store.Get(key)
+	value, err := s.store.Get(key)
+	s.usage.used(usageRead, key, user, err)
+	return value, err
 }
 
-func (s *secretOperations) Put(token, key, value string) error {
-	if err := s.auth.ValidateAccess(token, true); err != nil {
+func (s *secretOperations) Put(token, key, value string) (err error) {
+	defer s.audit.track(token, "put", key).done(&err)
+	user, err := s.authorizeKey(token, ActionWrite, key)
+	if err != nil {
 		return err
 	}
 
-	return s.store.Put(key, value)
+	err = s.store.Put(key, value)
+	s.usage.used(usageWrite, key, user, err)
+	return err
 }
 
-func (s *secretOperations) Generate(token, key string, length int) (string, error) {
-	if err := s.auth.ValidateAccess(token, true); err != nil {
+func (s *secretOperations) Generate(token, key string, length int) (_ string, err error) {
+	defer s.audit.track(token, "generate", key).done(&err)
+	user, err := s.authorizeKey(token, ActionWrite, key)
+	if err != nil {
 		return "", err
 	}
 
@@ -241,235 +579,446 @@ func (s *secretOperations) Generate(token, key string, length int) (string, erro
 	if err != nil {
 		return "", fmt.Errorf("failed to store generated secret: %w", err)
 	}
+	s.usage.used(usageWrite, key, user, nil)
 
 	return generatedValue, nil
 }
 
-func (s *secretOperations) Delete(token, key string) error {
-	if err := s.auth.ValidateAccess(token, true); err != nil {
+func (s *secretOperations) Delete(token, key string) (err error) {
+	defer s.audit.track(token, "delete", key).done(&err)
+	user, err := s.authorizeKey(token, ActionWrite, key)
+	if err != nil {
+		return err
+	}
+	approval, err := s.approvals.gate(user, OpDeleteSecret, key, time.Now())
+	if err != nil {
 		return err
 	}
+	defer approval.done(&err)
 
-	return s.store.Delete(key)
+	err = s.store.Delete(key)
+	s.usage.used(usageDelete, key, user, err)
+	return err
 }
 
-func (s *secretOperations) List(token string) ([]string, error) {
-	if _, err := s.auth.ValidateToken(token); err != nil {
+func (s *secretOperations) List(token string) (_ []string, err error) {
+	defer s.audit.track(token, "list-keys", "").done(&err)
+	user, err := s.auth.Authorize(token, PermRead)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.policies.Filter(user, s.userStore.Permissions(), ActionList, s.store.ListKeys()), nil
+}
+
+func (s *secretOperations) ListDisabled(token string) (_ []string, err error) {
+	defer s.audit.track(token, "list-disabled", "").done(&err)
+	user, err := s.auth.Authorize(token, PermRead)
+	if err != nil {
 		return nil, err
 	}
 
-	return s.store.ListKeys(), nil
+	return s.policies.Filter(user, s.userStore.Permissions(), ActionList, s.store.ListDisabledSecrets()), nil
 }
 
-func (s *secretOperations) ListDisabled(token string) ([]string, error) {
-	if _, err := s.auth.ValidateToken(token); err != nil {
+func (s *secretOperations) ListDisabledDetails(token string) (_ []DisabledSecret, err error) {
+	defer s.audit.track(token, "list-disabled", "").done(&err)
+	user, err := s.auth.Authorize(token, PermRead)
+	if err != nil {
 		return nil, err
 	}
 
-	return s.store.ListDisabledSecrets(), nil
+	var visible []DisabledSecret
+	for _, secret := range s.store.ListDisabledSecretDetails() {
+		if s.policies.Evaluate(user, s.userStore.Permissions(), ActionList, secret.Key).Allowed {
+			visible = append(visible, secret)
+		}
+	}
+	return visible, nil
 }
 
-func (s *secretOperations) Enable(token, key string) error {
-	if err := s.auth.ValidateAccess(token, true); err != nil {
+func (s *secretOperations) Enable(token, key string) (err error) {
+	defer s.audit.track(token, "enable", key).done(&err)
+	if _, err := s.authorizeKey(token, ActionWrite, key); err != nil {
 		return err
 	}
 
 	return s.store.EnableSecret(key)
 }
 
-func (s *secretOperations) Disable(token, key string) error {
-	if err := s.auth.ValidateAccess(token, true); err != nil {
+func (s *secretOperations) Disable(token, key, reason string) (err error) {
+	defer s.audit.track(token, "disable", key).done(&err)
+	user, err := s.authorizeKey(token, ActionWrite, key)
+	if err != nil {
 		return err
 	}
 
-	return s.store.DisableSecret(key)
+	return s.store.DisableSecretWithReason(key, user.Username, reason)
 }
 
-// Implementation of AuthOperations interface
-func (a *authOperations) ValidateToken(token string) (*User, error) {
-	return a.userStore.Lookup(token)
+func (s *secretOperations) PutStructured(token, key string, value StructuredValue) (err error) {
+	defer s.audit.track(token, "put", key).done(&err)
+	user, err := s.authorizeKey(token, ActionWrite, key)
+	if err != nil {
+		return err
+	}
+
+	err = s.store.PutStructured(key, value)
+	s.usage.used(usageWrite, key, user, err)
+	return err
 }
 
-func (a *authOperations) ValidateAccess(token string, needWrite bool) error {
-	user, err := a.ValidateToken(token)
+func (s *secretOperations) GetField(token, key, field string) (_ string, err error) {
+	defer s.audit.track(token, "get-field", key).done(&err)
+	user, err := s.authorizeKey(token, ActionRead, key)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if needWrite && !user.Can("write", a.userStore.Permissions()) {
-		return fmt.Errorf("permission denied: need 'write' permission")
+	value, err := s.store.GetField(key, field)
+	s.usage.used(usageRead, key, user, err)
+	return value, err
+}
+
+func (s *secretOperations) SetFields(token, key string, updates map[string]string) (err error) {
+	defer s.audit.track(token, "set-fields", key).done(&err)
+	user, err := s.authorizeKey(token, ActionWrite, key)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	err = s.store.SetFields(key, updates)
+	s.usage.used(usageWrite, key, user, err)
+	return err
 }
 
-// Implementation of UserOperations interface
-func (u *userOperations) CreateUser(adminToken, username, role string) (string, error) {
-	if err := u.auth.ValidateAccess(adminToken, true); err != nil {
-		return "", err
+func (s *secretOperations) ListFields(token, key string) (_ []string, err error) {
+	defer s.audit.track(token, "list-fields", key).done(&err)
+	if _, err := s.authorizeKey(token, ActionRead, key); err != nil {
+		return nil, err
 	}
 
-	admin, err := u.auth.ValidateToken(adminToken)
+	return s.store.ListFields(key)
+}
+
+func (s *secretOperations) Restore(token, key string) (err error) {
+	defer s.audit.track(token, "restore", key).done(&err)
+	user, err := s.authorizeKey(token, ActionWrite, key)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	if admin.Role != "admin" {
-		return "", fmt.Errorf("permission denied: admin role required")
+	err = s.store.RestoreSecretFromBackup(key)
+	s.usage.used(usageWrite, key, user, err)
+	return err
+}
+
+func (s *secretOperations) Describe(token, key string) (_ *SecretDescription, err error) {
+	defer s.audit.track(token, "describe", key).done(&err)
+	if _, err := s.authorizeKey(token, ActionList, key); err != nil {
+		return nil, err
 	}
 
-	newToken, err := u.userStore.CreateUser(username, role)
+	description, err := s.store.Describe(key)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	usage, err := s.usage.load()
+	if err != nil {
+		return nil, err
 	}
+	if u := usage.Secrets[key]; u != nil {
+		description.Usage = *u
+	}
+	description.TrackedSince = usage.Since
+	return description, nil
+}
 
-	// Save the updated users to disk
-	if err := u.saveUsersWithError(); err != nil {
-		return "", err
+// authorizeKey checks the role permission and the policies for an action on a key
+func (s *secretOperations) authorizeKey(token, action, key string) (*User, error) {
+	user, err := s.auth.Authorize(token, actionPermission(action))
+	if err != nil {
+		return nil, err
 	}
 
-	return newToken, nil
+	if err := s.policies.Check(user, s.userStore.Permissions(), action, key); err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
-func (u *userOperations) DeleteUser(adminToken, username string) error {
-	if err := u.auth.ValidateAccess(adminToken, true); err != nil {
-		return err
+// Implementation of AuthOperations interface
+// ValidateToken finds the token's user, rejecting expired tokens and warning about ones close to expiry
+func (a *authOperations) ValidateToken(token string) (*User, error) {
+	return a.ValidateTokenFrom(token, LocalAuthClient())
+}
+
+// ValidateTokenFrom validates a token presented by the named client, such as a server's
+// remote address, counting a failure against the client and the user the token names
+func (a *authOperations) ValidateTokenFrom(token, client string) (*User, error) {
+	now := time.Now()
+	user, match, err := a.userStore.authenticateFrom(token, client, now)
+	if err != nil {
+		return nil, err
 	}
 
-	admin, err := u.auth.ValidateToken(adminToken)
+	a.userStore.lifetime.warnIfExpiring(user, match, now)
+	return user, nil
+}
+
+// Authorize validates the token and checks that its user holds the named permission
+func (a *authOperations) Authorize(token, permission string) (*User, error) {
+	user, err := a.ValidateToken(token)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if admin.Role != "admin" {
-		return fmt.Errorf("permission denied: admin role required")
+	if !user.Can(permission, a.userStore.Permissions()) {
+		return nil, NewPermissionDeniedError(permission)
+	}
+
+	return user, nil
+}
+
+// auditedAuth is the AuthOperations the service exposes to callers. It records failed
+// authentication; the service's own operations record their results themselves.
+type auditedAuth struct {
+	AuthOperations
+	audit *auditLog
+}
+
+func (a *auditedAuth) ValidateToken(token string) (*User, error) {
+	return a.ValidateTokenFrom(token, LocalAuthClient())
+}
+
+func (a *auditedAuth) ValidateTokenFrom(token, client string) (_ *User, err error) {
+	event := a.audit.track(token, "authenticate", "")
+	event.entry.Client = client
+	defer event.failed(&err)
+	return a.AuthOperations.ValidateTokenFrom(token, client)
+}
+
+func (a *auditedAuth) Authorize(token, permission string) (_ *User, err error) {
+	defer a.audit.track(token, "authorize", permission).failed(&err)
+	return a.AuthOperations.Authorize(token, permission)
+}
+
+// Implementation of UserOperations interface
+func (u *userOperations) CreateUser(adminToken, username, role string) (_ string, err error) {
+	defer u.audit.track(adminToken, "create-user", username).done(&err)
+	admin, err := u.auth.Authorize(adminToken, PermManageUsers)
+	if err != nil {
+		return "", err
+	}
+	var approval *approval
+	if u.userStore.RoleGrantsPrivilege(role) {
+		if approval, err = u.approvals.gate(admin, OpGrantAdmin, username, time.Now()); err != nil {
+			return "", err
+		}
 	}
+	defer approval.done(&err)
 
-	if err := u.userStore.DeleteUser(username); err != nil {
+	var newToken string
+	err = u.updateUsers(func() error {
+		var err error
+		newToken, err = u.userStore.CreateUser(username, role)
 		return err
+	})
+	if err != nil {
+		return "", err
 	}
 
-	// Save the updated users to disk
-	return u.saveUsersWithError()
+	return newToken, nil
 }
 
-func (u *userOperations) ListUsers(adminToken string) ([]*User, error) {
-	admin, err := u.auth.ValidateToken(adminToken)
-	if err != nil {
-		return nil, err
+func (u *userOperations) DeleteUser(adminToken, username string) (err error) {
+	defer u.audit.track(adminToken, "delete-user", username).done(&err)
+	if _, err := u.auth.Authorize(adminToken, PermManageUsers); err != nil {
+		return err
 	}
 
-	if admin.Role != "admin" {
-		return nil, fmt.Errorf("permission denied: admin role required")
+	return u.updateUsers(func() error {
+		return u.userStore.DeleteUser(username)
+	})
+}
+
+func (u *userOperations) ListUsers(adminToken string) (_ []*User, err error) {
+	defer u.audit.track(adminToken, "list-users", "").done(&err)
+	if _, err := u.auth.Authorize(adminToken, PermManageUsers); err != nil {
+		return nil, err
 	}
 
 	return u.userStore.Users(), nil
 }
 
-func (u *userOperations) RotateToken(token, username string) (string, error) {
+func (u *userOperations) RotateToken(token, username string) (_ string, err error) {
+	defer u.audit.track(token, "rotate-token", username).done(&err)
 	user, err := u.auth.ValidateToken(token)
 	if err != nil {
 		return "", err
 	}
 
-	// Users can rotate their own tokens, admins can rotate any
-	if user.Username != username && user.Role != "admin" {
-		return "", fmt.Errorf("permission denied: can only rotate own token")
+	// Users can rotate their own tokens, rotate-tokens holders can rotate any
+	if user.Username != username && !user.Can(PermRotateTokens, u.userStore.Permissions()) {
+		return "", fmt.Errorf("%w: can only rotate own token", ErrPermissionDenied)
 	}
-
-	newToken, err := u.userStore.RotateUserToken(username)
-	if err != nil {
-		return "", err
+	if user.Username == username && !user.Can(PermRotateOwnToken, u.userStore.Permissions()) {
+		return "", NewPermissionDeniedError(PermRotateOwnToken)
 	}
 
-	// Save the updated users to disk
-	if err := u.saveUsersWithError(); err != nil {
+	var newToken string
+	err = u.updateUsers(func() error {
+		var err error
+		newToken, err = u.userStore.RotateUserToken(username)
+		return err
+	})
+	if err != nil {
 		return "", err
 	}
 
 	return newToken, nil
 }
 
-func (u *userOperations) DisableUser(token, username string) error {
+func (u *userOperations) DisableUser(token, username, reason string) (err error) {
+	defer u.audit.track(token, "disable-user", username).done(&err)
 	user, err := u.auth.ValidateToken(token)
 	if err != nil {
 		return err
 	}
 
-	if !user.Can("rotate-tokens", u.userStore.Permissions()) {
-		return fmt.Errorf("permission denied: require rotate-tokens permission to disable user tokens")
-	}
-
-	if err := u.userStore.DisableUserToken(username); err != nil {
-		return err
+	if !user.Can(PermRotateTokens, u.userStore.Permissions()) {
+		return fmt.Errorf("%w: require rotate-tokens permission to disable user tokens", ErrPermissionDenied)
 	}
 
-	// Save the updated users to disk
-	return u.saveUsersWithError()
+	return u.updateUsers(func() error {
+		return u.userStore.DisableUserToken(username, user.Username, reason)
+	})
 }
 
-func (u *userOperations) DisableUserByToken(token, tokenValue string) (string, error) {
+func (u *userOperations) DisableUserByToken(token, tokenValue string) (_ string, err error) {
+	// The token disabled is never recorded, only the user it belonged to
+	event := u.audit.track(token, "disable-user", "")
+	defer event.done(&err)
 	// Verify authentication and permissions
-	if err := u.auth.ValidateAccess(token, true); err != nil {
+	user, err := u.auth.Authorize(token, PermRotateTokens)
+	if err != nil {
 		return "", err
 	}
 
 	// Disable the user by token value
-	username, err := u.userStore.DisableUserByToken(tokenValue)
+	var username string
+	err = u.updateUsers(func() error {
+		var err error
+		username, err = u.userStore.DisableUserByToken(tokenValue, user.Username)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
 
-	// Save the updated users to disk
-	if err := u.saveUsersWithError(); err != nil {
-		return "", err
-	}
-
+	event.entry.Key = username
 	return username, nil
 }
 
-func (u *userOperations) EnableUser(token, username string) (string, error) {
+func (u *userOperations) EnableUser(token, username string) (_ string, err error) {
+	defer u.audit.track(token, "enable-user", username).done(&err)
 	// Verify authentication and permissions
-	if err := u.auth.ValidateAccess(token, true); err != nil {
+	if _, err := u.auth.Authorize(token, PermManageUsers); err != nil {
 		return "", err
 	}
 
 	// Generate new token for the disabled user
-	newToken, err := u.userStore.EnableUserToken(username)
+	var newToken string
+	err = u.updateUsers(func() error {
+		var err error
+		newToken, err = u.userStore.EnableUserToken(username)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
 
-	// Save the updated users to disk
-	if err := u.saveUsersWithError(); err != nil {
-		return "", err
+	return newToken, nil
+}
+
+// UpdateUser changes a user's contact, description, role or status
+func (u *userOperations) UpdateUser(adminToken, username string, update UserUpdate) (err error) {
+	defer u.audit.track(adminToken, "update-user", username).done(&err)
+	admin, err := u.auth.Authorize(adminToken, PermManageUsers)
+	if err != nil {
+		return err
+	}
+	var approval *approval
+	if update.Role != nil && u.userStore.RoleGrantsPrivilege(*update.Role) {
+		if approval, err = u.approvals.gate(admin, OpGrantAdmin, username, time.Now()); err != nil {
+			return err
+		}
 	}
+	defer approval.done(&err)
 
-	return newToken, nil
+	return u.updateUsers(func() error {
+		return u.userStore.UpdateUser(username, admin.Username, update)
+	})
 }
 
-func (u *userOperations) RotateSelfToken(currentUser *User) (string, error) {
-	newToken, err := u.userStore.RotateUserToken(currentUser.Username)
+// RenameUser changes a username, updating the groups, policies and disabled secrets that name the user
+func (u *userOperations) RenameUser(adminToken, oldName, newName string) (err error) {
+	defer u.audit.track(adminToken, "rename-user", oldName).done(&err)
+	if _, err := u.auth.Authorize(adminToken, PermManageUsers); err != nil {
+		return err
+	}
+
+	err = u.updateUsers(func() error {
+		return u.userStore.RenameUser(oldName, newName)
+	})
 	if err != nil {
-		return "", err
+		return err
+	}
+	if err := u.policies.RenameUser(oldName, newName); err != nil {
+		return fmt.Errorf("user renamed, but policies naming %q were not updated: %w", oldName, err)
+	}
+	if err := u.secrets.RenameDisabledBy(oldName, newName); err != nil {
+		return fmt.Errorf("user renamed, but disabled secrets naming %q were not updated: %w", oldName, err)
+	}
+	return nil
+}
+
+// UnlockUser clears a user's failed authentications and lockout, reporting whether there were any
+func (u *userOperations) UnlockUser(adminToken, username string) (_ bool, err error) {
+	defer u.audit.track(adminToken, "unlock-user", username).done(&err)
+	if _, err := u.auth.Authorize(adminToken, PermManageUsers); err != nil {
+		return false, err
 	}
+	return u.userStore.UnlockUser(username)
+}
+
+// UnlockClient clears a client's failed authentications and lockout, reporting whether there were any
+func (u *userOperations) UnlockClient(adminToken, client string) (_ bool, err error) {
+	defer u.audit.track(adminToken, "unlock-client", client).done(&err)
+	if _, err := u.auth.Authorize(adminToken, PermManageUsers); err != nil {
+		return false, err
+	}
+	return u.userStore.UnlockClient(client)
+}
 
-	// Save the updated users to disk
-	if err := u.saveUsersWithError(); err != nil {
+func (u *userOperations) RotateSelfToken(currentUser *User) (_ string, err error) {
+	defer u.audit.trackUser(currentUser.Username, "rotate-token", currentUser.Username).done(&err)
+	var newToken string
+	err = u.updateUsers(func() error {
+		var err error
+		newToken, err = u.userStore.RotateUserToken(currentUser.Username)
+		return err
+	})
+	if err != nil {
 		return "", err
 	}
 
 	return newToken, nil
 }
 
-// saveUsers persists the current user list to disk
+// saveUsers persists the current user list to disk, see UserStore.save
 func (u *userOperations) saveUsers() error {
-	users := u.userStore.Users()
-	data, err := json.MarshalIndent(users, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal users: %w", err)
-	}
-	return AtomicWriteFile(u.usersPath, data, 0600)
+	return u.userStore.save(u.usersPath)
 }
 
 // saveUsersWithError wraps saveUsers with consistent error messaging
@@ -480,6 +1029,645 @@ func (u *userOperations) saveUsersWithError() error {
 	return nil
 }
 
+// saveGroups persists the groups to disk; installations without groups get no groups.json
+func (u *userOperations) saveGroups() error {
+	groups := u.userStore.Groups()
+	if len(groups) == 0 && !fileExists(u.groupsPath) {
+		return nil
+	}
+	if err := saveGroups(u.groupsPath, groups); err != nil {
+		return fmt.Errorf("failed to save group changes: %w", err)
+	}
+	return nil
+}
+
+// Implementation of RoleOperations interface
+func (r *roleOperations) ListRoles(token string) (_ RolePermissions, err error) {
+	defer r.audit.track(token, "list-roles", "").done(&err)
+	if _, err := r.auth.Authorize(token, PermManageUsers); err != nil {
+		return nil, err
+	}
+
+	return r.userStore.Roles(), nil
+}
+
+func (r *roleOperations) CreateRole(token, name string, permissions []string) (err error) {
+	defer r.audit.track(token, "create-role", name).done(&err)
+	if _, err := r.auth.Authorize(token, PermManageUsers); err != nil {
+		return err
+	}
+
+	return r.updateRoles(func() error {
+		return r.userStore.CreateRole(name, permissions)
+	})
+}
+
+func (r *roleOperations) UpdateRole(token, name string, permissions []string) (err error) {
+	defer r.audit.track(token, "update-role", name).done(&err)
+	if _, err := r.auth.Authorize(token, PermManageUsers); err != nil {
+		return err
+	}
+
+	return r.updateRoles(func() error {
+		return r.userStore.UpdateRole(name, permissions)
+	})
+}
+
+func (r *roleOperations) DeleteRole(token, name string) (err error) {
+	defer r.audit.track(token, "delete-role", name).done(&err)
+	if _, err := r.auth.Authorize(token, PermManageUsers); err != nil {
+		return err
+	}
+
+	return r.updateRoles(func() error {
+		return r.userStore.DeleteRole(name)
+	})
+}
+
+// saveRoles persists the current role permissions to disk
+func (r *roleOperations) saveRoles() error {
+	if err := saveRoles(r.rolesPath, r.userStore.Roles()); err != nil {
+		return fmt.Errorf("failed to save role changes: %w", err)
+	}
+	return nil
+}
+
+// updateRoles applies a change to the roles the way updateUsers does to the users: under
+// roles.json's lock, with the roles reloaded from disk first and saved before the lock is
+// released. users.json's lock is held too, and taken first, since deleting a role checks
+// that no user holds it and every user change checks that its roles exist.
+func (r *roleOperations) updateRoles(change func() error) error {
+	usersLock, err := LockFile(r.usersPath)
+	if err != nil {
+		return fmt.Errorf("failed to acquire users lock: %w", err)
+	}
+	defer usersLock.Unlock()
+	rolesLock, err := LockFile(r.rolesPath)
+	if err != nil {
+		return fmt.Errorf("failed to acquire roles lock: %w", err)
+	}
+	defer rolesLock.Unlock()
+
+	if err := r.userStore.reload(r.usersPath, r.rolesPath); err != nil {
+		return fmt.Errorf("failed to reload roles: %w", err)
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	return r.saveRoles()
+}
+
+// Implementation of GroupOperations interface
+func (g *groupOperations) ListGroups(token string) (_ []*Group, err error) {
+	defer g.audit.track(token, "list-groups", "").done(&err)
+	if _, err := g.auth.Authorize(token, PermManageUsers); err != nil {
+		return nil, err
+	}
+
+	return g.userStore.Groups(), nil
+}
+
+func (g *groupOperations) CreateGroup(token, name string, roles []string) (err error) {
+	defer g.audit.track(token, "create-group", name).done(&err)
+	if _, err := g.auth.Authorize(token, PermManageUsers); err != nil {
+		return err
+	}
+
+	return g.users.updateUsers(func() error {
+		return g.userStore.CreateGroup(name, roles)
+	})
+}
+
+func (g *groupOperations) DeleteGroup(token, name string) (err error) {
+	defer g.audit.track(token, "delete-group", name).done(&err)
+	if _, err := g.auth.Authorize(token, PermManageUsers); err != nil {
+		return err
+	}
+
+	return g.users.updateUsers(func() error {
+		return g.userStore.DeleteGroup(name)
+	})
+}
+
+func (g *groupOperations) AddMember(token, group, username string) (err error) {
+	defer g.audit.track(token, "add-member", group).done(&err)
+	admin, err := g.auth.Authorize(token, PermManageUsers)
+	if err != nil {
+		return err
+	}
+	var approval *approval
+	if g.userStore.GroupGrantsPrivilege(group) {
+		if approval, err = g.users.approvals.gate(admin, OpGrantAdmin, username, time.Now()); err != nil {
+			return err
+		}
+	}
+	defer approval.done(&err)
+
+	return g.users.updateUsers(func() error {
+		return g.userStore.AddGroupMember(group, username)
+	})
+}
+
+func (g *groupOperations) RemoveMember(token, group, username string) (err error) {
+	defer g.audit.track(token, "remove-member", group).done(&err)
+	if _, err := g.auth.Authorize(token, PermManageUsers); err != nil {
+		return err
+	}
+
+	return g.users.updateUsers(func() error {
+		return g.userStore.RemoveGroupMember(group, username)
+	})
+}
+
+// Implementation of ApprovalOperations interface
+func (a *approvalOperations) Settings(token string) (_ ApprovalPolicy, err error) {
+	defer a.audit.track(token, "approval-settings", "").done(&err)
+	if _, err := a.auth.Authorize(token, PermManageUsers); err != nil {
+		return ApprovalPolicy{}, err
+	}
+
+	return a.approvals.Policy()
+}
+
+func (a *approvalOperations) Configure(token string, operations []string, expiry string) (err error) {
+	event := a.audit.track(token, OpChangeApprovals, strings.Join(operations, ","))
+	defer event.done(&err)
+	policy, err := ParseApprovalSettings(operations, expiry)
+	if err != nil {
+		return err
+	}
+	event.entry.Key = policy.Describe()
+	admin, err := authorizeAdmin(a.auth, token, "approval settings are changed by admins")
+	if err != nil {
+		return err
+	}
+	approval, err := a.approvals.gate(admin, OpChangeApprovals, policy.Describe(), time.Now())
+	if err != nil {
+		return err
+	}
+	defer approval.done(&err)
+
+	return a.approvals.configure(policy.Operations, expiry)
+}
+
+func (a *approvalOperations) ListRequests(token string) (_ []*ApprovalRequest, err error) {
+	defer a.audit.track(token, "list-approvals", "").done(&err)
+	if _, err := a.auth.Authorize(token, PermManageUsers); err != nil {
+		return nil, err
+	}
+
+	return a.approvals.load()
+}
+
+func (a *approvalOperations) Approve(token, id string) (_ *ApprovalRequest, err error) {
+	defer a.audit.track(token, "approve", id).done(&err)
+	// Approving unlocks admin-only operations, so it takes an admin too
+	admin, err := authorizeAdmin(a.auth, token, "requests are approved by admins")
+	if err != nil {
+		return nil, err
+	}
+
+	return a.approvals.decide(admin, id, true, "", time.Now())
+}
+
+func (a *approvalOperations) Reject(token, id, reason string) (_ *ApprovalRequest, err error) {
+	defer a.audit.track(token, "reject", id).done(&err)
+	admin, err := authorizeAdmin(a.auth, token, "requests are rejected by admins")
+	if err != nil {
+		return nil, err
+	}
+
+	return a.approvals.decide(admin, id, false, reason, time.Now())
+}
+
+func (a *approvalOperations) Require(token, operation, target string) (_ func(error), err error) {
+	// The caller records the operation once it runs; only a refusal is recorded here
+	defer a.audit.track(token, operation, target).failed(&err)
+	user, err := a.auth.ValidateToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	approval, err := a.approvals.gate(user, operation, target, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return func(err error) { approval.done(&err) }, nil
+}
+
+// Implementation of PolicyOperations interface
+func (p *policyOperations) ListPolicies(token string) (_ []Policy, err error) {
+	defer p.audit.track(token, "list-policies", "").done(&err)
+	if _, err := p.auth.Authorize(token, PermManageUsers); err != nil {
+		return nil, err
+	}
+
+	return p.engine.Policies(), nil
+}
+
+func (p *policyOperations) AddPolicy(token string, policy Policy) (_ Policy, err error) {
+	defer p.audit.track(token, "add-policy", strings.Join(policy.Keys, ",")).done(&err)
+	if _, err := p.auth.Authorize(token, PermManageUsers); err != nil {
+		return Policy{}, err
+	}
+
+	return p.engine.Add(policy)
+}
+
+func (p *policyOperations) RemovePolicy(token, id string) (err error) {
+	defer p.audit.track(token, "remove-policy", id).done(&err)
+	if _, err := p.auth.Authorize(token, PermManageUsers); err != nil {
+		return err
+	}
+
+	return p.engine.Remove(id)
+}
+
+// TestPolicy explains the decision for each action a user could take on a key
+func (p *policyOperations) TestPolicy(token, username, key string, actions []string) (_ []PolicyDecision, err error) {
+	defer p.audit.track(token, "test-policy", key).done(&err)
+	if _, err := p.auth.Authorize(token, PermManageUsers); err != nil {
+		return nil, err
+	}
+
+	user, err := p.userStore.FindUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	var decisions []PolicyDecision
+	for _, action := range actions {
+		if err := validatePolicyAction(action); err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, p.engine.Evaluate(user, p.userStore.Permissions(), action, key))
+	}
+	return decisions, nil
+}
+
+// Implementation of TokenOperations interface
+func (t *tokenOperations) CreateToken(token, username string, options TokenOptions) (_ string, _ *Token, err error) {
+	defer t.audit.track(token, "create-token", username).done(&err)
+	target, err := t.authorizeTarget(token, username)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var value string
+	var created *Token
+	err = t.users.updateUsers(func() error {
+		var err error
+		value, created, err = t.userStore.CreateToken(target, options)
+		return err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return value, created, nil
+}
+
+func (t *tokenOperations) ListTokens(token, username string) (_ []TokenInfo, err error) {
+	defer t.audit.track(token, "list-tokens", username).done(&err)
+	user, err := t.auth.ValidateToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Anyone may list their own tokens
+	if username == "" || username == user.Username {
+		return t.userStore.ListTokens(user.Username)
+	}
+	if !user.Can(PermRotateTokens, t.userStore.Permissions()) {
+		return nil, NewPermissionDeniedError(PermRotateTokens)
+	}
+	return t.userStore.ListTokens(username)
+}
+
+func (t *tokenOperations) RevokeToken(token, username, nameOrID string) (_ string, err error) {
+	defer t.audit.track(token, "revoke-token", nameOrID).done(&err)
+	if IsDerivedToken(nameOrID) || IsDerivedTokenID(nameOrID) {
+		return t.revokeDerived(token, nameOrID)
+	}
+
+	target, err := t.authorizeTarget(token, username)
+	if err != nil {
+		return "", err
+	}
+
+	var name string
+	err = t.users.updateUsers(func() error {
+		var err error
+		name, err = t.userStore.RevokeToken(target, nameOrID)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// RotateToken replaces a token by name or ID, keeping the old value valid for the grace period
+func (t *tokenOperations) RotateToken(token, username, nameOrID string, grace TokenGrace) (_ string, err error) {
+	defer t.audit.track(token, "rotate-named-token", nameOrID).done(&err)
+	target, err := t.authorizeTarget(token, username)
+	if err != nil {
+		return "", err
+	}
+
+	var value string
+	err = t.users.updateUsers(func() error {
+		var err error
+		value, err = t.userStore.RotateTokenWithGrace(target, nameOrID, grace)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// RevokePrevious ends the grace period of a rotated token early
+func (t *tokenOperations) RevokePrevious(token, username, nameOrID string) (_ string, err error) {
+	defer t.audit.track(token, "revoke-previous", nameOrID).done(&err)
+	target, err := t.authorizeTarget(token, username)
+	if err != nil {
+		return "", err
+	}
+
+	var name string
+	err = t.users.updateUsers(func() error {
+		var err error
+		name, err = t.userStore.RevokePreviousToken(target, nameOrID)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// IssueToken signs a derived token for the caller, limited to the scopes and expiring after ttl
+func (t *tokenOperations) IssueToken(token string, scopes []string, ttl time.Duration) (_ string, _ *DerivedClaims, err error) {
+	defer t.audit.track(token, "issue-token", strings.Join(scopes, ",")).done(&err)
+	now := time.Now()
+	user, match, err := t.userStore.authenticate(token, now)
+	if err != nil {
+		return "", nil, err
+	}
+	t.userStore.lifetime.warnIfExpiring(user, match, now)
+
+	return t.userStore.IssueDerivedToken(user, match.ExpiresAt, scopes, ttl)
+}
+
+// revokeDerived revokes a derived token given its value or ID. Its issuer may revoke it
+// by value; revoking by ID, or another user's token, needs rotate-tokens.
+func (t *tokenOperations) revokeDerived(token, valueOrID string) (string, error) {
+	user, err := t.auth.ValidateToken(token)
+	if err != nil {
+		return "", err
+	}
+
+	if IsDerivedTokenID(valueOrID) {
+		if !user.Can(PermRotateTokens, t.userStore.Permissions()) {
+			return "", NewPermissionDeniedError(PermRotateTokens)
+		}
+		if err := t.userStore.RevokeDerivedTokenID(valueOrID); err != nil {
+			return "", fmt.Errorf("failed to revoke derived token: %w", err)
+		}
+		return valueOrID, nil
+	}
+
+	claims, err := t.userStore.ParseDerivedToken(valueOrID)
+	if err != nil {
+		return "", fmt.Errorf("cannot revoke derived token: %w", err)
+	}
+	if claims.Issuer != user.Username && !user.Can(PermRotateTokens, t.userStore.Permissions()) {
+		return "", NewPermissionDeniedError(PermRotateTokens)
+	}
+	if err := t.userStore.RevokeDerivedToken(claims); err != nil {
+		return "", fmt.Errorf("failed to revoke derived token: %w", err)
+	}
+	return claims.ID, nil
+}
+
+// authorizeTarget returns whose tokens the caller may change: their own with
+// rotate-own-token, or another user's with rotate-tokens
+func (t *tokenOperations) authorizeTarget(token, username string) (string, error) {
+	user, err := t.auth.ValidateToken(token)
+	if err != nil {
+		return "", err
+	}
+
+	permission := PermRotateTokens
+	if username == "" || username == user.Username {
+		username = user.Username
+		permission = PermRotateOwnToken
+	}
+	if !user.Can(permission, t.userStore.Permissions()) {
+		return "", NewPermissionDeniedError(permission)
+	}
+	return username, nil
+}
+
+// Implementation of RecoveryOperations interface
+
+// AdminRecovery is the result of a successful recovery
+type AdminRecovery struct {
+	Username  string
+	Token     string
+	Remaining int // unused recovery codes left
+}
+
+// RecoverAdmin checks the code before the admin's account is changed, so a wrong code
+// changes nothing. Wrong codes count as failed authentications from this client.
+func (r *recoveryOperations) RecoverAdmin(code, username string) (_ *AdminRecovery, err error) {
+	// No one is signed in; the entry names the admin recovered
+	event := r.audit.trackUser("", "recover-admin", username)
+	defer event.done(&err)
+	client, now := LocalAuthClient(), time.Now()
+	failures := r.userStore.failures
+	if failures.enabled() {
+		file, err := failures.load()
+		if err != nil {
+			return nil, err
+		}
+		if err := failures.check(file, client, "", now); err != nil {
+			return nil, err
+		}
+	}
+
+	target, err := r.userStore.recoveryTarget(username)
+	if err != nil {
+		return nil, err
+	}
+	event.entry.Key = target.Username
+	remaining, err := r.codes.consume(code, target.Username, client, now)
+	if errors.Is(err, ErrInvalidRecoveryCode) && failures.enabled() {
+		failures.failed(client, "", now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var token string
+	err = r.users.updateUsers(func() error {
+		user, err := r.userStore.recoveryTarget(target.Username)
+		if err != nil {
+			return err
+		}
+		token, err = r.userStore.recoverAdmin(user)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("the recovery code was used but the new token could not be saved: %w", err)
+	}
+	// As after a successful sign-in, the failures of the client and the admin are cleared
+	if _, err := r.userStore.UnlockUser(target.Username); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to clear failed sign-ins of %s: %v\n", target.Username, err)
+	}
+	if _, err := r.userStore.UnlockClient(client); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to clear failed sign-ins from %s: %v\n", client, err)
+	}
+	return &AdminRecovery{Username: target.Username, Token: token, Remaining: remaining}, nil
+}
+
+// RegenerateCodes replaces every recovery code; only admins may
+func (r *recoveryOperations) RegenerateCodes(token string) (_ []string, err error) {
+	defer r.audit.track(token, "regenerate-recovery-codes", "").done(&err)
+	admin, err := r.authorizeAdmin(token)
+	if err != nil {
+		return nil, err
+	}
+	return r.codes.issue(admin.Username, time.Now())
+}
+
+// Status reports how many codes are left and every recovery made
+func (r *recoveryOperations) Status(token string) (_ *RecoveryStatus, err error) {
+	defer r.audit.track(token, "recovery-status", "").done(&err)
+	if _, err := r.authorizeAdmin(token); err != nil {
+		return nil, err
+	}
+	return r.codes.status()
+}
+
+// authorizeAdmin requires the admin role, directly or through a group
+func (r *recoveryOperations) authorizeAdmin(token string) (*User, error) {
+	return authorizeAdmin(r.auth, token, "recovery codes are managed by admins")
+}
+
+// authorizeAdmin validates the token and checks that its user is an admin, for
+// operations no permission grants
+func authorizeAdmin(auth AuthOperations, token, reason string) (*User, error) {
+	user, err := auth.ValidateToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsAdmin() {
+		return nil, fmt.Errorf("%w: %s", ErrPermissionDenied, reason)
+	}
+	return user, nil
+}
+
+// Implementation of AuditOperations interface
+func (a *auditOperations) Record(user *User, op, key string, err error) {
+	username := ""
+	if user != nil {
+		username = user.Username
+	}
+	a.log.trackUser(username, op, key).done(&err)
+}
+
+func (a *auditOperations) Verify(token string) (_ *AuditVerification, err error) {
+	defer a.log.track(token, "audit-verify", "").done(&err)
+	if _, err := authorizeAdmin(a.auth, token, "the audit log is verified by admins"); err != nil {
+		return nil, err
+	}
+	return a.log.verify()
+}
+
+func (a *auditOperations) Query(token string, filter AuditFilter) (_ []AuditEntry, err error) {
+	defer a.log.track(token, "audit-query", filter.Key).done(&err)
+	if _, err := authorizeAdmin(a.auth, token, "the audit log is read by admins"); err != nil {
+		return nil, err
+	}
+	return a.log.query(filter)
+}
+
+func (a *auditOperations) Export(token string, filter AuditFilter) (_ []AuditEntry, err error) {
+	defer a.log.track(token, "audit-export", filter.Key).done(&err)
+	if _, err := authorizeAdmin(a.auth, token, "the audit log is exported by admins"); err != nil {
+		return nil, err
+	}
+	return a.log.query(filter)
+}
+
+func (a *auditOperations) Prune(token string, olderThan time.Duration) (_ *AuditPruneResult, err error) {
+	// The entry is written after the prune, so it is never pruned itself
+	defer a.log.track(token, "audit-prune", "").done(&err)
+	if _, err := authorizeAdmin(a.auth, token, "the audit log is pruned by admins"); err != nil {
+		return nil, err
+	}
+	return a.log.prune(time.Now().Add(-olderThan))
+}
+
+// Implementation of ReportOperations interface
+func (r *reportOperations) Access(token string, namespaces []string) (_ *AccessReport, err error) {
+	defer r.audit.track(token, "report-access", strings.Join(namespaces, ",")).done(&err)
+	if _, err := r.auth.Authorize(token, PermManageUsers); err != nil {
+		return nil, err
+	}
+	return r.userStore.AccessReport(r.policies, r.secrets.ListKeys(), namespaces, time.Now())
+}
+
+func (r *reportOperations) Stale(token string, unusedFor time.Duration) (_ []StaleSecret, err error) {
+	defer r.audit.track(token, "report-stale", "").done(&err)
+	if _, err := r.auth.Authorize(token, PermManageUsers); err != nil {
+		return nil, err
+	}
+	return r.usage.stale(r.secrets.ListKeys(), unusedFor, time.Now())
+}
+
+// Implementation of BackupOperations interface
+func (b *backupOperations) Create(token, out string, key BackupKey) (_ *BackupManifest, err error) {
+	defer b.audit.track(token, "backup-create", filepath.Base(out)).done(&err)
+	user, err := authorizeAdmin(b.auth, token, "backup archives hold the master key and are created by admins")
+	if err != nil {
+		return nil, err
+	}
+	return CreateBackupArchive(b.configDir, out, key, user.Username)
+}
+
+func (b *backupOperations) Restore(token, archivePath string, key BackupKey) (_ *BackupRestore, err error) {
+	// The entry is appended to the restored audit log
+	defer b.audit.track(token, "backup-restore", filepath.Base(archivePath)).done(&err)
+	if _, err := authorizeAdmin(b.auth, token, "backup archives are restored by admins"); err != nil {
+		return nil, err
+	}
+	result, err := RestoreBackupArchive(b.configDir, archivePath, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.store.loadOrCreateKey(); err != nil {
+		return nil, fmt.Errorf("failed to load restored key: %w", err)
+	}
+	if err := b.store.loadSecrets(); err != nil {
+		return nil, fmt.Errorf("failed to load restored secrets: %w", err)
+	}
+	return result, nil
+}
+
+func (b *backupOperations) Prune(token string, dryRun bool) (_ *BackupPrunePlan, err error) {
+	mode := ""
+	if dryRun {
+		mode = "dry-run"
+	}
+	defer b.audit.track(token, "backup-prune", mode).done(&err)
+	if _, err := authorizeAdmin(b.auth, token, "backups are pruned by admins"); err != nil {
+		return nil, err
+	}
+	return PruneBackups(b.configDir, dryRun, time.Now())
+}
+
 // createSecretsStore creates a secrets store with the appropriate backend and directory
 func createSecretsStore(config *ServiceConfig) (*SecretsStore, error) {
 	if config.ConfigDir != "" {
@@ -503,7 +1691,7 @@ func loadUserStoreFromConfigDir(configDir string) (*UserStore, error) {
 	usersPath := filepath.Join(configDir, "users.json")
 	rolesPath := filepath.Join(configDir, "roles.json")
 
-	users, err := loadUsers(usersPath)
+	users, groups, err := loadUsersAndGroups(usersPath)
 	if os.IsNotExist(err) {
 		return nil, ErrFirstRunRequired
 	}
@@ -516,5 +1704,5 @@ func loadUserStoreFromConfigDir(configDir string) (*UserStore, error) {
 		return nil, err
 	}
 
-	return createUserStore(users, permissions), nil
+	return createUserStore(users, permissions).withGroups(groups).withConfigDir(configDir), nil
 }
@@ -0,0 +1,360 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNotStructured indicates a secret value is not a structured (JSON object) value
+var ErrNotStructured = errors.New("secret is not a structured value (expected a JSON object)")
+
+// ErrFieldNotFound indicates a requested field does not exist in a structured secret
+var ErrFieldNotFound = errors.New("field not found")
+
+// StructuredValue is a secret whose value is a record of named fields
+// (for example username, password, host and port). It is stored encrypted
+// as a canonical JSON object, so every read and write goes through the same
+// path as a plain string secret.
+type StructuredValue map[string]any
+
+// ParseStructuredJSON parses a JSON object into a structured value
+func ParseStructuredJSON(data []byte) (StructuredValue, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value StructuredValue
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid JSON object: %w", err)
+	}
+	if value == nil {
+		return nil, fmt.Errorf("invalid JSON object: value must be an object, not null")
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("invalid JSON object: unexpected data after object")
+	}
+	if err := value.validateFieldNames(); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// ParseStructuredYAML parses a flat YAML mapping of "field: value" lines.
+// Nested mappings and lists are not supported; use JSON for those.
+func ParseStructuredYAML(data []byte) (StructuredValue, error) {
+	value := StructuredValue{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if isIgnorableYAMLLine(line) {
+			continue
+		}
+
+		field, fieldValue, err := parseYAMLMappingLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML on line %d: %w", lineNumber, err)
+		}
+		if _, exists := value[field]; exists {
+			return nil, fmt.Errorf("invalid YAML on line %d: duplicate field %q", lineNumber, field)
+		}
+		value[field] = fieldValue
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read YAML: %w", err)
+	}
+
+	if len(value) == 0 {
+		return nil, fmt.Errorf("invalid YAML: no fields found")
+	}
+	return value, nil
+}
+
+// ParseStructuredFile parses structured data using the file extension to choose the format.
+// Files without a .json, .yaml or .yml extension are tried as JSON first, then YAML.
+func ParseStructuredFile(path string, data []byte) (StructuredValue, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ParseStructuredJSON(data)
+	case ".yaml", ".yml":
+		return ParseStructuredYAML(data)
+	}
+
+	if value, err := ParseStructuredJSON(data); err == nil {
+		return value, nil
+	}
+	return ParseStructuredYAML(data)
+}
+
+// parseStoredStructuredValue interprets a stored plaintext secret as a structured value
+func parseStoredStructuredValue(plaintext string) (StructuredValue, error) {
+	trimmed := strings.TrimSpace(plaintext)
+	if !strings.HasPrefix(trimmed, "{") {
+		return nil, ErrNotStructured
+	}
+
+	value, err := ParseStructuredJSON([]byte(trimmed))
+	if err != nil {
+		return nil, ErrNotStructured
+	}
+	return value, nil
+}
+
+// Encode returns the canonical JSON form used for storage (fields sorted by name)
+func (sv StructuredValue) Encode() (string, error) {
+	encoded, err := json.Marshal(sv)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode structured value: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// Field returns a single field rendered as text. Strings are returned as-is;
+// numbers, booleans and nested values are rendered as compact JSON.
+func (sv StructuredValue) Field(name string) (string, error) {
+	raw, ok := sv[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrFieldNotFound, name)
+	}
+
+	if text, isString := raw.(string); isString {
+		return text, nil
+	}
+
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to render field %q: %w", name, err)
+	}
+	return string(encoded), nil
+}
+
+// FieldNames returns the sorted list of field names
+func (sv StructuredValue) FieldNames() []string {
+	names := make([]string, 0, len(sv))
+	for name := range sv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateFieldNames rejects empty field names, which cannot be addressed with --field
+func (sv StructuredValue) validateFieldNames() error {
+	for name := range sv {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("field names cannot be empty")
+		}
+	}
+	return nil
+}
+
+// updatedFieldValue returns the value a field is set to. A field that holds a number, boolean
+// or nested value keeps its JSON type when the new text is valid JSON, so "port=5433" updates
+// a numeric port to a number; new fields and string fields are set to the text as given.
+func updatedFieldValue(current any, text string) any {
+	if _, isString := current.(string); isString || current == nil {
+		return text
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var updated any
+	if err := decoder.Decode(&updated); err != nil || decoder.More() {
+		return text
+	}
+	return updated
+}
+
+// ParseFieldAssignment splits a "field=value" assignment as used by put --set
+func ParseFieldAssignment(assignment string) (string, string, error) {
+	field, value, found := strings.Cut(assignment, "=")
+	if !found {
+		return "", "", fmt.Errorf("invalid field assignment %q: expected field=value", assignment)
+	}
+	if strings.TrimSpace(field) == "" {
+		return "", "", fmt.Errorf("invalid field assignment %q: field name cannot be empty", assignment)
+	}
+	return field, value, nil
+}
+
+func isIgnorableYAMLLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---"
+}
+
+func parseYAMLMappingLine(line string) (string, string, error) {
+	if line[0] == ' ' || line[0] == '\t' {
+		return "", "", fmt.Errorf("nested structures are not supported; use --json for nested values")
+	}
+	if strings.HasPrefix(line, "- ") {
+		return "", "", fmt.Errorf("lists are not supported; expected 'field: value'")
+	}
+
+	field, rawValue, found := strings.Cut(line, ":")
+	if !found {
+		return "", "", fmt.Errorf("expected 'field: value'")
+	}
+
+	field = unquoteYAMLScalar(strings.TrimSpace(field))
+	if field == "" {
+		return "", "", fmt.Errorf("field name cannot be empty")
+	}
+
+	fieldValue, err := parseYAMLScalar(rawValue)
+	if err != nil {
+		return "", "", fmt.Errorf("field %q: %w", field, err)
+	}
+	return field, fieldValue, nil
+}
+
+func parseYAMLScalar(rawValue string) (string, error) {
+	trimmed := strings.TrimSpace(rawValue)
+	if trimmed == "" {
+		return "", fmt.Errorf("nested structures and empty values are not supported")
+	}
+
+	if isQuotedYAMLScalar(trimmed) {
+		return unquoteYAMLScalar(trimmed), nil
+	}
+
+	withoutComment, _, _ := strings.Cut(trimmed, " #")
+	return unquoteYAMLScalar(strings.TrimSpace(withoutComment)), nil
+}
+
+func isQuotedYAMLScalar(value string) bool {
+	if len(value) < 2 {
+		return false
+	}
+	first, last := value[0], value[len(value)-1]
+	return (first == '"' && last == '"') || (first == '\'' && last == '\'')
+}
+
+func unquoteYAMLScalar(value string) string {
+	if !isQuotedYAMLScalar(value) {
+		return value
+	}
+
+	if value[0] == '\'' {
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+
+	var unquoted string
+	if err := json.Unmarshal([]byte(value), &unquoted); err != nil {
+		return value[1 : len(value)-1]
+	}
+	return unquoted
+}
+
+// ====================================
+// Structured Secret Store Operations
+// ====================================
+
+// PutStructured stores a structured value under key using the normal versioned write path
+func (s *SecretsStore) PutStructured(key string, value StructuredValue) error {
+	encoded, err := value.Encode()
+	if err != nil {
+		return err
+	}
+	return s.Put(key, encoded)
+}
+
+// GetStructured retrieves and parses a structured secret
+func (s *SecretsStore) GetStructured(key string) (StructuredValue, error) {
+	plaintext, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return parseStoredStructuredValue(plaintext)
+}
+
+// GetField returns a single field of a structured secret
+func (s *SecretsStore) GetField(key, field string) (string, error) {
+	value, err := s.GetStructured(key)
+	if err != nil {
+		return "", err
+	}
+	return value.Field(field)
+}
+
+// ListFields returns the sorted field names of a structured secret
+func (s *SecretsStore) ListFields(key string) ([]string, error) {
+	value, err := s.GetStructured(key)
+	if err != nil {
+		return nil, err
+	}
+	return value.FieldNames(), nil
+}
+
+// SetFields updates individual fields of an existing structured secret.
+// The read-modify-write happens under the database lock, and the previous
+// value is backed up exactly like a regular Put.
+func (s *SecretsStore) SetFields(key string, updates map[string]string) error {
+	lock, err := LockFile(s.SecretsPath)
+	if err != nil {
+		return fmt.Errorf("failed to acquire database lock: %w", err)
+	}
+	defer lock.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mergeWithDiskState(); err != nil {
+		return fmt.Errorf("failed to merge disk state: %w", err)
+	}
+
+	record, ok := s.secrets[key]
+	if !ok || !record.isEnabled() {
+		return ErrNotFound
+	}
+
+	plaintext, err := decrypt(s.masterKey, record.Value)
+	if err != nil {
+		return err
+	}
+
+	value, err := parseStoredStructuredValue(string(plaintext))
+	if err != nil {
+		return err
+	}
+
+	for field, fieldValue := range updates {
+		value[field] = updatedFieldValue(value[field], fieldValue)
+	}
+
+	encoded, err := value.Encode()
+	if err != nil {
+		return err
+	}
+
+	encryptedValue, err := encrypt(s.masterKey, []byte(encoded))
+	if err != nil {
+		return err
+	}
+
+	s.ensureBackupExists(key)
+	record.Value = encryptedValue
+	s.secrets[key] = record
+	return s.saveSecretsLocked()
+}
@@ -0,0 +1,218 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseStructuredJSON(t *testing.T) {
+	value, err := ParseStructuredJSON([]byte(`{"username":"app","password":"s3cret","port":5432}`))
+	if err != nil {
+		t.Fatalf("ParseStructuredJSON failed: %v", err)
+	}
+
+	port, err := value.Field("port")
+	if err != nil {
+		t.Fatalf("Field(port) failed: %v", err)
+	}
+	if port != "5432" {
+		t.Errorf("expected port to keep its numeric form, got %q", port)
+	}
+
+	if _, err := ParseStructuredJSON([]byte(`["not", "an", "object"]`)); err == nil {
+		t.Error("expected error for JSON array")
+	}
+	if _, err := ParseStructuredJSON([]byte(`null`)); err == nil {
+		t.Error("expected error for JSON null")
+	}
+	if _, err := ParseStructuredJSON([]byte(`{"a":1} {"b":2}`)); err == nil {
+		t.Error("expected error for trailing data")
+	}
+}
+
+func TestParseStructuredYAML(t *testing.T) {
+	data := []byte(`# database credentials
+---
+username: app
+password: 'it''s: secret'
+host: "db.internal" # primary
+port: 5432
+`)
+
+	value, err := ParseStructuredYAML(data)
+	if err != nil {
+		t.Fatalf("ParseStructuredYAML failed: %v", err)
+	}
+
+	expected := StructuredValue{
+		"username": "app",
+		"password": "it's: secret",
+		"host":     "db.internal",
+		"port":     "5432",
+	}
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("unexpected YAML result: got %v, want %v", value, expected)
+	}
+
+	invalidDocuments := map[string]string{
+		"nested":    "db:\n  host: x\n",
+		"list":      "- one\n- two\n",
+		"no_colon":  "just text\n",
+		"duplicate": "a: 1\na: 2\n",
+		"empty":     "# only a comment\n",
+	}
+	for name, document := range invalidDocuments {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseStructuredYAML([]byte(document)); err == nil {
+				t.Errorf("expected error for %s document", name)
+			}
+		})
+	}
+}
+
+func TestParseFieldAssignment(t *testing.T) {
+	field, value, err := ParseFieldAssignment("password=a=b")
+	if err != nil {
+		t.Fatalf("ParseFieldAssignment failed: %v", err)
+	}
+	if field != "password" || value != "a=b" {
+		t.Errorf("expected password/a=b, got %q/%q", field, value)
+	}
+
+	for _, invalid := range []string{"password", "=value"} {
+		if _, _, err := ParseFieldAssignment(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestStructuredSecretLifecycle(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("SIMPLE_SECRETS_CONFIG_DIR", tmpDir)
+
+	store, err := LoadSecretsStoreFromDir(NewFilesystemBackend(), tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	value := StructuredValue{"username": "app", "password": "old"}
+	if err := store.PutStructured("db-creds", value); err != nil {
+		t.Fatalf("PutStructured failed: %v", err)
+	}
+
+	fields, err := store.ListFields("db-creds")
+	if err != nil {
+		t.Fatalf("ListFields failed: %v", err)
+	}
+	if !reflect.DeepEqual(fields, []string{"password", "username"}) {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+
+	if err := store.SetFields("db-creds", map[string]string{"password": "new", "host": "db2"}); err != nil {
+		t.Fatalf("SetFields failed: %v", err)
+	}
+
+	password, err := store.GetField("db-creds", "password")
+	if err != nil {
+		t.Fatalf("GetField failed: %v", err)
+	}
+	if password != "new" {
+		t.Errorf("expected updated password, got %q", password)
+	}
+
+	username, _ := store.GetField("db-creds", "username")
+	if username != "app" {
+		t.Errorf("expected untouched field to survive update, got %q", username)
+	}
+
+	backup, err := os.ReadFile(store.GetBackupPath("db-creds"))
+	if err != nil {
+		t.Fatalf("expected field update to back up previous value: %v", err)
+	}
+	previous, err := store.DecryptBackup(string(backup))
+	if err != nil {
+		t.Fatalf("failed to decrypt backup: %v", err)
+	}
+	if previous != `{"password":"old","username":"app"}` {
+		t.Errorf("unexpected backup contents: %s", previous)
+	}
+
+	if _, err := store.GetField("db-creds", "missing"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("expected ErrFieldNotFound, got %v", err)
+	}
+}
+
+func TestSetFieldsKeepsJSONTypes(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("SIMPLE_SECRETS_CONFIG_DIR", tmpDir)
+
+	store, err := LoadSecretsStoreFromDir(NewFilesystemBackend(), tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	value, err := ParseStructuredJSON([]byte(`{"port": 5432, "tls": true, "pool": {"max": 10}, "password": "old"}`))
+	if err != nil {
+		t.Fatalf("ParseStructuredJSON failed: %v", err)
+	}
+	if err := store.PutStructured("db-creds", value); err != nil {
+		t.Fatalf("PutStructured failed: %v", err)
+	}
+
+	if err := store.SetFields("db-creds", map[string]string{"password": "123", "port": "5433"}); err != nil {
+		t.Fatalf("SetFields failed: %v", err)
+	}
+	if err := store.SetFields("db-creds", map[string]string{"tls": "false", "note": "42"}); err != nil {
+		t.Fatalf("SetFields failed: %v", err)
+	}
+
+	stored, err := store.Get("db-creds")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	want := `{"note":"42","password":"123","pool":{"max":10},"port":5433,"tls":false}`
+	if stored != want {
+		t.Errorf("expected field types to survive updates:\n got %s\nwant %s", stored, want)
+	}
+}
+
+func TestStructuredOperationsRejectPlainSecrets(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("SIMPLE_SECRETS_CONFIG_DIR", tmpDir)
+
+	store, err := LoadSecretsStoreFromDir(NewFilesystemBackend(), tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	if err := store.Put("plain", "just-a-string"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if _, err := store.GetField("plain", "password"); !errors.Is(err, ErrNotStructured) {
+		t.Errorf("expected ErrNotStructured from GetField, got %v", err)
+	}
+	if err := store.SetFields("plain", map[string]string{"a": "b"}); !errors.Is(err, ErrNotStructured) {
+		t.Errorf("expected ErrNotStructured from SetFields, got %v", err)
+	}
+	if err := store.SetFields("missing", map[string]string{"a": "b"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for missing secret, got %v", err)
+	}
+}
@@ -0,0 +1,382 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// DefaultTokenName names the token a user is created with, stored in User.TokenHash
+const DefaultTokenName = "default"
+
+// tokenUsageFileName holds the last-used time of each token, outside the sealed users.json
+const tokenUsageFileName = "token_usage.json"
+
+// tokenUsageInterval limits how often a token's last-used time is written
+const tokenUsageInterval = time.Minute
+
+// tokenNamePattern restricts token names to short identifiers such as ci-deploy or laptop
+var tokenNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// Token is an additional named token of a user, created with 'token create'.
+// Each one authenticates as its user and can be revoked without affecting the others.
+type Token struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Hash        string     `json:"hash"`                  // SHA-256 hash, base64-encoded
+	LookupID    string     `json:"lookup_id,omitempty"`   // ID embedded in the token, see token_format.go
+	Description string     `json:"description,omitempty"` // where the token is used, e.g. "GitHub Actions deploy"
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// TokenInfo describes one of a user's tokens without its hash, as shown by 'token list'
+type TokenInfo struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// TokenOptions describes a named token to create
+type TokenOptions struct {
+	Name        string
+	Description string
+	ExpiresAt   *time.Time
+}
+
+// ValidateTokenName checks that a token name is a short identifier and not reserved
+func ValidateTokenName(name string) error {
+	if name == DefaultTokenName {
+		return fmt.Errorf("token name %q is reserved for the token the user was created with", name)
+	}
+	if !tokenNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid token name %q: use up to 64 letters, digits, '.', '-' and '_', starting with a letter or digit", name)
+	}
+	return nil
+}
+
+// matchToken returns the ID of the user's token with the given hash, expired or not
+func (u *User) matchToken(tokenHash string) (string, bool) {
+	found := ""
+	if u.TokenHash != "" && subtle.ConstantTimeCompare([]byte(tokenHash), []byte(u.TokenHash)) == 1 {
+		found = DefaultTokenName
+	}
+	for _, t := range u.Tokens {
+		if subtle.ConstantTimeCompare([]byte(tokenHash), []byte(t.Hash)) == 1 {
+			found = t.ID
+		}
+	}
+	return found, found != ""
+}
+
+// findToken returns the index of the named token matching a name or ID, or -1
+func (u *User) findToken(nameOrID string) int {
+	return slices.IndexFunc(u.Tokens, func(t *Token) bool {
+		return t.Name == nameOrID || t.ID == nameOrID
+	})
+}
+
+// tokenHashes returns the hashes of every token the user holds
+func (u *User) tokenHashes() []string {
+	var hashes []string
+	if u.TokenHash != "" {
+		hashes = append(hashes, u.TokenHash)
+	}
+	for _, t := range u.Tokens {
+		hashes = append(hashes, t.Hash)
+	}
+	return hashes
+}
+
+// revokeDefaultToken clears the token the user was created with
+func (u *User) revokeDefaultToken() {
+	u.TokenHash = ""
+	u.TokenLookupID = ""
+	u.dropPreviousToken(DefaultTokenName)
+	now := time.Now()
+	u.TokenRotatedAt = &now
+}
+
+// revokeToken removes the named token with the given ID, or clears the default token
+func (u *User) revokeToken(id string) {
+	if id == DefaultTokenName {
+		u.revokeDefaultToken()
+		return
+	}
+	if i := u.findToken(id); i >= 0 {
+		u.Tokens = slices.Delete(u.Tokens, i, i+1)
+	}
+	u.dropPreviousToken(id)
+}
+
+// newTokenID returns a short random identifier for a named token
+func newTokenID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "tok_" + hex.EncodeToString(b), nil
+}
+
+// ====================================
+// UserStore Token Management
+// ====================================
+
+// CreateToken adds a named token to a user and returns its value
+func (us *UserStore) CreateToken(username string, options TokenOptions) (string, *Token, error) {
+	if err := ValidateTokenName(options.Name); err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	if options.ExpiresAt != nil && !options.ExpiresAt.After(now) {
+		return "", nil, fmt.Errorf("token expiry must be in the future")
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user, err := us.userByName(username)
+	if err != nil {
+		return "", nil, err
+	}
+	if user.findToken(options.Name) >= 0 {
+		return "", nil, fmt.Errorf("user %q already has a token named %q", username, options.Name)
+	}
+
+	value, err := generateSecureToken()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate token ID: %w", err)
+	}
+
+	token := &Token{
+		ID:          id,
+		Name:        options.Name,
+		Hash:        HashToken(value),
+		LookupID:    tokenLookupID(value),
+		Description: options.Description,
+		CreatedAt:   now.UTC(),
+		ExpiresAt:   options.ExpiresAt,
+	}
+	user.Tokens = append(user.Tokens, token)
+	us.indexUser(user)
+	return value, token, nil
+}
+
+// RevokeToken removes a user's token by name or ID and returns the revoked token's name.
+// Revoking "default" clears the token the user was created with. A user's last active
+// token is not revoked; 'disable user' leaves an account without tokens on purpose.
+func (us *UserStore) RevokeToken(username, nameOrID string) (string, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user, err := us.userByName(username)
+	if err != nil {
+		return "", err
+	}
+
+	if nameOrID == DefaultTokenName {
+		if user.TokenHash == "" {
+			return "", fmt.Errorf("user %q has no active default token", username)
+		}
+		if err := us.checkKeepsActiveToken(user, DefaultTokenName, time.Now()); err != nil {
+			return "", err
+		}
+		user.revokeDefaultToken()
+		return DefaultTokenName, nil
+	}
+
+	i := user.findToken(nameOrID)
+	if i < 0 {
+		return "", fmt.Errorf("user %q has no token named %q", username, nameOrID)
+	}
+	name, id := user.Tokens[i].Name, user.Tokens[i].ID
+	if err := us.checkKeepsActiveToken(user, id, time.Now()); err != nil {
+		return "", err
+	}
+	user.revokeToken(id)
+	return name, nil
+}
+
+// checkKeepsActiveToken refuses to revoke the user's last token that has not expired, which
+// would leave an account that cannot sign in, and for the last admin no admin at all.
+// Callers must hold the lock.
+func (us *UserStore) checkKeepsActiveToken(user *User, id string, now time.Time) error {
+	active := us.activeTokenIDs(user, now)
+	if !slices.Contains(active, id) || len(active) > 1 {
+		return nil
+	}
+	name := user.TokenDisplayName(id)
+	if user.IsAdmin() && us.countAdminUsers() <= 1 {
+		return fmt.Errorf("cannot revoke token %q: it is the last active token of the last admin user", name)
+	}
+	return fmt.Errorf("cannot revoke token %q: it is the last active token of user %q; "+
+		"create another token first, or disable the account with 'simple-secrets disable user %s'", name, user.Username, user.Username)
+}
+
+// activeTokenIDs returns the IDs of the user's default and named tokens that have not expired
+func (us *UserStore) activeTokenIDs(user *User, now time.Time) []string {
+	var ids []string
+	if user.TokenHash != "" {
+		ids = append(ids, DefaultTokenName)
+	}
+	for _, t := range user.Tokens {
+		ids = append(ids, t.ID)
+	}
+	return slices.DeleteFunc(ids, func(id string) bool {
+		return checkExpiry(user, us.lifetime.describeToken(user, id), now) != nil
+	})
+}
+
+// RotateNamedToken replaces the value of a user's token, keeping its name, description and expiry
+func (us *UserStore) RotateNamedToken(username, nameOrID string) (string, error) {
+	return us.RotateTokenWithGrace(username, nameOrID, TokenGrace{})
+}
+
+// ListTokens describes a user's tokens, starting with the default token
+func (us *UserStore) ListTokens(username string) ([]TokenInfo, error) {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	user, err := us.userByName(username)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := map[string]time.Time{}
+	if us.usage != nil {
+		usage = us.usage.load()
+	}
+
+	var tokens []TokenInfo
+	if user.TokenHash != "" {
+		tokens = append(tokens, TokenInfo{
+			ID:         DefaultTokenName,
+			Name:       DefaultTokenName,
+			CreatedAt:  user.TokenRotatedAt,
+			LastUsedAt: lastUsedAt(usage, user.TokenHash),
+			ExpiresAt:  us.lifetime.describeToken(user, DefaultTokenName).ExpiresAt,
+		})
+	}
+	for _, t := range user.Tokens {
+		created := t.CreatedAt
+		tokens = append(tokens, TokenInfo{
+			ID:          t.ID,
+			Name:        t.Name,
+			Description: t.Description,
+			CreatedAt:   &created,
+			LastUsedAt:  lastUsedAt(usage, t.Hash),
+			ExpiresAt:   us.lifetime.describeToken(user, t.ID).ExpiresAt,
+		})
+	}
+	return tokens, nil
+}
+
+// userByName returns the user with the given username. Callers must hold the lock.
+func (us *UserStore) userByName(username string) (*User, error) {
+	for _, u := range us.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("user %q not found", username)
+}
+
+// ====================================
+// Token Usage Tracking
+// ====================================
+
+// tokenUsageFile is the on-disk layout of token_usage.json. Entries are keyed by a
+// prefix of the token hash, so a rotated token starts without a last-used time.
+type tokenUsageFile struct {
+	Version  int                  `json:"version"`
+	LastUsed map[string]time.Time `json:"last_used"`
+}
+
+// tokenUsage records when tokens were last used. Recording is best effort: a
+// failed or lost write only makes 'token list' show an older time.
+type tokenUsage struct {
+	path string
+}
+
+func newTokenUsage(configDir string) *tokenUsage {
+	return &tokenUsage{path: filepath.Join(configDir, tokenUsageFileName)}
+}
+
+// usageKey identifies a token in token_usage.json without storing its full hash
+func usageKey(tokenHash string) string {
+	if len(tokenHash) > 16 {
+		return tokenHash[:16]
+	}
+	return tokenHash
+}
+
+// load returns the recorded last-used times; a missing or unreadable file records nothing
+func (tu *tokenUsage) load() map[string]time.Time {
+	var file tokenUsageFile
+	if err := readConfigFile(tu.path, tokenUsageFormat, &file); err != nil || file.LastUsed == nil {
+		return map[string]time.Time{}
+	}
+	return file.LastUsed
+}
+
+// lastUsedAt returns when the token with the given hash was last used, if known
+func lastUsedAt(usage map[string]time.Time, tokenHash string) *time.Time {
+	if t, ok := usage[usageKey(tokenHash)]; ok {
+		return &t
+	}
+	return nil
+}
+
+// record notes that the token was used, dropping entries for tokens that no longer exist
+func (tu *tokenUsage) record(tokenHash string, now time.Time, liveHashes []string) {
+	usage := tu.load()
+	key := usageKey(tokenHash)
+	if last, ok := usage[key]; ok && now.Sub(last) < tokenUsageInterval {
+		return
+	}
+
+	live := make(map[string]bool, len(liveHashes))
+	for _, hash := range liveHashes {
+		live[usageKey(hash)] = true
+	}
+	for k := range usage {
+		if !live[k] {
+			delete(usage, k)
+		}
+	}
+	usage[key] = now.UTC()
+
+	data, err := json.MarshalIndent(tokenUsageFile{Version: TokenUsageFormatVersion, LastUsed: usage}, "", "  ")
+	if err != nil {
+		return
+	}
+	_ = AtomicWriteFile(tu.path, data, secureFilePermissions)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Tokens are written as ss_<id>_<secret>_<crc>. The ID is public and lets a token be
+// found without hashing against every user; the CRC-32 of the rest catches typos
+// before any lookup and lets leak scanners ignore look-alike strings.
+const (
+	TokenPrefix      = "ss_"
+	tokenIDBytes     = 6  // 12 hex characters
+	tokenSecretBytes = 32 // 256 bits of entropy, 52 base32 characters
+)
+
+// ErrMalformedToken indicates a value looks like a simple-secrets token but fails its checksum
+var ErrMalformedToken = errors.New("malformed token")
+
+// TokenPattern matches structured tokens in text, for leak scanners such as gitleaks.
+// Matches should be confirmed with ParseStructuredToken to rule out look-alikes.
+var TokenPattern = regexp.MustCompile(`ss_[0-9a-f]{12}_[a-z2-7]{52}_[0-9a-f]{8}`)
+
+// structuredTokenFormat captures a whole token's body, ID, secret and checksum
+var structuredTokenFormat = regexp.MustCompile(`^(ss_([0-9a-f]{12})_([a-z2-7]{52}))_([0-9a-f]{8})$`)
+
+// tokenSecretEncoding is lowercase base32 without padding, so tokens contain no '_'
+var tokenSecretEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// NewStructuredToken generates a token in the ss_<id>_<secret>_<crc> format
+func NewStructuredToken() (string, error) {
+	id := make([]byte, tokenIDBytes)
+	secret := make([]byte, tokenSecretBytes)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", err
+	}
+
+	body := TokenPrefix + hex.EncodeToString(id) + "_" + tokenSecretEncoding.EncodeToString(secret)
+	return body + "_" + tokenChecksum(body), nil
+}
+
+// tokenChecksum returns the CRC-32 of a token's prefix, ID and secret as 8 hex characters
+func tokenChecksum(body string) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(body)))
+}
+
+// ParseStructuredToken returns the lookup ID of a structured token. Tokens issued before
+// the structured format have no ID and return "" without error; a value with the ss_
+// prefix that is truncated or fails its checksum returns ErrMalformedToken, though it
+// may still be a legacy token that happens to start with ss_.
+func ParseStructuredToken(value string) (string, error) {
+	if !strings.HasPrefix(value, TokenPrefix) {
+		return "", nil
+	}
+
+	parts := structuredTokenFormat.FindStringSubmatch(value)
+	if parts == nil || parts[4] != tokenChecksum(parts[1]) {
+		return "", fmt.Errorf("%w: the token was probably mistyped or truncated; check it was copied in full", ErrMalformedToken)
+	}
+	return parts[2], nil
+}
+
+// tokenLookupID returns the lookup ID embedded in a token value, or "" for legacy tokens
+func tokenLookupID(value string) string {
+	id, _ := ParseStructuredToken(value)
+	return id
+}
+
+// TokenLeak is a structured token found in scanned text
+type TokenLeak struct {
+	Line    int    `json:"line"`
+	TokenID string `json:"token_id"`
+}
+
+// ScanForTokens reports every structured token with a valid checksum in r, by line
+func ScanForTokens(r io.Reader) ([]TokenLeak, error) {
+	var leaks []TokenLeak
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		for _, match := range TokenPattern.FindAllString(scanner.Text(), -1) {
+			if id, err := ParseStructuredToken(match); err == nil {
+				leaks = append(leaks, TokenLeak{Line: line, TokenID: id})
+			}
+		}
+	}
+	return leaks, scanner.Err()
+}
+
+// MaskToken shows a token's prefix and ID but hides its secret
+func MaskToken(id string) string {
+	return TokenPrefix + id + "_****"
+}
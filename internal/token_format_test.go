@@ -0,0 +1,127 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStructuredTokenFormat(t *testing.T) {
+	token, err := NewStructuredToken()
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	if !TokenPattern.MatchString(token) {
+		t.Fatalf("token %q does not match the published pattern", token)
+	}
+	id, err := ParseStructuredToken(token)
+	if err != nil || id == "" || !strings.HasPrefix(token, TokenPrefix+id+"_") {
+		t.Fatalf("expected the embedded ID back, got %q (%v)", id, err)
+	}
+
+	typo := token[:20] + flipChar(token[20]) + token[21:]
+	if _, err := ParseStructuredToken(typo); !errors.Is(err, ErrMalformedToken) {
+		t.Fatalf("a mistyped token should fail its checksum, got %v", err)
+	}
+	if _, err := ParseStructuredToken(token[:len(token)-3]); !errors.Is(err, ErrMalformedToken) {
+		t.Fatalf("a truncated token should be rejected, got %v", err)
+	}
+	if id, err := ParseStructuredToken("legacy-base64-token"); err != nil || id != "" {
+		t.Fatalf("legacy tokens have no ID and no error, got %q (%v)", id, err)
+	}
+}
+
+// flipChar returns a different character from the same alphabet
+func flipChar(c byte) string {
+	if c == 'a' {
+		return "b"
+	}
+	return "a"
+}
+
+func TestStructuredTokenLookup(t *testing.T) {
+	store := createUserStore([]*User{
+		{Username: "admin", TokenHash: HashToken("legacy-admin-token"), Role: RoleAdmin},
+	}, createDefaultRoles())
+
+	token, err := store.CreateUser("alice", "reader")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	alice, _ := store.FindUser("alice")
+	if alice.TokenLookupID == "" || store.index[alice.TokenLookupID] != alice {
+		t.Fatalf("new tokens should be indexed by their ID, got %+v", alice)
+	}
+
+	if user, err := store.Lookup(token); err != nil || user.Username != "alice" {
+		t.Fatalf("structured token lookup: %v (%v)", user, err)
+	}
+	if user, err := store.Lookup("legacy-admin-token"); err != nil || user.Username != "admin" {
+		t.Fatalf("legacy tokens must keep working: %v (%v)", user, err)
+	}
+	if _, err := store.Lookup(token[:len(token)-1] + flipChar(token[len(token)-1])); !errors.Is(err, ErrMalformedToken) {
+		t.Fatalf("a mistyped token should be reported as malformed, got %v", err)
+	}
+
+	t.Run("legacy_token_with_structured_prefix", func(t *testing.T) {
+		legacy := "ss_Zq3vK9pLmR2xW8yT5nB7cD1fG4hJ6kM0"
+		store := createUserStore([]*User{
+			{Username: "ci", TokenHash: HashToken(legacy), Role: RoleReader},
+		}, createDefaultRoles())
+		if _, err := ParseStructuredToken(legacy); !errors.Is(err, ErrMalformedToken) {
+			t.Fatalf("the legacy token should not parse as a structured token, got %v", err)
+		}
+		if user, err := store.Lookup(legacy); err != nil || user.Username != "ci" {
+			t.Fatalf("a legacy token starting with ss_ must keep working: %v (%v)", user, err)
+		}
+		if _, err := store.Lookup(legacy + "x"); !errors.Is(err, ErrMalformedToken) {
+			t.Fatalf("a value matching no legacy token should still be reported as malformed, got %v", err)
+		}
+	})
+
+	t.Run("index_survives_reload", func(t *testing.T) {
+		reloaded := createUserStore(store.Users(), createDefaultRoles())
+		if user, err := reloaded.Lookup(token); err != nil || user.Username != "alice" {
+			t.Fatalf("lookup after reload: %v (%v)", user, err)
+		}
+	})
+
+	t.Run("deleted_user_not_found", func(t *testing.T) {
+		if err := store.DeleteUser("alice"); err != nil {
+			t.Fatalf("delete alice: %v", err)
+		}
+		if _, err := store.Lookup(token); err == nil {
+			t.Fatal("a deleted user's token must not authenticate")
+		}
+	})
+}
+
+func TestScanForTokens(t *testing.T) {
+	token, _ := NewStructuredToken()
+	lookAlike := "ss_000000000000_" + strings.Repeat("a", 52) + "_00000000"
+	text := "first line\nexport SIMPLE_SECRETS_TOKEN=" + token + "\n" + lookAlike + "\n"
+
+	leaks, err := ScanForTokens(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	id, _ := ParseStructuredToken(token)
+	if len(leaks) != 1 || leaks[0].Line != 2 || leaks[0].TokenID != id {
+		t.Fatalf("expected the real token on line 2 only, got %+v", leaks)
+	}
+}
@@ -0,0 +1,257 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// Access a previous token keeps during its grace window
+const (
+	GraceReadOnly = "read-only"
+	GraceFull     = "full"
+)
+
+// readOnlyScopes limits a previous token in a read-only grace window
+var readOnlyScopes = []TokenScope{{Action: ActionRead, Pattern: "*"}, {Action: ActionList, Pattern: "*"}}
+
+// TokenGrace keeps a token valid for a while after it is rotated, so hosts using it
+// can be updated without an outage. A zero Period replaces the token immediately.
+type TokenGrace struct {
+	Period time.Duration
+	Access string // GraceReadOnly or GraceFull
+}
+
+// Validate checks the grace access mode
+func (g TokenGrace) Validate() error {
+	if g.Period == 0 {
+		return nil
+	}
+	if g.Access != GraceReadOnly && g.Access != GraceFull {
+		return fmt.Errorf("invalid grace access %q: must be %q or %q", g.Access, GraceReadOnly, GraceFull)
+	}
+	return nil
+}
+
+// PreviousToken is a rotated-out token still accepted until its grace window ends
+type PreviousToken struct {
+	Token     string    `json:"token"` // the token it was rotated out of: "default" or a named token's ID
+	Hash      string    `json:"hash"`
+	LookupID  string    `json:"lookup_id,omitempty"`
+	Access    string    `json:"access"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RetireToken keeps the current value of the token with the given ID valid for the grace
+// period. Call it before replacing the token. Any earlier grace window of the same token
+// and windows that have ended are dropped.
+func (u *User) RetireToken(id string, grace TokenGrace) {
+	now := time.Now()
+	u.PreviousTokens = slices.DeleteFunc(u.PreviousTokens, func(p *PreviousToken) bool {
+		return p.Token == id || !now.Before(p.ExpiresAt)
+	})
+	if grace.Period == 0 {
+		return
+	}
+
+	hash, lookupID := u.TokenHash, u.TokenLookupID
+	if id != DefaultTokenName {
+		t := u.Tokens[u.findToken(id)]
+		hash, lookupID = t.Hash, t.LookupID
+	}
+	if hash == "" {
+		return
+	}
+	u.PreviousTokens = append(u.PreviousTokens, &PreviousToken{
+		Token:     id,
+		Hash:      hash,
+		LookupID:  lookupID,
+		Access:    grace.Access,
+		ExpiresAt: now.Add(grace.Period).UTC(),
+	})
+}
+
+// graceFor returns the grace period a token gets when it is rotated. A token that has
+// already expired is refused one, and a window never runs past the token's own expiry, so
+// rotating cannot bring back or extend a token the lifetime policy has ended.
+func (p TokenLifetimePolicy) graceFor(u *User, id string, grace TokenGrace, now time.Time) (TokenGrace, error) {
+	if grace.Period == 0 {
+		return grace, nil
+	}
+	match := p.describeToken(u, id)
+	if err := checkExpiry(u, match, now); err != nil {
+		return TokenGrace{}, fmt.Errorf("cannot give token %q of user %q a grace period: it has already expired; rotate it without --grace",
+			match.Name, u.Username)
+	}
+	if match.ExpiresAt != nil && match.ExpiresAt.Sub(now) < grace.Period {
+		grace.Period = match.ExpiresAt.Sub(now)
+	}
+	return grace, nil
+}
+
+// RotationGrace returns the grace period the token with the given ID gets when it is
+// rotated, under the token lifetime settings in configDir. See graceFor.
+func RotationGrace(configDir string, u *User, id string, grace TokenGrace) (TokenGrace, error) {
+	if grace.Period == 0 {
+		return grace, nil
+	}
+	policy, err := loadTokenLifetimePolicy(configDir)
+	if err != nil {
+		return TokenGrace{}, err
+	}
+	return policy.graceFor(u, id, grace, time.Now())
+}
+
+// replaceToken gives the token with the given ID a new value
+func (u *User) replaceToken(id, value string) {
+	if id == DefaultTokenName {
+		u.SetToken(value)
+		return
+	}
+	t := u.Tokens[u.findToken(id)]
+	t.Hash = HashToken(value)
+	t.LookupID = tokenLookupID(value)
+	t.CreatedAt = time.Now().UTC()
+}
+
+// matchPreviousToken returns the previous token with the given hash, ended or not
+func (u *User) matchPreviousToken(tokenHash string) *PreviousToken {
+	for _, p := range u.PreviousTokens {
+		if subtle.ConstantTimeCompare([]byte(tokenHash), []byte(p.Hash)) == 1 {
+			return p
+		}
+	}
+	return nil
+}
+
+// dropPreviousToken ends the grace window of the token with the given ID
+func (u *User) dropPreviousToken(id string) bool {
+	before := len(u.PreviousTokens)
+	u.PreviousTokens = slices.DeleteFunc(u.PreviousTokens, func(p *PreviousToken) bool { return p.Token == id })
+	return len(u.PreviousTokens) < before
+}
+
+// PendingGrace returns the previous tokens whose grace window has not ended
+func (u *User) PendingGrace(now time.Time) []*PreviousToken {
+	var pending []*PreviousToken
+	for _, p := range u.PreviousTokens {
+		if now.Before(p.ExpiresAt) {
+			pending = append(pending, p)
+		}
+	}
+	return pending
+}
+
+// TokenDisplayName returns the name of a user's token given its ID
+func (u *User) TokenDisplayName(id string) string {
+	if i := u.findToken(id); i >= 0 {
+		return u.Tokens[i].Name
+	}
+	return id
+}
+
+// authenticatePrevious accepts a previous token during its grace window. In a read-only
+// window it returns a copy of the user limited to reading and listing.
+func authenticatePrevious(u *User, p *PreviousToken, now time.Time) (*User, tokenMatch, error) {
+	match := tokenMatch{Name: u.TokenDisplayName(p.Token) + " (previous)", ExpiresAt: &p.ExpiresAt, Grace: true}
+	if err := u.checkActive(); err != nil {
+		return nil, match, err
+	}
+	if !now.Before(p.ExpiresAt) {
+		return nil, match, fmt.Errorf("%w: the grace period of user %q's previous %q token ended at %s; use the rotated token",
+			ErrTokenExpired, u.Username, u.TokenDisplayName(p.Token), p.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+	}
+	if p.Access == GraceFull {
+		return u, match, nil
+	}
+
+	scoped := *u
+	scoped.Scopes = readOnlyScopes
+	return &scoped, match, nil
+}
+
+// warnGrace tells the caller, once per process, that they are using a rotated-out token
+func warnGrace(u *User, match tokenMatch, now time.Time) {
+	if _, warned := expiryWarnings.LoadOrStore(u.Username+"/"+match.Name, true); warned {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: this is user %q's %s token, replaced by a rotation; it stops working in %s (%s). Switch to the new token\n",
+		u.Username, match.Name, FormatRemaining(match.ExpiresAt.Sub(now)), match.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+}
+
+// ====================================
+// UserStore Grace Periods
+// ====================================
+
+// RotateTokenWithGrace replaces a user's token by name or ID, keeping the old value
+// valid for the grace period
+func (us *UserStore) RotateTokenWithGrace(username, nameOrID string, grace TokenGrace) (string, error) {
+	if err := grace.Validate(); err != nil {
+		return "", err
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user, err := us.userByName(username)
+	if err != nil {
+		return "", err
+	}
+	id := DefaultTokenName
+	if nameOrID != DefaultTokenName {
+		i := user.findToken(nameOrID)
+		if i < 0 {
+			return "", fmt.Errorf("user %q has no token named %q", username, nameOrID)
+		}
+		id = user.Tokens[i].ID
+	}
+
+	grace, err = us.lifetime.graceFor(user, id, grace, time.Now())
+	if err != nil {
+		return "", err
+	}
+	value, err := generateSecureToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	user.RetireToken(id, grace)
+	user.replaceToken(id, value)
+	us.indexUser(user)
+	return value, nil
+}
+
+// RevokePreviousToken ends the grace window of a user's token by name or ID
+func (us *UserStore) RevokePreviousToken(username, nameOrID string) (string, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user, err := us.userByName(username)
+	if err != nil {
+		return "", err
+	}
+	id := nameOrID
+	if i := user.findToken(nameOrID); i >= 0 {
+		id = user.Tokens[i].ID
+	}
+	if !user.dropPreviousToken(id) {
+		return "", fmt.Errorf("user %q has no previous %q token in a grace period", username, nameOrID)
+	}
+	return user.TokenDisplayName(id), nil
+}
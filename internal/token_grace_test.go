@@ -0,0 +1,133 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotationGracePeriod(t *testing.T) {
+	service, dir := newRoleTestService(t)
+	if err := service.Secrets().Put("admin-token", "db", "v1"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	readOnly := TokenGrace{Period: time.Hour, Access: GraceReadOnly}
+	second, err := service.Tokens().RotateToken("admin-token", "", DefaultTokenName, readOnly)
+	if err != nil {
+		t.Fatalf("rotate with grace: %v", err)
+	}
+
+	t.Run("read_only_window", func(t *testing.T) {
+		if value, err := service.Secrets().Get("admin-token", "db"); err != nil || value != "v1" {
+			t.Fatalf("previous token should still read, got %q (%v)", value, err)
+		}
+		if err := service.Secrets().Put("admin-token", "db", "v2"); err == nil {
+			t.Fatal("a read-only previous token must not write")
+		}
+		if _, err := service.Users().CreateUser("admin-token", "mallory", "admin"); err == nil {
+			t.Fatal("a read-only previous token must not manage users")
+		}
+		if err := service.Secrets().Put(second, "db", "v2"); err != nil {
+			t.Fatalf("rotated token should have full access: %v", err)
+		}
+	})
+
+	t.Run("window_ends", func(t *testing.T) {
+		store, err := loadUserStoreFromConfigDir(dir)
+		if err != nil {
+			t.Fatalf("load users: %v", err)
+		}
+		_, match, err := store.authenticate("admin-token", time.Now().Add(2*time.Hour))
+		if !errors.Is(err, ErrTokenExpired) {
+			t.Fatalf("expected ErrTokenExpired after the grace period, got %v", err)
+		}
+		if !match.Grace {
+			t.Fatal("an ended grace window should still be reported as a previous token")
+		}
+	})
+
+	t.Run("revoke_previous", func(t *testing.T) {
+		if _, err := service.Tokens().RevokePrevious(second, "", DefaultTokenName); err != nil {
+			t.Fatalf("revoke previous: %v", err)
+		}
+		if _, err := service.Auth().ValidateToken("admin-token"); err == nil {
+			t.Fatal("a revoked previous token must not authenticate")
+		}
+		if _, err := service.Tokens().RevokePrevious(second, "", DefaultTokenName); err == nil {
+			t.Fatal("revoking with no grace window should fail")
+		}
+	})
+
+	t.Run("full_window_and_rotation_without_grace", func(t *testing.T) {
+		third, err := service.Tokens().RotateToken(second, "", DefaultTokenName, TokenGrace{Period: time.Hour, Access: GraceFull})
+		if err != nil {
+			t.Fatalf("rotate with full grace: %v", err)
+		}
+		if err := service.Secrets().Put(second, "db", "v3"); err != nil {
+			t.Fatalf("a full-access previous token should write: %v", err)
+		}
+
+		if _, err := service.Tokens().RotateToken(third, "", DefaultTokenName, TokenGrace{}); err != nil {
+			t.Fatalf("rotate without grace: %v", err)
+		}
+		if _, err := service.Auth().ValidateToken(second); err == nil {
+			t.Fatal("rotating again without grace should end the earlier window")
+		}
+		if _, err := service.Auth().ValidateToken(third); err == nil {
+			t.Fatal("rotating without grace should invalidate the old token")
+		}
+	})
+
+	if err := (TokenGrace{Period: time.Hour, Access: "write"}).Validate(); err == nil {
+		t.Fatal("unknown grace access should be rejected")
+	}
+}
+
+func TestRotationGraceOnlyForValidTokens(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-100 * 24 * time.Hour)
+	nearlyOld := now.Add(-90*24*time.Hour + time.Hour)
+	store := createUserStore([]*User{
+		{Username: "old", TokenHash: HashToken("old-token"), Role: RoleReader, TokenRotatedAt: &old},
+		{Username: "near", TokenHash: HashToken("near-token"), Role: RoleReader, TokenRotatedAt: &nearlyOld},
+	}, createDefaultRoles())
+	store.lifetime = TokenLifetimePolicy{MaxAge: 90 * 24 * time.Hour, WarningPeriod: 7 * 24 * time.Hour}
+	grace := TokenGrace{Period: 24 * time.Hour, Access: GraceFull}
+
+	if _, err := store.RotateTokenWithGrace("old", DefaultTokenName, grace); err == nil || !strings.Contains(err.Error(), "already expired") {
+		t.Fatalf("an expired token must not get a grace period, got %v", err)
+	}
+	if _, err := store.Lookup("old-token"); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("refused rotation should leave the expired token expired, got %v", err)
+	}
+	if _, err := store.RotateTokenWithGrace("old", DefaultTokenName, TokenGrace{}); err != nil {
+		t.Fatalf("an expired token can still be rotated without grace: %v", err)
+	}
+
+	if _, err := store.RotateTokenWithGrace("near", DefaultTokenName, grace); err != nil {
+		t.Fatalf("rotate with grace: %v", err)
+	}
+	if _, err := store.Lookup("near-token"); err != nil {
+		t.Fatalf("previous token should work during its grace period: %v", err)
+	}
+	if _, _, err := store.authenticate("near-token", now.Add(2*time.Hour)); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("the grace period must end when the token would have expired, got %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTokenExpiryWarning is how long before expiry commands start printing a notice
+const DefaultTokenExpiryWarning = 7 * 24 * time.Hour
+
+// ErrTokenExpired indicates the token was valid but has passed its expiry or max_token_age
+var ErrTokenExpired = errors.New("token expired")
+
+// expiryWarnings remembers which tokens were already warned about in this process
+var expiryWarnings sync.Map
+
+// TokenLifetimePolicy limits how long tokens are accepted, from config.json
+type TokenLifetimePolicy struct {
+	MaxAge        time.Duration // 0 means tokens only expire at their own expiry
+	WarningPeriod time.Duration
+}
+
+// ParseLifetime parses a positive duration such as 12h, also accepting whole days such as 90d
+func ParseLifetime(value string) (time.Duration, error) {
+	invalid := fmt.Errorf("invalid duration %q: use a positive duration such as 12h or 90d", value)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, invalid
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	lifetime, err := time.ParseDuration(value)
+	if err != nil || lifetime <= 0 {
+		return 0, invalid
+	}
+	return lifetime, nil
+}
+
+// tokenLifetimeConfig is the part of config.json that limits token lifetimes
+type tokenLifetimeConfig struct {
+	MaxTokenAge        string `json:"max_token_age,omitempty"`        // e.g. "90d"; unset means no limit
+	TokenExpiryWarning string `json:"token_expiry_warning,omitempty"` // e.g. "7d" (default)
+}
+
+// parse validates the configured durations
+func (c tokenLifetimeConfig) parse() (TokenLifetimePolicy, error) {
+	policy := TokenLifetimePolicy{WarningPeriod: DefaultTokenExpiryWarning}
+	if c.MaxTokenAge != "" {
+		maxAge, err := ParseLifetime(c.MaxTokenAge)
+		if err != nil {
+			return policy, fmt.Errorf("max_token_age: %w", err)
+		}
+		policy.MaxAge = maxAge
+	}
+	if c.TokenExpiryWarning != "" {
+		warning, err := ParseLifetime(c.TokenExpiryWarning)
+		if err != nil {
+			return policy, fmt.Errorf("token_expiry_warning: %w", err)
+		}
+		policy.WarningPeriod = warning
+	}
+	return policy, nil
+}
+
+// loadTokenLifetimePolicy reads the token lifetime settings from configDir's config.json.
+// A missing file means no max age. An invalid value is an error rather than no limit, so
+// that a mistyped max_token_age does not let tokens live forever.
+func loadTokenLifetimePolicy(configDir string) (TokenLifetimePolicy, error) {
+	defaults := TokenLifetimePolicy{WarningPeriod: DefaultTokenExpiryWarning}
+
+	data, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		return defaults, nil
+	}
+	if err := configFormat.checkReadable(data); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v. Token lifetime settings are ignored\n", err)
+		return defaults, nil
+	}
+
+	var config tokenLifetimeConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return defaults, nil // ResolveToken reports a corrupted config.json
+	}
+	policy, err := config.parse()
+	if err != nil {
+		return policy, fmt.Errorf("config.json %w; sign-ins are refused until it is fixed", err)
+	}
+	return policy, nil
+}
+
+// expiry returns when a token stops being accepted: its own expiry or, with a max age,
+// its issue time plus the max age, whichever comes first. Tokens issued before rotation
+// times were recorded have no known age, so only their own expiry applies.
+func (p TokenLifetimePolicy) expiry(issuedAt, expiresAt *time.Time) *time.Time {
+	if p.MaxAge == 0 || issuedAt == nil {
+		return expiresAt
+	}
+	limit := issuedAt.Add(p.MaxAge)
+	if expiresAt != nil && expiresAt.Before(limit) {
+		return expiresAt
+	}
+	return &limit
+}
+
+// tokenMatch describes the token a lookup matched
+type tokenMatch struct {
+	Name      string
+	ExpiresAt *time.Time // effective expiry, nil if the token never expires
+	Derived   bool       // a short-lived derived token, named by its ID
+	Grace     bool       // a rotated-out token in its grace period
+}
+
+// describeToken returns a user's token by ID with its effective expiry
+func (p TokenLifetimePolicy) describeToken(u *User, id string) tokenMatch {
+	if id == DefaultTokenName {
+		return tokenMatch{Name: DefaultTokenName, ExpiresAt: p.expiry(u.TokenRotatedAt, nil)}
+	}
+	t := u.Tokens[u.findToken(id)]
+	created := t.CreatedAt
+	return tokenMatch{Name: t.Name, ExpiresAt: p.expiry(&created, t.ExpiresAt)}
+}
+
+// checkExpiry returns ErrTokenExpired once the matched token's expiry has passed
+func checkExpiry(u *User, match tokenMatch, now time.Time) error {
+	if match.ExpiresAt == nil || now.Before(*match.ExpiresAt) {
+		return nil
+	}
+	return fmt.Errorf("%w: token %q of user %q expired at %s; ask an admin to rotate it with 'simple-secrets rotate token %s'",
+		ErrTokenExpired, match.Name, u.Username, match.ExpiresAt.Local().Format("2006-01-02 15:04:05"), u.Username)
+}
+
+// warnIfExpiring prints a notice, once per process, when the token is within the warning period
+func (p TokenLifetimePolicy) warnIfExpiring(u *User, match tokenMatch, now time.Time) {
+	if match.Grace {
+		warnGrace(u, match, now)
+		return
+	}
+	if match.Derived || match.ExpiresAt == nil || match.ExpiresAt.Sub(now) > p.WarningPeriod {
+		return
+	}
+	if _, warned := expiryWarnings.LoadOrStore(u.Username+"/"+match.Name, true); warned {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: token %q of user %q expires in %s (%s); rotate it with 'simple-secrets rotate token'\n",
+		match.Name, u.Username, FormatRemaining(match.ExpiresAt.Sub(now)), match.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+}
+
+// FormatRemaining renders a duration in whole days, or hours and minutes below a day
+func FormatRemaining(d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	}
+	return d.Truncate(time.Minute).String()
+}
+
+// StaleToken is a token that has expired or will within the warning period
+type StaleToken struct {
+	Username  string     `json:"username"`
+	Token     string     `json:"token"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil when the token's age is unknown
+	Expired   bool       `json:"expired"`
+}
+
+// StaleTokens lists tokens past or nearing their limit, most urgent first. With a max
+// age set, default tokens of unknown age are included so they can be rotated.
+func (us *UserStore) StaleTokens(now time.Time) []StaleToken {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	var stale []StaleToken
+	for _, u := range us.users {
+		var ids []string
+		if u.TokenHash != "" {
+			ids = append(ids, DefaultTokenName)
+		}
+		for _, t := range u.Tokens {
+			ids = append(ids, t.ID)
+		}
+
+		for _, id := range ids {
+			match := us.lifetime.describeToken(u, id)
+			unknownAge := id == DefaultTokenName && u.TokenRotatedAt == nil && us.lifetime.MaxAge > 0
+			if !unknownAge && (match.ExpiresAt == nil || match.ExpiresAt.Sub(now) > us.lifetime.WarningPeriod) {
+				continue
+			}
+			stale = append(stale, StaleToken{
+				Username:  u.Username,
+				Token:     match.Name,
+				ExpiresAt: match.ExpiresAt,
+				Expired:   checkExpiry(u, match, now) != nil,
+			})
+		}
+	}
+
+	sort.SliceStable(stale, func(i, j int) bool {
+		a, b := stale[i].ExpiresAt, stale[j].ExpiresAt
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		return a.Before(*b)
+	})
+	return stale
+}
+
+// TokenLifetime returns the token lifetime policy the store enforces
+func (us *UserStore) TokenLifetime() TokenLifetimePolicy {
+	return us.lifetime
+}
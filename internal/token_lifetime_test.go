@@ -0,0 +1,150 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLifetime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"90d", 90 * 24 * time.Hour, true},
+		{"12h", 12 * time.Hour, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"0d", 0, false},
+		{"-1h", 0, false},
+		{"d", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseLifetime(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseLifetime(%q) = %v, %v; want %v (ok=%v)", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTokenMaxAge(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-100 * 24 * time.Hour)
+	recent := now.Add(-85 * 24 * time.Hour)
+	store := createUserStore([]*User{
+		{Username: "admin", TokenHash: HashToken("admin-token"), Role: RoleAdmin, TokenRotatedAt: &recent},
+		{Username: "old", TokenHash: HashToken("old-token"), Role: RoleReader, TokenRotatedAt: &old},
+		{Username: "legacy", TokenHash: HashToken("legacy-token"), Role: RoleReader},
+	}, createDefaultRoles())
+
+	if _, err := store.Lookup("old-token"); err != nil {
+		t.Fatalf("without max_token_age tokens do not expire: %v", err)
+	}
+
+	store.lifetime = TokenLifetimePolicy{MaxAge: 90 * 24 * time.Hour, WarningPeriod: 7 * 24 * time.Hour}
+
+	if _, err := store.Lookup("old-token"); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("token older than max_token_age should be expired, got %v", err)
+	}
+	if _, err := store.Lookup("nope"); err == nil || errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("unknown tokens are invalid, not expired, got %v", err)
+	}
+	if _, err := store.Lookup("admin-token"); err != nil {
+		t.Fatalf("token within max_token_age should work: %v", err)
+	}
+	if _, err := store.Lookup("legacy-token"); err != nil {
+		t.Fatalf("tokens of unknown age are not expired: %v", err)
+	}
+
+	short := now.Add(2 * 24 * time.Hour)
+	if _, _, err := store.CreateToken("admin", TokenOptions{Name: "short", ExpiresAt: &short}); err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	stale := store.StaleTokens(now)
+	if len(stale) != 4 {
+		t.Fatalf("expected 4 stale tokens, got %+v", stale)
+	}
+	if stale[0].Username != "old" || !stale[0].Expired {
+		t.Fatalf("expired token should be listed first, got %+v", stale[0])
+	}
+	if stale[1].Token != "short" || stale[1].Expired {
+		t.Fatalf("named token expiring first should follow, got %+v", stale[1])
+	}
+	if stale[2].Username != "admin" || stale[2].Token != DefaultTokenName || stale[2].Expired {
+		t.Fatalf("admin token nearing max age should follow, got %+v", stale[2])
+	}
+	if stale[3].Username != "legacy" || stale[3].ExpiresAt != nil {
+		t.Fatalf("token of unknown age should be listed last, got %+v", stale[3])
+	}
+}
+
+func TestLoadTokenLifetimePolicy(t *testing.T) {
+	dir := t.TempDir()
+	if got, err := loadTokenLifetimePolicy(dir); err != nil || got.MaxAge != 0 || got.WarningPeriod != DefaultTokenExpiryWarning {
+		t.Fatalf("missing config.json should mean no max age, got %+v (%v)", got, err)
+	}
+
+	config := `{"version": 1, "max_token_age": "30d", "token_expiry_warning": "48h"}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadTokenLifetimePolicy(dir)
+	if err != nil || got.MaxAge != 30*24*time.Hour || got.WarningPeriod != 48*time.Hour {
+		t.Fatalf("unexpected policy %+v (%v)", got, err)
+	}
+
+	for _, invalid := range []string{"soon", "-30d", "0d"} {
+		config := fmt.Sprintf(`{"version": 1, "max_token_age": %q}`, invalid)
+		if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadTokenLifetimePolicy(dir); err == nil || !strings.Contains(err.Error(), "max_token_age") {
+			t.Fatalf("max_token_age %q should be an error, got %v", invalid, err)
+		}
+	}
+}
+
+func TestInvalidMaxTokenAgeRefusesSignIn(t *testing.T) {
+	dir := newSealedInstallation(t)
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"version": 1, "max_token_age": "-1d"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := loadUserStoreFromConfigDir(dir)
+	if err != nil {
+		t.Fatalf("load users: %v", err)
+	}
+	if _, _, err := store.authenticate("admin-token", time.Now()); err == nil || !strings.Contains(err.Error(), "max_token_age") {
+		t.Fatalf("an invalid max_token_age should refuse sign-ins, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"version": 1, "max_token_age": "90d"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = loadUserStoreFromConfigDir(dir); err != nil {
+		t.Fatalf("load users: %v", err)
+	}
+	if _, _, err := store.authenticate("admin-token", time.Now()); err != nil {
+		t.Fatalf("sign-ins should work once the setting is fixed: %v", err)
+	}
+}
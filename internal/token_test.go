@@ -0,0 +1,194 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNamedTokens(t *testing.T) {
+	service, dir := newRoleTestService(t)
+	tokens := service.Tokens()
+
+	laptop, created, err := tokens.CreateToken("bob-token", "", TokenOptions{Name: "laptop", Description: "work laptop"})
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	if created.Name != "laptop" || created.ID == "" {
+		t.Fatalf("unexpected token %+v", created)
+	}
+	if _, _, err := tokens.CreateToken("bob-token", "", TokenOptions{Name: "laptop"}); err == nil {
+		t.Fatal("duplicate token names should be rejected")
+	}
+	if _, _, err := tokens.CreateToken("bob-token", "", TokenOptions{Name: DefaultTokenName}); err == nil {
+		t.Fatal("the default token name is reserved")
+	}
+
+	user, err := service.Auth().ValidateToken(laptop)
+	if err != nil || user.Username != "bob" {
+		t.Fatalf("named token should authenticate as bob, got %v (%v)", user, err)
+	}
+	if _, err := service.Auth().ValidateToken("bob-token"); err != nil {
+		t.Fatalf("default token should still work: %v", err)
+	}
+
+	t.Run("persisted_and_listed", func(t *testing.T) {
+		store, err := loadUserStoreFromConfigDir(dir)
+		if err != nil {
+			t.Fatalf("reload users: %v", err)
+		}
+		if _, err := store.Lookup(laptop); err != nil {
+			t.Fatalf("named token should survive a reload: %v", err)
+		}
+
+		list, err := tokens.ListTokens("bob-token", "")
+		if err != nil {
+			t.Fatalf("list tokens: %v", err)
+		}
+		if len(list) != 2 || list[0].Name != DefaultTokenName || list[1].Name != "laptop" {
+			t.Fatalf("expected default and laptop tokens, got %+v", list)
+		}
+		if list[1].Description != "work laptop" || list[1].LastUsedAt == nil {
+			t.Fatalf("expected description and last-used time, got %+v", list[1])
+		}
+	})
+
+	t.Run("permissions", func(t *testing.T) {
+		if _, _, err := tokens.CreateToken("bob-token", "admin", TokenOptions{Name: "sneaky"}); err == nil {
+			t.Fatal("a reader must not create tokens for another user")
+		}
+		if _, err := tokens.ListTokens("bob-token", "admin"); err == nil {
+			t.Fatal("a reader must not list another user's tokens")
+		}
+		if _, err := tokens.ListTokens("admin-token", "bob"); err != nil {
+			t.Fatalf("rotate-tokens holders may list other users' tokens: %v", err)
+		}
+	})
+
+	t.Run("rotate_replaces_value", func(t *testing.T) {
+		rotated, err := tokens.RotateToken("bob-token", "", "laptop", TokenGrace{})
+		if err != nil {
+			t.Fatalf("rotate named token: %v", err)
+		}
+		if _, err := service.Auth().ValidateToken(laptop); err == nil {
+			t.Fatal("the old value must stop working after rotation")
+		}
+		if _, err := service.Auth().ValidateToken(rotated); err != nil {
+			t.Fatalf("rotated token should work: %v", err)
+		}
+		if _, err := service.Auth().ValidateToken("bob-token"); err != nil {
+			t.Fatalf("rotating a named token must not touch the default token: %v", err)
+		}
+		laptop = rotated
+	})
+
+	t.Run("revoke_by_name_and_id", func(t *testing.T) {
+		ci, created, err := tokens.CreateToken("admin-token", "bob", TokenOptions{Name: "ci"})
+		if err != nil {
+			t.Fatalf("admin creates token for bob: %v", err)
+		}
+		if name, err := tokens.RevokeToken("admin-token", "bob", created.ID); err != nil || name != "ci" {
+			t.Fatalf("revoke by ID: %q %v", name, err)
+		}
+		if _, err := service.Auth().ValidateToken(ci); err == nil {
+			t.Fatal("revoked token must not authenticate")
+		}
+		if _, err := tokens.RevokeToken("bob-token", "", "laptop"); err != nil {
+			t.Fatalf("revoke by name: %v", err)
+		}
+		if _, err := service.Auth().ValidateToken(laptop); err == nil {
+			t.Fatal("revoked token must not authenticate")
+		}
+		if _, err := tokens.RevokeToken("bob-token", "", "laptop"); err == nil {
+			t.Fatal("revoking a missing token should fail")
+		}
+	})
+}
+
+func TestRevokeKeepsAnActiveToken(t *testing.T) {
+	service, _ := newRoleTestService(t)
+	tokens := service.Tokens()
+
+	if _, err := tokens.RevokeToken("bob-token", "", DefaultTokenName); err == nil || !strings.Contains(err.Error(), "last active token of user") {
+		t.Fatalf("revoking bob's only token should be refused, got %v", err)
+	}
+	if _, err := tokens.RevokeToken("admin-token", "", DefaultTokenName); err == nil || !strings.Contains(err.Error(), "last admin") {
+		t.Fatalf("revoking the last admin's only token should be refused, got %v", err)
+	}
+	if _, err := service.Auth().ValidateToken("admin-token"); err != nil {
+		t.Fatalf("the refused revocation must leave the token working: %v", err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if _, _, err := tokens.CreateToken("bob-token", "", TokenOptions{Name: "laptop", ExpiresAt: &later}); err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	if _, err := tokens.RevokeToken("bob-token", "", DefaultTokenName); err != nil {
+		t.Fatalf("with another active token the default token can be revoked: %v", err)
+	}
+}
+
+func TestNamedTokenExpiry(t *testing.T) {
+	store := createUserStore([]*User{{Username: "bob", TokenHash: HashToken("bob-token"), Role: RoleReader}}, createDefaultRoles())
+
+	past := time.Now().Add(-time.Minute)
+	if _, _, err := store.CreateToken("bob", TokenOptions{Name: "old", ExpiresAt: &past}); err == nil {
+		t.Fatal("an expiry in the past should be rejected")
+	}
+
+	future := time.Now().Add(time.Hour)
+	value, token, err := store.CreateToken("bob", TokenOptions{Name: "short", ExpiresAt: &future})
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	if _, err := store.Lookup(value); err != nil {
+		t.Fatalf("unexpired token should work: %v", err)
+	}
+
+	expired := time.Now().Add(-time.Second)
+	token.ExpiresAt = &expired
+	if _, err := store.Lookup(value); err == nil {
+		t.Fatal("expired token must not authenticate")
+	}
+}
+
+func TestDisableByTokenRevokesOnlyThatToken(t *testing.T) {
+	store := createUserStore([]*User{{Username: "bob", TokenHash: HashToken("bob-token"), Role: RoleReader}}, createDefaultRoles())
+
+	value, _, err := store.CreateToken("bob", TokenOptions{Name: "leaked"})
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	if username, err := store.DisableUserByToken(value, "admin"); err != nil || username != "bob" {
+		t.Fatalf("disable by token: %q %v", username, err)
+	}
+	if _, err := store.Lookup(value); err == nil {
+		t.Fatal("disabled token must not authenticate")
+	}
+	if _, err := store.Lookup("bob-token"); err != nil {
+		t.Fatalf("bob's other tokens should keep working: %v", err)
+	}
+
+	if err := store.DisableUserToken("bob", "admin", ""); err != nil {
+		t.Fatalf("disable user: %v", err)
+	}
+	bob, _ := store.FindUser("bob")
+	if bob.TokenHash != "" || len(bob.Tokens) != 0 {
+		t.Fatalf("disabling a user must revoke every token, got %+v", bob)
+	}
+}
@@ -16,13 +16,11 @@ limitations under the License.
 package internal
 
 import (
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"slices"
@@ -46,10 +44,31 @@ var DefaultTokenGenerator TokenGenerator
 
 // User represents a user in the system with authentication and authorization info
 type User struct {
-	Username       string     `json:"username"`
-	TokenHash      string     `json:"token_hash"` // SHA-256 hash, base64-encoded
-	Role           Role       `json:"role"`
-	TokenRotatedAt *time.Time `json:"token_rotated_at,omitempty"` // When token was last rotated
+	Username       string           `json:"username"`
+	TokenHash      string           `json:"token_hash"`                // SHA-256 hash, base64-encoded
+	TokenLookupID  string           `json:"token_lookup_id,omitempty"` // ID embedded in the token, see token_format.go
+	Role           Role             `json:"role"`
+	TokenRotatedAt *time.Time       `json:"token_rotated_at,omitempty"` // When token was last rotated
+	Tokens         []*Token         `json:"tokens,omitempty"`           // Additional named tokens
+	PreviousTokens []*PreviousToken `json:"previous_tokens,omitempty"`  // Rotated-out tokens in a grace period
+
+	// Account state and metadata, see user_state.go. DisabledAt, DisabledBy and
+	// DisabledReason record when, by whom and why the account was disabled or locked.
+	Status         UserStatus `json:"status,omitempty"`
+	DisabledAt     *time.Time `json:"disabled_at,omitempty"`
+	DisabledBy     string     `json:"disabled_by,omitempty"`
+	DisabledReason string     `json:"disabled_reason,omitempty"`
+	Contact        string     `json:"contact,omitempty"`     // who to ask about the account, e.g. an owner's email
+	Description    string     `json:"description,omitempty"` // what the account is for
+
+	// Groups and GroupRoles are the groups the user belongs to and the roles inherited
+	// from them, set from groups.json when the users are loaded; see groups.go
+	Groups     []string `json:"-"`
+	GroupRoles []Role   `json:"-"`
+
+	// Scopes is set only when the user authenticated with a derived token, which is
+	// limited to these scopes and never persisted
+	Scopes []TokenScope `json:"-"`
 }
 
 // RolePermissions maps roles to their allowed permissions
@@ -61,18 +80,41 @@ func (rp RolePermissions) Has(role Role, perm string) bool {
 	return slices.Contains(perms, perm)
 }
 
-// Can checks if the user has a specific permission
+// Can checks if the user has a specific permission through their role or a group's. A scoped token (a derived token, or a
+// previous token in a read-only grace period) keeps read and write only where one of its
+// scopes needs them, and never holds any other permission.
 func (u *User) Can(perm string, perms RolePermissions) bool {
-	return perms.Has(u.Role, perm)
+	if u.Scopes != nil && !slices.ContainsFunc(u.Scopes, func(s TokenScope) bool { return actionPermission(s.Action) == perm }) {
+		return false
+	}
+	return slices.ContainsFunc(u.EffectiveRoles(), func(r Role) bool { return perms.Has(r, perm) })
 }
 
-// DisableToken disables the user's token by clearing the token hash and updating the timestamp
-func (u *User) DisableToken() {
-	u.TokenHash = ""
+// InScope reports whether the user may perform the action on the key as far as a
+// scoped token's scopes are concerned; users with their own tokens are unscoped
+func (u *User) InScope(action, key string) bool {
+	if u.Scopes == nil {
+		return true
+	}
+	return slices.ContainsFunc(u.Scopes, func(s TokenScope) bool { return s.matches(action, key) })
+}
+
+// SetToken replaces the user's default token with the given value
+func (u *User) SetToken(value string) {
+	u.TokenHash = HashToken(value)
+	u.TokenLookupID = tokenLookupID(value)
 	now := time.Now()
 	u.TokenRotatedAt = &now
 }
 
+// DisableToken disables the user by revoking every token and recording by whom and why
+func (u *User) DisableToken(by, reason string) {
+	u.revokeDefaultToken()
+	u.Tokens = nil
+	u.PreviousTokens = nil
+	u.disable(by, reason)
+}
+
 // HashToken creates a SHA-256 hash of a token for secure storage
 func HashToken(token string) string {
 	h := sha256.Sum256([]byte(token))
@@ -90,12 +132,7 @@ func generateSecureToken() (string, error) {
 
 // generateSecureTokenFallback is the original implementation for fallback use
 func generateSecureTokenFallback() (string, error) {
-	const tokenLengthBytes = 20 // 20 bytes = 160 bits of entropy
-	tokenBytes := make([]byte, tokenLengthBytes)
-	if _, err := io.ReadFull(rand.Reader, tokenBytes); err != nil {
-		return "", err
-	}
-	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+	return NewStructuredToken()
 }
 
 // DefaultUserConfigPath exports defaultUserConfigPath for CLI use.
@@ -144,6 +181,9 @@ For more config options, run: simple-secrets help config`)
 		}
 		return "", fmt.Errorf("failed to read configuration: %w", err)
 	}
+	if err := configFormat.checkReadable(data); err != nil {
+		return "", err
+	}
 
 	var config struct {
 		Token               string `json:"token"`
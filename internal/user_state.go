@@ -0,0 +1,199 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// UserStatus is the state of a user's account
+type UserStatus string
+
+// A disabled user has had every token revoked and needs 'enable user' for a new one.
+// A locked user keeps their tokens, but none of them is accepted until it is unlocked.
+const (
+	StatusActive   UserStatus = "active"
+	StatusDisabled UserStatus = "disabled"
+	StatusLocked   UserStatus = "locked"
+)
+
+// ErrUserDisabled and ErrUserLocked indicate a token of an account that may not sign in
+var (
+	ErrUserDisabled = errors.New("user is disabled")
+	ErrUserLocked   = errors.New("user is locked")
+)
+
+// UserUpdate lists the account fields to change; nil fields are left as they are
+type UserUpdate struct {
+	Contact     *string
+	Description *string
+	Role        *string
+	Status      *UserStatus // StatusActive or StatusLocked
+	Reason      string      // recorded when the account is locked
+}
+
+// AccountStatus returns the user's status; users created before statuses existed are active
+func (u *User) AccountStatus() UserStatus {
+	if u.Status == "" {
+		return StatusActive
+	}
+	return u.Status
+}
+
+// disable marks the account disabled and records when, by whom and why
+func (u *User) disable(by, reason string) {
+	u.setStatus(StatusDisabled, by, reason)
+}
+
+// setStatus changes the account status, recording when, by whom and why for
+// anything but active
+func (u *User) setStatus(status UserStatus, by, reason string) {
+	u.Status = status
+	u.DisabledAt, u.DisabledBy, u.DisabledReason = nil, "", ""
+	if status == StatusActive {
+		return
+	}
+	now := time.Now().UTC()
+	u.DisabledAt = &now
+	u.DisabledBy = by
+	u.DisabledReason = strings.TrimSpace(reason)
+}
+
+// checkActive rejects tokens of disabled and locked accounts
+func (u *User) checkActive() error {
+	reason := ""
+	if u.DisabledReason != "" {
+		reason = " (" + u.DisabledReason + ")"
+	}
+	switch u.AccountStatus() {
+	case StatusDisabled:
+		return fmt.Errorf("%w: user %q was disabled%s; an admin can issue a new token with 'simple-secrets enable user %s'",
+			ErrUserDisabled, u.Username, reason, u.Username)
+	case StatusLocked:
+		return fmt.Errorf("%w: user %q is locked%s; an admin can unlock it with 'simple-secrets user update %s --status active'",
+			ErrUserLocked, u.Username, reason, u.Username)
+	}
+	return nil
+}
+
+// hasTokens reports whether the user holds any token, current or in a grace period
+func (u *User) hasTokens() bool {
+	return u.TokenHash != "" || len(u.Tokens) > 0 || len(u.PreviousTokens) > 0
+}
+
+// ParseUserStatus checks a status given to 'user update'
+func ParseUserStatus(value string) (UserStatus, error) {
+	status := UserStatus(value)
+	if status != StatusActive && status != StatusLocked {
+		return "", fmt.Errorf("invalid status %q: must be %q or %q; use 'disable user' to disable an account",
+			value, StatusActive, StatusLocked)
+	}
+	return status, nil
+}
+
+// ====================================
+// UserStore Account Management
+// ====================================
+
+// UpdateUser changes a user's contact, description, role or status. by is the
+// username of the admin making the change.
+func (us *UserStore) UpdateUser(username, by string, update UserUpdate) error {
+	if update.Role != nil {
+		if err := us.UpdateUserRole(username, *update.Role); err != nil {
+			return err
+		}
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user, err := us.userByName(username)
+	if err != nil {
+		return err
+	}
+	if update.Status != nil {
+		if err := us.changeStatus(user, by, *update.Status, update.Reason); err != nil {
+			return err
+		}
+	}
+	if update.Contact != nil {
+		user.Contact = strings.TrimSpace(*update.Contact)
+	}
+	if update.Description != nil {
+		user.Description = strings.TrimSpace(*update.Description)
+	}
+	return nil
+}
+
+// changeStatus locks or unlocks an account. Callers must hold the lock.
+func (us *UserStore) changeStatus(user *User, by string, status UserStatus, reason string) error {
+	current := user.AccountStatus()
+	switch {
+	case status == current:
+		return fmt.Errorf("user %q is already %s", user.Username, status)
+	case current == StatusDisabled:
+		return fmt.Errorf("user %q is disabled; use 'simple-secrets enable user %s' to issue a new token", user.Username, user.Username)
+	case status == StatusLocked && user.Username == by:
+		return fmt.Errorf("you cannot lock your own account")
+	case status == StatusLocked && user.IsAdmin() && us.countActiveAdmins() <= 1:
+		return fmt.Errorf("cannot lock the last active admin user")
+	}
+	user.setStatus(status, by, reason)
+	return nil
+}
+
+// countActiveAdmins returns the number of admins who can still sign in. Callers must hold the lock.
+func (us *UserStore) countActiveAdmins() int {
+	count := 0
+	for _, u := range us.users {
+		if u.IsAdmin() && u.AccountStatus() == StatusActive {
+			count++
+		}
+	}
+	return count
+}
+
+// RenameUser changes a username, along with every reference to it in users.json and
+// the groups. Policies and disabled secrets that name the user are updated by the service.
+func (us *UserStore) RenameUser(oldName, newName string) error {
+	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		return fmt.Errorf("username cannot be empty")
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user, err := us.userByName(oldName)
+	if err != nil {
+		return err
+	}
+	if _, err := us.userByName(newName); err == nil {
+		return fmt.Errorf("user %q already exists", newName)
+	}
+
+	user.Username = newName
+	us.renameInGroups(oldName, newName)
+	for _, u := range us.users {
+		if u.DisabledBy == oldName {
+			u.DisabledBy = newName
+		}
+	}
+	return nil
+}
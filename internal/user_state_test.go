@@ -0,0 +1,134 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUserAccountStatus(t *testing.T) {
+	service, _ := newRoleTestService(t)
+	locked, active := StatusLocked, StatusActive
+
+	t.Run("lock_and_unlock", func(t *testing.T) {
+		if err := service.Users().UpdateUser("admin-token", "bob", UserUpdate{Status: &locked, Reason: "laptop stolen"}); err != nil {
+			t.Fatalf("lock bob: %v", err)
+		}
+		if _, err := service.Auth().ValidateToken("bob-token"); !errors.Is(err, ErrUserLocked) {
+			t.Fatalf("a locked user's token must be refused, got %v", err)
+		}
+		users, _ := service.Users().ListUsers("admin-token")
+		bob := users[1]
+		if bob.Status != StatusLocked || bob.DisabledBy != "admin" || bob.DisabledReason != "laptop stolen" || bob.DisabledAt == nil {
+			t.Fatalf("expected the lock to be recorded, got %+v", bob)
+		}
+
+		if err := service.Users().UpdateUser("admin-token", "bob", UserUpdate{Status: &active}); err != nil {
+			t.Fatalf("unlock bob: %v", err)
+		}
+		if _, err := service.Auth().ValidateToken("bob-token"); err != nil {
+			t.Fatalf("an unlocked user keeps their token: %v", err)
+		}
+	})
+
+	t.Run("cannot_lock_self_or_last_admin", func(t *testing.T) {
+		if err := service.Users().UpdateUser("admin-token", "admin", UserUpdate{Status: &locked}); err == nil {
+			t.Fatal("an admin must not lock their own account")
+		}
+		store := createUserStore([]*User{{Username: "admin", Role: RoleAdmin}}, createDefaultRoles())
+		if err := store.UpdateUser("admin", "someone", UserUpdate{Status: &locked}); err == nil {
+			t.Fatal("the last active admin must not be locked")
+		}
+	})
+
+	t.Run("disable_records_reason", func(t *testing.T) {
+		if err := service.Users().DisableUser("admin-token", "bob", "left the team"); err != nil {
+			t.Fatalf("disable bob: %v", err)
+		}
+		users, _ := service.Users().ListUsers("admin-token")
+		if bob := users[1]; bob.Status != StatusDisabled || bob.DisabledReason != "left the team" {
+			t.Fatalf("expected bob disabled with a reason, got %+v", bob)
+		}
+		if err := service.Users().UpdateUser("admin-token", "bob", UserUpdate{Status: &active}); err == nil {
+			t.Fatal("a disabled user needs 'enable user', not an unlock")
+		}
+
+		if _, err := service.Users().EnableUser("admin-token", "bob"); err != nil {
+			t.Fatalf("enable bob: %v", err)
+		}
+		users, _ = service.Users().ListUsers("admin-token")
+		if bob := users[1]; bob.Status != StatusActive || bob.DisabledAt != nil || bob.DisabledReason != "" {
+			t.Fatalf("enabling should clear the disabled record, got %+v", bob)
+		}
+	})
+
+	t.Run("enable_needs_disabled_status", func(t *testing.T) {
+		// An active user without a default token is not disabled
+		store := createUserStore([]*User{
+			{Username: "admin", Role: RoleAdmin, TokenHash: HashToken("admin-token")},
+			{Username: "carol", Role: RoleReader, Status: StatusActive},
+			{Username: "dave", Role: RoleReader, Status: StatusLocked, TokenHash: HashToken("dave-token")},
+		}, createDefaultRoles())
+		if _, err := store.EnableUserToken("carol"); err == nil || !strings.Contains(err.Error(), "not disabled") {
+			t.Fatalf("an active user must not be enabled, got %v", err)
+		}
+		if _, err := store.EnableUserToken("dave"); err == nil || !strings.Contains(err.Error(), "locked") {
+			t.Fatalf("a locked user is unlocked, not enabled, got %v", err)
+		}
+	})
+}
+
+func TestRenameUser(t *testing.T) {
+	service, _ := newRoleTestService(t)
+	if err := service.Secrets().Put("admin-token", "db", "v1"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err := service.Policies().AddPolicy("admin-token", Policy{Effect: PolicyAllow, Subject: "user:bob", Actions: []string{ActionRead}, Keys: []string{"db"}}); err != nil {
+		t.Fatalf("add policy: %v", err)
+	}
+	if err := service.Secrets().Disable("admin-token", "db", "rotating"); err != nil {
+		t.Fatalf("disable secret: %v", err)
+	}
+
+	contact := "ops@example.com"
+	if err := service.Users().UpdateUser("admin-token", "bob", UserUpdate{Contact: &contact}); err != nil {
+		t.Fatalf("update contact: %v", err)
+	}
+	if err := service.Users().RenameUser("admin-token", "admin", "root"); err != nil {
+		t.Fatalf("rename admin: %v", err)
+	}
+	if err := service.Users().RenameUser("admin-token", "bob", "robert"); err != nil {
+		t.Fatalf("rename bob: %v", err)
+	}
+	if err := service.Users().RenameUser("admin-token", "robert", "root"); err == nil {
+		t.Fatal("renaming onto an existing user should fail")
+	}
+
+	user, err := service.Auth().ValidateToken("bob-token")
+	if err != nil || user.Username != "robert" || user.Contact != contact {
+		t.Fatalf("bob's token should now belong to robert, got %+v (%v)", user, err)
+	}
+	policies, _ := service.Policies().ListPolicies("admin-token")
+	if len(policies) != 1 || policies[0].Subject != "user:robert" {
+		t.Fatalf("the policy should follow the rename, got %+v", policies)
+	}
+	disabled, _ := service.Secrets().ListDisabledDetails("admin-token")
+	if len(disabled) != 1 || disabled[0].DisabledBy != "root" {
+		t.Fatalf("the disabled secret should name the renamed admin, got %+v", disabled)
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrUsersConflict indicates users.json changed on disk after it was reloaded for an update,
+// by a writer that did not take its lock. The update is not saved and can be retried.
+var ErrUsersConflict = errors.New("users.json was changed by another process during the update; try again")
+
+// fileRevision identifies the contents of a file, to notice changes made by other processes
+func fileRevision(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// reload replaces the users, groups and roles with those on disk, so that a change made
+// under users.json's lock applies to what other processes saved since this one loaded
+// them, and records the file's revision for the conflict check in save
+func (us *UserStore) reload(usersPath, rolesPath string) error {
+	revision, err := fileRevision(usersPath)
+	if err != nil {
+		return err
+	}
+	users, groups, err := loadUsersAndGroups(usersPath)
+	if err != nil {
+		return err
+	}
+	permissions, err := loadRoles(rolesPath)
+	if err != nil {
+		return fmt.Errorf("load roles.json: %w", err)
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	us.users, us.permissions, us.revision = users, permissions, revision
+	us.withGroups(groups)
+	us.rebuildIndex()
+	return nil
+}
+
+// save writes the users to usersPath. A store that was reloaded refuses with
+// ErrUsersConflict if the file changed since, rather than overwrite that change.
+func (us *UserStore) save(usersPath string) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if us.revision != "" {
+		current, err := fileRevision(usersPath)
+		if err != nil {
+			return err
+		}
+		if current != us.revision {
+			return ErrUsersConflict
+		}
+	}
+	if err := SaveUsersList(usersPath, us.users); err != nil {
+		return err
+	}
+	revision, err := fileRevision(usersPath)
+	if err != nil {
+		return err
+	}
+	us.revision = revision
+	return nil
+}
+
+// UpdateUsersList applies a change to the users in users.json under its lock, loading them
+// fresh so that concurrent changes by other processes are kept, and saves the result
+func UpdateUsersList(path string, change func(users []*User) error) error {
+	lock, err := LockFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to acquire users lock: %w", err)
+	}
+	defer lock.Unlock()
+
+	users, err := loadUsers(path)
+	if err != nil {
+		return err
+	}
+	if err := change(users); err != nil {
+		return err
+	}
+	return SaveUsersList(path, users)
+}
+
+// updateUsers applies a change to the users and groups under the locks of users.json and
+// groups.json, following the read-modify-write discipline of SecretsStore.Put: the store
+// is reloaded from disk, changed and saved, so two processes changing users at once cannot
+// drop each other's change. Both files are written before either lock is released, and
+// users.json is put back if groups.json cannot be written.
+func (u *userOperations) updateUsers(change func() error) error {
+	// Locks are taken in the order users.json, roles.json, groups.json; see updateRoles
+	usersLock, err := LockFile(u.usersPath)
+	if err != nil {
+		return fmt.Errorf("failed to acquire users lock: %w", err)
+	}
+	defer usersLock.Unlock()
+	groupsLock, err := LockFile(u.groupsPath)
+	if err != nil {
+		return fmt.Errorf("failed to acquire groups lock: %w", err)
+	}
+	defer groupsLock.Unlock()
+
+	if err := u.userStore.reload(u.usersPath, u.rolesPath); err != nil {
+		return fmt.Errorf("failed to reload users: %w", err)
+	}
+	before, err := loadUsers(u.usersPath)
+	if err != nil {
+		return fmt.Errorf("failed to reload users: %w", err)
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	if err := u.saveUsersWithError(); err != nil {
+		return err
+	}
+	if err := u.saveGroups(); err != nil {
+		if restore := SaveUsersList(u.usersPath, before); restore != nil {
+			return fmt.Errorf("%w; users.json could not be put back: %v", err, restore)
+		}
+		return err
+	}
+	return nil
+}
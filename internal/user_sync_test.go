@@ -0,0 +1,206 @@
+/*
+Copyright © 2025 Ian Shuley
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentUserChangesAcrossServices runs user changes through separate services, each
+// standing in for a process with its own copy of the users loaded before the others' changes
+func TestConcurrentUserChangesAcrossServices(t *testing.T) {
+	dir := newSealedInstallation(t)
+
+	const numServices = 8
+	services := make([]*Service, numServices)
+	for i := range services {
+		service, err := NewService(WithStorageBackend(NewFilesystemBackend()), WithConfigDir(dir))
+		if err != nil {
+			t.Fatalf("create service %d: %v", i, err)
+		}
+		services[i] = service
+	}
+
+	var wg sync.WaitGroup
+	for i, service := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 3 {
+				if _, err := service.Users().CreateUser("admin-token", fmt.Sprintf("user%d_%d", i, j), string(RoleReader)); err != nil {
+					t.Errorf("service %d: create user: %v", i, err)
+				}
+			}
+			// Every service also changes bob, whom all of them loaded
+			description := fmt.Sprintf("changed by service %d", i)
+			if err := service.Users().UpdateUser("admin-token", "bob", UserUpdate{Description: &description}); err != nil {
+				t.Errorf("service %d: update bob: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	store, err := loadUserStoreFromConfigDir(dir)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got, want := len(store.Users()), 2+numServices*3; got != want {
+		t.Fatalf("expected %d users, got %d: concurrent creations were lost", want, got)
+	}
+	if bob, err := store.FindUser("bob"); err != nil || bob.Description == "" {
+		t.Fatalf("bob should carry one of the updates: %+v, %v", bob, err)
+	}
+
+	// A service loaded before the deletion sees it when it takes the lock
+	if err := services[0].Users().DeleteUser("admin-token", "user1_0"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := services[1].Users().RotateToken("admin-token", "user1_0"); err == nil {
+		t.Fatal("rotating a user another process deleted should fail")
+	}
+}
+
+// TestConcurrentPolicyChangesAcrossServices checks that policies.json, like users.json, is
+// reloaded under its lock, so services that loaded it earlier keep each other's changes
+func TestConcurrentPolicyChangesAcrossServices(t *testing.T) {
+	dir := newSealedInstallation(t)
+
+	const numServices = 6
+	services := make([]*Service, numServices)
+	for i := range services {
+		service, err := NewService(WithStorageBackend(NewFilesystemBackend()), WithConfigDir(dir))
+		if err != nil {
+			t.Fatalf("create service %d: %v", i, err)
+		}
+		services[i] = service
+	}
+
+	var wg sync.WaitGroup
+	for i, service := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			policy := Policy{Effect: PolicyAllow, Subject: UserSubject("bob"), Actions: []string{ActionRead}, Keys: []string{fmt.Sprintf("app%d/*", i)}}
+			if _, err := service.Policies().AddPolicy("admin-token", policy); err != nil {
+				t.Errorf("service %d: add policy: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	policies, err := loadPolicies(filepath.Join(dir, "policies.json"))
+	if err != nil {
+		t.Fatalf("load policies: %v", err)
+	}
+	ids := map[string]bool{}
+	for _, p := range policies {
+		ids[p.ID] = true
+	}
+	if len(policies) != numServices || len(ids) != numServices {
+		t.Fatalf("expected %d policies with distinct IDs, got %+v: concurrent additions were lost", numServices, policies)
+	}
+
+	// A service loaded before a policy was removed sees the removal
+	if err := services[0].Policies().RemovePolicy("admin-token", "p1"); err != nil {
+		t.Fatalf("remove policy: %v", err)
+	}
+	if err := services[1].Policies().RemovePolicy("admin-token", "p1"); err == nil {
+		t.Fatal("removing a policy another process removed should fail")
+	}
+}
+
+// TestConcurrentRoleChangesAcrossServices checks that roles.json is reloaded under its lock
+func TestConcurrentRoleChangesAcrossServices(t *testing.T) {
+	dir := newSealedInstallation(t)
+
+	const numServices = 6
+	services := make([]*Service, numServices)
+	for i := range services {
+		service, err := NewService(WithStorageBackend(NewFilesystemBackend()), WithConfigDir(dir))
+		if err != nil {
+			t.Fatalf("create service %d: %v", i, err)
+		}
+		services[i] = service
+	}
+
+	var wg sync.WaitGroup
+	for i, service := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := service.Roles().CreateRole("admin-token", fmt.Sprintf("role%d", i), []string{PermRead}); err != nil {
+				t.Errorf("service %d: create role: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	roles, err := loadRoles(filepath.Join(dir, "roles.json"))
+	if err != nil {
+		t.Fatalf("load roles: %v", err)
+	}
+	for i := range numServices {
+		if _, ok := roles[Role(fmt.Sprintf("role%d", i))]; !ok {
+			t.Fatalf("role%d was lost: %v", i, roles)
+		}
+	}
+
+	// A role another service assigned since this one loaded is still refused for deletion
+	if _, err := services[0].Users().CreateUser("admin-token", "carol", "role1"); err != nil {
+		t.Fatalf("create carol: %v", err)
+	}
+	if err := services[1].Roles().DeleteRole("admin-token", "role1"); err == nil {
+		t.Fatal("deleting a role assigned by another process should fail")
+	}
+}
+
+func TestUserSaveDetectsUnlockedWriters(t *testing.T) {
+	dir := newSealedInstallation(t)
+	usersPath, rolesPath := filepath.Join(dir, "users.json"), filepath.Join(dir, "roles.json")
+
+	store, err := loadUserStoreFromConfigDir(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := store.reload(usersPath, rolesPath); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, err := store.CreateUser("carol", string(RoleReader)); err != nil {
+		t.Fatalf("create carol: %v", err)
+	}
+
+	// Another writer saves without taking the lock between the reload and the save
+	users, err := LoadUsersList(usersPath)
+	if err != nil {
+		t.Fatalf("load users: %v", err)
+	}
+	users = append(users, &User{Username: "dave", TokenHash: HashToken("dave-token"), Role: RoleReader})
+	if err := SaveUsersList(usersPath, users); err != nil {
+		t.Fatalf("save users: %v", err)
+	}
+
+	if err := store.save(usersPath); !errors.Is(err, ErrUsersConflict) {
+		t.Fatalf("expected ErrUsersConflict, got %v", err)
+	}
+	saved, err := LoadUsersList(usersPath)
+	if err != nil || len(saved) != 3 {
+		t.Fatalf("the other writer's change must survive the conflict, got %d users, %v", len(saved), err)
+	}
+}
@@ -19,10 +19,50 @@ limitations under the License.
 // the principle of accepting interfaces and returning concrete types.
 package api
 
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
 // User represents an authenticated user with role-based permissions
 type User struct {
 	Username string
 	Role     string
+
+	// Scopes limits a user authenticated with a derived token, as "action:pattern"
+	// entries such as "read:payments-*". Empty for users' own tokens.
+	Scopes []string
+}
+
+// InScope reports whether the user's scopes allow the action ("read", "write" or
+// "list") on the key. Users without scopes are limited by their role alone.
+func (u *User) InScope(action, key string) bool {
+	if len(u.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range u.Scopes {
+		scopeAction, pattern, _ := strings.Cut(scope, ":")
+		if ok, _ := path.Match(pattern, key); ok && scopeAction == action {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScopeFor reports whether any of the user's scopes covers one of the actions; users
+// without scopes always do
+func (u *User) HasScopeFor(actions ...string) bool {
+	if len(u.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range u.Scopes {
+		scopeAction, _, _ := strings.Cut(scope, ":")
+		if slices.Contains(actions, scopeAction) {
+			return true
+		}
+	}
+	return false
 }
 
 // BackupInfo represents information about available backups